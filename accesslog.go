@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// accessLogTime is the timestamp layout of the common and combined log formats
+const accessLogTime = "02/Jan/2006:15:04:05 -0700"
+
+// statusRecorder captures the status code and body size of a response for the access log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(p []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(p)
+	sr.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming responses working through the recorder
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// accessLogger writes one line per request in the common, combined or json format
+type accessLogger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+}
+
+// openAccessLog returns the access logger writing to dest: "-" for standard output, otherwise a
+// file appended to
+func openAccessLog(dest, format string) (*accessLogger, error) {
+	if format != "common" && format != "combined" && format != "json" {
+		return nil, fmt.Errorf("unknown access log format '%s'", format)
+	}
+	if dest == "-" {
+		return &accessLogger{w: os.Stdout, format: format}, nil
+	}
+	file, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening access log: %w", err)
+	}
+	return &accessLogger{w: file, format: format}, nil
+}
+
+// line formats the log line of a served request
+func (l *accessLogger) line(r *http.Request, start time.Time, status int, size int64) []byte {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	user, _, _ := r.BasicAuth()
+
+	if l.format == "json" {
+		line, _ := json.Marshal(struct {
+			Time       time.Time `json:"time"`
+			Remote     string    `json:"remote"`
+			User       string    `json:"user,omitempty"`
+			Method     string    `json:"method"`
+			URI        string    `json:"uri"`
+			Proto      string    `json:"proto"`
+			Status     int       `json:"status"`
+			Bytes      int64     `json:"bytes"`
+			DurationMS float64   `json:"durationMs"`
+			Referer    string    `json:"referer,omitempty"`
+			UserAgent  string    `json:"userAgent,omitempty"`
+		}{start, host, user, r.Method, r.RequestURI, r.Proto, status, size,
+			float64(time.Since(start).Microseconds()) / 1000, r.Referer(), r.UserAgent()})
+		return append(line, '\n')
+	}
+
+	sizeField := "-"
+	if size > 0 {
+		sizeField = strconv.FormatInt(size, 10)
+	}
+	if user == "" {
+		user = "-"
+	}
+	line := fmt.Sprintf("%s - %s [%s] %s %d %s", host, user, start.Format(accessLogTime),
+		strconv.Quote(r.Method+" "+r.RequestURI+" "+r.Proto), status, sizeField)
+	if l.format == "combined" {
+		line += " " + quoteOrDash(r.Referer()) + " " + quoteOrDash(r.UserAgent())
+	}
+	return []byte(line + "\n")
+}
+
+// quoteOrDash quotes a header value for the combined format, "-" standing for a missing one
+func quoteOrDash(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return strconv.Quote(s)
+}
+
+// wrap logs every request served by next
+func (l *accessLogger) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			line := l.line(r, start, status, recorder.bytes)
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.w.Write(line)
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the alert rules
+const (
+	defaultAlertWindow   = 7 * 24 * time.Hour
+	defaultAlertBaseline = 90 * 24 * time.Hour
+)
+
+// AlertRule is a rule of -alert-rules, e.g. "src/billing churn above twice its 90-day average" or
+// "any file with more than 30 commits a week"
+type AlertRule struct {
+	Name     string  `json:"name"`
+	Path     string  `json:"path,omitempty"`     // Directory or file watched, "" for the whole repository
+	Each     string  `json:"each,omitempty"`     // file or directory: judge every file or directory below path on its own
+	Metric   string  `json:"metric,omitempty"`   // churn (file changes, default) or commits
+	Window   string  `json:"window,omitempty"`   // Period judged, ending with the analysis (default 7d)
+	Above    float64 `json:"above,omitempty"`    // Fires when the metric within the window exceeds this
+	Factor   float64 `json:"factor,omitempty"`   // Fires when the metric exceeds this multiple of its average over the baseline
+	Baseline string  `json:"baseline,omitempty"` // Period before the window whose average per window is the reference (default 90d)
+
+	window, baseline time.Duration
+}
+
+// Alert is a rule firing for a path
+type Alert struct {
+	Rule    string  `json:"rule"`
+	Path    string  `json:"path"`
+	Metric  string  `json:"metric"`
+	Window  string  `json:"window"`
+	Value   float64 `json:"value"` // Metric within the window
+	Limit   float64 `json:"limit"` // Value it exceeded: above, or factor times the baseline average
+	Message string  `json:"message"`
+}
+
+// alertRules are the rules of -alert-rules
+var alertRules []AlertRule
+
+// loadAlertRules reads a JSON list of alert rules
+func loadAlertRules(file string) ([]AlertRule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rules []AlertRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("error parsing alert rules '%s': %w", file, err)
+	}
+	for i := range rules {
+		if err := rules[i].validate(); err != nil {
+			return nil, fmt.Errorf("error parsing alert rules '%s': rule %d: %w", file, i+1, err)
+		}
+	}
+	return rules, nil
+}
+
+// validate checks the rule and fills in its defaults
+func (r *AlertRule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	r.Path = strings.Trim(r.Path, "/")
+	if r.Metric == "" {
+		r.Metric = "churn"
+	}
+	if r.Metric != "churn" && r.Metric != "commits" {
+		return fmt.Errorf("'%s': unknown metric '%s' (expected churn or commits)", r.Name, r.Metric)
+	}
+	if r.Each != "" && r.Each != "file" && r.Each != "directory" {
+		return fmt.Errorf("'%s': unknown each '%s' (expected file or directory)", r.Name, r.Each)
+	}
+	if r.Above <= 0 && r.Factor <= 0 {
+		return fmt.Errorf("'%s': above or factor is required", r.Name)
+	}
+	r.window, r.baseline = defaultAlertWindow, defaultAlertBaseline
+	for _, period := range []struct {
+		value  string
+		target *time.Duration
+	}{{r.Window, &r.window}, {r.Baseline, &r.baseline}} {
+		if period.value == "" {
+			continue
+		}
+		d, err := parseAge(period.value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("'%s': invalid period '%s' (expected e.g. 7d)", r.Name, period.value)
+		}
+		*period.target = d
+	}
+	return nil
+}
+
+// subjects returns what the rule judges a change of file as: the rule's path, the file or its
+// directories below the path, depending on each
+func (r *AlertRule) subjects(file string) []string {
+	if !pathWithin(file, r.Path) {
+		return nil
+	}
+	switch r.Each {
+	case "file":
+		return []string{file}
+	case "directory":
+		var dirs []string
+		for _, dir := range directoriesOf(file, math.MaxInt) {
+			if pathWithin(dir, r.Path) {
+				dirs = append(dirs, dir)
+			}
+		}
+		return dirs
+	}
+	return []string{r.Path}
+}
+
+// evaluate returns the alerts of the rule for the analysis. Factor rules don't fire for paths
+// without changes in the baseline.
+func (r *AlertRule) evaluate(a *Analysis, now time.Time) []Alert {
+	recent, baseline := make(map[string]float64), make(map[string]float64)
+	for _, commit := range a.Commits {
+		age := now.Sub(commit.Date)
+		if age > r.window+r.baseline {
+			break // Newest first
+		}
+		values := baseline
+		if age <= r.window {
+			values = recent
+		}
+		counted := make(map[string]bool)
+		for _, file := range commit.Files {
+			for _, subject := range r.subjects(file.Path) {
+				if r.Metric == "churn" {
+					values[subject] += commit.Weight
+				} else if !counted[subject] {
+					counted[subject] = true
+					values[subject] += commit.Weight
+				}
+			}
+		}
+	}
+
+	var alerts []Alert
+	window := formatAge(r.window)
+	for path, value := range recent {
+		name := path
+		if name == "" {
+			name = a.Root.Name()
+		}
+		if r.Above > 0 && value > r.Above {
+			alerts = append(alerts, Alert{Rule: r.Name, Path: path, Metric: r.Metric, Window: window, Value: value, Limit: r.Above,
+				Message: fmt.Sprintf("%s: %s %s %.0f in the last %s, above %g", r.Name, name, r.Metric, value, window, r.Above)})
+			continue
+		}
+		average := baseline[path] * float64(r.window) / float64(r.baseline)
+		if r.Factor > 0 && average > 0 && value > r.Factor*average {
+			limit := math.Round(r.Factor*average*100) / 100
+			alerts = append(alerts, Alert{Rule: r.Name, Path: path, Metric: r.Metric, Window: window, Value: value, Limit: limit,
+				Message: fmt.Sprintf("%s: %s %s %.0f in the last %s, %.1fx its %s average", r.Name, name, r.Metric, value, window, value/average, formatAge(r.baseline))})
+		}
+	}
+	return alerts
+}
+
+// evaluateAlerts returns the alerts of all rules, by rule and path
+func evaluateAlerts(a *Analysis, rules []AlertRule, now time.Time) []Alert {
+	alerts := []Alert{}
+	for i := range rules {
+		alerts = append(alerts, rules[i].evaluate(a, now)...)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Rule != alerts[j].Rule {
+			return alerts[i].Rule < alerts[j].Rule
+		}
+		return alerts[i].Path < alerts[j].Path
+	})
+	return alerts
+}
+
+// firingAlerts remembers the alerts of the previous evaluation, so only new ones are dispatched
+var firingAlerts struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+// dispatchAlerts evaluates the rules after an analysis and posts the alerts that weren't firing
+// after the previous one to the -notify-webhook, or logs them without one
+func dispatchAlerts(ctx context.Context, a *Analysis, opts NotifyOptions) error {
+	alerts := evaluateAlerts(a, alertRules, a.Meta.GeneratedAt)
+	firingAlerts.mu.Lock()
+	var fresh []Alert
+	keys := make(map[string]bool, len(alerts))
+	for _, alert := range alerts {
+		key := alert.Rule + "\x00" + alert.Path
+		keys[key] = true
+		if !firingAlerts.keys[key] {
+			fresh = append(fresh, alert)
+		}
+	}
+	firingAlerts.keys = keys
+	firingAlerts.mu.Unlock()
+
+	if len(fresh) == 0 {
+		return nil
+	}
+	if opts.Webhook == "" {
+		for _, alert := range fresh {
+			slog.Warn("Alert", "rule", alert.Rule, "path", alert.Path, "metric", alert.Metric, "value", alert.Value, "limit", alert.Limit)
+		}
+		return nil
+	}
+	markdown := func(bold func(string) string) string {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s\n", bold("git-dirheat alerts for "+a.Root.Name()))
+		for _, alert := range fresh {
+			fmt.Fprintf(&b, "• %s\n", alert.Message)
+		}
+		return b.String()
+	}
+	format, err := postWebhook(ctx, opts, "git-dirheat alerts for "+a.Root.Name(), markdown)
+	if err != nil {
+		return fmt.Errorf("error posting the alerts: %w", err)
+	}
+	slog.Info("Posted alerts", "format", format, "alerts", len(fresh))
+	return nil
+}
+
+// handleAlerts serves GET /alerts, the alerts of -alert-rules firing for the current analysis
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	writeJSON(w, evaluateAlerts(analysis, alertRules, analysis.Meta.GeneratedAt))
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxAnnotationBody limits the size of an annotation request body
+const maxAnnotationBody = 64 * 1024
+
+// Annotation is a note and/or labels attached to a path, e.g. "owned by infra"
+type Annotation struct {
+	Path      string    `json:"path"`
+	Note      string    `json:"note,omitempty"`
+	Labels    []string  `json:"labels,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// annotationStore keeps annotations in memory and persists them to a JSON file on every change
+type annotationStore struct {
+	mu          sync.RWMutex
+	file        string
+	annotations map[string]*Annotation
+	version     int // Incremented on every change, invalidating cached responses
+}
+
+// loadAnnotations opens the annotation store backed by file, which may not exist yet
+func loadAnnotations(file string) (*annotationStore, error) {
+	store := &annotationStore{file: file, annotations: make(map[string]*Annotation)}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var list []*Annotation
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("error parsing annotations file '%s': %w", file, err)
+	}
+	for _, annotation := range list {
+		store.annotations[annotation.Path] = annotation
+	}
+	return store, nil
+}
+
+// save writes all annotations to the backing file. The caller holds the lock.
+func (s *annotationStore) save() error {
+	return writeJSONFile(s.file, s.listLocked())
+}
+
+// listLocked returns the annotations ordered by path. The caller holds the lock.
+func (s *annotationStore) listLocked() []*Annotation {
+	list := make([]*Annotation, 0, len(s.annotations))
+	for _, annotation := range s.annotations {
+		list = append(list, annotation)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
+
+// list returns all annotations ordered by path
+func (s *annotationStore) list() []*Annotation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.listLocked()
+}
+
+// get returns the annotation of path, or nil
+func (s *annotationStore) get(path string) *Annotation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.annotations[path]
+}
+
+// put creates or replaces the annotation of its path and persists the store
+func (s *annotationStore) put(annotation *Annotation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.annotations[annotation.Path] = annotation
+	s.version++
+	return s.save()
+}
+
+// remove deletes the annotation of path, reporting whether it existed
+func (s *annotationStore) remove(path string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.annotations[path]; !ok {
+		return false, nil
+	}
+	delete(s.annotations, path)
+	s.version++
+	return true, s.save()
+}
+
+// currentVersion returns a counter that changes whenever an annotation does
+func (s *annotationStore) currentVersion() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version
+}
+
+// byPath returns a copy of the annotations by path, for lookups while encoding a tree
+func (s *annotationStore) byPath() map[string]*Annotation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	annotations := make(map[string]*Annotation, len(s.annotations))
+	for path, annotation := range s.annotations {
+		annotations[path] = annotation
+	}
+	return annotations
+}
+
+// handleAnnotations serves GET /annotations, all annotations ordered by path
+func handleAnnotations(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, annotations.list())
+}
+
+// handleAnnotation serves GET, PUT and DELETE /annotations/{path...} for a single path's annotation
+func handleAnnotation(w http.ResponseWriter, r *http.Request) {
+	path, err := cleanPath(r.PathValue("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		annotation := annotations.get(path)
+		if annotation == nil {
+			http.Error(w, fmt.Sprintf("No annotation for '%s'", path), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, annotation)
+
+	case http.MethodPut:
+		var body struct {
+			Note   string   `json:"note"`
+			Labels []string `json:"labels"`
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAnnotationBody))
+		if err == nil {
+			err = json.Unmarshal(data, &body)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid annotation: %v", err), http.StatusBadRequest)
+			return
+		}
+		annotation := &Annotation{Path: path, Note: body.Note, Labels: body.Labels, UpdatedAt: time.Now().UTC()}
+		if err := annotations.put(annotation); err != nil {
+			http.Error(w, fmt.Sprintf("Error saving annotation: %v", err), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, annotation)
+
+	case http.MethodDelete:
+		existed, err := annotations.remove(path)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error saving annotations: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !existed {
+			http.Error(w, fmt.Sprintf("No annotation for '%s'", path), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Anomaly detection: a month is anomalous for a directory when its changes lie anomalyThreshold
+// standard deviations or more from the mean of the anomalyHistory months before
+const (
+	anomalyHistory   = 6
+	anomalyThreshold = 3.0
+	anomalyMinStdDev = 1.0 // Floor of the standard deviation, so steady directories don't flag a single change
+	anomalyRecent    = 3   // Months /anomalies reports by default
+)
+
+// Anomaly is a month in which a directory's activity broke with its recent pattern
+type Anomaly struct {
+	Path      string  `json:"path"`
+	Month     string  `json:"month"` // YYYY-MM
+	Value     int     `json:"value"` // File changes within the month
+	Mean      float64 `json:"mean"`  // Of the months before
+	StdDev    float64 `json:"stdDev"`
+	ZScore    float64 `json:"zScore"`
+	Direction string  `json:"direction"` // spike or drop
+}
+
+// monthlySeries returns the changes per month of every directory up to depth levels, from the
+// monthly snapshots. Months without commits count as 0.
+func monthlySeries(a *Analysis, depth int) (months []string, series map[string][]int) {
+	series = make(map[string][]int)
+	if len(a.Snapshots) == 0 {
+		return nil, series
+	}
+	first, _ := time.Parse("2006-01", a.Snapshots[0].Month)
+	last, _ := time.Parse("2006-01", a.Snapshots[len(a.Snapshots)-1].Month)
+	index := make(map[string]int)
+	for month := first; !month.After(last); month = month.AddDate(0, 1, 0) {
+		index[month.Format("2006-01")] = len(months)
+		months = append(months, month.Format("2006-01"))
+	}
+	for _, snapshot := range a.Snapshots {
+		for path, value := range directoryValues(snapshot.Root, depth) {
+			if series[path] == nil {
+				series[path] = make([]int, len(months))
+			}
+			series[path][index[snapshot.Month]] = value
+		}
+	}
+	return months, series
+}
+
+// detectAnomalies returns the anomalies of the months from since (YYYY-MM) on, judged by a rolling
+// z-score against the anomalyHistory months before each; the strongest first
+func detectAnomalies(a *Analysis, depth int, threshold float64, since string) []Anomaly {
+	months, series := monthlySeries(a, depth)
+	anomalies := []Anomaly{}
+	for path, values := range series {
+		for i := anomalyHistory; i < len(values); i++ {
+			if months[i] < since {
+				continue
+			}
+			mean, variance := 0.0, 0.0
+			for _, value := range values[i-anomalyHistory : i] {
+				mean += float64(value)
+			}
+			mean /= anomalyHistory
+			for _, value := range values[i-anomalyHistory : i] {
+				variance += (float64(value) - mean) * (float64(value) - mean)
+			}
+			stdDev := math.Sqrt(variance / anomalyHistory)
+			z := (float64(values[i]) - mean) / max(stdDev, anomalyMinStdDev)
+			if math.Abs(z) < threshold {
+				continue
+			}
+			direction := "spike"
+			if z < 0 {
+				direction = "drop"
+			}
+			anomalies = append(anomalies, Anomaly{Path: path, Month: months[i], Value: values[i],
+				Mean: math.Round(mean*100) / 100, StdDev: math.Round(stdDev*100) / 100, ZScore: math.Round(z*100) / 100, Direction: direction})
+		}
+	}
+	sort.Slice(anomalies, func(i, j int) bool {
+		if zi, zj := math.Abs(anomalies[i].ZScore), math.Abs(anomalies[j].ZScore); zi != zj {
+			return zi > zj
+		}
+		if anomalies[i].Month != anomalies[j].Month {
+			return anomalies[i].Month > anomalies[j].Month
+		}
+		return anomalies[i].Path < anomalies[j].Path
+	})
+	return anomalies
+}
+
+// handleAnomalies serves GET /anomalies?depth=2&threshold=3&months=3, the directories whose monthly
+// changes broke with their pattern within the last months of the history, the strongest first
+func handleAnomalies(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	depth := digestDirectoryDepth
+	if query.Has("depth") {
+		if depth, ok = authorDepth(w, r); !ok {
+			return
+		}
+	}
+	threshold := anomalyThreshold
+	if value := query.Get("threshold"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid threshold '%s'", value), http.StatusBadRequest)
+			return
+		}
+		threshold = parsed
+	}
+	recent := anomalyRecent
+	if value := query.Get("months"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			http.Error(w, fmt.Sprintf("Invalid months '%s'", value), http.StatusBadRequest)
+			return
+		}
+		recent = parsed
+	}
+	since := ""
+	if n := len(analysis.Snapshots); n > 0 {
+		last, _ := time.Parse("2006-01", analysis.Snapshots[n-1].Month)
+		since = last.AddDate(0, 1-recent, 0).Format("2006-01")
+	}
+	writeJSON(w, detectAnomalies(analysis, depth, threshold, since))
+}
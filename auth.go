@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookie   = "dirheat_session"
+	stateCookie     = "dirheat_oauth_state"
+	sessionLifetime = 12 * time.Hour
+)
+
+// User is the identity of a signed-in user
+type User struct {
+	Email string   `json:"email"`
+	Name  string   `json:"name,omitempty"`
+	Login string   `json:"login,omitempty"` // GitHub login
+	Orgs  []string `json:"-"`               // GitHub organizations, only fetched to check the allowlist
+}
+
+// oauthProvider describes the endpoints of an OAuth provider and how to read the user from it
+type oauthProvider struct {
+	authURL  string
+	tokenURL string
+	scope    string
+	user     func(ctx context.Context, token string) (*User, error)
+}
+
+var oauthProviders = map[string]oauthProvider{
+	"github": {
+		authURL:  "https://github.com/login/oauth/authorize",
+		tokenURL: "https://github.com/login/oauth/access_token",
+		scope:    "read:user user:email read:org",
+		user:     githubUser,
+	},
+	"google": {
+		authURL:  "https://accounts.google.com/o/oauth2/v2/auth",
+		tokenURL: "https://oauth2.googleapis.com/token",
+		scope:    "openid email profile",
+		user:     googleUser,
+	},
+}
+
+// OAuthOptions configures the optional login
+type OAuthOptions struct {
+	Provider     string // github or google, empty disables authentication
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string   // External URL of /auth/callback, as registered with the provider
+	Allow        []string // Allowed users: emails, "@domain" email domains or "org:name" GitHub organizations
+}
+
+// authenticator protects the server with an OAuth login and signed session cookies
+type authenticator struct {
+	opts     OAuthOptions
+	provider oauthProvider
+	key      []byte // Signs the session cookies, sessions don't survive a restart
+	secure   bool   // Cookies only sent over HTTPS
+}
+
+// oauthClient talks to the OAuth providers
+var oauthClient = &http.Client{Timeout: 15 * time.Second}
+
+// newAuthenticator validates opts and sets up the login
+func newAuthenticator(opts OAuthOptions) (*authenticator, error) {
+	provider, ok := oauthProviders[opts.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown OAuth provider '%s', expected github or google", opts.Provider)
+	}
+	if opts.ClientID == "" || opts.ClientSecret == "" || opts.RedirectURL == "" {
+		return nil, errors.New("-oauth-client-id, the client secret and -oauth-redirect-url are required with -oauth-provider")
+	}
+	if len(opts.Allow) == 0 {
+		return nil, errors.New("-oauth-allow is required with -oauth-provider, otherwise any account could sign in")
+	}
+	redirect, err := url.Parse(opts.RedirectURL)
+	if err != nil || redirect.Host == "" {
+		return nil, fmt.Errorf("invalid -oauth-redirect-url '%s'", opts.RedirectURL)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &authenticator{
+		opts:     opts,
+		provider: provider,
+		key:      key,
+		secure:   redirect.Scheme == "https",
+	}, nil
+}
+
+// allowed reports whether the allowlist admits user
+func (a *authenticator) allowed(user *User) bool {
+	email := strings.ToLower(user.Email)
+	for _, entry := range a.opts.Allow {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case strings.HasPrefix(entry, "org:"):
+			if slices.ContainsFunc(user.Orgs, func(org string) bool { return strings.EqualFold(org, entry[4:]) }) {
+				return true
+			}
+		case strings.HasPrefix(entry, "@"):
+			if email != "" && strings.HasSuffix(email, entry) {
+				return true
+			}
+		case entry != "" && entry == email:
+			return true
+		}
+	}
+	return false
+}
+
+// sign returns the value of a session cookie for user
+func (a *authenticator) sign(user *User, expires time.Time) string {
+	payload, _ := json.Marshal(struct {
+		*User
+		Expires int64 `json:"exp"`
+	}{user, expires.Unix()})
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + a.mac(encoded)
+}
+
+func (a *authenticator) mac(s string) string {
+	h := hmac.New(sha256.New, a.key)
+	h.Write([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// session returns the signed-in user of r, nil without a valid session cookie
+func (a *authenticator) session(r *http.Request) *User {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	encoded, mac, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(a.mac(encoded))) {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil
+	}
+	var session struct {
+		User
+		Expires int64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &session) != nil || time.Now().Unix() > session.Expires {
+		return nil
+	}
+	return &session.User
+}
+
+// setCookie sets a cookie scoped to the served path
+func (a *authenticator) setCookie(w http.ResponseWriter, name, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name: name, Value: value, Path: basePath + "/", MaxAge: maxAge,
+		HttpOnly: true, Secure: a.secure, SameSite: http.SameSiteLaxMode,
+	})
+}
+
+type userKey struct{}
+
+// currentUser returns the signed-in user of the request, nil when authentication is disabled
+func currentUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userKey{}).(*User)
+	return user
+}
+
+// wrap requires a session for everything but the health checks and the login itself. Browsers
+// opening the UI are sent to the login, API clients get 401.
+func (a *authenticator) wrap(next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/login", a.handleLogin)
+	mux.HandleFunc("/auth/callback", a.handleCallback)
+	mux.HandleFunc("/auth/logout", a.handleLogout)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz", "/readyz":
+			next.ServeHTTP(w, r)
+			return
+		case "/auth/login", "/auth/callback", "/auth/logout":
+			mux.ServeHTTP(w, r)
+			return
+		}
+		user := a.session(r)
+		if user == nil {
+			if r.Method == http.MethodGet && (r.URL.Path == "/" || strings.HasPrefix(r.URL.Path, "/v/")) {
+				http.Redirect(w, r, basePath+"/auth/login?next="+url.QueryEscape(basePath+r.URL.RequestURI()), http.StatusFound)
+				return
+			}
+			http.Error(w, "Authentication required, sign in at "+basePath+"/auth/login", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	})
+}
+
+// localRedirect returns next if it is a path below the base path of this server, the base path
+// otherwise, so the login can't redirect elsewhere. Browsers read a backslash as a slash, so
+// "/\evil.example" would leave the server like "//evil.example" does.
+func localRedirect(next string) string {
+	parsed, err := url.Parse(next)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" || parsed.User != nil ||
+		strings.ContainsRune(next, '\\') || strings.HasPrefix(next, "//") || !strings.HasPrefix(next, basePath+"/") {
+		return basePath + "/"
+	}
+	return next
+}
+
+// handleLogin serves /auth/login, redirecting to the provider
+func (a *authenticator) handleLogin(w http.ResponseWriter, r *http.Request) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, "Error starting login", http.StatusInternalServerError)
+		return
+	}
+	state := base64.RawURLEncoding.EncodeToString(b)
+	next := localRedirect(r.URL.Query().Get("next"))
+	a.setCookie(w, stateCookie, state+"|"+next, 600)
+
+	query := url.Values{
+		"client_id":     {a.opts.ClientID},
+		"redirect_uri":  {a.opts.RedirectURL},
+		"scope":         {a.provider.scope},
+		"state":         {state},
+		"response_type": {"code"},
+	}
+	http.Redirect(w, r, a.provider.authURL+"?"+query.Encode(), http.StatusFound)
+}
+
+// handleCallback serves /auth/callback: it exchanges the code, checks the allowlist and starts the session
+func (a *authenticator) handleCallback(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(stateCookie)
+	state, next, _ := strings.Cut(cookieValue(cookie, err), "|")
+	if state == "" || r.URL.Query().Get("state") != state {
+		http.Error(w, "Invalid login state, start over at "+basePath+"/auth/login", http.StatusBadRequest)
+		return
+	}
+	a.setCookie(w, stateCookie, "", -1)
+
+	token, err := a.exchange(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Login failed: %v", err), http.StatusBadGateway)
+		return
+	}
+	user, err := a.provider.user(r.Context(), token)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Login failed: %v", err), http.StatusBadGateway)
+		return
+	}
+	if !a.allowed(user) {
+		http.Error(w, fmt.Sprintf("Access denied for %s", user.Email), http.StatusForbidden)
+		return
+	}
+	user.Orgs = nil
+	a.setCookie(w, sessionCookie, a.sign(user, time.Now().Add(sessionLifetime)), int(sessionLifetime.Seconds()))
+	http.Redirect(w, r, next, http.StatusFound)
+}
+
+// handleLogout serves /auth/logout, ending the session
+func (a *authenticator) handleLogout(w http.ResponseWriter, r *http.Request) {
+	a.setCookie(w, sessionCookie, "", -1)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "Signed out.")
+}
+
+// handleMe serves GET /auth/me, the signed-in user (404 without authentication)
+func handleMe(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		http.Error(w, "Authentication is disabled", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, user)
+}
+
+func cookieValue(cookie *http.Cookie, err error) string {
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// exchange trades an authorization code for an access token
+func (a *authenticator) exchange(ctx context.Context, code string) (string, error) {
+	if code == "" {
+		return "", errors.New("no authorization code")
+	}
+	form := url.Values{
+		"client_id":     {a.opts.ClientID},
+		"client_secret": {a.opts.ClientSecret},
+		"code":          {code},
+		"redirect_uri":  {a.opts.RedirectURL},
+		"grant_type":    {"authorization_code"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.provider.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	var response struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := doJSON(req, &response); err != nil {
+		return "", fmt.Errorf("error exchanging the code: %w", err)
+	}
+	if response.AccessToken == "" {
+		return "", fmt.Errorf("no access token received (%s)", response.Error)
+	}
+	return response.AccessToken, nil
+}
+
+// doJSON sends req and decodes the JSON response into v
+func doJSON(req *http.Request, v any) error {
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", req.URL.Host, resp.Status)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// providerGet fetches a JSON API resource with the access token
+func providerGet(ctx context.Context, apiURL, token string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	return doJSON(req, v)
+}
+
+// githubUser reads the GitHub account with its primary verified email and organizations
+func githubUser(ctx context.Context, token string) (*User, error) {
+	var account struct {
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := providerGet(ctx, "https://api.github.com/user", token, &account); err != nil {
+		return nil, err
+	}
+	user := &User{Login: account.Login, Name: account.Name}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := providerGet(ctx, "https://api.github.com/user/emails", token, &emails); err != nil {
+		return nil, err
+	}
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			user.Email = email.Email
+		}
+	}
+
+	var orgs []struct {
+		Login string `json:"login"`
+	}
+	if err := providerGet(ctx, "https://api.github.com/user/orgs?per_page=100", token, &orgs); err != nil {
+		return nil, err
+	}
+	for _, org := range orgs {
+		user.Orgs = append(user.Orgs, org.Login)
+	}
+	return user, nil
+}
+
+// googleUser reads the Google account, only accepting a verified email
+func googleUser(ctx context.Context, token string) (*User, error) {
+	var info struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := providerGet(ctx, "https://openidconnect.googleapis.com/v1/userinfo", token, &info); err != nil {
+		return nil, err
+	}
+	user := &User{Name: info.Name}
+	if info.EmailVerified {
+		user.Email = info.Email
+	}
+	return user, nil
+}
+
+// oauthClientSecret returns the client secret, preferring the environment over the command line
+// where it would show up in the process list
+func oauthClientSecret(flagValue string) string {
+	if secret := os.Getenv("DIRHEAT_OAUTH_CLIENT_SECRET"); secret != "" {
+		return secret
+	}
+	return flagValue
+}
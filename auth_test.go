@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestLocalRedirect(t *testing.T) {
+	tests := []struct {
+		basePath string
+		next     string
+		want     string
+	}{
+		{"", "/files?limit=10", "/files?limit=10"},
+		{"", "/", "/"},
+		{"", "", "/"},
+		{"", "//evil.example/", "/"},
+		{"", `/\evil.example/`, "/"},
+		{"", `\\evil.example`, "/"},
+		{"", "https://evil.example/", "/"},
+		{"", "javascript:alert(1)", "/"},
+		{"", "/\t/evil.example", "/"},
+		{"", "files", "/"},
+		{"/dirheat", "/dirheat/data", "/dirheat/data"},
+		{"/dirheat", "/data", "/dirheat/"},
+		{"/dirheat", "/dirheat//evil.example", "/dirheat//evil.example"}, // A path below the base path
+		{"/dirheat", `/dirheat/\evil.example`, "/dirheat/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.basePath+" "+tt.next, func(t *testing.T) {
+			defer func(saved string) { basePath = saved }(basePath)
+			basePath = tt.basePath
+			if got := localRedirect(tt.next); got != tt.want {
+				t.Errorf("localRedirect(%q) = %q, want %q", tt.next, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultAuthorDepth is how many directory levels are considered for author expertise
+const defaultAuthorDepth = 2
+
+// DirectoryActivity is an author's activity within one directory
+type DirectoryActivity struct {
+	Path         string    `json:"path"`
+	Commits      int       `json:"commits"`
+	LinesChanged int       `json:"linesChanged"`
+	LastTouched  time.Time `json:"lastTouched"`
+}
+
+// AuthorSummary describes a contributor and the directories they touched most
+type AuthorSummary struct {
+	Name         string              `json:"name"`
+	Email        string              `json:"email"`
+	Commits      int                 `json:"commits"`
+	LinesChanged int                 `json:"linesChanged"`
+	FirstCommit  time.Time           `json:"firstCommit"`
+	LastCommit   time.Time           `json:"lastCommit"`
+	Directories  []DirectoryActivity `json:"directories"` // Most touched first
+}
+
+// directoriesOf returns the directories containing the file, outermost first, up to depth levels
+func directoriesOf(file string, depth int) []string {
+	parts := strings.Split(file, "/")
+	var dirs []string
+	for i := 1; i < len(parts) && i <= depth; i++ {
+		dirs = append(dirs, strings.Join(parts[:i], "/"))
+	}
+	return dirs
+}
+
+// authorSummaries aggregates the analyzed commits per author (identified by email),
+// ordered by number of commits
+func authorSummaries(a *Analysis, depth int) []*AuthorSummary {
+	authors := make(map[string]*AuthorSummary)
+	activity := make(map[string]map[string]*DirectoryActivity)
+
+	for _, commit := range a.Commits {
+		key := strings.ToLower(commit.Email)
+		author, ok := authors[key]
+		if !ok {
+			// Commits are newest first, so the first one seen carries the current name
+			author = &AuthorSummary{Name: commit.Author, Email: commit.Email, LastCommit: commit.Date}
+			authors[key] = author
+			activity[key] = make(map[string]*DirectoryActivity)
+		}
+		author.Commits++
+		author.FirstCommit = commit.Date
+
+		touched := make(map[string]int)
+		for _, file := range commit.Files {
+			lines := file.Added + file.Deleted
+			author.LinesChanged += lines
+			for _, dir := range directoriesOf(file.Path, depth) {
+				touched[dir] += lines
+			}
+		}
+		for dir, lines := range touched {
+			dirActivity, ok := activity[key][dir]
+			if !ok {
+				dirActivity = &DirectoryActivity{Path: dir, LastTouched: commit.Date}
+				activity[key][dir] = dirActivity
+			}
+			dirActivity.Commits++
+			dirActivity.LinesChanged += lines
+		}
+	}
+
+	summaries := make([]*AuthorSummary, 0, len(authors))
+	for key, author := range authors {
+		author.Directories = make([]DirectoryActivity, 0, len(activity[key]))
+		for _, dirActivity := range activity[key] {
+			author.Directories = append(author.Directories, *dirActivity)
+		}
+		sort.Slice(author.Directories, func(i, j int) bool {
+			a, b := author.Directories[i], author.Directories[j]
+			if a.Commits != b.Commits {
+				return a.Commits > b.Commits
+			}
+			return a.Path < b.Path
+		})
+		summaries = append(summaries, author)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Commits != summaries[j].Commits {
+			return summaries[i].Commits > summaries[j].Commits
+		}
+		return summaries[i].Name < summaries[j].Name
+	})
+	return summaries
+}
+
+// authorDepth reads the depth query parameter, writing an error response if it is invalid
+func authorDepth(w http.ResponseWriter, r *http.Request) (int, bool) {
+	depth := defaultAuthorDepth
+	if value := r.URL.Query().Get("depth"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			http.Error(w, fmt.Sprintf("Invalid depth '%s'", value), http.StatusBadRequest)
+			return 0, false
+		}
+		depth = parsed
+	}
+	return depth, true
+}
+
+// handleAuthors serves GET /authors, every contributor with their five most touched directories
+func handleAuthors(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	depth, ok := authorDepth(w, r)
+	if !ok {
+		return
+	}
+
+	summaries := authorSummaries(analysis, depth)
+	for _, summary := range summaries {
+		summary.Directories = summary.Directories[:min(len(summary.Directories), 5)]
+	}
+	writeJSON(w, summaries)
+}
+
+// handleAuthor serves GET /authors/{name}, matching the author by name or email (case-insensitive)
+func handleAuthor(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	depth, ok := authorDepth(w, r)
+	if !ok {
+		return
+	}
+
+	name := r.PathValue("name")
+	for _, summary := range authorSummaries(analysis, depth) {
+		if strings.EqualFold(summary.Name, name) || strings.EqualFold(summary.Email, name) {
+			writeJSON(w, summary)
+			return
+		}
+	}
+	http.Error(w, fmt.Sprintf("Author '%s' not found", name), http.StatusNotFound)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BlameOptions configures the blame based "current heat" mode
+type BlameOptions struct {
+	Window   time.Duration // Only surviving lines written within this window count, 0 counts all lines
+	Author   string        // Only lines by authors whose name or email contains this (case-insensitive)
+	Workers  int           // Concurrent git blame processes
+	CacheDir string        // Directory for persisted blame results, "" disables the disk cache
+}
+
+// BlameCommit is the number of surviving lines a file owes to one commit
+type BlameCommit struct {
+	Hash   string    `json:"sha"`
+	Author string    `json:"author"`
+	Email  string    `json:"email"`
+	Time   time.Time `json:"time"`
+	Lines  int       `json:"lines"`
+}
+
+// BlameResult is the blame of a single file at HEAD, aggregated per commit
+type BlameResult struct {
+	Path    string        `json:"path"`
+	Commits []BlameCommit `json:"commits"`
+}
+
+// blameRunner runs git blame with bounded concurrency, caching results in memory and on disk.
+// Results are keyed by the repository, the blamed commit, the file's path and its blob id: the
+// cache directory is shared by all repositories, which may hold the same content with different
+// histories, and the analysis' filters (merges, excluded paths, time windows) don't change the blame.
+type blameRunner struct {
+	repo     string
+	revision string // Commit whose files are blamed
+	workers  int
+	cacheDir string
+
+	mu    sync.Mutex
+	cache map[string]*BlameResult
+}
+
+// newBlameRunner creates a blame runner for the files of the repository at revision
+func newBlameRunner(repo, revision string, opts BlameOptions) *blameRunner {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	return &blameRunner{repo: repo, revision: revision, workers: workers, cacheDir: opts.CacheDir, cache: make(map[string]*BlameResult)}
+}
+
+// cacheKey identifies the blame of path with the content of blob at the runner's repository and revision
+func (b *blameRunner) cacheKey(path, blob string) string {
+	sum := sha1.Sum([]byte(absRepoPath(b.repo) + "\x00" + b.revision + "\x00" + blob + "\x00" + path))
+	return hex.EncodeToString(sum[:])
+}
+
+// blobIDs returns the blob id of every file tracked at revision by path; submodules are left out
+func blobIDs(ctx context.Context, repo, revision string) (map[string]string, error) {
+	output, err := gitRun(ctx, repo, "ls-tree", "-r", "-z", revision)
+	if err != nil {
+		return nil, err
+	}
+	blobs := make(map[string]string)
+	for _, entry := range nulSeparated(output) {
+		// <mode> SP <type> SP <object> TAB <path>
+		info, path, ok := strings.Cut(entry, "\t")
+		if fields := strings.Fields(info); ok && len(fields) == 3 && fields[1] == "blob" {
+			blobs[path] = fields[2]
+		}
+	}
+	return blobs, nil
+}
+
+// blame returns the blame of the file at path, whose content is blob, using the caches when possible
+func (b *blameRunner) blame(ctx context.Context, path, blob string) (*BlameResult, error) {
+	key := b.cacheKey(path, blob)
+	b.mu.Lock()
+	result, ok := b.cache[key]
+	b.mu.Unlock()
+	if ok {
+		return result, nil
+	}
+
+	cacheFile := ""
+	if b.cacheDir != "" {
+		cacheFile = filepath.Join(b.cacheDir, key+".json")
+		if data, err := os.ReadFile(cacheFile); err == nil {
+			result = &BlameResult{}
+			if err := json.Unmarshal(data, result); err == nil {
+				b.store(key, result)
+				now := time.Now()
+				os.Chtimes(cacheFile, now, now) // Marks the file as recently used for -retention-disk
+				return result, nil
+			}
+		}
+	}
+
+	result, err := runBlame(ctx, b.repo, b.revision, path)
+	if err != nil {
+		return nil, err
+	}
+	b.store(key, result)
+	if cacheFile != "" {
+		if data, err := json.Marshal(result); err == nil {
+			if err := os.MkdirAll(b.cacheDir, 0o755); err == nil {
+				if err := os.WriteFile(cacheFile, data, 0o644); err != nil {
+					slog.Warn("Could not write blame cache", "file", cacheFile, "error", err)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+func (b *blameRunner) store(key string, result *BlameResult) {
+	b.mu.Lock()
+	b.cache[key] = result
+	b.mu.Unlock()
+}
+
+// blameAll blames the given files (path -> blob id) concurrently.
+// Files that can't be blamed are logged and left out; once ctx is done no further files are blamed.
+func (b *blameRunner) blameAll(ctx context.Context, files map[string]string) map[string]*BlameResult {
+	jobs := make(chan string)
+	results := make(map[string]*BlameResult, len(files))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < b.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				result, err := b.blame(ctx, path, files[path])
+				if ctx.Err() != nil {
+					continue // Drain the remaining jobs
+				}
+				if err != nil {
+					slog.Warn("Could not blame file", "path", path, "error", err)
+					continue
+				}
+				mu.Lock()
+				results[path] = result
+				if len(results)%500 == 0 {
+					slog.Info("Blame progress", "blamed", len(results), "files", len(files))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for path := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- path
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// runBlame runs git blame --porcelain on the file at revision and aggregates the lines per commit
+func runBlame(ctx context.Context, repo, revision, path string) (*BlameResult, error) {
+	output, err := gitRun(ctx, repo, "blame", "--porcelain", revision, "--", path)
+	if err != nil {
+		return nil, err
+	}
+
+	commits := make(map[string]*BlameCommit)
+	var order []string
+	var current *BlameCommit
+	scanner := newLineScanner(output) // Source lines can be arbitrarily long
+	lineNumber := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNumber++
+		if strings.HasPrefix(line, "\t") {
+			if current != nil {
+				current.Lines++
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && isHash(fields[0]) {
+			var ok bool
+			if current, ok = commits[fields[0]]; !ok {
+				current = &BlameCommit{Hash: fields[0]}
+				commits[fields[0]] = current
+				order = append(order, fields[0])
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "author "); ok {
+			current.Author = value
+		} else if value, ok := strings.CutPrefix(line, "author-mail "); ok {
+			current.Email = strings.Trim(value, "<>")
+		} else if value, ok := strings.CutPrefix(line, "author-time "); ok {
+			if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+				current.Time = time.Unix(seconds, 0).UTC()
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &ParseError{Source: "git blame " + path, Line: lineNumber + 1, Err: err}
+	}
+
+	result := &BlameResult{Path: path, Commits: make([]BlameCommit, 0, len(order))}
+	for _, hash := range order {
+		result.Commits = append(result.Commits, *commits[hash])
+	}
+	return result, nil
+}
+
+// isHash reports whether s looks like a full SHA-1 or SHA-256 object name
+func isHash(s string) bool {
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// survivingLines counts the blamed lines matching the window and author options
+func (r *BlameResult) survivingLines(opts BlameOptions, now time.Time) int {
+	author := strings.ToLower(opts.Author)
+	lines := 0
+	for _, commit := range r.Commits {
+		if opts.Window > 0 && commit.Time.Before(now.Add(-opts.Window)) {
+			continue
+		}
+		if author != "" && !strings.Contains(strings.ToLower(commit.Author), author) &&
+			!strings.Contains(strings.ToLower(commit.Email), author) {
+			continue
+		}
+		lines += commit.Lines
+	}
+	return lines
+}
+
+// applyBlameHeat replaces the churn tree of the analysis with one where each file's value is the
+// number of its surviving lines at HEAD matching the blame options
+func applyBlameHeat(ctx context.Context, a *Analysis, opts BlameOptions) error {
+	if a.Meta.PartialClone != "" {
+		prefetchBlobs(ctx, a.Meta.RepoPath, a.Meta.PartialClone)
+	}
+	files, err := blobIDs(ctx, a.Meta.RepoPath, a.Meta.Revision)
+	if err != nil {
+		return fmt.Errorf("error listing files at HEAD: %w", err)
+	}
+
+	slog.Info("Blaming files at HEAD", "files", len(files), "workers", max(opts.Workers, 1))
+	results := newBlameRunner(a.Meta.RepoPath, a.Meta.Revision, opts).blameAll(ctx, files)
+	if ctx.Err() != nil {
+		return fmt.Errorf("blame aborted: %w", ctx.Err())
+	}
+
+	now := time.Now()
+	values := make(map[string]int, len(results))
+	for path, result := range results {
+		values[path] = result.survivingLines(opts, now)
+	}
+	a.Root = buildTree(ctx, a.Meta.RepoPath, values)
+
+	a.Meta.Filters["mode"] = "blame"
+	if opts.Window > 0 {
+		a.Meta.Filters["blameWindow"] = opts.Window.String()
+	}
+	if opts.Author != "" {
+		a.Meta.Filters["blameAuthor"] = opts.Author
+	}
+	return nil
+}
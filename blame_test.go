@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBlameCacheRepositories(t *testing.T) {
+	ctx := context.Background()
+	// The same file content, committed on top of different histories
+	first, second := initRepo(t), initRepo(t)
+	commitFiles(t, first, map[string]string{"shared.txt": "shared\n"})
+	commitFiles(t, second, map[string]string{"other.txt": "other\n"})
+	commitFiles(t, second, map[string]string{"shared.txt": "shared\n"})
+
+	opts := BlameOptions{Workers: 1, CacheDir: t.TempDir()}
+	for _, repo := range []string{first, second, first} {
+		head, err := resolveCommit(ctx, repo, "HEAD")
+		if err != nil {
+			t.Fatal(err)
+		}
+		blobs, err := blobIDs(ctx, repo, head)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := newBlameRunner(repo, head, opts).blame(ctx, "shared.txt", blobs["shared.txt"])
+		if err != nil {
+			t.Fatalf("blame() error: %v", err)
+		}
+		if len(result.Commits) != 1 || result.Commits[0].Hash != head {
+			t.Errorf("blame of %s = %+v, want its lines from %s", repo, result.Commits, head)
+		}
+	}
+}
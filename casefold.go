@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// caseFold (-case-fold) merges paths that differ only in case, like case-insensitive file systems
+// (Windows, macOS) see them. Without it they are separate nodes on every platform, as git keeps them.
+var caseFold bool
+
+// caseSpellings maps the lower-cased paths and directory prefixes of the history to their
+// spellings, the newest first
+type caseSpellings map[string][]string
+
+// collectSpellings records the spellings of every path and directory touched by the commits
+func collectSpellings(commits []*Commit) caseSpellings {
+	spellings := make(caseSpellings)
+	seen := make(map[string]bool)
+	for _, commit := range commits { // Newest first
+		for _, file := range commit.Files {
+			prefix := file.Path
+			for {
+				if seen[prefix] {
+					break // Its directories were recorded along with it
+				}
+				seen[prefix] = true
+				key := strings.ToLower(prefix)
+				spellings[key] = append(spellings[key], prefix)
+				slash := strings.LastIndexByte(prefix, '/')
+				if slash < 0 {
+					break
+				}
+				prefix = prefix[:slash]
+			}
+		}
+	}
+	return spellings
+}
+
+// collisions returns the paths with several spellings, sorted
+func (s caseSpellings) collisions() []string {
+	var paths []string
+	for _, spellings := range s {
+		if len(spellings) > 1 {
+			paths = append(paths, strings.Join(spellings, " | "))
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// canonical returns the newest spelling of every directory level of the path
+func (s caseSpellings) canonical(path string) string {
+	parts := strings.Split(path, "/")
+	for i := range parts {
+		if spellings := s[strings.ToLower(strings.Join(parts[:i+1], "/"))]; len(spellings) > 0 {
+			canonical := spellings[0]
+			parts[i] = canonical[strings.LastIndexByte(canonical, '/')+1:]
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// checkCaseCollisions warns about paths differing only in case, which case-insensitive file systems
+// can't check out side by side, and with -case-fold merges them under their newest spelling
+func checkCaseCollisions(repo string, counts map[string]int, commits []*Commit) map[string]int {
+	spellings := collectSpellings(commits)
+	collisions := spellings.collisions()
+	if len(collisions) == 0 {
+		return counts
+	}
+	if !caseFold {
+		slog.Warn("Paths differ only in case and are shown as separate nodes, use -case-fold to merge them",
+			"repo", repo, "paths", len(collisions), "examples", collisions[:min(3, len(collisions))])
+		return counts
+	}
+	slog.Info("Merging paths that differ only in case", "repo", repo, "paths", len(collisions))
+	folded := make(map[string]int, len(counts))
+	for path, count := range counts {
+		folded[spellings.canonical(treePath(path))] += count
+	}
+	for _, commit := range commits {
+		for i := range commit.Files {
+			commit.Files[i].Path = spellings.canonical(commit.Files[i].Path)
+		}
+	}
+	return folded
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testHistory returns the commits of the repository's history and the change count per file
+func testHistory(t *testing.T, repo string) ([]*Commit, map[string]int) {
+	t.Helper()
+	list, counts := &commitList{}, newChangeCounts()
+	if err := streamHistory(context.Background(), repo, "HEAD", list, counts); err != nil {
+		t.Fatalf("streaming the history: %v", err)
+	}
+	return list.commits, counts.result()
+}
+
+// commitsOf returns a history of one commit per list of changed paths, newest first
+func commitsOf(paths ...[]string) []*Commit {
+	var commits []*Commit
+	for _, files := range paths {
+		commit := &Commit{Weight: 1}
+		for _, path := range files {
+			commit.Files = append(commit.Files, FileChange{Path: path, Added: 1})
+		}
+		commits = append(commits, commit)
+	}
+	return commits
+}
+
+func TestCollectSpellings(t *testing.T) {
+	tests := []struct {
+		name    string
+		commits []*Commit
+		want    caseSpellings
+	}{
+		{
+			name:    "no commits",
+			commits: nil,
+			want:    caseSpellings{},
+		},
+		{
+			name:    "one spelling per path and directory",
+			commits: commitsOf([]string{"src/main.go", "README.md"}),
+			want:    caseSpellings{"src": {"src"}, "src/main.go": {"src/main.go"}, "readme.md": {"README.md"}},
+		},
+		{
+			name:    "repeated changes record a spelling once",
+			commits: commitsOf([]string{"src/main.go"}, []string{"src/main.go", "src/util.go"}),
+			want:    caseSpellings{"src": {"src"}, "src/main.go": {"src/main.go"}, "src/util.go": {"src/util.go"}},
+		},
+		{
+			name:    "spellings newest first",
+			commits: commitsOf([]string{"Src/Main.go"}, []string{"src/main.go"}),
+			want:    caseSpellings{"src": {"Src", "src"}, "src/main.go": {"Src/Main.go", "src/main.go"}},
+		},
+		{
+			name:    "directory renamed in case, file names kept",
+			commits: commitsOf([]string{"Docs/api/index.md"}, []string{"docs/api/index.md"}),
+			want: caseSpellings{
+				"docs":              {"Docs", "docs"},
+				"docs/api":          {"Docs/api", "docs/api"},
+				"docs/api/index.md": {"Docs/api/index.md", "docs/api/index.md"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := collectSpellings(tt.commits)
+			if !maps.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("collectSpellings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	spellings := collectSpellings(commitsOf(
+		[]string{"Src/Main.go", "docs/Guide.md"},
+		[]string{"src/main.go", "src/util.go", "Docs/guide.md"},
+	))
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"src/main.go", "Src/Main.go"},
+		{"SRC/MAIN.GO", "Src/Main.go"},
+		{"src/util.go", "Src/util.go"}, // The directory takes its newest spelling, the file keeps its own
+		{"Docs/guide.md", "docs/Guide.md"},
+		{"src/new.go", "Src/new.go"}, // Unknown levels are kept as they are
+		{"other/file.go", "other/file.go"},
+		{"src", "Src"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := spellings.canonical(tt.path); got != tt.want {
+				t.Errorf("canonical(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckCaseCollisions(t *testing.T) {
+	clean := testRepo(t)
+	_, counts := testHistory(t, clean)
+	var dir string // A generated directory, to add a differently cased spelling of
+	for path := range counts {
+		if d, _, ok := strings.Cut(path, "/"); ok && (dir == "" || d < dir) {
+			dir = d
+		}
+	}
+	if dir == "" {
+		t.Fatal("the test repository has no directory")
+	}
+	variant := strings.ToUpper(dir[:1]) + dir[1:]
+	colliding := testRepo(t)
+	commitFiles(t, colliding, map[string]string{variant + "/added.txt": "added\n"})
+
+	tests := []struct {
+		name       string
+		repo       string
+		caseFold   bool
+		wantLower  bool // Paths below the generated spelling remain
+		wantUpper  bool // Paths below the variant spelling remain
+		wantMerged bool // The commits' paths are rewritten to the variant spelling
+	}{
+		{name: "no collisions", repo: clean, caseFold: true, wantLower: true},
+		{name: "collisions kept apart", repo: colliding, caseFold: false, wantLower: true, wantUpper: true},
+		{name: "collisions merged", repo: colliding, caseFold: true, wantUpper: true, wantMerged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(saved bool) { caseFold = saved }(caseFold)
+			caseFold = tt.caseFold
+			commits, counts := testHistory(t, tt.repo)
+			total := totalChanges(counts)
+			got := checkCaseCollisions(tt.repo, counts, commits)
+
+			if totalChanges(got) != total {
+				t.Errorf("total changes = %d, want %d", totalChanges(got), total)
+			}
+			lower, upper := below(got, dir), below(got, variant)
+			if lower != tt.wantLower || upper != tt.wantUpper {
+				t.Errorf("paths below %s: %v, below %s: %v, want %v and %v", dir, lower, variant, upper, tt.wantLower, tt.wantUpper)
+			}
+			for _, commit := range commits {
+				for _, file := range commit.Files {
+					if tt.wantMerged && strings.HasPrefix(file.Path, dir+"/") {
+						t.Errorf("commit file %q not merged into %s", file.Path, variant)
+					}
+				}
+			}
+		})
+	}
+}
+
+// below reports whether any of the counted paths is below the directory
+func below(counts map[string]int, dir string) bool {
+	for path := range counts {
+		if strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// totalChanges returns the sum of the change counts
+func totalChanges(counts map[string]int) int {
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	return total
+}
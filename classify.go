@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Test ratio thresholds
+const (
+	undertestedRatio = 0.2 // Directories whose test churn is below this share of their source churn...
+	undertestedMin   = 10  // ...and with at least this many source changes are flagged
+)
+
+// defaultTestPatterns are the file patterns of tests used without -test-patterns
+const defaultTestPatterns = "_test.go,/test/,/tests/,/__tests__/,.spec.ts,.test.ts,.spec.js,.test.js,test_*.py,*_test.py,Test*.java,*Test.java"
+
+// fileCategory is a category of files and the patterns of its files
+type fileCategory struct {
+	name     string
+	patterns []string
+}
+
+// dependencyManifests are the patterns of the dependency manifests and lock files
+const dependencyManifests = "/go.mod,/go.sum,/package.json,/package-lock.json,/yarn.lock,/pnpm-lock.yaml,requirements*.txt,/Pipfile,/Pipfile.lock," +
+	"/pyproject.toml,/poetry.lock,/Cargo.toml,/Cargo.lock,/Gemfile,/Gemfile.lock,/pom.xml,/build.gradle,/build.gradle.kts,/gradle.lockfile," +
+	"/composer.json,/composer.lock,*.csproj,/packages.config,/mix.exs,/mix.lock"
+
+// fileCategories are checked in order, the first matching category wins; other files are "source".
+// CI, dependencies and infrastructure precede docs and config, whose patterns would claim pipeline
+// definitions, package.json, requirements.txt or Kubernetes YAML.
+var fileCategories = []fileCategory{
+	{name: "test", patterns: strings.Split(defaultTestPatterns, ",")},
+	{name: "ci", patterns: []string{"/.github/workflows/", "/.circleci/", "/.buildkite/", ".gitlab-ci.yml", ".travis.yml", "azure-pipelines.yml", "bitbucket-pipelines.yml", "Jenkinsfile"}},
+	{name: "dependencies", patterns: strings.Split(dependencyManifests, ",")},
+	{name: "docs", patterns: []string{"/docs/", "/doc/", ".md", ".rst", ".adoc", ".txt", "README*", "LICENSE*", "CHANGELOG*"}},
+	{name: "infrastructure", patterns: []string{"*.tf", "*.tfvars", "*.hcl", "/terraform/", "/pulumi/", "/ansible/",
+		"/helm/", "/charts/", "/Chart.yaml", "/values.yaml", "/kustomization.yaml", "/k8s/", "/kubernetes/", "/manifests/", "/deploy/",
+		"/Dockerfile", "*.dockerfile", "docker-compose*.yml", "docker-compose*.yaml"}},
+	{name: "config", patterns: []string{".yml", ".yaml", ".json", ".toml", ".ini", ".cfg", ".conf", ".properties", ".env"}},
+}
+
+// groupBy is the -group-by option: "category" groups the /data tree by file category
+var groupBy string
+
+// matchesPattern reports whether the slash separated path matches a file pattern: "/dir/" matches
+// a directory anywhere in the path, a pattern with wildcards matches the file name (path.Match),
+// anything else the end of the path ("_test.go", "/go.mod" for exactly that file name)
+func matchesPattern(file, pattern string) bool {
+	switch {
+	case strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/"):
+		return strings.Contains("/"+file, pattern)
+	case strings.ContainsAny(pattern, "*?["):
+		matched, _ := path.Match(pattern, path.Base(file))
+		return matched
+	default:
+		return strings.HasSuffix("/"+file, pattern)
+	}
+}
+
+// categoryOf returns the category of the file
+func categoryOf(file string) string {
+	for _, category := range fileCategories {
+		for _, pattern := range category.patterns {
+			if pattern != "" && matchesPattern(file, pattern) {
+				return category.name
+			}
+		}
+	}
+	return "source"
+}
+
+// categoryAnalysis returns the tree of the analysis regrouped with the file category as first path
+// segment, so the root's children show how the change energy splits between code, tests, docs,
+// config and CI
+func categoryAnalysis(a *Analysis) *Analysis {
+	return regroupAnalysis(a, func(file string) string { return categoryOf(file) + "/" + file })
+}
+
+// regroupAnalysis returns the tree of the analysis with every file moved to the path move returns
+// for it, keeping its value and metrics. Files moved to the same path add up.
+func regroupAnalysis(a *Analysis, move func(file string) string) *Analysis {
+	values := make(map[string]int)
+	fileMetrics := nodeMetrics(a)
+	metrics := make(map[string]map[string]float64)
+	var collect func(n Node)
+	collect = func(n Node) {
+		if n.IsFile() {
+			file := n.relPath()
+			path := move(file)
+			values[path] += n.Value()
+			if entry := fileMetrics[file]; entry != nil {
+				metrics[path] = entry
+			}
+		}
+		for child := range n.Children() {
+			collect(child)
+		}
+	}
+	collect(a.Root)
+	root := populateTree(a.Root.Name(), values)
+	root.aggregateCounts()
+	return &Analysis{Root: root, Meta: a.Meta, Commits: a.Commits, metrics: metrics}
+}
+
+// fileAnalysis returns the tree of the files kept by keep, with their values
+func fileAnalysis(a *Analysis, keep func(path string) bool) *Analysis {
+	values := make(map[string]int)
+	var collect func(n Node)
+	collect = func(n Node) {
+		if n.IsFile() && n.Value() > 0 {
+			if file := n.relPath(); keep(file) {
+				values[file] = n.Value()
+			}
+		}
+		for child := range n.Children() {
+			collect(child)
+		}
+	}
+	collect(a.Root)
+	root := populateTree(a.Root.Name(), values)
+	root.aggregateCounts()
+	return &Analysis{Root: root, Meta: a.Meta}
+}
+
+// handleCategoryFilterData serves GET /data?category=infrastructure, the tree of the files of the
+// comma separated categories. It takes the /data query parameters.
+func handleCategoryFilterData(w http.ResponseWriter, r *http.Request, a *Analysis) {
+	categories := make(map[string]bool)
+	for _, name := range splitParam(r.URL.Query().Get("category")) {
+		known := name == "source"
+		for _, category := range fileCategories {
+			known = known || category.name == name
+		}
+		if !known {
+			http.Error(w, fmt.Sprintf("Unknown category '%s'", name), http.StatusBadRequest)
+			return
+		}
+		categories[name] = true
+	}
+	filters := map[string]string{"category": r.URL.Query().Get("category")}
+	if mode := a.Meta.Filters["mode"]; mode != "" {
+		filters["mode"] = mode
+	}
+	writeCommitData(w, r, fileAnalysis(a, func(path string) bool { return categories[categoryOf(path)] }), filters)
+}
+
+// handleCategoryData serves GET /data?groupBy=category (the default with -group-by category), the
+// tree grouped by file category. It takes the /data query parameters.
+func handleCategoryData(w http.ResponseWriter, r *http.Request, a *Analysis) {
+	filters := map[string]string{"groupBy": "category"}
+	if mode := a.Meta.Filters["mode"]; mode != "" {
+		filters["mode"] = mode
+	}
+	writeCommitData(w, r, categoryAnalysis(a), filters)
+}
+
+// TestRatio compares the test and source churn of a directory
+type TestRatio struct {
+	Path        string  `json:"path"`
+	TestChurn   int     `json:"testChurn"`   // Changes to test files
+	SourceChurn int     `json:"sourceChurn"` // Changes to other files
+	Ratio       float64 `json:"ratio"`       // Test churn per source change, 0 without source churn
+	Undertested bool    `json:"undertested"` // Changes a lot without corresponding test changes
+}
+
+// testRatios returns the test to source churn ratio of every directory up to depth levels, the
+// undertested directories with the most source churn first
+func testRatios(a *Analysis, depth int) []TestRatio {
+	dirs := make(map[string]*TestRatio)
+	categories := make(map[string]string)
+	for _, commit := range a.Commits {
+		for _, file := range commit.Files {
+			category, ok := categories[file.Path]
+			if !ok {
+				category = categoryOf(file.Path)
+				categories[file.Path] = category
+			}
+			for _, dir := range directoriesOf(file.Path, depth) {
+				entry := dirs[dir]
+				if entry == nil {
+					entry = &TestRatio{Path: dir}
+					dirs[dir] = entry
+				}
+				if category == "test" {
+					entry.TestChurn++
+				} else if category == "source" {
+					entry.SourceChurn++
+				}
+			}
+		}
+	}
+
+	list := make([]TestRatio, 0, len(dirs))
+	for _, entry := range dirs {
+		if entry.SourceChurn > 0 {
+			entry.Ratio = float64(entry.TestChurn) / float64(entry.SourceChurn)
+			entry.Undertested = entry.SourceChurn >= undertestedMin && entry.Ratio < undertestedRatio
+		}
+		list = append(list, *entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Undertested != list[j].Undertested {
+			return list[i].Undertested
+		}
+		if list[i].SourceChurn != list[j].SourceChurn {
+			return list[i].SourceChurn > list[j].SourceChurn
+		}
+		return list[i].Path < list[j].Path
+	})
+	return list
+}
+
+// handleTestRatio serves GET /test-ratio?depth=2, the test to source churn ratio per directory
+func handleTestRatio(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	depth, ok := authorDepth(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, testRatios(analysis, depth))
+}
+
+// DependencyChurn is how often the dependency manifests of a directory changed, per month
+type DependencyChurn struct {
+	Path       string       `json:"path"`
+	Changes    int          `json:"changes"` // Commits changing a manifest or lock file below the directory
+	LastChange time.Time    `json:"lastChange"`
+	Months     []TimeBucket `json:"months"` // Oldest first, months without changes are omitted
+}
+
+// dependencyChurn returns the dependency manifest changes of every directory up to depth levels,
+// those changed most often first
+func dependencyChurn(a *Analysis, depth int) []DependencyChurn {
+	dirs := make(map[string]*DependencyChurn)
+	months := make(map[string]map[string]*TimeBucket)
+	for _, commit := range a.Commits {
+		touched := make(map[string]int) // Directory: changed lines
+		for _, file := range commit.Files {
+			if categoryOf(file.Path) != "dependencies" {
+				continue
+			}
+			for _, dir := range directoriesOf(file.Path, depth) {
+				touched[dir] += file.Added + file.Deleted
+			}
+			if !strings.Contains(file.Path, "/") {
+				touched[""] += file.Added + file.Deleted // Manifests of the repository root
+			}
+		}
+		period := commit.Date.Format("2006-01")
+		for dir, lines := range touched {
+			entry := dirs[dir]
+			if entry == nil {
+				entry = &DependencyChurn{Path: dir, LastChange: commit.Date} // Commits are newest first
+				dirs[dir] = entry
+				months[dir] = make(map[string]*TimeBucket)
+			}
+			entry.Changes++
+			bucket := months[dir][period]
+			if bucket == nil {
+				bucket = &TimeBucket{Period: period}
+				months[dir][period] = bucket
+			}
+			bucket.Commits++
+			bucket.LinesChanged += lines
+		}
+	}
+
+	list := make([]DependencyChurn, 0, len(dirs))
+	for dir, entry := range dirs {
+		for _, bucket := range months[dir] {
+			entry.Months = append(entry.Months, *bucket)
+		}
+		sort.Slice(entry.Months, func(i, j int) bool { return entry.Months[i].Period < entry.Months[j].Period })
+		list = append(list, *entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Changes != list[j].Changes {
+			return list[i].Changes > list[j].Changes
+		}
+		return list[i].Path < list[j].Path
+	})
+	return list
+}
+
+// handleDependencies serves GET /dependencies?depth=2, the dependency manifest churn per directory
+// and month
+func handleDependencies(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	depth, ok := authorDepth(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, dependencyChurn(analysis, depth))
+}
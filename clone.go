@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCloneRefresh is how often a -clone is fetched and re-analyzed without -refresh
+const defaultCloneRefresh = 15 * time.Minute
+
+var (
+	cloneURL string // -clone: analyze a clone of this repository URL, kept up to date by -refresh
+	cacheDir string // -cache-dir: directory keeping clones and the blame cache, e.g. a volume
+)
+
+// unsafeCloneName matches what doesn't belong in the directory name of a clone
+var unsafeCloneName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// defaultCacheDir returns the user's cache directory for git-dirheat, or one in the temporary
+// directory for users without a home, e.g. containers running as an arbitrary UID
+func defaultCacheDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "git-dirheat")
+	}
+	return filepath.Join(os.TempDir(), "git-dirheat")
+}
+
+// cloneDir returns the directory below the cache directory the repository at rawURL is cloned to,
+// e.g. repos/github.com_org/repo, named after its host and path without credentials
+func cloneDir(rawURL string) string {
+	name := rawURL
+	if parsed, err := url.Parse(rawURL); err == nil && parsed.Host != "" {
+		name = parsed.Host + parsed.Path
+	} else if _, path, ok := strings.Cut(rawURL, "@"); ok {
+		name = path // scp-like git@host:org/repo.git
+	}
+	// The last segment names the directory, and so the root of the tree
+	name = strings.TrimSuffix(strings.TrimRight(name, "/"), ".git")
+	i := strings.LastIndexAny(name, "/:")
+	clean := func(s string) string { return strings.Trim(unsafeCloneName.ReplaceAllString(s, "_"), "_.") }
+	return filepath.Join(cacheDir, "repos", clean(name[:i+1]), clean(name[i+1:]))
+}
+
+// redactURL returns the URL without credentials, for messages
+func redactURL(rawURL string) string {
+	if parsed, err := url.Parse(rawURL); err == nil && parsed.User != nil {
+		parsed.User = nil // Tokens often go in as user name
+		return parsed.String()
+	}
+	return rawURL
+}
+
+// cloneCredentials splits the credentials off the repository URL: the URL without them, and the
+// environment passing them to git as an Authorization header for this command only. Credentials
+// in the URL would otherwise be stored in the clone's .git/config as the origin remote.
+func cloneCredentials(rawURL string) (string, []string) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.User == nil || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+		return rawURL, nil
+	}
+	password, _ := parsed.User.Password()
+	auth := base64.StdEncoding.EncodeToString([]byte(parsed.User.Username() + ":" + password))
+	parsed.User = nil
+	// Appended to the configuration of the environment, e.g. safe.directory in the container
+	n, _ := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+	return parsed.String(), []string{
+		fmt.Sprintf("GIT_CONFIG_COUNT=%d", n+1),
+		fmt.Sprintf("GIT_CONFIG_KEY_%d=http.extraHeader", n),
+		fmt.Sprintf("GIT_CONFIG_VALUE_%d=Authorization: Basic %s", n, auth),
+	}
+}
+
+// syncClone clones the repository at rawURL to dir, or fetches it into an existing clone (e.g.
+// on a volume kept across restarts) and moves the checkout to the fetched default branch.
+// Credentials in the URL are sent along but not stored in the clone.
+func syncClone(ctx context.Context, rawURL, dir string) error {
+	remoteURL, env := cloneCredentials(rawURL)
+	safeURL := redactURL(rawURL)
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		// Clones made by earlier versions have the credentials in the origin URL
+		if _, err := gitRun(ctx, dir, "remote", "set-url", "origin", remoteURL); err != nil {
+			return fmt.Errorf("error updating the origin of the clone of '%s': %w (%s)", safeURL, err, gitStderr(err))
+		}
+		if _, err := gitRunEnv(ctx, dir, env, "fetch", "--quiet", "--prune", "origin"); err != nil {
+			return fmt.Errorf("error fetching '%s': %w (%s)", safeURL, err, gitStderr(err))
+		}
+		if _, err := gitRun(ctx, dir, "reset", "--quiet", "--hard", "origin/HEAD"); err != nil {
+			return fmt.Errorf("error updating the clone of '%s': %w (%s)", safeURL, err, gitStderr(err))
+		}
+		touchClone(dir)
+		slog.Debug("Fetched clone", "url", safeURL, "dir", dir)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return fmt.Errorf("error creating the clone directory: %w", err)
+	}
+	slog.Info("Cloning repository", "url", safeURL, "dir", dir)
+	start := time.Now()
+	if _, err := gitRunEnv(ctx, filepath.Dir(dir), env, "clone", "--quiet", "--", remoteURL, dir); err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("error cloning '%s': %w (%s)", safeURL, err, gitStderr(err))
+	}
+	slog.Info("Cloned repository", "url", safeURL, "time", time.Since(start).Round(time.Millisecond).String())
+	return nil
+}
+
+// touchClone marks the clone as used now, so retention keeps it
+func touchClone(dir string) {
+	now := time.Now()
+	if err := os.Chtimes(dir, now, now); err != nil {
+		slog.Debug("Could not mark the clone as used", "dir", dir, "error", err)
+	}
+}
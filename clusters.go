@@ -0,0 +1,303 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultClusterThreshold is the coupling below which clusters are no longer merged
+	defaultClusterThreshold = 0.3
+	// clusterMaxDirectories leaves out commits changing more directories, mass changes like
+	// reformatting couple everything with everything
+	clusterMaxDirectories = 20
+)
+
+// unsafeModuleName matches what doesn't belong in a module name of a module map
+var unsafeModuleName = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// Cluster is a group of directories changing together, suggested as a module
+type Cluster struct {
+	Name     string   `json:"name"`     // After its most changed directory
+	Paths    []string `json:"paths"`    // Most changed first
+	Commits  int      `json:"commits"`  // Commits changing the cluster
+	Internal int      `json:"internal"` // Co-changes of directory pairs within the cluster
+	External int      `json:"external"` // Co-changes with directories of other clusters
+	Cohesion float64  `json:"cohesion"` // Share of the co-changes within the cluster, 0-1
+}
+
+// ClusterReport is the suggested grouping of the directories into modules, as served on /clusters
+type ClusterReport struct {
+	Depth     int       `json:"depth"`
+	Threshold float64   `json:"threshold"`
+	Clusters  []Cluster `json:"clusters"`  // Of at least two directories, most changed first
+	Unpaired  []string  `json:"unpaired"`  // Directories not coupled enough to any cluster
+	CrossRate float64   `json:"crossRate"` // Share of all co-changes crossing clusters, 0-1
+	Remap     string    `json:"remap"`     // Rules for /data?remap= showing the tree by cluster
+}
+
+// clusterDirectories groups the directories up to depth levels by how often they change together:
+// starting from one cluster per directory it merges the most strongly coupled pair of clusters as
+// long as their coupling (co-changes relative to the commits changing either, so clusters don't
+// swallow everything as they grow) reaches threshold
+func clusterDirectories(a *Analysis, depth int, threshold float64) *ClusterReport {
+	commits := make(map[string]int)
+	pairs := make(map[[2]string]int)
+	for _, commit := range a.Commits {
+		touched := make(map[string]bool)
+		for _, file := range commit.Files {
+			if dirs := directoriesOf(file.Path, depth); len(dirs) > 0 {
+				touched[dirs[len(dirs)-1]] = true
+			}
+		}
+		if len(touched) > clusterMaxDirectories {
+			continue
+		}
+		dirs := make([]string, 0, len(touched))
+		for dir := range touched {
+			dirs = append(dirs, dir)
+			commits[dir]++
+		}
+		sort.Strings(dirs)
+		for i := range dirs {
+			for j := i + 1; j < len(dirs); j++ {
+				pairs[[2]string{dirs[i], dirs[j]}]++
+			}
+		}
+	}
+
+	// Clusters as member lists, with their commits and the co-changes between each pair of them
+	type group struct {
+		members []string
+		commits int
+	}
+	var groups []*group
+	index := make(map[string]int)
+	names := make([]string, 0, len(commits))
+	for dir := range commits {
+		names = append(names, dir)
+	}
+	sort.Strings(names)
+	for i, dir := range names {
+		index[dir] = i
+		groups = append(groups, &group{members: []string{dir}, commits: commits[dir]})
+	}
+	links := make([]map[int]int, len(groups))
+	for i := range links {
+		links[i] = make(map[int]int)
+	}
+	for pair, count := range pairs {
+		i, j := index[pair[0]], index[pair[1]]
+		links[i][j] += count
+		links[j][i] += count
+	}
+	for {
+		best, bi, bj := 0.0, -1, -1
+		for i, neighbors := range links {
+			if groups[i] == nil {
+				continue
+			}
+			for j, count := range neighbors {
+				if j <= i {
+					continue
+				}
+				strength := float64(count) / float64(groups[i].commits+groups[j].commits-count)
+				if strength > best || (strength == best && (i < bi || (i == bi && j < bj))) {
+					best, bi, bj = strength, i, j
+				}
+			}
+		}
+		if bi < 0 || best < threshold {
+			break
+		}
+		// Merge bj into bi
+		groups[bi].members = append(groups[bi].members, groups[bj].members...)
+		groups[bi].commits += groups[bj].commits
+		for k, count := range links[bj] {
+			delete(links[k], bj)
+			if k != bi {
+				links[bi][k] += count
+				links[k][bi] += count
+			}
+		}
+		delete(links[bi], bj)
+		groups[bj], links[bj] = nil, map[int]int{}
+	}
+
+	cluster := make(map[string]int)
+	for i, g := range groups {
+		if g != nil {
+			for _, dir := range g.members {
+				cluster[dir] = i
+			}
+		}
+	}
+	report := &ClusterReport{Depth: depth, Threshold: threshold, Clusters: []Cluster{}, Unpaired: []string{}}
+	internal, external := make(map[int]int), make(map[int]int)
+	crossing, total := 0, 0
+	for pair, count := range pairs {
+		ci, cj := cluster[pair[0]], cluster[pair[1]]
+		total += count
+		if ci == cj {
+			internal[ci] += count
+		} else {
+			external[ci] += count
+			external[cj] += count
+			crossing += count
+		}
+	}
+	if total > 0 {
+		report.CrossRate = math.Round(float64(crossing)/float64(total)*1000) / 1000
+	}
+	taken := make(map[string]bool)
+	for i, g := range groups {
+		if g == nil {
+			continue
+		}
+		sort.Slice(g.members, func(a, b int) bool {
+			if commits[g.members[a]] != commits[g.members[b]] {
+				return commits[g.members[a]] > commits[g.members[b]]
+			}
+			return g.members[a] < g.members[b]
+		})
+		if len(g.members) == 1 {
+			report.Unpaired = append(report.Unpaired, g.members[0])
+			continue
+		}
+		entry := Cluster{Name: moduleName(g.members[0], taken), Paths: g.members, Commits: g.commits, Internal: internal[i], External: external[i]}
+		if entry.Internal+entry.External > 0 {
+			entry.Cohesion = math.Round(float64(entry.Internal)/float64(entry.Internal+entry.External)*1000) / 1000
+		}
+		report.Clusters = append(report.Clusters, entry)
+	}
+	sort.Slice(report.Clusters, func(i, j int) bool {
+		if report.Clusters[i].Commits != report.Clusters[j].Commits {
+			return report.Clusters[i].Commits > report.Clusters[j].Commits
+		}
+		return report.Clusters[i].Name < report.Clusters[j].Name
+	})
+	sort.Strings(report.Unpaired)
+
+	// The first matching rule moves a file, so deeper directories go first, and unpaired ones
+	// below a clustered directory stay in place rather than moving along with it
+	targets := make(map[string]string)
+	for _, c := range report.Clusters {
+		for _, dir := range c.Paths {
+			targets[dir] = c.Name + "/" + dir
+		}
+	}
+	for _, dir := range report.Unpaired {
+		for _, parent := range directoriesOf(dir, depth) {
+			if targets[parent] != "" && cluster[parent] != cluster[dir] {
+				targets[dir] = dir
+			}
+		}
+	}
+	dirs := make([]string, 0, len(targets))
+	for dir := range targets {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if di, dj := strings.Count(dirs[i], "/"), strings.Count(dirs[j], "/"); di != dj {
+			return di > dj
+		}
+		return dirs[i] < dirs[j]
+	})
+	var rules []string
+	for _, dir := range dirs {
+		rules = append(rules, dir+"=>"+targets[dir])
+	}
+	report.Remap = strings.Join(rules, ",")
+	return report
+}
+
+// moduleName turns a directory path into a module name for a module map not taken yet, e.g.
+// pkg-server
+func moduleName(dir string, taken map[string]bool) string {
+	base := cmp.Or(strings.Trim(unsafeModuleName.ReplaceAllString(strings.ToLower(dir), "-"), "-"), "module")
+	name := base
+	for n := 2; taken[name]; n++ {
+		name = fmt.Sprintf("%s-%d", base, n)
+	}
+	taken[name] = true
+	return name
+}
+
+// moduleMapYAML renders the clusters as a module map for -module-map
+func (r *ClusterReport) moduleMapYAML() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Directories up to %d levels clustered by co-change (threshold %g)\n", r.Depth, r.Threshold)
+	for _, c := range r.Clusters {
+		fmt.Fprintf(&b, "%s:\n", c.Name)
+		for _, dir := range c.Paths {
+			fmt.Fprintf(&b, "  - \"%s\"\n", dir)
+		}
+	}
+	return b.String()
+}
+
+// markdown renders the report as a Markdown document, e.g. for a decomposition discussion
+func (r *ClusterReport) markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Suggested modules\n\nDirectories up to %d levels clustered by co-change (threshold %g). ", r.Depth, r.Threshold)
+	fmt.Fprintf(&b, "%.0f%% of the co-changes cross clusters.\n\n", r.CrossRate*100)
+	if len(r.Clusters) == 0 {
+		b.WriteString("No clusters.\n")
+	}
+	for _, c := range r.Clusters {
+		fmt.Fprintf(&b, "## %s\n\n%d commits, cohesion %.0f%% (%d co-changes within, %d with other clusters)\n\n", c.Name, c.Commits, c.Cohesion*100, c.Internal, c.External)
+		for _, dir := range c.Paths {
+			fmt.Fprintf(&b, "- `%s`\n", dir)
+		}
+		b.WriteString("\n")
+	}
+	if len(r.Unpaired) > 0 {
+		fmt.Fprintf(&b, "Not clustered: %d directories.\n", len(r.Unpaired))
+	}
+	return b.String()
+}
+
+// handleClusters serves GET /clusters?depth=2&threshold=0.3&format=json, module groupings
+// suggested by clustering the directories that change together; format=markdown renders a report
+// and format=yaml a module map for -module-map
+func handleClusters(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	depth := digestDirectoryDepth
+	if query.Has("depth") {
+		if depth, ok = authorDepth(w, r); !ok {
+			return
+		}
+	}
+	threshold := defaultClusterThreshold
+	if value := query.Get("threshold"); value != "" {
+		t, err := strconv.ParseFloat(value, 64)
+		if err != nil || t <= 0 || t > 1 {
+			http.Error(w, fmt.Sprintf("Invalid threshold '%s' (expected a number above 0, up to 1)", value), http.StatusBadRequest)
+			return
+		}
+		threshold = t
+	}
+	report := clusterDirectories(analysis, depth, threshold)
+	switch format := query.Get("format"); format {
+	case "", "json":
+		writeJSON(w, report)
+	case "markdown":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		fmt.Fprint(w, report.markdown())
+	case "yaml":
+		w.Header().Set("Content-Type", "application/yaml; charset=utf-8")
+		fmt.Fprint(w, report.moduleMapYAML())
+	default:
+		http.Error(w, fmt.Sprintf("Unknown format '%s' (expected json, markdown or yaml)", format), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// collector subscribes to the stream of parsed commits and their file changes. Metrics computed
+// from the history are collectors, so enabling more of them adds no git invocation: the parser
+// streams the log to all of them at once, and replay streams the parsed commits again.
+type collector interface {
+	// commit starts a commit, whose changes count c.Weight times (see Dirheat-Weight). The commit
+	// is nil for changes logged before the first commit header.
+	commit(c *Commit)
+	// change is a file change of the current commit
+	change(c *Commit, change FileChange)
+	// copied is a file the current commit created as a copy of another one (with -copies)
+	copied(c *Commit, fileCopy FileCopy)
+}
+
+// replay streams the commits of an analysis to the collectors in one pass, newest first
+func replay(commits []*Commit, collectors ...collector) {
+	for _, commit := range commits {
+		for _, col := range collectors {
+			col.commit(commit)
+		}
+		for _, file := range commit.Files {
+			for _, col := range collectors {
+				col.change(commit, file)
+			}
+		}
+		for _, fileCopy := range commit.Copies {
+			for _, col := range collectors {
+				col.copied(commit, fileCopy)
+			}
+		}
+	}
+}
+
+// commitList collects the commits with their file changes and copies
+type commitList struct {
+	commits []*Commit
+}
+
+func (l *commitList) commit(c *Commit) {
+	if c != nil {
+		l.commits = append(l.commits, c)
+	}
+}
+
+func (l *commitList) change(c *Commit, change FileChange) {
+	if c != nil {
+		c.Files = append(c.Files, change)
+	}
+}
+
+func (l *commitList) copied(c *Commit, fileCopy FileCopy) {
+	if c != nil {
+		c.Copies = append(c.Copies, fileCopy)
+	}
+}
+
+// weightedCounts sums values by path, each counting with the weight of its commit. Values of
+// commits weighted by trailer are summed apart and rounded at the end, so that many down-weighted
+// changes still add up.
+type weightedCounts struct {
+	counts   map[string]int
+	weighted map[string]float64
+}
+
+func newWeightedCounts() *weightedCounts {
+	return &weightedCounts{counts: make(map[string]int), weighted: make(map[string]float64)}
+}
+
+// add counts value for the path, weight times
+func (w *weightedCounts) add(path string, value int, weight float64) {
+	if weight == 1 {
+		w.counts[path] += value
+	} else {
+		w.weighted[path] += float64(value) * weight
+	}
+}
+
+// result returns the values by path, once all were added
+func (w *weightedCounts) result() map[string]int {
+	for path, value := range w.weighted {
+		// Paths only changed by down-weighted commits may round to no change at all
+		if rounded := int(math.Round(value)); rounded > 0 || w.counts[path] > 0 {
+			w.counts[path] += rounded
+		}
+	}
+	return w.counts
+}
+
+// changeCounts collects the change count per file, the value of the churn tree. A copy also counts
+// as a change of its origin, so copied templates get their share of the heat.
+type changeCounts struct {
+	*weightedCounts
+	weight float64 // Of the current commit
+}
+
+func newChangeCounts() *changeCounts {
+	return &changeCounts{weightedCounts: newWeightedCounts(), weight: 1}
+}
+
+func (cc *changeCounts) commit(c *Commit) {
+	if c != nil {
+		cc.weight = c.Weight
+	}
+}
+
+func (cc *changeCounts) change(_ *Commit, change FileChange) {
+	cc.add(change.Path, 1, cc.weight)
+}
+
+func (cc *changeCounts) copied(_ *Commit, fileCopy FileCopy) {
+	cc.add(fileCopy.From, 1, cc.weight)
+}
+
+// metricCollector collects the commits, lines (changed), authors and age (days since the last change)
+// of every file and directory by tree path, "" being the root
+type metricCollector struct {
+	now     time.Time
+	metrics map[string]map[string]float64
+	authors map[string]map[string]bool
+	last    map[string]*Commit // The last commit counted per path
+	author  string
+	age     float64
+	weight  float64
+}
+
+func newMetricCollector(now time.Time) *metricCollector {
+	return &metricCollector{
+		now:     now,
+		metrics: make(map[string]map[string]float64),
+		authors: make(map[string]map[string]bool),
+		last:    make(map[string]*Commit),
+	}
+}
+
+func (m *metricCollector) commit(c *Commit) {
+	m.weight = c.Weight
+	m.author = strings.ToLower(c.Email)
+	m.age = float64(int(m.now.Sub(c.Date) / (24 * time.Hour)))
+}
+
+func (m *metricCollector) change(c *Commit, file FileChange) {
+	filePath := treePath(file.Path)
+	for _, path := range append(directoriesOf(filePath, math.MaxInt), filePath, "") {
+		entry := m.metrics[path]
+		if entry == nil {
+			entry = map[string]float64{"age": m.age} // Commits are newest first
+			m.metrics[path] = entry
+			m.authors[path] = make(map[string]bool)
+		}
+		if m.last[path] != c {
+			m.last[path] = c
+			entry["commits"] += m.weight
+		}
+		entry["lines"] += float64(file.Added+file.Deleted) * m.weight
+		if !m.authors[path][m.author] {
+			m.authors[path][m.author] = true
+			entry["authors"]++
+		}
+	}
+}
+
+func (m *metricCollector) copied(*Commit, FileCopy) {}
+
+// profileValues collects the tree values and the commits of a weight profile
+type profileValues struct {
+	profile  weightProfile
+	now      time.Time
+	values   *weightedCounts
+	commits  []*Commit
+	counting bool // Whether the current commit counts for the profile
+	weight   float64
+}
+
+func (p *profileValues) commit(c *Commit) {
+	if p.counting = p.profile.matches(c, p.now); p.counting {
+		p.commits = append(p.commits, c)
+		p.weight = c.Weight
+	}
+}
+
+func (p *profileValues) change(_ *Commit, file FileChange) {
+	switch {
+	case !p.counting:
+	case p.profile.lines:
+		p.values.add(file.Path, file.Added+file.Deleted, p.weight)
+	default:
+		p.values.add(file.Path, 1, p.weight)
+	}
+}
+
+func (p *profileValues) copied(*Commit, FileCopy) {}
+
+// ticketKeys collects the distinct ticket keys the commit subjects reference, in order of appearance
+type ticketKeys struct {
+	pattern *regexp.Regexp
+	seen    map[string]bool
+	keys    []string
+}
+
+func (t *ticketKeys) commit(c *Commit) {
+	for _, key := range t.pattern.FindAllString(c.Subject, -1) {
+		if !t.seen[key] {
+			t.seen[key] = true
+			t.keys = append(t.keys, key)
+		}
+	}
+}
+
+func (t *ticketKeys) change(*Commit, FileChange) {}
+
+func (t *ticketKeys) copied(*Commit, FileCopy) {}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// writeCommitGraph is the -commit-graph option: write or update the commit-graph before analyses
+var writeCommitGraph bool
+
+// commitGraphHinted holds the repositories already told about -commit-graph, so refreshes don't repeat it
+var commitGraphHinted sync.Map
+
+// hasCommitGraph reports whether the repository has a commit-graph file, single or split into a chain
+func hasCommitGraph(ctx context.Context, repo string) bool {
+	for _, name := range []string{"objects/info/commit-graph", "objects/info/commit-graphs/commit-graph-chain"} {
+		file, err := gitOutput(ctx, repo, "rev-parse", "--git-path", name)
+		if err != nil {
+			return false
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(repo, file)
+		}
+		if _, err := os.Stat(file); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// updateCommitGraph writes the commits new since the last analysis into the commit-graph, with
+// the changed-path Bloom filters that speed up path-limited logs like the file history. Split
+// graphs make the update cheap: only new commits are written, into a layer of their own.
+func updateCommitGraph(ctx context.Context, repo string) error {
+	if _, err := gitRun(ctx, repo, "commit-graph", "write", "--reachable", "--changed-paths", "--split"); err != nil {
+		return fmt.Errorf("error writing the commit-graph: %w (%s)", err, gitStderr(err))
+	}
+	return nil
+}
+
+// prepareCommitGraph makes the history walk of the analysis use a commit-graph: with
+// -commit-graph it is written or updated, otherwise repositories without one get a hint once.
+// git reads the graph by itself (core.commitGraph); shallow clones can't have one.
+func prepareCommitGraph(ctx context.Context, repo string, shallow bool) {
+	switch {
+	case shallow:
+	case writeCommitGraph:
+		if err := updateCommitGraph(ctx, repo); err != nil {
+			slog.Warn("Could not update the commit-graph, analyzing without it", "repo", repo, "error", err)
+		}
+	case !hasCommitGraph(ctx, repo):
+		if _, hinted := commitGraphHinted.LoadOrStore(repo, true); !hinted {
+			slog.Info("Repository has no commit-graph, -commit-graph writes one to speed up the analysis of large histories", "repo", repo)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCommitLimit and maxCommitLimit bound the number of commits returned by /commits
+const (
+	defaultCommitLimit = 50
+	maxCommitLimit     = 1000
+)
+
+// CommitSummary is a commit that contributed to a node's heat, restricted to the requested path
+type CommitSummary struct {
+	Hash         string    `json:"sha"`
+	Author       string    `json:"author"`
+	Email        string    `json:"email"`
+	Date         time.Time `json:"date"`
+	Subject      string    `json:"subject"`
+	Added        int       `json:"added"`
+	Deleted      int       `json:"deleted"`
+	LinesChanged int       `json:"linesChanged"`
+	Files        int       `json:"files"` // Number of files under the path touched by the commit
+	Trailers     []Trailer `json:"trailers,omitempty"`
+}
+
+// CommitsResponse is the response of /commits
+type CommitsResponse struct {
+	Path    string          `json:"path"`
+	Total   int             `json:"total"` // All commits touching the path, regardless of the limit
+	Commits []CommitSummary `json:"commits"`
+}
+
+// pathWithin reports whether the slash separated file path is dir itself or lies below it.
+// An empty dir matches every path.
+func pathWithin(file, dir string) bool {
+	dir = strings.Trim(dir, "/")
+	return dir == "" || file == dir || strings.HasPrefix(file, dir+"/")
+}
+
+// commitsTouching returns the commits that changed files under path, newest first
+func commitsTouching(a *Analysis, path string, limit int) *CommitsResponse {
+	response := &CommitsResponse{Path: strings.Trim(path, "/"), Commits: []CommitSummary{}}
+	for _, commit := range a.Commits {
+		summary := CommitSummary{
+			Hash:     commit.Hash,
+			Author:   commit.Author,
+			Email:    commit.Email,
+			Date:     commit.Date,
+			Subject:  commit.Subject,
+			Trailers: commit.Trailers,
+		}
+		for _, file := range commit.Files {
+			if pathWithin(file.Path, path) {
+				summary.Added += file.Added
+				summary.Deleted += file.Deleted
+				summary.Files++
+			}
+		}
+		if summary.Files == 0 {
+			continue
+		}
+		summary.LinesChanged = summary.Added + summary.Deleted
+		response.Total++
+		if len(response.Commits) < limit {
+			response.Commits = append(response.Commits, summary)
+		}
+	}
+	return response
+}
+
+// handleCommits serves GET /commits?path=src/auth&limit=50, listing the commits behind a node's heat
+func handleCommits(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+
+	limit := defaultCommitLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid limit '%s'", value), http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, maxCommitLimit)
+	}
+
+	path := r.URL.Query().Get("path")
+	if node, _ := analysis.Root.find(path); !node.exists() {
+		http.Error(w, fmt.Sprintf("%v: '%s'", errPathNotFound, path), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, commitsTouching(analysis, path, limit))
+}
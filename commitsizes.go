@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// Commit size profile thresholds, in changed lines of the whole commit
+const (
+	hugeCommitLines  = 500 // Directories whose median commit is at least this big only get huge commits
+	smallCommitLines = 50  // Directories whose p90 commit is at most this big are changed in small increments
+)
+
+// CommitSizes is the distribution of the sizes of the commits touching a directory
+type CommitSizes struct {
+	Path    string `json:"path"`
+	Commits int    `json:"commits"`
+	Median  int    `json:"median"` // Lines changed by the whole commit
+	P90     int    `json:"p90"`
+	Max     int    `json:"max"`
+	// Profile is "huge" when even the median commit is huge (risky big-bang changes),
+	// "incremental" when nine in ten commits are small, "mixed" otherwise
+	Profile string `json:"profile"`
+}
+
+// percentile returns the p-th percentile (nearest rank) of the sorted sizes
+func percentile(sorted []int, p float64) int {
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// commitSizes returns the commit size distribution of every directory up to depth levels, the
+// directories with the biggest median commits first
+func commitSizes(a *Analysis, depth int) []CommitSizes {
+	sizes := make(map[string][]int)
+	for _, commit := range a.Commits {
+		lines := 0
+		touched := make(map[string]bool)
+		for _, file := range commit.Files {
+			lines += file.Added + file.Deleted
+			for _, dir := range directoriesOf(file.Path, depth) {
+				touched[dir] = true
+			}
+		}
+		for dir := range touched {
+			sizes[dir] = append(sizes[dir], lines)
+		}
+	}
+
+	list := make([]CommitSizes, 0, len(sizes))
+	for dir, lines := range sizes {
+		sort.Ints(lines)
+		entry := CommitSizes{Path: dir, Commits: len(lines), Median: percentile(lines, 0.5), P90: percentile(lines, 0.9), Max: lines[len(lines)-1]}
+		switch {
+		case entry.Median >= hugeCommitLines:
+			entry.Profile = "huge"
+		case entry.P90 <= smallCommitLines:
+			entry.Profile = "incremental"
+		default:
+			entry.Profile = "mixed"
+		}
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Median != list[j].Median {
+			return list[i].Median > list[j].Median
+		}
+		return list[i].Path < list[j].Path
+	})
+	return list
+}
+
+// handleCommitSizes serves GET /commit-sizes?depth=2, the commit size distribution per directory
+func handleCommitSizes(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	depth, ok := authorDepth(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, commitSizes(analysis, depth))
+}
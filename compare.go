@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCachedComparisons bounds the number of branch comparisons kept in memory
+const maxCachedComparisons = 16
+
+// CompareNode is a node of the base tree overlaid with the churn of a branch
+type CompareNode struct {
+	ID          string         `json:"id"`
+	Path        string         `json:"path"`
+	Name        string         `json:"name"`
+	Value       int            `json:"value"`       // Base churn, drives the treemap layout
+	BranchValue int            `json:"branchValue"` // Churn of base..head
+	Children    []*CompareNode `json:"children,omitempty"`
+}
+
+// CompareMetadata describes a branch comparison
+type CompareMetadata struct {
+	ToolVersion   string    `json:"toolVersion"`
+	RepoPath      string    `json:"repoPath"`
+	Base          string    `json:"base"`
+	Head          string    `json:"head"`
+	BaseRevision  string    `json:"baseRevision"`
+	HeadRevision  string    `json:"headRevision"`
+	BaseCommits   int       `json:"baseCommits"`
+	BranchCommits int       `json:"branchCommits"` // Commits in base..head
+	GeneratedAt   time.Time `json:"generatedAt"`
+}
+
+// CompareResponse is the response of /compare
+type CompareResponse struct {
+	Metadata CompareMetadata `json:"metadata"`
+	Tree     *CompareNode    `json:"tree"`
+}
+
+var (
+	comparisonsMu sync.Mutex
+	comparisons   = make(map[string]*CompareResponse)
+)
+
+// resolveCommit resolves a user supplied revision to a commit hash, rejecting anything git could
+// mistake for an option
+func resolveCommit(ctx context.Context, repo, rev string) (string, error) {
+	if rev == "" || strings.HasPrefix(rev, "-") {
+		return "", fmt.Errorf("invalid revision '%s'", rev)
+	}
+	hash, err := gitOutput(ctx, repo, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if ctx.Err() != nil {
+		return "", err
+	}
+	if err != nil || hash == "" {
+		return "", fmt.Errorf("unknown revision '%s'", rev)
+	}
+	return hash, nil
+}
+
+// logHistory runs git log --numstat (file names only on partial clones) for the revision range and parses it
+func logHistory(ctx context.Context, repo string, revs ...string) (map[string]int, []*Commit, error) {
+	args := append([]string{"log"}, changeListArgs(partialCloneFilter(ctx, repo))...)
+	args = append(append(args, "--pretty=format:"+commitMarker+commitFormat, "--no-merges"), revs...)
+	output, err := gitRun(ctx, repo, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error running git log %s: %w", strings.Join(revs, " "), err)
+	}
+	counts, commits, _, err := parseNumstatLog(ctx, output)
+	return counts, commits, err
+}
+
+// mergeCompareTrees combines the base tree and the branch tree into a single overlay tree
+func mergeCompareTrees(base, branch Node) *CompareNode {
+	node := base
+	if !node.exists() {
+		node = branch
+	}
+	merged := &CompareNode{ID: nodeID(node.relPath()), Path: node.relPath(), Name: node.Name()}
+	if base.exists() {
+		merged.Value = base.Value()
+	}
+	if branch.exists() {
+		merged.BranchValue = branch.Value()
+	}
+
+	names := make(map[string]bool)
+	if base.exists() {
+		for child := range base.Children() {
+			names[child.Name()] = true
+		}
+	}
+	if branch.exists() {
+		for child := range branch.Children() {
+			names[child.Name()] = true
+		}
+	}
+	for name := range names {
+		var baseChild, branchChild Node
+		if base.exists() {
+			baseChild = base.child(name)
+		}
+		if branch.exists() {
+			branchChild = branch.child(name)
+		}
+		merged.Children = append(merged.Children, mergeCompareTrees(baseChild, branchChild))
+	}
+	sort.Slice(merged.Children, func(i, j int) bool {
+		a, b := merged.Children[i], merged.Children[j]
+		if a.Value != b.Value {
+			return a.Value > b.Value
+		}
+		return a.Name < b.Name
+	})
+	return merged
+}
+
+// compareBranches computes the churn of base..head overlaid on the base tree
+func compareBranches(ctx context.Context, repo, base, head string) (*CompareResponse, error) {
+	baseRevision, err := resolveCommit(ctx, repo, base)
+	if err != nil {
+		return nil, err
+	}
+	headRevision, err := resolveCommit(ctx, repo, head)
+	if err != nil {
+		return nil, err
+	}
+
+	key := baseRevision + ".." + headRevision
+	comparisonsMu.Lock()
+	cached, ok := comparisons[key]
+	comparisonsMu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	baseCounts, baseCommits, err := logHistory(ctx, repo, baseRevision)
+	if err != nil {
+		return nil, err
+	}
+	branchCounts, branchCommits, err := logHistory(ctx, repo, key)
+	if err != nil {
+		return nil, err
+	}
+	baseTree := populateTree(repo, baseCounts)
+	baseTree.aggregateCounts()
+	branchTree := populateTree(repo, branchCounts)
+	branchTree.aggregateCounts()
+
+	response := &CompareResponse{
+		Metadata: CompareMetadata{
+			ToolVersion:   version,
+			RepoPath:      repo,
+			Base:          base,
+			Head:          head,
+			BaseRevision:  baseRevision,
+			HeadRevision:  headRevision,
+			BaseCommits:   len(baseCommits),
+			BranchCommits: len(branchCommits),
+			GeneratedAt:   time.Now().UTC(),
+		},
+		Tree: mergeCompareTrees(baseTree, branchTree),
+	}
+
+	comparisonsMu.Lock()
+	if len(comparisons) >= maxCachedComparisons {
+		comparisons = make(map[string]*CompareResponse)
+	}
+	comparisons[key] = response
+	comparisonsMu.Unlock()
+	return response, nil
+}
+
+// handleCompare serves GET /compare?base=main&head=feature-x
+func handleCompare(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	base, head := r.URL.Query().Get("base"), r.URL.Query().Get("head")
+	if base == "" || head == "" {
+		http.Error(w, "Both 'base' and 'head' query parameters are required", http.StatusBadRequest)
+		return
+	}
+
+	if analysis.Repos != nil {
+		http.Error(w, "Branch comparison is not available when serving several repositories", http.StatusBadRequest)
+		return
+	}
+	response, err := compareBranches(r.Context(), analysis.Meta.RepoPath, base, head)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, response)
+}
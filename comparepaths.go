@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// PathMetrics are the metrics of one side of a path comparison
+type PathMetrics struct {
+	Path          string             `json:"path"`
+	Value         int                `json:"value"` // Churn, like in the tree
+	Files         int                `json:"files"` // Changed files within
+	PercentOfRoot float64            `json:"percentOfRoot"`
+	Metrics       map[string]float64 `json:"metrics"`
+}
+
+// MetricComparison compares one metric of both paths
+type MetricComparison struct {
+	Metric string   `json:"metric"`
+	A      float64  `json:"a"`
+	B      float64  `json:"b"`
+	Ratio  *float64 `json:"ratio,omitempty"` // A divided by B, left out when B is 0
+	Higher string   `json:"higher"`          // a, b or equal
+}
+
+// PathComparison is the side-by-side comparison of two paths, as served on /compare-paths
+type PathComparison struct {
+	A PathMetrics `json:"a"`
+	B PathMetrics `json:"b"`
+	// Comparison holds the raw metrics followed by the per-file ones (e.g. valuePerFile), which
+	// compare paths of different sizes
+	Comparison []MetricComparison `json:"comparison"`
+}
+
+// pathMetrics returns the metrics of the tree node at path
+func pathMetrics(a *Analysis, node Node, path string) PathMetrics {
+	metrics := PathMetrics{
+		Path:          path,
+		Value:         node.Value(),
+		Files:         node.fileCount(),
+		PercentOfRoot: percentOf(node.Value(), a.Root.Value()),
+		Metrics:       map[string]float64{},
+	}
+	for name, value := range nodeMetrics(a)[path] {
+		metrics.Metrics[name] = value
+	}
+	return metrics
+}
+
+// comparePaths compares the metrics of two paths, raw and per changed file
+func comparePaths(a *Analysis, nodeA, nodeB Node, pathA, pathB string) *PathComparison {
+	comparison := &PathComparison{A: pathMetrics(a, nodeA, pathA), B: pathMetrics(a, nodeB, pathB)}
+	compare := func(metric string, valueA, valueB float64) {
+		entry := MetricComparison{Metric: metric, A: valueA, B: valueB, Higher: "equal"}
+		if valueB != 0 {
+			ratio := math.Round(valueA/valueB*100) / 100
+			entry.Ratio = &ratio
+		}
+		if valueA > valueB {
+			entry.Higher = "a"
+		} else if valueB > valueA {
+			entry.Higher = "b"
+		}
+		comparison.Comparison = append(comparison.Comparison, entry)
+	}
+	perFile := func(value float64, files int) float64 {
+		if files == 0 {
+			return 0
+		}
+		return math.Round(value/float64(files)*100) / 100
+	}
+
+	names := map[string]bool{}
+	for name := range comparison.A.Metrics {
+		names[name] = true
+	}
+	for name := range comparison.B.Metrics {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	compare("value", float64(comparison.A.Value), float64(comparison.B.Value))
+	compare("files", float64(comparison.A.Files), float64(comparison.B.Files))
+	for _, name := range sorted {
+		compare(name, comparison.A.Metrics[name], comparison.B.Metrics[name])
+	}
+	compare("valuePerFile", perFile(float64(comparison.A.Value), comparison.A.Files), perFile(float64(comparison.B.Value), comparison.B.Files))
+	for _, name := range []string{"commits", "lines", "hotspot"} {
+		compare(name+"PerFile", perFile(comparison.A.Metrics[name], comparison.A.Files), perFile(comparison.B.Metrics[name], comparison.B.Files))
+	}
+	return comparison
+}
+
+// handleComparePaths serves GET /compare-paths?a=pkg/server&b=pkg/client, the metrics of two files
+// or directories side by side, to decide which of two candidates to refactor first
+func handleComparePaths(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	if !query.Has("a") || !query.Has("b") {
+		http.Error(w, "Both 'a' and 'b' query parameters are required", http.StatusBadRequest)
+		return
+	}
+	pathA, pathB := strings.Trim(query.Get("a"), "/"), strings.Trim(query.Get("b"), "/")
+	nodeA, _ := analysis.Root.find(pathA)
+	nodeB, _ := analysis.Root.find(pathB)
+	for path, node := range map[string]Node{pathA: nodeA, pathB: nodeB} {
+		if !node.exists() {
+			http.Error(w, fmt.Sprintf("%v: '%s'", errPathNotFound, path), http.StatusNotFound)
+			return
+		}
+	}
+	writeJSON(w, comparePaths(analysis, nodeA, nodeB, pathA, pathB))
+}
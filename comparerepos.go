@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// RepoCompareNode is a node of the merged tree of two repositories, valued by the churn of the
+// commits that only exist on one side
+type RepoCompareNode struct {
+	ID         string             `json:"id"`
+	Path       string             `json:"path"`
+	Name       string             `json:"name"`
+	Value      int                `json:"value"` // Divergent churn of both sides, drives the treemap layout
+	LeftValue  int                `json:"leftValue"`
+	RightValue int                `json:"rightValue"`
+	Divergence float64            `json:"divergence"` // 1 = changed equally on both sides (hardest to reconcile), 0 = one side only
+	Children   []*RepoCompareNode `json:"children,omitempty"`
+}
+
+// RepoCompareSide describes one of the compared repositories
+type RepoCompareSide struct {
+	RepoPath       string `json:"repoPath"`
+	Revision       string `json:"revision"`
+	Commits        int    `json:"commits"`
+	UniqueCommits  int    `json:"uniqueCommits"` // Commits not present in the other repository
+	DivergentValue int    `json:"divergentValue"`
+}
+
+// RepoCompareResponse is the output of compare-repos
+type RepoCompareResponse struct {
+	ToolVersion   string           `json:"toolVersion"`
+	OptionsHash   string           `json:"optionsHash"` // See Metadata
+	Left          RepoCompareSide  `json:"left"`
+	Right         RepoCompareSide  `json:"right"`
+	SharedCommits int              `json:"sharedCommits"`
+	GeneratedAt   time.Time        `json:"generatedAt"`
+	Tree          *RepoCompareNode `json:"tree"`
+}
+
+// divergence scores how evenly the divergent churn is spread over both sides
+func divergence(left, right int) float64 {
+	if left+right == 0 {
+		return 0
+	}
+	return math.Round(float64(2*min(left, right))*10000/float64(left+right)) / 10000
+}
+
+// mergeRepoTrees combines the divergent churn trees of both sides into a single tree
+func mergeRepoTrees(left, right Node) *RepoCompareNode {
+	node := left
+	if !node.exists() {
+		node = right
+	}
+	merged := &RepoCompareNode{ID: nodeID(node.relPath()), Path: node.relPath(), Name: node.Name()}
+	names := make(map[string]bool)
+	if left.exists() {
+		merged.LeftValue = left.Value()
+		for child := range left.Children() {
+			names[child.Name()] = true
+		}
+	}
+	if right.exists() {
+		merged.RightValue = right.Value()
+		for child := range right.Children() {
+			names[child.Name()] = true
+		}
+	}
+	merged.Value = merged.LeftValue + merged.RightValue
+	merged.Divergence = divergence(merged.LeftValue, merged.RightValue)
+
+	for name := range names {
+		var leftChild, rightChild Node
+		if left.exists() {
+			leftChild = left.child(name)
+		}
+		if right.exists() {
+			rightChild = right.child(name)
+		}
+		if child := mergeRepoTrees(leftChild, rightChild); child.Value > 0 {
+			merged.Children = append(merged.Children, child)
+		}
+	}
+	sort.Slice(merged.Children, func(i, j int) bool {
+		a, b := merged.Children[i], merged.Children[j]
+		if a.Value != b.Value {
+			return a.Value > b.Value
+		}
+		return a.Name < b.Name
+	})
+	return merged
+}
+
+// uniqueChurn counts per-file changes of the commits missing from the other side
+func uniqueChurn(commits []*Commit, other map[string]bool) (map[string]int, int) {
+	counts := newWeightedCounts()
+	unique := 0
+	for _, commit := range commits {
+		if other[commit.Hash] {
+			continue
+		}
+		unique++
+		for _, file := range commit.Files {
+			counts.add(file.Path, 1, commit.Weight)
+		}
+	}
+	return counts.result(), unique
+}
+
+// compareRepos analyzes two clones (e.g. an upstream and a long-lived fork) and merges their divergent churn.
+// Commits present in both histories are shared and don't count as divergence.
+func compareRepos(ctx context.Context, leftRepo, rightRepo string) (*RepoCompareResponse, error) {
+	response := &RepoCompareResponse{ToolVersion: version, GeneratedAt: time.Now().UTC()}
+	response.OptionsHash = optionsHash(Metadata{
+		ToolVersion:   version,
+		RevisionRange: "HEAD",
+		Filters:       map[string]string{"merges": "excluded", "compare": "repos"},
+		Repos:         []RepoInfo{{Name: repoName(leftRepo)}, {Name: repoName(rightRepo)}},
+	})
+	sides := []*RepoCompareSide{&response.Left, &response.Right}
+	histories := make([][]*Commit, 2)
+	hashes := make([]map[string]bool, 2)
+
+	for i, repo := range []string{leftRepo, rightRepo} {
+		repo = absRepoPath(repo)
+		sides[i].RepoPath = repo
+		hashes[i] = make(map[string]bool)
+		if ok, err := hasCommits(ctx, repo); err != nil {
+			return nil, fmt.Errorf("%s: %w", repo, err)
+		} else if !ok {
+			continue // Nothing committed yet, so all churn of the other side is divergent
+		}
+		revision, err := resolveCommit(ctx, repo, "HEAD")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", repo, err)
+		}
+		_, commits, err := logHistory(ctx, repo, revision)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", repo, err)
+		}
+		sides[i].Revision = revision
+		sides[i].Commits = len(commits)
+		histories[i] = commits
+		for _, commit := range commits {
+			hashes[i][commit.Hash] = true
+		}
+	}
+
+	leftCounts, leftUnique := uniqueChurn(histories[0], hashes[1])
+	rightCounts, rightUnique := uniqueChurn(histories[1], hashes[0])
+	response.Left.UniqueCommits = leftUnique
+	response.Right.UniqueCommits = rightUnique
+	response.SharedCommits = response.Left.Commits - leftUnique
+
+	leftTree := populateTree(response.Left.RepoPath, leftCounts)
+	leftTree.aggregateCounts()
+	rightTree := populateTree(response.Left.RepoPath, rightCounts)
+	rightTree.aggregateCounts()
+	response.Left.DivergentValue = leftTree.Value()
+	response.Right.DivergentValue = rightTree.Value()
+	response.Tree = mergeRepoTrees(leftTree, rightTree)
+	return response, nil
+}
+
+// runCompareRepos implements the compare-repos command
+func runCompareRepos(args []string) int {
+	flags := flag.NewFlagSet("compare-repos", flag.ContinueOnError)
+	output := flags.String("o", "", "write the result to this file instead of standard output")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s compare-repos [options] <upstream_repo> <fork_repo>\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Merges the churn of the commits unique to each clone into one tree with per-side values and divergence scores.")
+		flags.PrintDefaults()
+	}
+	parseArgs(flags, args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	response, err := compareRepos(ctx, flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error comparing repositories: %v\n", err)
+		return exitCode(err)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating output file: %v\n", err)
+			return exitError
+		}
+		defer file.Close()
+		out = file
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(response); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing result: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
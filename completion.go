@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// flagValues are the values completed for options taking one of a few words
+var flagValues = map[string][]string{
+	"mode":              {"churn", "blame"},
+	"scale":             {"log", "sqrt", "percentile"},
+	"normalize":         {"none", "share", "commits"},
+	"group-by":          {"category", "module", "none"},
+	"log-level":         {"debug", "info", "warn", "error"},
+	"log-format":        {"text", "json"},
+	"access-log-format": {"common", "combined", "json"},
+	"notify-format":     {"slack", "teams", "auto"},
+	"oauth-provider":    {"github", "google"},
+	"format":            {"markdown", "html", "json"},
+}
+
+// completionShells are the shells the completion command writes scripts for
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// completionFlag is an option offered by the completion scripts
+type completionFlag struct {
+	name, usage string
+	boolean     bool
+}
+
+// takesFile reports whether the option's value is a file or directory, completed from the disk
+func (f completionFlag) takesFile() bool {
+	return strings.HasSuffix(f.name, "-file") || strings.HasSuffix(f.name, "-dir") || f.name == "access-log" || f.name == "alert-rules" || f.name == "module-map"
+}
+
+// parseHelp reads the options from the output of flag.PrintDefaults
+func parseHelp(help []byte) []completionFlag {
+	var flags []completionFlag
+	scanner := bufio.NewScanner(bytes.NewReader(help))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "  -") {
+			// One-letter options have their usage on the same line, after a tab
+			line, usage, _ := strings.Cut(line, "\t")
+			fields := strings.Fields(line)
+			flags = append(flags, completionFlag{name: strings.TrimPrefix(fields[0], "-"), usage: usage, boolean: len(fields) == 1})
+		} else if usage, ok := strings.CutPrefix(line, "    \t"); ok && len(flags) > 0 && flags[len(flags)-1].usage == "" {
+			flags[len(flags)-1].usage = usage
+		}
+	}
+	return flags
+}
+
+// commandFlags returns the options of the main command (command "") or a subcommand, from the
+// help the executable prints for -h
+func commandFlags(executable, command string) ([]completionFlag, error) {
+	args := []string{"-h"}
+	if command != "" {
+		args = []string{command, "-h"}
+	}
+	var help bytes.Buffer
+	cmd := exec.Command(executable, args...)
+	cmd.Stdout, cmd.Stderr = &help, &help
+	if err := cmd.Run(); err != nil && help.Len() == 0 {
+		return nil, fmt.Errorf("error reading the options of '%s': %w", strings.TrimSpace("git-dirheat "+command), err)
+	}
+	return parseHelp(help.Bytes()), nil
+}
+
+// completionCommands returns the subcommands, sorted
+func completionCommands() []string {
+	names := make([]string, 0, len(commandSummaries))
+	for name := range commandSummaries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// bashCompletion writes the bash completion script
+func bashCompletion(w io.Writer, commands []string, flags map[string][]completionFlag) {
+	fmt.Fprintln(w, "# bash completion for git-dirheat, e.g. source <(git-dirheat completion bash)")
+	fmt.Fprintln(w, "_git_dirheat() {")
+	fmt.Fprintln(w, `    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" cmd="" opts files`)
+	fmt.Fprintln(w, `    [[ ${COMP_CWORD} -gt 1 ]] && cmd="${COMP_WORDS[1]}"`)
+	fmt.Fprintln(w, `    case "$cmd" in`)
+	for _, command := range append(commands, "") {
+		var names, files []string
+		for _, f := range flags[command] {
+			names = append(names, "-"+f.name)
+			if f.takesFile() {
+				files = append(files, "-"+f.name)
+			}
+		}
+		pattern := command
+		if command == "" {
+			pattern = "*"
+		}
+		fmt.Fprintf(w, "        %s) opts=%q files=%q ;;\n", pattern, strings.Join(names, " "), " "+strings.Join(files, " ")+" ")
+	}
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w, `    case "$prev" in`)
+	values := make([]string, 0, len(flagValues))
+	for name := range flagValues {
+		values = append(values, name)
+	}
+	sort.Strings(values)
+	for _, name := range values {
+		fmt.Fprintf(w, "        -%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", name, strings.Join(flagValues[name], " "))
+	}
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w, `    if [[ "$files" == *" $prev "* ]]; then COMPREPLY=($(compgen -f -- "$cur")); return; fi`)
+	fmt.Fprintln(w, `    if [[ "$cur" == -* ]]; then COMPREPLY=($(compgen -W "$opts" -- "$cur")); return; fi`)
+	fmt.Fprintln(w, `    if [[ "$cmd" == completion ]]; then COMPREPLY=($(compgen -W "`+strings.Join(completionShells, " ")+`" -- "$cur")); return; fi`)
+	fmt.Fprintf(w, "    if [[ ${COMP_CWORD} -eq 1 ]]; then COMPREPLY=($(compgen -W %q -- \"$cur\")); fi\n", strings.Join(commands, " "))
+	fmt.Fprintln(w, `    COMPREPLY+=($(compgen -d -- "$cur"))`)
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -o filenames -F _git_dirheat git-dirheat")
+}
+
+// zshQuote quotes a description for a zsh _arguments spec
+func zshQuote(s string) string {
+	return strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+}
+
+// zshSpecs returns the _arguments specs of the options
+func zshSpecs(flags []completionFlag) string {
+	var specs []string
+	for _, f := range flags {
+		spec := fmt.Sprintf("'-%s[%s]", f.name, zshQuote(f.usage))
+		switch {
+		case f.boolean:
+		case flagValues[f.name] != nil:
+			spec += fmt.Sprintf(":%s:(%s)", f.name, strings.Join(flagValues[f.name], " "))
+		case f.takesFile():
+			spec += fmt.Sprintf(":%s:_files", f.name)
+		default:
+			spec += fmt.Sprintf(":%s: ", f.name)
+		}
+		specs = append(specs, spec+"'")
+	}
+	return strings.Join(specs, " \\\n        ")
+}
+
+// zshCompletion writes the zsh completion script
+func zshCompletion(w io.Writer, commands []string, flags map[string][]completionFlag) {
+	fmt.Fprintln(w, "#compdef git-dirheat")
+	fmt.Fprintln(w, "# zsh completion for git-dirheat, e.g. git-dirheat completion zsh > \"${fpath[1]}/_git-dirheat\"")
+	fmt.Fprintln(w, "_git_dirheat() {")
+	fmt.Fprintln(w, "    local -a commands")
+	fmt.Fprintln(w, "    commands=(")
+	for _, command := range commands {
+		fmt.Fprintf(w, "        '%s:%s'\n", command, zshQuote(commandSummaries[command]))
+	}
+	fmt.Fprintln(w, "    )")
+	fmt.Fprintln(w, `    if (( CURRENT == 2 )) && [[ "$words[2]" != -* ]]; then`)
+	fmt.Fprintln(w, "        _describe -t commands command commands")
+	fmt.Fprintln(w, "        _files -/")
+	fmt.Fprintln(w, "        return")
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprintln(w, `    case "$words[2]" in`)
+	for _, command := range commands {
+		if command == "completion" {
+			fmt.Fprintf(w, "    completion)\n        _arguments '2:shell:(%s)' ;;\n", strings.Join(completionShells, " "))
+			continue
+		}
+		fmt.Fprintf(w, "    %s)\n        shift words; (( CURRENT-- ))\n        _arguments \\\n        %s \\\n        '*:argument:_files -/' ;;\n", command, zshSpecs(flags[command]))
+	}
+	fmt.Fprintf(w, "    *)\n        _arguments \\\n        %s \\\n        '*:repository:_files -/' ;;\n", zshSpecs(flags[""]))
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, `_git_dirheat "$@"`)
+}
+
+// fishQuote quotes a string for fish
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// fishCompletion writes the fish completion script
+func fishCompletion(w io.Writer, commands []string, flags map[string][]completionFlag) {
+	fmt.Fprintln(w, "# fish completion for git-dirheat, e.g. git-dirheat completion fish > ~/.config/fish/completions/git-dirheat.fish")
+	fmt.Fprintln(w, "complete -c git-dirheat -f")
+	noCommand := "not __fish_seen_subcommand_from " + strings.Join(commands, " ")
+	for _, command := range commands {
+		fmt.Fprintf(w, "complete -c git-dirheat -n '__fish_use_subcommand' -a %s -d %s\n", command, fishQuote(commandSummaries[command]))
+	}
+	fmt.Fprintf(w, "complete -c git-dirheat -n %s -a '(__fish_complete_directories)'\n", fishQuote(noCommand))
+	fmt.Fprintf(w, "complete -c git-dirheat -n '__fish_seen_subcommand_from completion' -a %s\n", fishQuote(strings.Join(completionShells, " ")))
+	for _, command := range append(commands, "") {
+		condition := noCommand
+		if command != "" {
+			condition = "__fish_seen_subcommand_from " + command
+		}
+		for _, f := range flags[command] {
+			fmt.Fprintf(w, "complete -c git-dirheat -n %s -o %s -d %s", fishQuote(condition), f.name, fishQuote(f.usage))
+			switch {
+			case f.boolean:
+			case flagValues[f.name] != nil:
+				fmt.Fprintf(w, " -x -a %s", fishQuote(strings.Join(flagValues[f.name], " ")))
+			case f.takesFile():
+				fmt.Fprint(w, " -r -F")
+			default:
+				fmt.Fprint(w, " -x")
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// runCompletion implements the completion command, printing the completion script of a shell
+func runCompletion(args []string) int {
+	flags := flag.NewFlagSet("completion", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s completion bash|zsh|fish\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Prints the completion script of the shell for the commands and their options.")
+		fmt.Fprintln(flags.Output(), "\nExamples:")
+		fmt.Fprintln(flags.Output(), "  source <(git-dirheat completion bash)  # in ~/.bashrc")
+		fmt.Fprintln(flags.Output(), "  git-dirheat completion zsh > \"${fpath[1]}/_git-dirheat\"")
+		fmt.Fprintln(flags.Output(), "  git-dirheat completion fish > ~/.config/fish/completions/git-dirheat.fish")
+	}
+	parseArgs(flags, args)
+	scripts := map[string]func(io.Writer, []string, map[string][]completionFlag){"bash": bashCompletion, "zsh": zshCompletion, "fish": fishCompletion}
+	script, ok := scripts[flags.Arg(0)]
+	if flags.NArg() != 1 || !ok {
+		flags.Usage()
+		return exitUsage
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+	commands := completionCommands()
+	options := make(map[string][]completionFlag)
+	for _, command := range append(commands, "") {
+		if command == "completion" {
+			continue
+		}
+		if options[command], err = commandFlags(executable, command); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitError
+		}
+	}
+	script(os.Stdout, commands, options)
+	return exitOK
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// minCompressSize is the smallest response worth compressing, smaller bodies rarely shrink
+const minCompressSize = 1024
+
+// negotiateEncoding picks the response encoding from an Accept-Encoding header, preferring gzip.
+// It returns "" when the client accepts neither gzip nor deflate. Brotli is left out on purpose, the
+// standard library has no encoder for it.
+func negotiateEncoding(acceptEncoding string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		accepted[name] = q > 0
+	}
+	for _, encoding := range []string{"gzip", "deflate"} {
+		if accepted[encoding] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compressWriter compresses the response body once the handler has committed to a compressible response
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	writer      io.WriteCloser
+	wroteHeader bool
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	h := cw.Header()
+	h.Add("Vary", "Accept-Encoding")
+
+	length, _ := strconv.Atoi(h.Get("Content-Length"))
+	compressible := code == http.StatusOK && h.Get("Content-Encoding") == "" &&
+		(h.Get("Content-Length") == "" || length >= minCompressSize)
+	if compressible {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		// The compressed body is a different byte sequence, so only a weak validator still applies
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		switch cw.encoding {
+		case "gzip":
+			cw.writer = gzip.NewWriter(cw.ResponseWriter)
+		case "deflate":
+			cw.writer, _ = flate.NewWriter(cw.ResponseWriter, flate.DefaultCompression)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.writer != nil {
+		return cw.writer.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush flushes buffered compressed data to the client, keeping streaming responses working
+func (cw *compressWriter) Flush() {
+	if gz, ok := cw.writer.(*gzip.Writer); ok {
+		gz.Flush()
+	} else if fl, ok := cw.writer.(*flate.Writer); ok {
+		fl.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *compressWriter) close() {
+	if cw.writer != nil {
+		cw.writer.Close()
+	}
+}
+
+// withCompression transparently compresses responses for clients that accept gzip or deflate
+func withCompression(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			w.Header().Add("Vary", "Accept-Encoding")
+			next(w, r)
+			return
+		}
+		// Byte ranges would refer to the uncompressed body, always serve the full representation instead
+		r.Header.Del("Range")
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		next(cw, r)
+	}
+}
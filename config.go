@@ -0,0 +1,229 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// repoConfigFile is the name of the configuration file at the root of a repository
+const repoConfigFile = ".dirheat.yaml"
+
+// repoConfigOptions are the options a repository's own configuration file may set: those shaping
+// the analysis and its views. Anything writing files, sending data, listening or signing in is
+// left to the user's configuration, the environment and the command line, so analyzing a
+// repository can't turn it on.
+var repoConfigOptions = map[string]bool{
+	"min-value": true, "min-percent": true, "max-depth": true, "scale": true, "normalize": true,
+	"rev": true, "mode": true, "blame-window": true, "blame-author": true, "copies": true,
+	"case-fold": true, "group-by": true, "module-map": true, "test-patterns": true,
+	"security-paths": true, "weight-profile": true,
+}
+
+// configKey matches a top-level "option: value" line of a configuration file
+var configKey = regexp.MustCompile(`^([a-z0-9-]+):(?:\s+(.*))?$`)
+
+// configFiles returns the configuration files applying to the repository, lowest precedence
+// first: the user's ($XDG_CONFIG_HOME or ~/.config/dirheat/config.yaml), then the repository's
+// unless repo is empty
+func configFiles(repo string) []string {
+	var files []string
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, ".config")
+		}
+	}
+	if dir != "" {
+		files = append(files, filepath.Join(dir, "dirheat", "config.yaml"))
+	}
+	if repo != "" {
+		files = append(files, filepath.Join(repo, repoConfigFile))
+	}
+	return files
+}
+
+// configScalar unquotes a YAML scalar and drops a trailing comment
+func configScalar(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+		if end := strings.IndexByte(value[1:], value[0]); end >= 0 {
+			return value[1 : end+1]
+		}
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
+
+// parseConfig parses the YAML subset of the configuration files: a mapping of option names (as on
+// the command line, without the dash) to scalars, or to lists for options that may be repeated,
+// either as "[a, b]" or as "- a" lines below the name
+func parseConfig(data []byte) (map[string][]string, error) {
+	config := make(map[string][]string)
+	key := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		if item, ok := strings.CutPrefix(trimmed, "- "); ok && line != trimmed && key != "" {
+			config[key] = append(config[key], configScalar(item))
+			continue
+		}
+		match := configKey.FindStringSubmatch(strings.TrimRight(line, " \t\r"))
+		if match == nil {
+			return nil, fmt.Errorf("line %d: expected 'option: value'", i+1)
+		}
+		key = match[1]
+		if _, ok := config[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicate option '%s'", i+1, key)
+		}
+		value := configScalar(match[2])
+		switch {
+		case value == "":
+			config[key] = nil // A list follows
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			config[key] = []string{}
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = configScalar(item); item != "" {
+					config[key] = append(config[key], item)
+				}
+			}
+		default:
+			config[key] = []string{value}
+		}
+	}
+	return config, nil
+}
+
+// envAliases are environment variables named after what they configure rather than the option
+var envAliases = map[string]string{"DIRHEAT_REFRESH_INTERVAL": "refresh"}
+
+// envName returns the environment variable of an option, e.g. DIRHEAT_LOG_LEVEL for -log-level
+func envName(option string) string {
+	return "DIRHEAT_" + strings.ToUpper(strings.ReplaceAll(option, "-", "_"))
+}
+
+// envConfig returns the options set by DIRHEAT_ environment variables. Variables not naming an
+// option (e.g. DIRHEAT_JIRA_TOKEN) are left alone.
+func envConfig(flags *flag.FlagSet) map[string][]string {
+	config := make(map[string][]string)
+	for variable, option := range envAliases {
+		if value, ok := os.LookupEnv(variable); ok && value != "" {
+			config[option] = []string{value}
+		}
+	}
+	flags.VisitAll(func(f *flag.Flag) {
+		if value, ok := os.LookupEnv(envName(f.Name)); ok && value != "" {
+			config[f.Name] = []string{value}
+		}
+	})
+	return config
+}
+
+// configSources records where each option not left at its default came from: "command line", a
+// configuration file or an environment variable
+var configSources = make(map[string]string)
+
+// applyConfig sets the options that weren't given on the command line from the configuration files
+// and the environment. Later files take precedence over earlier ones and the environment over all
+// files; missing files are skipped. It returns the files read.
+func applyConfig(flags *flag.FlagSet, files []string) ([]string, error) {
+	flags.Visit(func(f *flag.Flag) { configSources[f.Name] = "command line" })
+
+	type setting struct {
+		source string
+		values []string
+	}
+	settings := make(map[string]setting)
+	var read []string
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading the configuration: %w", err)
+		}
+		config, err := parseConfig(data)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing '%s': %w", file, err)
+		}
+		for name, values := range config {
+			if flags.Lookup(name) == nil {
+				return nil, fmt.Errorf("error parsing '%s': unknown option '%s'", file, name)
+			}
+			if filepath.Base(file) == repoConfigFile && !repoConfigOptions[name] {
+				return nil, fmt.Errorf("error parsing '%s': option '%s' can't be set by the repository, only in the user's configuration, the environment or on the command line", file, name)
+			}
+			settings[name] = setting{file, values}
+		}
+		read = append(read, file)
+	}
+	for name, values := range envConfig(flags) {
+		source := "$" + envName(name)
+		for variable, option := range envAliases {
+			if option == name && os.Getenv(envName(name)) == "" {
+				source = "$" + variable
+			}
+		}
+		settings[name] = setting{source, values}
+	}
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if configSources[name] != "" {
+			continue
+		}
+		for _, value := range settings[name].values {
+			if err := flags.Set(name, value); err != nil {
+				return nil, fmt.Errorf("invalid %s '%s' in %s: %w", name, value, settings[name].source, err)
+			}
+		}
+		configSources[name] = settings[name].source
+	}
+	return read, nil
+}
+
+// secretOptions are the options -print-config masks: secrets, and incoming webhook URLs whose
+// path is the secret
+var secretOptions = map[string]bool{"oauth-client-secret": true, "notify-webhook": true}
+
+// urlOptions are the URL options -print-config prints without their user info, where tokens go
+var urlOptions = map[string]bool{"clone": true, "pushgateway": true}
+
+// printConfig writes the effective options in the configuration file format, each with its source
+// as comment, followed by the repositories
+func printConfig(w io.Writer, flags *flag.FlagSet, repos []string) {
+	flags.VisitAll(func(f *flag.Flag) {
+		if f.Name == "print-config" {
+			return
+		}
+		value := f.Value.String()
+		if secretOptions[f.Name] && value != "" {
+			value = "********"
+		} else if urlOptions[f.Name] {
+			value = redactURL(value)
+		}
+		if value == "" || strings.ContainsAny(value, "#:'\"[]") || strings.TrimSpace(value) != value {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(w, "%s: %s # %s\n", f.Name, value, cmp.Or(configSources[f.Name], "default"))
+	})
+	fmt.Fprintf(w, "# repositories: %s\n", strings.Join(repos, ", "))
+}
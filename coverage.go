@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CoverageOverlay is the test coverage of a node, summed over the covered files below it
+type CoverageOverlay struct {
+	Covered int     `json:"covered"` // Covered lines (statements for Go coverprofiles)
+	Total   int     `json:"total"`
+	Percent float64 `json:"percent"`
+}
+
+// fileCoverage counts the covered and coverable lines of the files of a coverage report
+type fileCoverage map[string]*CoverageOverlay
+
+func (c fileCoverage) add(file string, covered, total int) {
+	entry := c[file]
+	if entry == nil {
+		entry = &CoverageOverlay{}
+		c[file] = entry
+	}
+	entry.Covered += covered
+	entry.Total += total
+}
+
+// parseLCOV reads an LCOV tracefile, counting the DA records of every SF section
+func parseLCOV(data []byte) (fileCoverage, error) {
+	coverage := make(fileCoverage)
+	scanner := newLineScanner(data)
+	file := ""
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "SF:"):
+			file = line[3:]
+		case strings.HasPrefix(line, "DA:") && file != "":
+			fields := strings.Split(line[3:], ",")
+			if len(fields) < 2 {
+				continue
+			}
+			hits, err := strconv.Atoi(fields[1])
+			if err != nil {
+				continue
+			}
+			covered := 0
+			if hits > 0 {
+				covered = 1
+			}
+			coverage.add(file, covered, 1)
+		case line == "end_of_record":
+			file = ""
+		}
+	}
+	return coverage, scanner.Err()
+}
+
+// parseCobertura reads a Cobertura XML report, counting the lines of every class
+func parseCobertura(data []byte) (fileCoverage, error) {
+	var report struct {
+		Sources []string `xml:"sources>source"`
+		Classes []struct {
+			Filename string `xml:"filename,attr"`
+			Lines    []struct {
+				Hits int `xml:"hits,attr"`
+			} `xml:"lines>line"`
+		} `xml:"packages>package>classes>class"`
+	}
+	if err := xml.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+	coverage := make(fileCoverage)
+	for _, class := range report.Classes {
+		covered := 0
+		for _, line := range class.Lines {
+			if line.Hits > 0 {
+				covered++
+			}
+		}
+		file := class.Filename
+		if len(report.Sources) > 0 && !filepath.IsAbs(file) {
+			file = strings.TrimRight(report.Sources[0], "/") + "/" + file
+		}
+		coverage.add(file, covered, len(class.Lines))
+	}
+	return coverage, nil
+}
+
+// parseCoverprofile reads a Go coverage profile, counting statements. Blocks are listed once per
+// test binary that covers them, so a block counts as covered when any of its records has hits.
+func parseCoverprofile(data []byte) (fileCoverage, error) {
+	type block struct {
+		statements int
+		covered    bool
+	}
+	blocks := make(map[string]*block)
+	files := make(map[string]string)
+	scanner := newLineScanner(data)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "mode:") || line == "" {
+			continue
+		}
+		// file.go:12.5,14.2 3 1
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid coverprofile line '%s'", line)
+		}
+		file, _, ok := strings.Cut(fields[0], ":")
+		statements, err1 := strconv.Atoi(fields[1])
+		count, err2 := strconv.Atoi(fields[2])
+		if !ok || err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid coverprofile line '%s'", line)
+		}
+		b := blocks[fields[0]]
+		if b == nil {
+			b = &block{statements: statements}
+			blocks[fields[0]] = b
+			files[fields[0]] = file
+		}
+		b.covered = b.covered || count > 0
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	coverage := make(fileCoverage)
+	for key, b := range blocks {
+		covered := 0
+		if b.covered {
+			covered = b.statements
+		}
+		coverage.add(files[key], covered, b.statements)
+	}
+	return coverage, nil
+}
+
+// repoRelative maps a path of a report (absolute, relative to another root, or a Go import path)
+// to the repository file it names: the longest suffix of it existing at HEAD
+func repoRelative(head *headTree, file string) (string, bool) {
+	parts := strings.Split(strings.ReplaceAll(file, `\`, "/"), "/")
+	for i := range parts {
+		if candidate := strings.Join(parts[i:], "/"); head.files[candidate] {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// loadCoverage reads an LCOV, Cobertura or Go coverprofile report and returns the coverage per file
+// and directory of the repository, the whole repository under ""
+func loadCoverage(file string, head *headTree) (map[string]*CoverageOverlay, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var coverage fileCoverage
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		coverage, err = parseCobertura(data)
+	case bytes.HasPrefix(trimmed, []byte("mode:")):
+		coverage, err = parseCoverprofile(data)
+	default:
+		coverage, err = parseLCOV(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing coverage report '%s': %w", file, err)
+	}
+
+	nodes := make(map[string]*CoverageOverlay)
+	add := func(path string, c *CoverageOverlay) {
+		entry := nodes[path]
+		if entry == nil {
+			entry = &CoverageOverlay{}
+			nodes[path] = entry
+		}
+		entry.Covered += c.Covered
+		entry.Total += c.Total
+	}
+	unmatched := 0
+	for reported, c := range coverage {
+		path, ok := repoRelative(head, reported)
+		if !ok {
+			unmatched++
+			continue
+		}
+		add(path, c)
+		for _, dir := range directoriesOf(path, math.MaxInt) {
+			add(dir, c)
+		}
+		add("", c)
+	}
+	for _, entry := range nodes {
+		if entry.Total > 0 {
+			entry.Percent = math.Round(float64(entry.Covered)/float64(entry.Total)*10000) / 100
+		}
+	}
+	if unmatched > 0 && len(nodes) == 0 {
+		return nil, fmt.Errorf("none of the %d files of coverage report '%s' exist in the repository", unmatched, file)
+	}
+	return nodes, nil
+}
+
+// coverageOverlay returns the tree overlay of the coverage
+func coverageOverlay(coverage map[string]*CoverageOverlay) treeOverlay {
+	return treeOverlay{field: "coverage", value: func(path string, n Node, rootValue int) any {
+		if entry := coverage[path]; entry != nil {
+			return entry
+		}
+		return nil
+	}}
+}
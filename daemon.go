@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// daemonStartup is how long the query command retries connecting while the daemon starts
+const daemonStartup = 10 * time.Second
+
+// socketPath is the -socket option: serve on this Unix domain socket instead of the TCP port,
+// running as the local daemon the query command talks to
+var socketPath string
+
+// listenSocket opens the daemon's listener on a Unix domain socket only the user can connect to.
+// The socket is created in a fresh directory only the user can enter and restricted to the user
+// before it is moved into place, so it is never reachable by others, whatever the umask. A socket
+// left behind by a daemon that died is replaced; one a daemon answers on is an error.
+func listenSocket(path string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("a daemon is already listening on %s", path)
+	}
+	info, err := os.Lstat(path)
+	switch {
+	case err == nil && info.Mode()&os.ModeSocket == 0:
+		return nil, fmt.Errorf("%s exists and is not a socket", path)
+	case err == nil:
+		slog.Warn("Replacing the stale socket of a daemon that is gone", "socket", path)
+	}
+
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".dirheat-socket-") // Created with mode 0700
+	if err != nil {
+		return nil, fmt.Errorf("error creating the socket's private directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	private := filepath.Join(dir, "socket")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: private, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	listener.SetUnlinkOnClose(false) // The socket is removed from where it was moved to
+	if err := os.Chmod(private, 0o600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error restricting the socket to the user: %w", err)
+	}
+	if err := os.Rename(private, path); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error moving the socket into place: %w", err)
+	}
+	return &socketListener{Listener: listener, path: path}, nil
+}
+
+// socketListener is the daemon's listener, removing its socket when closed
+type socketListener struct {
+	net.Listener
+	path string
+}
+
+func (l *socketListener) Close() error {
+	err := l.Listener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+// socketClient returns an HTTP client talking to the daemon on the Unix domain socket
+func socketClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+// queryDaemon requests the endpoint from the daemon, retrying while it starts and while its first
+// analysis runs (503 with Retry-After) until wait has passed
+func queryDaemon(ctx context.Context, client *http.Client, endpoint string, wait time.Duration) (*http.Response, error) {
+	start := time.Now()
+	deadline := start.Add(wait)
+	for {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://dirheat"+endpoint, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint '%s': %w", endpoint, err)
+		}
+		response, err := client.Do(request)
+		retry := time.Duration(0)
+		switch {
+		case err != nil && time.Since(start) < min(wait, daemonStartup):
+			retry = 200 * time.Millisecond // The daemon creates the socket once it listens
+		case err != nil:
+			return nil, err
+		case response.StatusCode == http.StatusServiceUnavailable:
+			seconds, _ := strconv.Atoi(response.Header.Get("Retry-After"))
+			if retry = time.Duration(seconds) * time.Second; retry <= 0 || time.Now().Add(retry).After(deadline) {
+				return response, nil
+			}
+			response.Body.Close()
+		default:
+			return response, nil
+		}
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// runQuery is the query command: the thin client of a daemon (a server started with -socket)
+// holding the analysis and its caches, so repeated queries don't analyze the repository again
+func runQuery(args []string) int {
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+	socket := flags.String("socket", os.Getenv("DIRHEAT_SOCKET"), "Unix domain socket of the daemon (default: $DIRHEAT_SOCKET)")
+	wait := flags.Duration("wait", 10*time.Minute, "how long to wait for the daemon's first analysis")
+	output := flags.String("o", "", "write the response to this file instead of standard output")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s query [options] <endpoint>\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Requests an endpoint, e.g. '/files?limit=10' or '/data?metric=lines', from the daemon started with -socket and prints the response.")
+		flags.PrintDefaults()
+	}
+	parseArgs(flags, args)
+	if *socket == "" || flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	endpoint := flags.Arg(0)
+	if !strings.HasPrefix(endpoint, "/") {
+		endpoint = "/" + endpoint
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	response, err := queryDaemon(ctx, socketClient(*socket), endpoint, *wait)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error querying the daemon on %s: %v\n", *socket, err)
+		fmt.Fprintf(os.Stderr, "Start one with: %s -socket %s <repo>\n", os.Args[0], *socket)
+		return exitError
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		fmt.Fprintf(os.Stderr, "Error: %s: %s\n", response.Status, strings.TrimSpace(string(body)))
+		return exitError
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating output file: %v\n", err)
+			return exitError
+		}
+		defer file.Close()
+		out = file
+	}
+	if _, err := io.Copy(out, response.Body); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing the response: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// codeownersFiles are the places GitHub and GitLab look for CODEOWNERS, in order
+var codeownersFiles = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS", ".gitlab/CODEOWNERS"}
+
+// DescriptionOverlay explains what a directory is, for the tooltips
+type DescriptionOverlay struct {
+	Title  string   `json:"title,omitempty"`  // First heading of the directory's README
+	Owners []string `json:"owners,omitempty"` // CODEOWNERS owners of the directory
+}
+
+// codeownersRule is a CODEOWNERS line: a gitignore-style pattern and its owners
+type codeownersRule struct {
+	pattern string
+	owners  []string
+}
+
+// readBlobs reads the files at the analyzed revision, by path. Files missing at the revision are
+// left out.
+func readBlobs(ctx context.Context, repo string, files []string) (map[string][]byte, error) {
+	objects := make([]string, len(files))
+	for i, file := range files {
+		objects[i] = analysisRev + ":" + file
+	}
+	contents, err := catFiles(ctx, repo, objects)
+	if err != nil {
+		return nil, fmt.Errorf("error reading files at %s: %w", analysisRev, err)
+	}
+	blobs := make(map[string][]byte, len(contents))
+	for i, file := range files {
+		if content, ok := contents[objects[i]]; ok {
+			blobs[file] = content
+		}
+	}
+	return blobs, nil
+}
+
+// catFiles reads objects (hashes or revision:path) with a single git cat-file --batch, by object
+// name. Missing objects are left out.
+func catFiles(ctx context.Context, repo string, objects []string) (map[string][]byte, error) {
+	var request bytes.Buffer
+	for _, object := range objects {
+		request.WriteString(object + "\n")
+	}
+	cmd := gitCommand(ctx, repo, "cat-file", "--batch")
+	cmd.Stdin = &request
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	contents := make(map[string][]byte)
+	reader := bufio.NewReader(bytes.NewReader(output))
+	for _, object := range objects {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, errors.New("unexpected end of git cat-file output")
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			continue // "<object> missing"
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid git cat-file header '%s'", strings.TrimSpace(header))
+		}
+		content := make([]byte, size+1) // Followed by a newline
+		if _, err := io.ReadFull(reader, content); err != nil {
+			return nil, err
+		}
+		contents[object] = content[:size]
+	}
+	return contents, nil
+}
+
+// readmeTitle returns the first heading of a Markdown, reStructuredText or AsciiDoc README: an
+// ATX heading ("# Title", "= Title") or a line underlined with = or -
+func readmeTitle(content []byte) string {
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if title := strings.TrimLeft(line, "#"); title != line && strings.HasPrefix(title, " ") {
+			return strings.TrimSpace(strings.TrimRight(title, "#"))
+		}
+		if title, ok := strings.CutPrefix(line, "= "); ok {
+			return strings.TrimSpace(title)
+		}
+		if line != "" && strings.Trim(line, "=-") != "" && i+1 < len(lines) {
+			underline := strings.TrimSpace(lines[i+1])
+			if len(underline) >= 3 && (strings.Trim(underline, "=") == "" || strings.Trim(underline, "-") == "") {
+				return line
+			}
+		}
+	}
+	return ""
+}
+
+// parseCodeowners returns the rules of a CODEOWNERS file, in file order. Section headers of GitLab
+// ("[Section]") and rules without owners are skipped.
+func parseCodeowners(content []byte) []codeownersRule {
+	var rules []codeownersRule
+	for _, line := range strings.Split(string(content), "\n") {
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "[") || strings.HasPrefix(fields[0], "^[") {
+			continue
+		}
+		rules = append(rules, codeownersRule{pattern: fields[0], owners: fields[1:]})
+	}
+	return rules
+}
+
+// matchesDirectory reports whether the CODEOWNERS pattern covers the directory dir ("" for the
+// root): patterns naming the directory or one of its parents, "*" and "**". Patterns without a
+// slash match at any depth, like in .gitignore.
+func (r codeownersRule) matchesDirectory(dir string) bool {
+	pattern := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(r.pattern, "/**"), "/*"), "/")
+	if pattern == "*" || pattern == "**" || pattern == "" {
+		return true
+	}
+	if dir == "" {
+		return false
+	}
+	pattern, anchored := strings.CutPrefix(pattern, "/")
+	if rest, ok := strings.CutPrefix(pattern, "**/"); ok {
+		pattern, anchored = rest, false
+	}
+	anchored = anchored || strings.Contains(pattern, "/")
+	parts := strings.Split(dir, "/")
+	for end := 1; end <= len(parts); end++ {
+		for start := 0; start < end; start++ {
+			if anchored && start > 0 {
+				break
+			}
+			if matched, _ := path.Match(pattern, strings.Join(parts[start:end], "/")); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadDescriptions reads the first heading of every directory's README and the CODEOWNERS owners of
+// every directory at the analyzed revision, by directory path ("" for the root)
+func loadDescriptions(ctx context.Context, repo string, head *headTree) (map[string]*DescriptionOverlay, error) {
+	readmes := make(map[string]string) // By directory, Markdown preferred
+	var files []string
+	for file := range head.files {
+		dir, name := path.Split(file)
+		dir = strings.TrimSuffix(dir, "/")
+		if !strings.HasPrefix(strings.ToUpper(name), "README") {
+			continue
+		}
+		if current, ok := readmes[dir]; !ok || (!strings.HasSuffix(strings.ToLower(current), ".md") && strings.HasSuffix(strings.ToLower(name), ".md")) {
+			readmes[dir] = file
+		}
+	}
+	for _, file := range readmes {
+		files = append(files, file)
+	}
+	var codeowners string
+	for _, file := range codeownersFiles {
+		if head.files[file] {
+			codeowners = file
+			files = append(files, file)
+			break
+		}
+	}
+	if len(files) == 0 {
+		return map[string]*DescriptionOverlay{}, nil
+	}
+	blobs, err := readBlobs(ctx, repo, files)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptions := make(map[string]*DescriptionOverlay)
+	for dir, file := range readmes {
+		if title := readmeTitle(blobs[file]); title != "" {
+			descriptions[dir] = &DescriptionOverlay{Title: title}
+		}
+	}
+	if codeowners != "" {
+		rules := parseCodeowners(blobs[codeowners])
+		for dir := range head.dirs {
+			assignOwners(descriptions, dir, rules)
+		}
+		assignOwners(descriptions, "", rules)
+	}
+	return descriptions, nil
+}
+
+// assignOwners sets the owners of the last CODEOWNERS rule covering dir, which wins like on GitHub
+func assignOwners(descriptions map[string]*DescriptionOverlay, dir string, rules []codeownersRule) {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].matchesDirectory(dir) {
+			if descriptions[dir] == nil {
+				descriptions[dir] = &DescriptionOverlay{}
+			}
+			descriptions[dir].Owners = rules[i].owners
+			return
+		}
+	}
+}
+
+// descriptionOverlay attaches the README titles and CODEOWNERS owners to the directory nodes
+func descriptionOverlay(descriptions map[string]*DescriptionOverlay) treeOverlay {
+	return treeOverlay{field: "description", value: func(path string, n Node, rootValue int) any {
+		if entry := descriptions[path]; entry != nil && !n.IsFile() {
+			return entry
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Digest limits: entries per section, and the thresholds of the bus-factor warnings
+const (
+	digestEntries        = 5
+	busFactorMinCommits  = 10  // Directories with fewer commits in the period are not judged
+	busFactorShare       = 0.8 // Share of the commits by one author that triggers a warning
+	busFactorPeriod      = 365 * 24 * time.Hour
+	defaultDigestWindow  = 7 * 24 * time.Hour
+	digestDirectoryDepth = 2
+)
+
+// Mover is a directory whose churn changed the most between the previous and the recent window
+type Mover struct {
+	Path     string `json:"path"`
+	Recent   int    `json:"recent"`   // File changes within the window
+	Previous int    `json:"previous"` // File changes within the window before
+}
+
+// BusFactorWarning is a directory changed almost only by one author
+type BusFactorWarning struct {
+	Path    string  `json:"path"`
+	Author  string  `json:"author"`
+	Share   float64 `json:"share"` // Share of the directory's commits within the last year
+	Commits int     `json:"commits"`
+}
+
+// Digest summarizes what happened recently, for notifications
+type Digest struct {
+	Repo        string             `json:"repo"`
+	Window      string             `json:"window"`
+	Since       time.Time          `json:"since"`
+	Commits     int                `json:"commits"` // Within the window
+	Movers      []Mover            `json:"movers"`
+	NewHotspots []Hotspot          `json:"newHotspots"` // Hottest files of the window that weren't hot in the window before
+	BusFactor   []BusFactorWarning `json:"busFactor"`
+	Anomalies   []Anomaly          `json:"anomalies"` // Directories whose monthly changes broke with their pattern since the window began
+}
+
+// hottest returns the hottest hotspotShare of the files in values with at least two changes
+func hottest(values map[string]int) []Hotspot {
+	var files []Hotspot
+	for path, value := range values {
+		if value >= 2 {
+			files = append(files, Hotspot{Path: path, Value: value})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Value != files[j].Value {
+			return files[i].Value > files[j].Value
+		}
+		return files[i].Path < files[j].Path
+	})
+	return files[:min(int(math.Ceil(float64(len(values))*hotspotShare)), len(files))]
+}
+
+// buildDigest summarizes the window before now: the top movers, new hotspots and bus-factor warnings
+func buildDigest(a *Analysis, window time.Duration, now time.Time) *Digest {
+	since := now.Add(-window)
+	digest := &Digest{Repo: a.Root.Name(), Window: formatAge(window), Since: since,
+		Movers: []Mover{}, NewHotspots: []Hotspot{}, BusFactor: []BusFactorWarning{}}
+
+	recentDirs, previousDirs := make(map[string]int), make(map[string]int)
+	recentFiles, previousFiles := make(map[string]int), make(map[string]int)
+	authorCommits := make(map[string]map[string]int) // Directory, author name: commits
+	dirCommits := make(map[string]int)
+	for _, commit := range a.Commits {
+		age := now.Sub(commit.Date)
+		if age > busFactorPeriod && age > 2*window {
+			break // Newest first
+		}
+		recent := !commit.Date.Before(since)
+		if recent {
+			digest.Commits++
+		}
+		touched := make(map[string]bool)
+		for _, file := range commit.Files {
+			dirs := directoriesOf(file.Path, digestDirectoryDepth)
+			if age <= 2*window {
+				files, dirValues := previousFiles, previousDirs
+				if recent {
+					files, dirValues = recentFiles, recentDirs
+				}
+				files[file.Path]++
+				for _, dir := range dirs {
+					dirValues[dir]++
+				}
+			}
+			if len(dirs) > 0 {
+				touched[dirs[0]] = true
+			}
+		}
+		if age <= busFactorPeriod {
+			for dir := range touched {
+				if authorCommits[dir] == nil {
+					authorCommits[dir] = make(map[string]int)
+				}
+				authorCommits[dir][commit.Author]++
+				dirCommits[dir]++
+			}
+		}
+	}
+
+	for dir := range recentDirs {
+		digest.Movers = append(digest.Movers, Mover{Path: dir, Recent: recentDirs[dir], Previous: previousDirs[dir]})
+	}
+	sort.Slice(digest.Movers, func(i, j int) bool {
+		a, b := digest.Movers[i], digest.Movers[j]
+		if da, db := a.Recent-a.Previous, b.Recent-b.Previous; da != db {
+			return da > db
+		}
+		return a.Path < b.Path
+	})
+	digest.Movers = digest.Movers[:min(len(digest.Movers), digestEntries)]
+
+	previouslyHot := make(map[string]bool)
+	for _, spot := range hottest(previousFiles) {
+		previouslyHot[spot.Path] = true
+	}
+	for _, spot := range hottest(recentFiles) {
+		if !previouslyHot[spot.Path] && len(digest.NewHotspots) < digestEntries {
+			digest.NewHotspots = append(digest.NewHotspots, spot)
+		}
+	}
+
+	for dir, authors := range authorCommits {
+		if dirCommits[dir] < busFactorMinCommits {
+			continue
+		}
+		for author, commits := range authors {
+			if share := float64(commits) / float64(dirCommits[dir]); share >= busFactorShare {
+				digest.BusFactor = append(digest.BusFactor, BusFactorWarning{Path: dir, Author: author, Share: share, Commits: dirCommits[dir]})
+			}
+		}
+	}
+	sort.Slice(digest.BusFactor, func(i, j int) bool {
+		if digest.BusFactor[i].Commits != digest.BusFactor[j].Commits {
+			return digest.BusFactor[i].Commits > digest.BusFactor[j].Commits
+		}
+		return digest.BusFactor[i].Path < digest.BusFactor[j].Path
+	})
+	digest.BusFactor = digest.BusFactor[:min(len(digest.BusFactor), digestEntries)]
+
+	digest.Anomalies = detectAnomalies(a, digestDirectoryDepth, anomalyThreshold, digest.Since.UTC().Format("2006-01"))
+	digest.Anomalies = digest.Anomalies[:min(len(digest.Anomalies), digestEntries)]
+	return digest
+}
+
+// formatAge formats a duration in days when it is a whole number of days, like -blame-window takes it
+func formatAge(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
+
+// markdown renders the digest as Markdown-like text, understood by Slack (mrkdwn) and Teams
+func (d *Digest) markdown(bold func(string) string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n%d commits in the last %s.\n", bold("git-dirheat digest for "+d.Repo), d.Commits, d.Window)
+	if len(d.Movers) > 0 {
+		fmt.Fprintf(&b, "\n%s\n", bold("Top movers"))
+		for _, mover := range d.Movers {
+			fmt.Fprintf(&b, "• `%s` %d changes (%+d vs the %s before)\n", mover.Path, mover.Recent, mover.Recent-mover.Previous, d.Window)
+		}
+	}
+	if len(d.NewHotspots) > 0 {
+		fmt.Fprintf(&b, "\n%s\n", bold("New hotspots"))
+		for _, spot := range d.NewHotspots {
+			fmt.Fprintf(&b, "• `%s` %d changes\n", spot.Path, spot.Value)
+		}
+	}
+	if len(d.BusFactor) > 0 {
+		fmt.Fprintf(&b, "\n%s\n", bold("Bus-factor warnings"))
+		for _, warning := range d.BusFactor {
+			fmt.Fprintf(&b, "• `%s` %.0f%% of %d commits in the last year by %s\n", warning.Path, warning.Share*100, warning.Commits, warning.Author)
+		}
+	}
+	if len(d.Anomalies) > 0 {
+		fmt.Fprintf(&b, "\n%s\n", bold("Anomalies"))
+		for _, anomaly := range d.Anomalies {
+			fmt.Fprintf(&b, "• `%s` %d changes in %s, a %s (%.1f standard deviations from %.1f)\n", anomaly.Path, anomaly.Value, anomaly.Month, anomaly.Direction, math.Abs(anomaly.ZScore), anomaly.Mean)
+		}
+	}
+	return b.String()
+}
+
+// handleDigest serves GET /digest?window=7d, the digest the notifications post
+func handleDigest(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	window := defaultDigestWindow
+	if value := r.URL.Query().Get("window"); value != "" {
+		parsed, err := parseAge(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid window '%s'", value), http.StatusBadRequest)
+			return
+		}
+		window = parsed
+	}
+	writeJSON(w, buildDigest(analysis, window, time.Now()))
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SMTPOptions configures sending the digest by email
+type SMTPOptions struct {
+	Addr     string // host:port of the SMTP server, STARTTLS is used when offered
+	User     string // Empty sends without authentication
+	Password string
+	From     string
+	To       []string
+}
+
+// digestHTML renders a digest as an HTML email body
+var digestHTML = template.Must(template.New("digest").Funcs(template.FuncMap{
+	"percent": func(share float64) string { return fmt.Sprintf("%.0f%%", share*100) },
+	"delta":   func(m Mover) string { return fmt.Sprintf("%+d", m.Recent-m.Previous) },
+}).Parse(`<!DOCTYPE html>
+<html><body style="font-family: sans-serif">
+<h2>git-dirheat digest for {{.Repo}}</h2>
+<p>{{.Commits}} commits in the last {{.Window}}.</p>
+{{if .Movers}}<h3>Top movers</h3>
+<table cellpadding="4"><tr><th align="left">Directory</th><th>Changes</th><th>vs the {{.Window}} before</th></tr>
+{{range .Movers}}<tr><td><code>{{.Path}}</code></td><td align="right">{{.Recent}}</td><td align="right">{{delta .}}</td></tr>
+{{end}}</table>{{end}}
+{{if .NewHotspots}}<h3>New hotspots</h3>
+<ul>{{range .NewHotspots}}<li><code>{{.Path}}</code> {{.Value}} changes</li>{{end}}</ul>{{end}}
+{{if .BusFactor}}<h3>Bus-factor warnings</h3>
+<ul>{{range .BusFactor}}<li><code>{{.Path}}</code> {{percent .Share}} of {{.Commits}} commits in the last year by {{.Author}}</li>{{end}}</ul>{{end}}
+{{if .Anomalies}}<h3>Anomalies</h3>
+<ul>{{range .Anomalies}}<li><code>{{.Path}}</code> {{.Value}} changes in {{.Month}}, a {{.Direction}} (z-score {{.ZScore}} against a mean of {{.Mean}})</li>{{end}}</ul>{{end}}
+</body></html>
+`))
+
+// digestEmail builds a multipart message with the Markdown and HTML renderings of the digest
+func digestEmail(digest *Digest, from string, to []string, now time.Time) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	part := func(contentType string, write func(w *quotedprintable.Writer) error) error {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if err := write(qp); err != nil {
+			return err
+		}
+		return qp.Close()
+	}
+	err := part("text/plain", func(w *quotedprintable.Writer) error {
+		_, err := w.Write([]byte(digest.markdown(func(s string) string { return "**" + s + "**" })))
+		return err
+	})
+	if err == nil {
+		err = part("text/html", func(w *quotedprintable.Writer) error { return digestHTML.Execute(w, digest) })
+	}
+	if err == nil {
+		err = parts.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "git-dirheat digest for "+digest.Repo))
+	fmt.Fprintf(&message, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	body.WriteTo(&message)
+	return message.Bytes(), nil
+}
+
+// sendDigest emails the digest to the configured recipients
+func sendDigest(digest *Digest, opts SMTPOptions) error {
+	from, err := mail.ParseAddress(opts.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender '%s': %w", opts.From, err)
+	}
+	recipients := make([]string, 0, len(opts.To))
+	for _, to := range opts.To {
+		address, err := mail.ParseAddress(strings.TrimSpace(to))
+		if err != nil {
+			return fmt.Errorf("invalid recipient '%s': %w", to, err)
+		}
+		recipients = append(recipients, address.Address)
+	}
+	message, err := digestEmail(digest, from.String(), opts.To, time.Now())
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if opts.User != "" {
+		host, _, _ := net.SplitHostPort(opts.Addr)
+		auth = smtp.PlainAuth("", opts.User, opts.Password, host)
+	}
+	if err := smtp.SendMail(opts.Addr, auth, from.Address, recipients, message); err != nil {
+		return fmt.Errorf("error sending the digest: %w", err)
+	}
+	return nil
+}
+
+// runDigest implements the digest command
+func runDigest(args []string) int {
+	flags := flag.NewFlagSet("digest", flag.ContinueOnError)
+	window := flags.String("window", "7d", "period the digest covers, e.g. 7d")
+	format := flags.String("format", "markdown", "output without -to: markdown, html or json")
+	var smtpOpts SMTPOptions
+	flags.StringVar(&smtpOpts.Addr, "smtp-addr", "", "SMTP server host:port, e.g. smtp.example.com:587")
+	flags.StringVar(&smtpOpts.User, "smtp-user", "", "SMTP user, the password is read from DIRHEAT_SMTP_PASSWORD (default: no authentication)")
+	flags.StringVar(&smtpOpts.From, "from", "", "sender address of the email")
+	flags.StringVar(&expectedHash, "expect-hash", "", "fail (exit code 5) unless the analysis has this options hash")
+	to := flags.String("to", "", "comma separated recipients, e.g. a mailing list; emails the digest instead of printing it")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s digest [options] <repo>\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Prints or emails a digest of the top movers, new hotspots and bus-factor warnings, e.g. weekly from cron.")
+		flags.PrintDefaults()
+	}
+	parseArgs(flags, args)
+	period, err := parseAge(*window)
+	if err != nil || period <= 0 || flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	if *to != "" {
+		smtpOpts.To = strings.Split(*to, ",")
+		smtpOpts.Password = os.Getenv("DIRHEAT_SMTP_PASSWORD")
+		if smtpOpts.Addr == "" || smtpOpts.From == "" {
+			fmt.Fprintln(os.Stderr, "Error: -smtp-addr and -from are required with -to.")
+			flags.Usage()
+			return exitUsage
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	analysis, err := analyze(ctx, flags.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error analyzing repository: %v\n", err)
+		return exitCode(err)
+	}
+	digest := buildDigest(analysis, period, time.Now())
+
+	if *to != "" {
+		if err := sendDigest(digest, smtpOpts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitError
+		}
+		fmt.Fprintf(os.Stderr, "Sent the digest to %s\n", *to)
+		return exitOK
+	}
+	switch *format {
+	case "markdown":
+		fmt.Print(digest.markdown(func(s string) string { return "**" + s + "**" }))
+	case "html":
+		err = digestHTML.Execute(os.Stdout, digest)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(digest)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown format '%s'.\n", *format)
+		return exitUsage
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing the digest: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// treeEncoder writes the JSON tree straight from the internal tree, without materializing a JSON
+// node per tree node first. Every node object has the fields id, path, name, value,
+// percentOfParent, percentOfRoot and rank (plus rawValue with a scale), followed by other,
+// truncated, annotation, the overlay fields, metrics and children when they apply.
+type treeEncoder struct {
+	w           *bufio.Writer
+	minValue    int                           // Siblings below this value are collapsed into an "other" node
+	rootValue   int                           // Value of the analysis root, for percentOfRoot
+	annotations map[string]*Annotation        // By path
+	scaled      map[Node]float64              // Scaled values replacing the raw ones, nil without a scale
+	metrics     map[string]map[string]float64 // By path
+}
+
+// valueScales transform a file value given all file values in ascending order
+var valueScales = map[string]func(value int, sorted []int) float64{
+	"log":  func(value int, _ []int) float64 { return math.Log1p(float64(value)) },
+	"sqrt": func(value int, _ []int) float64 { return math.Sqrt(float64(value)) },
+	// The share of files changed at most as often, in percent
+	"percentile": func(value int, sorted []int) float64 {
+		return 100 * float64(sort.SearchInts(sorted, value+1)) / float64(len(sorted))
+	},
+}
+
+// scaledValues transforms the file values below root with the named scale. Directories get the
+// sum of their children, so the treemap areas still add up.
+func scaledValues(root Node, scale string) map[Node]float64 {
+	transform, ok := valueScales[scale]
+	if !ok {
+		return nil
+	}
+	var sorted []int
+	var collect func(n Node)
+	collect = func(n Node) {
+		if n.IsFile() && n.Value() > 0 {
+			sorted = append(sorted, n.Value())
+		}
+		for child := range n.Children() {
+			collect(child)
+		}
+	}
+	collect(root)
+	sort.Ints(sorted)
+
+	scaled := make(map[Node]float64)
+	var sum func(n Node) float64
+	sum = func(n Node) float64 {
+		var value float64
+		if n.IsFile() && n.Value() > 0 {
+			value = transform(n.Value(), sorted)
+		}
+		for child := range n.Children() {
+			value += sum(child)
+		}
+		scaled[n] = value
+		return value
+	}
+	sum(root)
+	return scaled
+}
+
+// treeOverlay adds a field to the node objects of the JSON tree, e.g. imported code quality issues
+type treeOverlay struct {
+	field string
+	value func(path string, n Node, rootValue int) any // nil leaves the field out
+}
+
+// treeOverlays are the overlays configured at startup
+var treeOverlays []treeOverlay
+
+// treeEntry is a child as it appears in the JSON tree: a node, or the synthetic "other" node
+// collapsing small siblings
+type treeEntry struct {
+	node   Node // The zero Node for the "other" node
+	name   string
+	value  int
+	scaled float64 // Scaled value of the "other" node
+}
+
+// entries returns the children of n to encode, hottest first, ties broken by name so the order
+// doesn't depend on the tree layout
+func (e *treeEncoder) entries(n Node) []treeEntry {
+	entries := make([]treeEntry, 0, n.childCount())
+	var small []Node
+	for child := range n.Children() {
+		// Only include children with changes or that are non-empty directories
+		if child.Value() <= 0 {
+			continue
+		}
+		if child.Value() < e.minValue {
+			small = append(small, child)
+			continue
+		}
+		entries = append(entries, treeEntry{node: child, name: child.Name(), value: child.Value()})
+	}
+
+	// A single small child is kept as is, collapsing it would only hide its name
+	if len(small) == 1 {
+		entries = append(entries, treeEntry{node: small[0], name: small[0].Name(), value: small[0].Value()})
+	} else if len(small) > 1 {
+		other := treeEntry{}
+		files := 0
+		for _, child := range small {
+			other.value += child.Value()
+			other.scaled += e.scaled[child]
+			files += child.fileCount()
+		}
+		other.name = fmt.Sprintf("other (%d files)", files)
+		if files == 1 {
+			other.name = "other (1 file)"
+		}
+		entries = append(entries, other)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.value != b.value {
+			return a.value > b.value
+		}
+		return a.name < b.name
+	})
+	return entries
+}
+
+// encode writes node n at path with depth levels of descendants (negative means unlimited)
+func (e *treeEncoder) encode(n Node, path string, depth, rank, parentValue int) {
+	e.writeHeader(nodeID(path), path, n.Name(), n.Value(), e.scaled[n], rank, parentValue)
+
+	if depth == 0 {
+		for child := range n.Children() {
+			if child.Value() > 0 {
+				e.w.WriteString(`,"truncated":true`)
+				break
+			}
+		}
+	}
+	if n.Symlink() {
+		e.w.WriteString(`,"symlink":true`)
+	}
+	if annotation := e.annotations[path]; annotation != nil {
+		e.w.WriteString(`,"annotation":`)
+		e.writeValue(annotation)
+	}
+	for _, overlay := range treeOverlays {
+		if value := overlay.value(path, n, e.rootValue); value != nil {
+			e.w.WriteString(`,"` + overlay.field + `":`)
+			e.writeValue(value)
+		}
+	}
+	if metrics := e.metrics[path]; metrics != nil {
+		e.w.WriteString(`,"metrics":`)
+		e.writeValue(metrics)
+	}
+
+	if depth != 0 {
+		if entries := e.entries(n); len(entries) > 0 {
+			e.w.WriteString(`,"children":[`)
+			for i, entry := range entries {
+				if i > 0 {
+					e.w.WriteByte(',')
+				}
+				if !entry.node.exists() {
+					// A path of its own, matching the id, so clients don't mistake the node for its parent
+					otherPath := path + "/*other*"
+					e.writeHeader(nodeID(otherPath), otherPath, entry.name, entry.value, entry.scaled, i+1, n.Value())
+					e.w.WriteString(`,"other":true}`)
+					continue
+				}
+				childPath := entry.node.Name()
+				if path != "" {
+					childPath = path + "/" + childPath
+				}
+				e.encode(entry.node, childPath, depth-1, i+1, n.Value())
+			}
+			e.w.WriteByte(']')
+		}
+	}
+	e.w.WriteByte('}')
+}
+
+// writeHeader opens a node object with the fields every node has. With a scale the value is the
+// scaled one, rounded to two decimals, and the raw value follows as rawValue; percentages and
+// ranks always refer to the raw values.
+func (e *treeEncoder) writeHeader(id, path, name string, value int, scaled float64, rank, parentValue int) {
+	e.w.WriteString(`{"id":"`)
+	e.w.WriteString(id)
+	e.w.WriteString(`","path":`)
+	e.writeString(path)
+	e.w.WriteString(`,"name":`)
+	e.writeString(name)
+	e.w.WriteString(`,"value":`)
+	if e.scaled != nil {
+		e.writeFloat(math.Round(scaled*100) / 100)
+		e.w.WriteString(`,"rawValue":`)
+	}
+	e.w.WriteString(strconv.Itoa(value))
+	e.w.WriteString(`,"percentOfParent":`)
+	e.writeFloat(percentOf(value, parentValue))
+	e.w.WriteString(`,"percentOfRoot":`)
+	e.writeFloat(percentOf(value, e.rootValue))
+	e.w.WriteString(`,"rank":`)
+	e.w.WriteString(strconv.Itoa(rank))
+}
+
+// writeString writes s as a JSON string, escaped exactly like encoding/json does
+func (e *treeEncoder) writeString(s string) {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c >= 0x80 || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			e.writeValue(s) // Rare, leave the escaping rules to encoding/json
+			return
+		}
+	}
+	e.w.WriteByte('"')
+	e.w.WriteString(s)
+	e.w.WriteByte('"')
+}
+
+// writeFloat writes a percentage or scaled value like encoding/json, which uses the shortest
+// decimal form for values of this magnitude
+func (e *treeEncoder) writeFloat(f float64) {
+	var buf [32]byte
+	e.w.Write(strconv.AppendFloat(buf[:0], f, 'f', -1, 64))
+}
+
+// writeValue writes v encoded by encoding/json
+func (e *treeEncoder) writeValue(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data = []byte("null")
+	}
+	e.w.Write(data)
+}
+
+// writeDataResponse writes the /data envelope of the analysis: the metadata, recording the tree
+// options and the extra filters, and the tree. A non-empty path restricts the tree to the subtree
+// rooted at that node. It returns the options hash of the response, over all of its options.
+func writeDataResponse(w io.Writer, a *Analysis, opts TreeOptions, path string, filters map[string]string) (string, error) {
+	node, parent := a.Root.find(path)
+	if !node.exists() {
+		return "", fmt.Errorf("%w: '%s'", errPathNotFound, path)
+	}
+
+	meta := a.Meta
+	meta.Filters = make(map[string]string, len(a.Meta.Filters)+len(filters)+2)
+	for k, v := range a.Meta.Filters {
+		meta.Filters[k] = v
+	}
+	if opts.MinValue > 0 {
+		meta.Filters["minValue"] = fmt.Sprint(opts.MinValue)
+	}
+	if opts.MinPercent > 0 {
+		meta.Filters["minPercent"] = fmt.Sprint(opts.MinPercent)
+	}
+	if opts.MaxDepth > 0 {
+		meta.Filters["maxDepth"] = fmt.Sprint(opts.MaxDepth)
+	}
+	if _, ok := valueScales[opts.Scale]; ok {
+		meta.Filters["scale"] = opts.Scale
+	}
+	if node != a.Root {
+		meta.Filters["path"] = "/" + node.relPath()
+	}
+	for k, v := range filters {
+		meta.Filters[k] = v
+	}
+	meta.OptionsHash = optionsHash(meta)
+
+	encoder := &treeEncoder{w: bufio.NewWriter(w), minValue: opts.minValue(a.Root.Value()), rootValue: a.Root.Value(), scaled: scaledValues(a.Root, opts.Scale), metrics: nodeMetrics(a)}
+	if annotations != nil {
+		encoder.annotations = annotations.byPath()
+	}
+	depth := opts.MaxDepth
+	if depth <= 0 {
+		depth = -1
+	}
+	parentValue := node.Value()
+	if parent.exists() {
+		parentValue = parent.Value()
+	}
+
+	encoder.w.WriteString(`{"metadata":`)
+	encoder.writeValue(meta)
+	encoder.w.WriteString(`,"tree":`)
+	encoder.encode(node, node.relPath(), depth, 1, parentValue)
+	encoder.w.WriteByte('}')
+	return meta.OptionsHash, encoder.w.Flush()
+}
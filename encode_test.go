@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestEncodeOtherNode(t *testing.T) {
+	root := populateTree("repo", map[string]int{"big.go": 10, "small1.go": 1, "small2.go": 1, "src/big.go": 10, "src/a.go": 1, "src/b.go": 1})
+	root.aggregateCounts()
+	a := &Analysis{Root: root, Meta: Metadata{Filters: map[string]string{}}}
+
+	tests := []struct {
+		path string // Of the requested subtree
+		want []string
+	}{
+		{"", []string{"/*other*", "src/*other*"}},
+		{"src", []string{"src/*other*"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			var body bytes.Buffer
+			if _, err := writeDataResponse(&body, a, TreeOptions{MinValue: 2}, tt.path, nil); err != nil {
+				t.Fatalf("writeDataResponse() error: %v", err)
+			}
+			var response struct {
+				Tree jsonNode `json:"tree"`
+			}
+			if err := json.Unmarshal(body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			ids := make(map[string]string) // Path by id
+			var walk func(n jsonNode)
+			walk = func(n jsonNode) {
+				if other, ok := ids[n.ID]; ok {
+					t.Errorf("paths %q and %q share the id %s", other, n.Path, n.ID)
+				}
+				ids[n.ID] = n.Path
+				if n.Other {
+					got = append(got, n.Path)
+					if n.Path == tt.path || n.ID != nodeID(n.Path) {
+						t.Errorf("other node path %q, id %s: want its own path matching the id", n.Path, n.ID)
+					}
+				}
+				for _, child := range n.Children {
+					walk(child)
+				}
+			}
+			walk(response.Tree)
+			if slices.Sort(got); !slices.Equal(got, tt.want) {
+				t.Errorf("other node paths = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// jsonNode is the part of a /data tree node the tests look at
+type jsonNode struct {
+	ID       string     `json:"id"`
+	Path     string     `json:"path"`
+	Other    bool       `json:"other"`
+	Children []jsonNode `json:"children"`
+}
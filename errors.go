@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// NotARepoError reports a path that isn't a git working tree
+type NotARepoError struct {
+	Path string
+}
+
+func (e *NotARepoError) Error() string {
+	return fmt.Sprintf("path '%s' does not appear to be a git repository (.git directory not found)", e.Path)
+}
+
+// GitNotFoundError reports that the git executable couldn't be started
+type GitNotFoundError struct {
+	Err error
+}
+
+func (e *GitNotFoundError) Error() string {
+	return fmt.Sprintf("git executable not found, is git installed and on the PATH? (%v)", e.Err)
+}
+
+func (e *GitNotFoundError) Unwrap() error { return e.Err }
+
+// EmptyHistoryError reports a repository without any commits yet
+type EmptyHistoryError struct {
+	Repo string
+}
+
+func (e *EmptyHistoryError) Error() string {
+	return fmt.Sprintf("repository '%s' has no commits yet", e.Repo)
+}
+
+// ParseError reports git output that couldn't be parsed, with the line it stopped at
+type ParseError struct {
+	Source string // The git command whose output was parsed, e.g. "git log"
+	Line   int    // 1-based line number of the offending line
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	if errors.Is(e.Err, bufio.ErrTooLong) {
+		return fmt.Sprintf("line %d of the %s output exceeds %d bytes, raise -max-line-size to accept it", e.Line, e.Source, maxLineSize)
+	}
+	return fmt.Sprintf("error parsing %s output at line %d: %v", e.Source, e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// errorKind classifies err for automation, see the Status endpoint
+func errorKind(err error) string {
+	var notARepo *NotARepoError
+	var gitNotFound *GitNotFoundError
+	var emptyHistory *EmptyHistoryError
+	var parseErr *ParseError
+	var mismatch *OptionsMismatchError
+	switch {
+	case errors.As(err, &notARepo):
+		return "notARepo"
+	case errors.As(err, &gitNotFound):
+		return "gitNotFound"
+	case errors.As(err, &emptyHistory):
+		return "emptyHistory"
+	case errors.As(err, &parseErr):
+		return "parseError"
+	case errors.As(err, &mismatch):
+		return "optionsMismatch"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	}
+	return "error"
+}
+
+// Exit codes of the process, a contract scripts can branch on
+const (
+	exitOK        = 0
+	exitError     = 1  // Other errors, e.g. writing the output
+	exitNotARepo  = 2  // A path is not a git repository
+	exitNoGit     = 3  // The git executable was not found
+	exitAnalysis  = 4  // The analysis failed, e.g. unparsable git output or a timeout
+	exitThreshold = 5  // A check failed, e.g. a hook with -strict touching a hotspot or -expect-hash
+	exitUsage     = 64 // Invalid command line (EX_USAGE of sysexits.h)
+)
+
+// exitCodes are the process exit codes of the error kinds, other kinds are analysis failures
+var exitCodes = map[string]int{
+	"notARepo":        exitNotARepo,
+	"gitNotFound":     exitNoGit,
+	"optionsMismatch": exitThreshold,
+}
+
+// exitCode returns the process exit code for err, an error of the analysis
+func exitCode(err error) int {
+	if code, ok := exitCodes[errorKind(err)]; ok {
+		return code
+	}
+	return exitAnalysis
+}
+
+// parseArgs parses the options of a command, exiting with exitUsage when they are invalid (after
+// the flag package printed the error and the usage) and with exitOK for -h
+func parseArgs(flags *flag.FlagSet, args []string) {
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		os.Exit(exitOK)
+	} else if err != nil {
+		os.Exit(exitUsage)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// ChangeEvent is a file change of the parsed history, as written by export -format events
+type ChangeEvent struct {
+	Commit  string    `json:"commit"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Date    time.Time `json:"date"`
+	Path    string    `json:"path"`
+	Added   int       `json:"added"` // 0 for binary files and on partial clones
+	Deleted int       `json:"deleted"`
+	// Options hash of the export (see Metadata), so analyses downstream can tell exports apart
+	OptionsHash string `json:"optionsHash"`
+}
+
+// eventWriter is a collector writing every file change as a JSON line, as it is parsed
+type eventWriter struct {
+	encoder     *json.Encoder
+	optionsHash string
+	events      int
+	err         error // The first write error, the following events are dropped
+}
+
+func (e *eventWriter) commit(*Commit) {}
+
+func (e *eventWriter) change(c *Commit, change FileChange) {
+	if c == nil || e.err != nil {
+		return
+	}
+	e.err = e.encoder.Encode(ChangeEvent{
+		Commit:  c.Hash,
+		Author:  c.Author,
+		Email:   c.Email,
+		Date:    c.Date,
+		Path:    change.Path,
+		Added:   change.Added,
+		Deleted: change.Deleted,
+
+		OptionsHash: e.optionsHash,
+	})
+	e.events++
+}
+
+func (e *eventWriter) copied(*Commit, FileCopy) {}
+
+// streamHistory runs git log --numstat for the history reachable from rev (a revision range
+// works too) and streams it to the collectors, like the analysis does. Shallow clones are
+// streamed as far as they go, repositories without commits yet stream nothing.
+func streamHistory(ctx context.Context, repo, rev string, collectors ...collector) error {
+	if _, err := os.Stat(filepath.Join(repo, ".git")); os.IsNotExist(err) {
+		return &NotARepoError{Path: repo}
+	}
+	if ok, err := hasCommits(ctx, repo); err != nil {
+		return err
+	} else if !ok {
+		return nil
+	}
+	args := append([]string{"log"}, changeListArgs(partialCloneFilter(ctx, repo))...)
+	args = append(args, "--pretty=format:"+commitMarker+commitFormat, "--no-merges", rev)
+	if isShallow(ctx, repo) {
+		boundary, err := shallowBoundary(ctx, repo)
+		if err != nil {
+			return err
+		}
+		args = append(append(args, "--not"), boundary...)
+	}
+	output, err := gitRun(ctx, repo, args...)
+	if err != nil {
+		return fmt.Errorf("error running git log %s: %w", rev, err)
+	}
+	_, err = streamNumstatLog(ctx, output, collectors...)
+	return err
+}
+
+// exportOptionsHash returns the options hash of an export of the history reachable from rev
+func exportOptionsHash(ctx context.Context, repo, rev string) string {
+	return optionsHash(Metadata{
+		ToolVersion:   version,
+		RevisionRange: rev,
+		PartialClone:  partialCloneFilter(ctx, repo),
+		Filters:       map[string]string{"merges": "excluded", "export": "events"},
+	})
+}
+
+// runExport is the export command: it writes the parsed history for analyses downstream
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "events", "output: events (a JSON object per changed file and commit)")
+	rev := flags.String("rev", "HEAD", "export the history reachable from this commit, tag or branch, or a revision range like v1.0..HEAD")
+	output := flags.String("o", "", "write the export to this file instead of standard output")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s export [options] <repo>\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Writes the normalized history the analyses are computed from: per commit and changed file the commit, author, date, path and lines added and deleted, newest first.")
+		flags.PrintDefaults()
+	}
+	parseArgs(flags, args)
+	if flags.NArg() != 1 || *format != "events" {
+		flags.Usage()
+		return exitUsage
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating output file: %v\n", err)
+			return exitError
+		}
+		defer file.Close()
+		out = file
+	}
+	buffered := bufio.NewWriter(out)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	events := &eventWriter{encoder: json.NewEncoder(buffered), optionsHash: exportOptionsHash(ctx, flags.Arg(0), *rev)}
+	if err := streamHistory(ctx, flags.Arg(0), *rev, events); err != nil {
+		fmt.Fprintf(os.Stderr, "Error exporting the history: %v\n", err)
+		return exitCode(err)
+	}
+	if err := events.err; err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing the export: %v\n", err)
+		return exitError
+	}
+	if err := buffered.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing the export: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestStreamHistory(t *testing.T) {
+	empty := t.TempDir()
+	if _, err := gitRun(context.Background(), empty, "init", "--quiet"); err != nil {
+		t.Skipf("git is not available: %v", err)
+	}
+	generated := testRepo(t)
+
+	tests := []struct {
+		name       string
+		repo       string
+		rev        string
+		wantEvents bool
+	}{
+		{"empty repository", empty, "HEAD", false},
+		{"generated history", generated, "HEAD", true},
+		{"empty revision range", generated, "HEAD..HEAD", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			events := &eventWriter{encoder: json.NewEncoder(&out)}
+			if err := streamHistory(context.Background(), tt.repo, tt.rev, events); err != nil {
+				t.Fatalf("streamHistory() error: %v", err)
+			}
+			if events.err != nil {
+				t.Fatalf("writing events: %v", events.err)
+			}
+			if got := events.events > 0; got != tt.wantEvents {
+				t.Errorf("wrote %d events, want events: %v", events.events, tt.wantEvents)
+			}
+			if lines := bytes.Count(out.Bytes(), []byte("\n")); lines != events.events {
+				t.Errorf("wrote %d lines for %d events", lines, events.events)
+			}
+		})
+	}
+}
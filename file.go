@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileCommit is one commit's changes to a file
+type FileCommit struct {
+	Hash    string    `json:"sha"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+	Subject string    `json:"subject"`
+	Added   int       `json:"added"`
+	Deleted int       `json:"deleted"`
+}
+
+// AuthorShare is an author's share of the changes to a file
+type AuthorShare struct {
+	Name         string  `json:"name"`
+	Email        string  `json:"email"`
+	Commits      int     `json:"commits"`
+	LinesChanged int     `json:"linesChanged"`
+	Percent      float64 `json:"percent"` // Share of the file's commits, in percent
+}
+
+// TimeBucket aggregates changes within one calendar month
+type TimeBucket struct {
+	Period       string `json:"period"` // YYYY-MM
+	Commits      int    `json:"commits"`
+	LinesChanged int    `json:"linesChanged"`
+}
+
+// Rename is a step in a file's rename history
+type Rename struct {
+	Hash string    `json:"sha"`
+	Date time.Time `json:"date"`
+	From string    `json:"from"`
+	To   string    `json:"to"`
+}
+
+// Copy is a copy of a file made by a commit, found with -copies
+type Copy struct {
+	Hash string    `json:"sha"`
+	Date time.Time `json:"date"`
+	From string    `json:"from"`
+	To   string    `json:"to"`
+}
+
+// FileDetail is the full churn profile of a file, as served on /file
+type FileDetail struct {
+	Path       string        `json:"path"`
+	Commits    int           `json:"commits"`
+	Added      int           `json:"added"`
+	Deleted    int           `json:"deleted"`
+	History    []FileCommit  `json:"history"` // Newest first
+	Authors    []AuthorShare `json:"authors"`
+	TimeSeries []TimeBucket  `json:"timeSeries"`       // Oldest first, months without changes are omitted
+	Renames    []Rename      `json:"renames"`          // Newest first
+	Copies     []Copy        `json:"copies,omitempty"` // With -copies: the file's copies and the file it was copied from, newest first
+}
+
+// buildFileDetail collects the churn profile of the file at path from the analyzed commits
+func buildFileDetail(a *Analysis, path string) *FileDetail {
+	detail := &FileDetail{Path: path, History: []FileCommit{}, Authors: []AuthorShare{}, TimeSeries: []TimeBucket{}}
+	authors := make(map[string]*AuthorShare)
+	buckets := make(map[string]*TimeBucket)
+
+	for _, commit := range a.Commits {
+		for _, copied := range commit.Copies {
+			if copied.From == path || copied.To == path {
+				detail.Copies = append(detail.Copies, Copy{Hash: commit.Hash, Date: commit.Date, From: copied.From, To: copied.To})
+			}
+		}
+		for _, file := range commit.Files {
+			if file.Path != path {
+				continue
+			}
+			lines := file.Added + file.Deleted
+			detail.Commits++
+			detail.Added += file.Added
+			detail.Deleted += file.Deleted
+			detail.History = append(detail.History, FileCommit{
+				Hash:    commit.Hash,
+				Author:  commit.Author,
+				Date:    commit.Date,
+				Subject: commit.Subject,
+				Added:   file.Added,
+				Deleted: file.Deleted,
+			})
+
+			key := strings.ToLower(commit.Email)
+			author, ok := authors[key]
+			if !ok {
+				author = &AuthorShare{Name: commit.Author, Email: commit.Email}
+				authors[key] = author
+			}
+			author.Commits++
+			author.LinesChanged += lines
+
+			period := commit.Date.Format("2006-01")
+			bucket, ok := buckets[period]
+			if !ok {
+				bucket = &TimeBucket{Period: period}
+				buckets[period] = bucket
+			}
+			bucket.Commits++
+			bucket.LinesChanged += lines
+		}
+	}
+
+	for _, author := range authors {
+		author.Percent = percentOf(author.Commits, detail.Commits)
+		detail.Authors = append(detail.Authors, *author)
+	}
+	sort.Slice(detail.Authors, func(i, j int) bool {
+		a, b := detail.Authors[i], detail.Authors[j]
+		if a.Commits != b.Commits {
+			return a.Commits > b.Commits
+		}
+		return a.Name < b.Name
+	})
+
+	for _, bucket := range buckets {
+		detail.TimeSeries = append(detail.TimeSeries, *bucket)
+	}
+	sort.Slice(detail.TimeSeries, func(i, j int) bool {
+		return detail.TimeSeries[i].Period < detail.TimeSeries[j].Period
+	})
+	return detail
+}
+
+// renameHistory follows the file back through its renames using git log --follow
+func renameHistory(ctx context.Context, repo, path string) ([]Rename, error) {
+	output, err := gitOutput(ctx, repo, "log", "--follow", "-M", "--name-status", "--no-merges",
+		"--pretty=format:"+commitMarker+"%H%x1f%at", "--", path)
+	if err != nil {
+		return nil, err
+	}
+
+	renames := []Rename{}
+	var hash string
+	var date time.Time
+	for _, line := range strings.Split(output, "\n") {
+		if header, ok := strings.CutPrefix(line, commitMarker); ok {
+			var seconds string
+			hash, seconds, _ = strings.Cut(header, "\x1f")
+			date = time.Time{}
+			if parsed, err := strconv.ParseInt(seconds, 10, 64); err == nil {
+				date = time.Unix(parsed, 0).UTC()
+			}
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) == 3 && strings.HasPrefix(fields[0], "R") {
+			renames = append(renames, Rename{Hash: hash, Date: date, From: unquotePath(fields[1]), To: unquotePath(fields[2])})
+		}
+	}
+	return renames, nil
+}
+
+// handleFile serves GET /file?path=..., the churn profile of a single file
+func handleFile(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+
+	path := strings.Trim(r.URL.Query().Get("path"), "/")
+	node, _ := analysis.Root.find(path)
+	if !node.exists() || path == "" {
+		http.Error(w, fmt.Sprintf("%v: '%s'", errPathNotFound, path), http.StatusNotFound)
+		return
+	}
+	if !node.IsFile() {
+		http.Error(w, fmt.Sprintf("Path '%s' is a directory, not a file", path), http.StatusBadRequest)
+		return
+	}
+
+	detail := buildFileDetail(analysis, path)
+	repo, repoRelPath := analysis.repoFor(path)
+	renames, err := renameHistory(r.Context(), repo, repoRelPath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading rename history: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if prefix := strings.TrimSuffix(path, repoRelPath); prefix != "" {
+		// Portfolio mode: report the renames with the repository's path segment, like the tree
+		for i := range renames {
+			renames[i].From = prefix + renames[i].From
+			renames[i].To = prefix + renames[i].To
+		}
+	}
+	detail.Renames = renames
+	writeJSON(w, detail)
+}
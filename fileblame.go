@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// blameAgeBuckets are the upper bounds of the age buckets of /file/blame, the last one is open
+var blameAgeBuckets = []struct {
+	label string
+	age   time.Duration
+}{
+	{"<1 month", 30 * 24 * time.Hour},
+	{"1-6 months", 182 * 24 * time.Hour},
+	{"6-12 months", 365 * 24 * time.Hour},
+	{"1-2 years", 2 * 365 * 24 * time.Hour},
+	{"2+ years", 0},
+}
+
+// BlameAuthor is an author's share of a file's surviving lines
+type BlameAuthor struct {
+	Name    string  `json:"name"`
+	Email   string  `json:"email"`
+	Lines   int     `json:"lines"`
+	Percent float64 `json:"percent"`
+}
+
+// BlameAge is the number of surviving lines within an age bucket
+type BlameAge struct {
+	Age   string `json:"age"`
+	Lines int    `json:"lines"`
+}
+
+// FileBlame is the ownership of a file's current content, as served on /file/blame
+type FileBlame struct {
+	Path     string        `json:"path"`
+	Revision string        `json:"revision"`
+	Lines    int           `json:"lines"`
+	Authors  []BlameAuthor `json:"authors"` // Most lines first
+	Ages     []BlameAge    `json:"ages"`    // Youngest first
+	Oldest   *time.Time    `json:"oldest,omitempty"`
+	Newest   *time.Time    `json:"newest,omitempty"`
+}
+
+// summarizeBlame aggregates the blame of a file per author (by email) and age bucket
+func summarizeBlame(path, revision string, result *BlameResult, now time.Time) *FileBlame {
+	summary := &FileBlame{Path: path, Revision: revision, Authors: []BlameAuthor{}, Ages: make([]BlameAge, len(blameAgeBuckets))}
+	for i, bucket := range blameAgeBuckets {
+		summary.Ages[i].Age = bucket.label
+	}
+	authors := make(map[string]*BlameAuthor)
+	for _, commit := range result.Commits {
+		summary.Lines += commit.Lines
+		key := strings.ToLower(commit.Email)
+		author := authors[key]
+		if author == nil {
+			author = &BlameAuthor{Name: commit.Author, Email: commit.Email}
+			authors[key] = author
+		}
+		author.Lines += commit.Lines
+
+		age := now.Sub(commit.Time)
+		for i, bucket := range blameAgeBuckets {
+			if age < bucket.age || bucket.age == 0 {
+				summary.Ages[i].Lines += commit.Lines
+				break
+			}
+		}
+		if commit.Lines > 0 {
+			if summary.Oldest == nil || commit.Time.Before(*summary.Oldest) {
+				summary.Oldest = &commit.Time
+			}
+			if summary.Newest == nil || commit.Time.After(*summary.Newest) {
+				summary.Newest = &commit.Time
+			}
+		}
+	}
+	for _, author := range authors {
+		author.Percent = percentOf(author.Lines, summary.Lines)
+		summary.Authors = append(summary.Authors, *author)
+	}
+	sort.Slice(summary.Authors, func(i, j int) bool {
+		if summary.Authors[i].Lines != summary.Authors[j].Lines {
+			return summary.Authors[i].Lines > summary.Authors[j].Lines
+		}
+		return summary.Authors[i].Email < summary.Authors[j].Email
+	})
+	return summary
+}
+
+// handleFileBlame serves GET /file/blame?path=..., the surviving lines of a file at the analyzed
+// revision per author and age. Results share the -blame-cache-dir cache with the blame mode.
+func handleFileBlame(w http.ResponseWriter, r *http.Request) {
+	if noFileContent {
+		http.Error(w, errNoFileContent.Error(), http.StatusForbidden)
+		return
+	}
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	path := strings.Trim(r.URL.Query().Get("path"), "/")
+	node, _ := analysis.Root.find(path)
+	if !node.exists() || path == "" {
+		http.Error(w, fmt.Sprintf("%v: '%s'", errPathNotFound, path), http.StatusNotFound)
+		return
+	}
+	if !node.IsFile() {
+		http.Error(w, fmt.Sprintf("Path '%s' is a directory, not a file", path), http.StatusBadRequest)
+		return
+	}
+
+	repo, repoRelPath := analysis.repoFor(path)
+	revision, err := resolveCommit(r.Context(), repo, analysisRev)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The blob id of the file at the revision keys the cache, with the repository and the revision
+	blob, err := gitOutput(r.Context(), repo, "rev-parse", "--verify", "--quiet", revision+":"+repoRelPath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("'%s' no longer exists at %s", path, revision), http.StatusNotFound)
+		return
+	}
+	result, err := newBlameRunner(repo, revision, blameOptions).blame(r.Context(), repoRelPath, blob)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error blaming '%s': %v", path, err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, summarizeBlame(path, revision, result, time.Now()))
+}
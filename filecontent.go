@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxPreviewSize bounds the files /file/content serves
+const maxPreviewSize = 512 * 1024
+
+// binarySniffLen is how much of a file is checked for NUL bytes, like git does to tell binary files
+const binarySniffLen = 8000
+
+// filePreview is the -file-preview option: serve file contents on /file/content
+var filePreview bool
+
+// FileContent is a file at a revision, as served by /file/content
+type FileContent struct {
+	Path     string `json:"path"`
+	Revision string `json:"revision"`
+	Size     int64  `json:"size"`
+	Binary   bool   `json:"binary"`            // Binary files are served without content
+	Content  string `json:"content,omitempty"` // UTF-8 text
+}
+
+// isBinary reports whether the content looks binary: a NUL byte within its first bytes or invalid UTF-8
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), binarySniffLen)], 0) >= 0 || !utf8.Valid(content)
+}
+
+// handleFileContent serves GET /file/content?path=...&rev=HEAD, the content of a file at a revision
+// (-rev by default) for previews. It needs -file-preview; binary files are served without content
+// and files over maxPreviewSize get 413.
+func handleFileContent(w http.ResponseWriter, r *http.Request) {
+	if !filePreview {
+		http.Error(w, "File previews are disabled, start the server with -file-preview", http.StatusForbidden)
+		return
+	}
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	path := strings.Trim(r.URL.Query().Get("path"), "/")
+	if path == "" {
+		http.Error(w, "The 'path' query parameter is required", http.StatusBadRequest)
+		return
+	}
+	repo, repoRelPath := analysis.repoFor(path)
+	revision, err := resolveCommit(r.Context(), repo, cmp.Or(r.URL.Query().Get("rev"), analysisRev))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	object := revision + ":" + repoRelPath
+	if kind, err := gitOutput(r.Context(), repo, "cat-file", "-t", object); err != nil || kind != "blob" {
+		http.Error(w, fmt.Sprintf("'%s' is no file at %s", path, revision), http.StatusNotFound)
+		return
+	}
+	sizeText, err := gitOutput(r.Context(), repo, "cat-file", "-s", object)
+	size, parseErr := strconv.ParseInt(sizeText, 10, 64)
+	if err != nil || parseErr != nil {
+		http.Error(w, fmt.Sprintf("Error reading the size of '%s': %v", path, cmp.Or(err, parseErr)), http.StatusInternalServerError)
+		return
+	}
+	if size > maxPreviewSize {
+		http.Error(w, fmt.Sprintf("'%s' is too large to preview (%d bytes, at most %d)", path, size, maxPreviewSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	content, err := gitRun(r.Context(), repo, "show", "--no-textconv", object)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading '%s': %v", path, err), http.StatusInternalServerError)
+		return
+	}
+
+	file := FileContent{Path: path, Revision: revision, Size: size, Binary: isBinary(content)}
+	if !file.Binary {
+		file.Content = string(content)
+	}
+	writeJSON(w, file)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File map page sizes
+const (
+	defaultFileMapLimit = 5000
+	maxFileMapLimit     = 50000
+)
+
+// fileMapFields names the values of every file map entry, in order
+var fileMapFields = []string{"path", "value", "heat"}
+
+// FileMapResponse is a page of the per-file heat, for editor integrations. Entries are arrays in
+// the order of Fields to keep large pages small.
+type FileMapResponse struct {
+	Revision    string    `json:"revision,omitempty"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	Fields      []string  `json:"fields"`
+	MaxValue    int       `json:"maxValue"`   // Value of the hottest file of the whole repository
+	TotalFiles  int       `json:"totalFiles"` // Changed files matching the prefix, over all pages
+	Files       [][3]any  `json:"files"`
+	Next        string    `json:"next,omitempty"` // Cursor of the next page, absent on the last one
+}
+
+// fileHeat is a changed file with its heat: the percentile of its value among all changed files
+type fileHeat struct {
+	path  string
+	value int
+	heat  int
+}
+
+// fileMapIndex caches the files of the current analysis, sorted by path
+var fileMapIndex struct {
+	mu       sync.Mutex
+	analysis *Analysis
+	files    []fileHeat
+	maxValue int
+}
+
+// fileHeats returns the changed files of the analysis sorted by path, along with the hottest value
+func fileHeats(a *Analysis) ([]fileHeat, int) {
+	fileMapIndex.mu.Lock()
+	defer fileMapIndex.mu.Unlock()
+	if fileMapIndex.analysis == a {
+		return fileMapIndex.files, fileMapIndex.maxValue
+	}
+
+	var files []fileHeat
+	var walk func(n Node)
+	walk = func(n Node) {
+		if n.IsFile() {
+			if n.Value() > 0 {
+				files = append(files, fileHeat{path: n.relPath(), value: n.Value()})
+			}
+			return
+		}
+		for child := range n.Children() {
+			walk(child)
+		}
+	}
+	walk(a.Root)
+
+	// Heat is the share of files that are at most as hot, so the hottest files are 100 and
+	// outliers don't flatten everything else like a linear scale would
+	values := make([]int, len(files))
+	for i, file := range files {
+		values[i] = file.value
+	}
+	sort.Ints(values)
+	maxValue := 0
+	for i := range files {
+		atMost := sort.SearchInts(values, files[i].value+1)
+		files[i].heat = atMost * 100 / len(files)
+		maxValue = max(maxValue, files[i].value)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+
+	fileMapIndex.analysis, fileMapIndex.files, fileMapIndex.maxValue = a, files, maxValue
+	return files, maxValue
+}
+
+// fileMapPage returns the files below prefix sorted by path, starting after the cursor
+func fileMapPage(a *Analysis, prefix, cursor string, limit int) *FileMapResponse {
+	files, maxValue := fileHeats(a)
+	response := &FileMapResponse{
+		Revision:    a.Meta.Revision,
+		GeneratedAt: a.Meta.GeneratedAt,
+		Fields:      fileMapFields,
+		MaxValue:    maxValue,
+		Files:       [][3]any{},
+	}
+	start := sort.Search(len(files), func(i int) bool { return files[i].path >= prefix })
+	end := start + sort.Search(len(files)-start, func(i int) bool { return !strings.HasPrefix(files[start+i].path, prefix) })
+	response.TotalFiles = end - start
+	if cursor != "" {
+		start = max(start, sort.Search(len(files), func(i int) bool { return files[i].path > cursor }))
+	}
+	for i := start; i < end; i++ {
+		if len(response.Files) == limit {
+			response.Next = files[i-1].path
+			break
+		}
+		response.Files = append(response.Files, [3]any{files[i].path, files[i].value, files[i].heat})
+	}
+	return response
+}
+
+// handleFileMap serves GET /filemap?prefix=src/&cursor=...&limit=5000, the heat of every changed file
+// for editor gutters and explorer badges
+func handleFileMap(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	limit := defaultFileMapLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			http.Error(w, fmt.Sprintf("Invalid limit '%s'", value), http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, maxFileMapLimit)
+	}
+
+	// Pages only change with the analysis, so editors polling /filemap mostly get 304 Not Modified
+	// without the page being encoded again
+	key := fmt.Sprintf("%s|%d|%s|%s|%d", analysis.Meta.Revision, analysis.Meta.GeneratedAt.UnixNano(), query.Get("prefix"), query.Get("cursor"), limit)
+	sum := sha1.Sum([]byte(key))
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", etag)
+	if match := r.Header.Get("If-None-Match"); match == etag || match == "W/"+etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(fileMapPage(analysis, query.Get("prefix"), query.Get("cursor"), limit)); err != nil {
+		http.Error(w, "Error encoding JSON data", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	http.ServeContent(w, r, "", analysis.Meta.GeneratedAt, bytes.NewReader(body.Bytes()))
+}
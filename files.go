@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultFilesLimit is the page size of /files without limit
+const defaultFilesLimit = 500
+
+// fileSortKeys are the fields /files sorts by, with the file record value compared
+var fileSortKeys = map[string]func(FileRecord) float64{
+	"changes": func(f FileRecord) float64 { return float64(f.Changes) },
+	"heat":    func(f FileRecord) float64 { return float64(f.Heat) },
+	"commits": func(f FileRecord) float64 { return float64(f.Commits) },
+	"lines":   func(f FileRecord) float64 { return float64(f.Lines) },
+	"authors": func(f FileRecord) float64 { return float64(f.Authors) },
+	"age":     func(f FileRecord) float64 { return float64(f.Age) },
+}
+
+// FileRecord is a changed file of the flat per-file table
+type FileRecord struct {
+	Path    string `json:"path"`
+	Changes int    `json:"changes"` // The file's value in the tree
+	Heat    int    `json:"heat"`    // Percentile of its changes among all changed files
+	Commits int    `json:"commits"`
+	Lines   int    `json:"lines"` // Lines added and deleted
+	Authors int    `json:"authors"`
+	Age     int    `json:"age"` // Days since the last change
+}
+
+// FilesResponse is a page of the per-file table, as served on /files
+type FilesResponse struct {
+	Total  int          `json:"total"` // Files matching the prefix, over all pages
+	Offset int          `json:"offset"`
+	Limit  int          `json:"limit"`
+	Files  []FileRecord `json:"files"`
+}
+
+// fileRecords returns the changed files below prefix sorted by the key (a fileSortKeys name or
+// path), descending unless ascending is set; ties go by path
+func fileRecords(a *Analysis, prefix, key string, ascending bool) []FileRecord {
+	files, _ := fileHeats(a)
+	metrics := nodeMetrics(a)
+	records := []FileRecord{}
+	for _, file := range files {
+		if !strings.HasPrefix(file.path, prefix) {
+			continue
+		}
+		entry := metrics[file.path]
+		records = append(records, FileRecord{
+			Path:    file.path,
+			Changes: file.value,
+			Heat:    file.heat,
+			Commits: int(entry["commits"]),
+			Lines:   int(entry["lines"]),
+			Authors: int(entry["authors"]),
+			Age:     int(entry["age"]),
+		})
+	}
+	// The files come sorted by path, which stays the order of ties
+	if value := fileSortKeys[key]; value != nil {
+		sort.SliceStable(records, func(i, j int) bool {
+			if ascending {
+				return value(records[i]) < value(records[j])
+			}
+			return value(records[i]) > value(records[j])
+		})
+	} else if !ascending {
+		slices.Reverse(records)
+	}
+	return records
+}
+
+// handleFiles serves GET /files?sort=changes&order=desc&limit=500&offset=0&prefix=src/, the flat
+// per-file records for consumers that don't need the hierarchy
+func handleFiles(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	key := query.Get("sort")
+	if key == "" {
+		key = "changes"
+	}
+	if _, known := fileSortKeys[key]; !known && key != "path" {
+		http.Error(w, fmt.Sprintf("Unknown sort '%s' (expected changes, heat, commits, lines, authors, age or path)", key), http.StatusBadRequest)
+		return
+	}
+	ascending := key == "path"
+	switch order := query.Get("order"); order {
+	case "":
+	case "asc", "desc":
+		ascending = order == "asc"
+	default:
+		http.Error(w, fmt.Sprintf("Unknown order '%s' (expected asc or desc)", order), http.StatusBadRequest)
+		return
+	}
+	limit, offset := defaultFilesLimit, 0
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, fmt.Sprintf("Invalid limit '%s'", value), http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxFileMapLimit)
+	}
+	if value := query.Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("Invalid offset '%s'", value), http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+
+	records := fileRecords(analysis, query.Get("prefix"), key, ascending)
+	start := min(offset, len(records))
+	writeJSON(w, FilesResponse{Total: len(records), Offset: offset, Limit: limit, Files: records[start:min(start+limit, len(records))]})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Forecast defaults: months of history the trend is fitted to, months projected, and directories listed
+const (
+	forecastHistory = 12
+	forecastHorizon = 1
+	forecastEntries = 20
+)
+
+// Forecast is the projected churn of a directory, from a linear trend of its monthly changes
+type Forecast struct {
+	Path      string  `json:"path"`
+	Average   float64 `json:"average"`   // Changes per month over the fitted months
+	Slope     float64 `json:"slope"`     // Changes per month gained (or lost) each month
+	Projected float64 `json:"projected"` // Changes expected over the projected months
+	Trend     string  `json:"trend"`     // growing, shrinking or flat
+}
+
+// ForecastReport is the projection of the next months, as served on /forecast
+type ForecastReport struct {
+	Fitted    []string   `json:"fitted"`    // Months the trends are fitted to (YYYY-MM)
+	Projected []string   `json:"projected"` // Months projected
+	Forecasts []Forecast `json:"forecasts"` // Highest projection first
+}
+
+// fitTrend fits a line to values by least squares, returning its value at the first point and
+// its slope
+func fitTrend(values []int) (intercept, slope float64) {
+	n := float64(len(values))
+	var sumX, sumY, sumXY, sumXX float64
+	for i, value := range values {
+		x, y := float64(i), float64(value)
+		sumX, sumY, sumXY, sumXX = sumX+x, sumY+y, sumXY+x*y, sumXX+x*x
+	}
+	if denominator := n*sumXX - sumX*sumX; denominator != 0 {
+		slope = (n*sumXY - sumX*sumY) / denominator
+	}
+	return (sumY - slope*sumX) / n, slope
+}
+
+// forecast projects the churn of the directories up to depth levels over the horizon months
+// following the last history months. The month of the analysis is left out of the fit as it is
+// incomplete; it is the first month projected.
+func forecast(a *Analysis, depth, history, horizon int) *ForecastReport {
+	report := &ForecastReport{Fitted: []string{}, Projected: []string{}, Forecasts: []Forecast{}}
+	months, series := monthlySeries(a, depth)
+	end := len(months)
+	if end > 0 && months[end-1] >= a.Meta.GeneratedAt.UTC().Format("2006-01") {
+		end--
+	}
+	start := max(0, end-history)
+	if end-start < 2 {
+		return report // No trend without two complete months
+	}
+	report.Fitted = months[start:end]
+	first, _ := time.Parse("2006-01", months[end-1])
+	for i := 1; i <= horizon; i++ {
+		report.Projected = append(report.Projected, first.AddDate(0, i, 0).Format("2006-01"))
+	}
+
+	for path, values := range series {
+		intercept, slope := fitTrend(values[start:end])
+		projected := 0.0
+		for i := 0; i < horizon; i++ {
+			projected += max(0, intercept+slope*float64(end-start+i))
+		}
+		sum := 0
+		for _, value := range values[start:end] {
+			sum += value
+		}
+		if sum == 0 {
+			continue
+		}
+		entry := Forecast{Path: path, Average: math.Round(float64(sum)/float64(end-start)*100) / 100,
+			Slope: math.Round(slope*100) / 100, Projected: math.Round(projected*100) / 100, Trend: "flat"}
+		// A trend counts when it moves the monthly changes by more than a tenth of the average over the fit
+		if change := slope * float64(end-start); change > entry.Average/10 {
+			entry.Trend = "growing"
+		} else if change < -entry.Average/10 {
+			entry.Trend = "shrinking"
+		}
+		report.Forecasts = append(report.Forecasts, entry)
+	}
+	sort.Slice(report.Forecasts, func(i, j int) bool {
+		if report.Forecasts[i].Projected != report.Forecasts[j].Projected {
+			return report.Forecasts[i].Projected > report.Forecasts[j].Projected
+		}
+		return report.Forecasts[i].Path < report.Forecasts[j].Path
+	})
+	return report
+}
+
+// handleForecast serves GET /forecast?depth=2&months=12&horizon=1&limit=20, the churn projected per
+// directory for the next months from the trend of the last months, the highest first
+func handleForecast(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	depth := digestDirectoryDepth
+	if query.Has("depth") {
+		if depth, ok = authorDepth(w, r); !ok {
+			return
+		}
+	}
+	params := map[string]int{"months": forecastHistory, "horizon": forecastHorizon, "limit": forecastEntries}
+	for name := range params {
+		if value := query.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || (name == "months" && n < 2) {
+				http.Error(w, fmt.Sprintf("Invalid %s '%s'", name, value), http.StatusBadRequest)
+				return
+			}
+			params[name] = n
+		}
+	}
+	report := forecast(analysis, depth, params["months"], params["horizon"])
+	report.Forecasts = report.Forecasts[:min(len(report.Forecasts), params["limit"])]
+	writeJSON(w, report)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxGrafanaBody limits the size of a Grafana request body
+const maxGrafanaBody = 64 * 1024
+
+// The Grafana targets: commits and file changes per interval, optionally within a path
+// ("churn:src/auth"), the number of hotspot files changed per interval, and a table of the hotspots
+const (
+	targetCommits  = "commits"
+	targetChurn    = "churn"
+	targetLines    = "lines"
+	targetHotspots = "hotspots"
+)
+
+// grafanaRange is the time range of a Grafana query
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// grafanaQuery is the body of a simple JSON datasource /query request
+type grafanaQuery struct {
+	Range         grafanaRange `json:"range"`
+	IntervalMs    int64        `json:"intervalMs"`
+	MaxDataPoints int          `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string `json:"target"`
+		RefID  string `json:"refId"`
+		Type   string `json:"type"` // "timeserie" (default) or "table"
+	} `json:"targets"`
+}
+
+// grafanaSeries is a time series, datapoints are [value, unix milliseconds] pairs
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// grafanaColumn and grafanaTable are a table response
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]any         `json:"rows"`
+}
+
+// grafanaAnnotation is an event shown on Grafana graphs
+type grafanaAnnotation struct {
+	Annotation json.RawMessage `json:"annotation"` // The annotation query, echoed back
+	Time       int64           `json:"time"`       // Unix milliseconds
+	Title      string          `json:"title"`
+	Text       string          `json:"text"`
+	Tags       []string        `json:"tags,omitempty"`
+}
+
+// readGrafanaBody decodes a Grafana request body into v
+func readGrafanaBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxGrafanaBody))
+	if err == nil {
+		err = json.Unmarshal(data, v)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// handleGrafanaTest serves GET /grafana/, the datasource connection test
+func handleGrafanaTest(w http.ResponseWriter, r *http.Request) {
+	if _, ok := currentAnalysis(w); !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleGrafanaSearch serves POST /grafana/search, the targets offered in the query editor
+func handleGrafanaSearch(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	var body struct {
+		Target string `json:"target"`
+	}
+	if !readGrafanaBody(w, r, &body) {
+		return
+	}
+	targets := []string{targetCommits, targetChurn, targetLines, targetHotspots}
+	for child := range analysis.Root.Children() {
+		if !child.IsFile() && child.Value() > 0 {
+			targets = append(targets, targetChurn+":"+child.Name(), targetLines+":"+child.Name())
+		}
+	}
+	matching := []string{}
+	for _, target := range targets {
+		if strings.Contains(target, body.Target) {
+			matching = append(matching, target)
+		}
+	}
+	writeJSON(w, matching)
+}
+
+// grafanaBuckets returns the bucket width for a query, at most maxDataPoints buckets over the range
+func grafanaBuckets(q *grafanaQuery) time.Duration {
+	interval := time.Duration(q.IntervalMs) * time.Millisecond
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	if q.MaxDataPoints > 0 {
+		if span := q.Range.To.Sub(q.Range.From); span/interval > time.Duration(q.MaxDataPoints) {
+			interval = span / time.Duration(q.MaxDataPoints)
+		}
+	}
+	return interval
+}
+
+// grafanaTimeSeries computes one target over the query range
+func grafanaTimeSeries(a *Analysis, q *grafanaQuery, target string, hot map[string]bool) (*grafanaSeries, error) {
+	metric, path, _ := strings.Cut(target, ":")
+	if metric != targetCommits && metric != targetChurn && metric != targetLines && metric != targetHotspots {
+		return nil, fmt.Errorf("unknown target '%s'", target)
+	}
+	interval := grafanaBuckets(q)
+	from := q.Range.From.Truncate(interval)
+	values := make([]float64, int(q.Range.To.Sub(from)/interval)+1)
+	for _, commit := range a.Commits {
+		if commit.Date.Before(q.Range.From) || commit.Date.After(q.Range.To) {
+			continue
+		}
+		value := 0
+		for _, file := range commit.Files {
+			if !pathWithin(file.Path, path) {
+				continue
+			}
+			switch metric {
+			case targetChurn:
+				value++
+			case targetLines:
+				value += file.Added + file.Deleted
+			case targetCommits:
+				value = 1
+			case targetHotspots:
+				if hot[file.Path] {
+					value++
+				}
+			}
+		}
+		values[int(commit.Date.Sub(from)/interval)] += float64(value) * commit.Weight
+	}
+
+	series := &grafanaSeries{Target: target, Datapoints: make([][2]float64, len(values))}
+	for i, value := range values {
+		series.Datapoints[i] = [2]float64{value, float64(from.Add(time.Duration(i) * interval).UnixMilli())}
+	}
+	return series, nil
+}
+
+// handleGrafanaQuery serves POST /grafana/query
+func handleGrafanaQuery(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	var query grafanaQuery
+	if !readGrafanaBody(w, r, &query) {
+		return
+	}
+	if !query.Range.To.After(query.Range.From) {
+		http.Error(w, "Invalid request: empty time range", http.StatusBadRequest)
+		return
+	}
+
+	hot := make(map[string]bool)
+	spots := hotspots(analysis)
+	for _, spot := range spots {
+		hot[spot.Path] = true
+	}
+	response := []any{}
+	for _, target := range query.Targets {
+		if target.Type == "table" {
+			table := grafanaTable{Type: "table", Columns: []grafanaColumn{{Text: "Path", Type: "string"}, {Text: "Changes", Type: "number"}}, Rows: [][]any{}}
+			for _, spot := range spots {
+				if _, path, _ := strings.Cut(target.Target, ":"); pathWithin(spot.Path, path) {
+					table.Rows = append(table.Rows, []any{spot.Path, spot.Value})
+				}
+			}
+			response = append(response, table)
+			continue
+		}
+		series, err := grafanaTimeSeries(analysis, &query, target.Target, hot)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		response = append(response, series)
+	}
+	writeJSON(w, response)
+}
+
+// handleGrafanaAnnotations serves POST /grafana/annotations: the path annotations updated within
+// the range, or with a path as annotation query, the commits touching that path
+func handleGrafanaAnnotations(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	var body struct {
+		Range      grafanaRange    `json:"range"`
+		Annotation json.RawMessage `json:"annotation"`
+	}
+	if !readGrafanaBody(w, r, &body) {
+		return
+	}
+	var annotationQuery struct {
+		Query string `json:"query"`
+	}
+	json.Unmarshal(body.Annotation, &annotationQuery)
+	echo := body.Annotation
+	if len(echo) == 0 {
+		echo = json.RawMessage("null")
+	}
+
+	inRange := func(t time.Time) bool { return !t.Before(body.Range.From) && !t.After(body.Range.To) }
+	events := []grafanaAnnotation{}
+	if path := strings.Trim(annotationQuery.Query, "/"); path != "" {
+		for _, commit := range analysis.Commits {
+			if !inRange(commit.Date) {
+				continue
+			}
+			lines := 0
+			touched := false
+			for _, file := range commit.Files {
+				if pathWithin(file.Path, path) {
+					lines += file.Added + file.Deleted
+					touched = true
+				}
+			}
+			if touched && len(events) < maxCommitLimit {
+				events = append(events, grafanaAnnotation{Annotation: echo, Time: commit.Date.UnixMilli(), Title: commit.Subject,
+					Text: fmt.Sprintf("%s by %s, %d lines changed", commit.Hash[:min(len(commit.Hash), 12)], commit.Author, lines), Tags: []string{path}})
+			}
+		}
+	} else if annotations != nil {
+		for _, annotation := range annotations.list() {
+			if inRange(annotation.UpdatedAt) {
+				events = append(events, grafanaAnnotation{Annotation: echo, Time: annotation.UpdatedAt.UnixMilli(), Title: annotation.Path,
+					Text: annotation.Note, Tags: annotation.Labels})
+			}
+		}
+	}
+	writeJSON(w, events)
+}
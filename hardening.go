@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	readOnly      bool // -read-only: reject requests changing annotations, views or jobs; implies noFileContent
+	noFileContent bool // -no-file-content: never serve or derive anything from file contents
+)
+
+// pathParams are the query parameters naming a path of the repository
+var pathParams = []string{"path", "prefix", "a", "b"}
+
+// pathListParams are the query parameters naming a comma-separated list of paths
+var pathListParams = []string{"paths"}
+
+// pathRoutes are the URL path prefixes whose remainder is a path of the repository
+var pathRoutes = []string{"/annotations/"}
+
+// cleanPath validates a repository path taken from a request and returns it without leading and
+// trailing slashes. It rejects traversal ("..", "."), NUL bytes and what git would mistake for an
+// option or pathspec magic (a leading "-" or ":").
+func cleanPath(value string) (string, error) {
+	path := strings.Trim(value, "/")
+	if strings.ContainsRune(path, 0) || strings.HasPrefix(path, "-") || strings.HasPrefix(path, ":") {
+		return "", fmt.Errorf("invalid path '%s'", value)
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == ".." || segment == "." || (segment == "" && path != "") {
+			return "", fmt.Errorf("invalid path '%s'", value)
+		}
+	}
+	return path, nil
+}
+
+// withPathChecks rejects requests whose path query parameters or path route segments don't pass
+// cleanPath, so no endpoint sees traversal or pathspec magic
+func withPathChecks(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var values []string
+		for _, param := range pathParams {
+			values = append(values, query[param]...)
+		}
+		for _, param := range pathListParams {
+			for _, value := range query[param] {
+				values = append(values, splitParam(value)...)
+			}
+		}
+		for _, route := range pathRoutes {
+			if path, ok := strings.CutPrefix(r.URL.Path, route); ok {
+				values = append(values, path)
+			}
+		}
+		for _, value := range values {
+			if _, err := cleanPath(value); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withWrites guards an endpoint changing state: with -read-only only its GET and HEAD requests
+// are served, others get 403
+func withWrites(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if readOnly && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "The server is read-only", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// errNoFileContent is returned when a feature needs file contents but -no-file-content (or
+// -read-only) forbids them
+var errNoFileContent = errors.New("file contents are disabled by -no-file-content or -read-only")
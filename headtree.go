@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+)
+
+// headTree lists the files and directories of the analyzed revision. It is read from git rather
+// than the working tree, which a sparse checkout leaves mostly empty.
+type headTree struct {
+	files map[string]bool
+	dirs  map[string]bool
+}
+
+// loadHeadTree lists the tree of the analyzed revision (-rev, HEAD by default) of the repository,
+// empty for a repository without commits
+func loadHeadTree(ctx context.Context, repo string) (*headTree, error) {
+	tree := &headTree{files: make(map[string]bool), dirs: make(map[string]bool)}
+	if ok, err := hasCommits(ctx, repo); err != nil || (!ok && analysisRev == "HEAD") {
+		return tree, err
+	}
+	if sparse, _ := gitOutput(ctx, repo, "config", "--bool", "core.sparseCheckout"); sparse == "true" {
+		slog.Info("Repository has a sparse checkout, matching paths against the analyzed revision instead of the working tree", "repo", repo)
+	}
+	output, err := gitRun(ctx, repo, "ls-tree", "-r", "--name-only", "-z", analysisRev)
+	if err != nil {
+		return nil, fmt.Errorf("error listing files at %s: %w", analysisRev, err)
+	}
+	for _, file := range nulSeparated(output) {
+		tree.files[file] = true
+		for _, dir := range directoriesOf(file, math.MaxInt) {
+			tree.dirs[dir] = true
+		}
+	}
+	return tree, nil
+}
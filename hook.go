@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// zeroSHA is the object name pre-push hooks get for refs missing on one side
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+// TouchedHotspot is a hotspot changed by the staged or pushed changes
+type TouchedHotspot struct {
+	Path  string
+	Value int // Changes over the analyzed history
+	Rank  int // Position among the hotspots, hottest first
+	Heat  int // Percentage of the changed files that are at most as hot
+}
+
+// OwnerWarning is a touched file changed almost only by one author
+type OwnerWarning struct {
+	Path    string
+	Author  string
+	Share   float64 // Share of the file's commits within the last year
+	Commits int
+}
+
+// nulSeparated splits git output of -z options
+func nulSeparated(output []byte) []string {
+	var paths []string
+	for _, path := range bytes.Split(output, []byte{0}) {
+		if len(path) > 0 {
+			paths = append(paths, string(path))
+		}
+	}
+	return paths
+}
+
+// stagedFiles returns the files changed in the index against HEAD
+func stagedFiles(ctx context.Context, repo string) ([]string, error) {
+	output, err := gitRun(ctx, repo, "diff", "--cached", "--name-only", "--no-renames", "-z")
+	if err != nil {
+		return nil, fmt.Errorf("error listing staged files: %w", err)
+	}
+	return nulSeparated(output), nil
+}
+
+// pushedFiles returns the files changed by the commits a pre-push hook is about to push, reading
+// the "<local ref> <local sha> <remote ref> <remote sha>" lines git passes on standard input
+func pushedFiles(ctx context.Context, repo string, refs io.Reader) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	scanner := bufio.NewScanner(refs)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 || fields[1] == zeroSHA {
+			continue // Malformed, or a deleted ref
+		}
+		// Commits of new branches are those not on any remote yet
+		args := []string{"log", "--format=", "--name-only", "--no-renames", "-z", fields[1]}
+		if fields[3] == zeroSHA {
+			args = append(args, "--not", "--remotes")
+		} else {
+			args = append(args, "^"+fields[3])
+		}
+		output, err := gitRun(ctx, repo, args...)
+		if err != nil {
+			return nil, fmt.Errorf("error listing pushed files: %w", err)
+		}
+		for _, file := range nulSeparated(output) {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	return files, scanner.Err()
+}
+
+// touchedHotspots returns the hotspots among the files, hottest first
+func touchedHotspots(a *Analysis, files []string) []TouchedHotspot {
+	touched := make(map[string]bool, len(files))
+	for _, file := range files {
+		touched[file] = true
+	}
+	heats, _ := fileHeats(a)
+	heat := make(map[string]int, len(heats))
+	for _, file := range heats {
+		heat[file.path] = file.heat
+	}
+	var list []TouchedHotspot
+	for i, spot := range hotspots(a) {
+		if touched[spot.Path] {
+			list = append(list, TouchedHotspot{Path: spot.Path, Value: spot.Value, Rank: i + 1, Heat: heat[spot.Path]})
+		}
+	}
+	return list
+}
+
+// ownerWarnings returns the files with at least busFactorMinCommits commits within the last year of
+// which one author made busFactorShare or more
+func ownerWarnings(a *Analysis, files []string, now time.Time) []OwnerWarning {
+	authors := make(map[string]map[string]int, len(files)) // File, author name: commits
+	for _, file := range files {
+		authors[file] = make(map[string]int)
+	}
+	for _, commit := range a.Commits {
+		if now.Sub(commit.Date) > busFactorPeriod {
+			break // Newest first
+		}
+		for _, file := range commit.Files {
+			if counts, ok := authors[file.Path]; ok {
+				counts[commit.Author]++
+			}
+		}
+	}
+	var warnings []OwnerWarning
+	for file, counts := range authors {
+		total := 0
+		for _, commits := range counts {
+			total += commits
+		}
+		if total < busFactorMinCommits {
+			continue
+		}
+		for author, commits := range counts {
+			if share := float64(commits) / float64(total); share >= busFactorShare {
+				warnings = append(warnings, OwnerWarning{Path: file, Author: author, Share: share, Commits: total})
+			}
+		}
+	}
+	sort.Slice(warnings, func(i, j int) bool { return warnings[i].Path < warnings[j].Path })
+	return warnings
+}
+
+// runHook implements the hook command
+func runHook(args []string) int {
+	flags := flag.NewFlagSet("hook", flag.ContinueOnError)
+	push := flags.Bool("push", false, "pre-push mode: check the commits about to be pushed, read from standard input as git passes them to pre-push hooks, instead of the staged changes")
+	busFactor := flags.Bool("bus-factor", false, "also warn about touched files with 80% or more of their last year's commits by one author")
+	strict := flags.Bool("strict", false, "exit with 5 when the change touches a hotspot, aborting the commit or push")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s hook [options] [repo]\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Prints the hotspots a commit or push touches, for pre-commit and pre-push hooks. The repo defaults to the current directory.")
+		flags.PrintDefaults()
+	}
+	parseArgs(flags, args)
+	if flags.NArg() > 2 {
+		flags.Usage()
+		return exitUsage
+	}
+	repo := "."
+	if flags.NArg() > 0 && !*push {
+		repo = flags.Arg(0)
+	}
+
+	setupLogging(os.Stderr, "warn", "text") // Hooks only speak up about the change
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var files []string
+	var err error
+	if *push {
+		// git runs pre-push hooks with the remote name and URL as arguments, not a repository
+		files, err = pushedFiles(ctx, repo, os.Stdin)
+	} else {
+		files, err = stagedFiles(ctx, repo)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "git-dirheat: %v\n", err)
+		return exitCode(err)
+	}
+	if len(files) == 0 {
+		return exitOK
+	}
+	analysis, err := analyze(ctx, []string{repo})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "git-dirheat: error analyzing repository: %v\n", err)
+		return exitCode(err)
+	}
+
+	spots := touchedHotspots(analysis, files)
+	if len(spots) > 0 {
+		fmt.Fprintf(os.Stderr, "git-dirheat: this change touches %d of the repository's hotspots (options hash %s):\n", len(spots), analysis.Meta.OptionsHash)
+		for _, spot := range spots {
+			fmt.Fprintf(os.Stderr, "  %s  %d changes (hotspot #%d, at least as hot as %d%% of the files)\n", spot.Path, spot.Value, spot.Rank, spot.Heat)
+		}
+	}
+	if *busFactor {
+		for _, warning := range ownerWarnings(analysis, files, time.Now()) {
+			fmt.Fprintf(os.Stderr, "git-dirheat: warning: %s: %.0f%% of its %d commits in the last year by %s\n", warning.Path, warning.Share*100, warning.Commits, warning.Author)
+		}
+	}
+	if *strict && len(spots) > 0 {
+		return exitThreshold
+	}
+	return exitOK
+}
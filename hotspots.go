@@ -0,0 +1,42 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// hotspotShare is the share of the changed files, hottest first, that count as hotspots
+const hotspotShare = 0.05
+
+// Hotspot is a file among the most changed of the analysis
+type Hotspot struct {
+	Path  string `json:"path"`
+	Value int    `json:"value"`
+}
+
+// hotspots returns the hottest hotspotShare of the changed files (at least one), hottest first
+func hotspots(a *Analysis) []Hotspot {
+	var files []Hotspot
+	var walk func(n Node)
+	walk = func(n Node) {
+		if n.IsFile() {
+			if n.Value() > 0 {
+				files = append(files, Hotspot{Path: n.relPath(), Value: n.Value()})
+			}
+			return
+		}
+		for child := range n.Children() {
+			walk(child)
+		}
+	}
+	walk(a.Root)
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Value != files[j].Value {
+			return files[i].Value > files[j].Value
+		}
+		return files[i].Path < files[j].Path
+	})
+	count := int(math.Ceil(float64(len(files)) * hotspotShare))
+	return files[:min(count, len(files))]
+}
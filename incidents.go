@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultMinIncidents is the number of incidents a directory must be implicated in to be reported
+const defaultMinIncidents = 2
+
+// Incident is an incident or postmortem, mapped to the paths or services it implicated
+type Incident struct {
+	ID       string   `json:"id"`
+	Title    string   `json:"title,omitempty"`
+	Date     string   `json:"date,omitempty"`
+	Paths    []string `json:"paths,omitempty"`
+	Services []string `json:"services,omitempty"`
+}
+
+// incidentExport is the JSON incident file: a plain list, or the incidents with a service map
+type incidentExport struct {
+	Services  map[string][]string `json:"services"` // Paths per service name
+	Incidents []Incident          `json:"incidents"`
+}
+
+// IncidentOverlay is the reliability of a node: the incidents implicating it and how hot and
+// incident-prone it is
+type IncidentOverlay struct {
+	Incidents int `json:"incidents"`
+	// Risk is the geometric mean of the node's share of the churn and its share of the incidents,
+	// in percent, like the quality risk
+	Risk float64 `json:"risk"`
+}
+
+// incidentIndex holds the IDs of the incidents implicating every node, all incidents under ""
+type incidentIndex map[string][]string
+
+var incidents incidentIndex
+
+// splitList splits a CSV cell of values separated by semicolons or pipes
+func splitList(cell string) []string {
+	var values []string
+	for _, value := range strings.FieldsFunc(cell, func(r rune) bool { return r == ';' || r == '|' }) {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// parseIncidentCSV reads incidents from a CSV file with a header row naming the id, title, date,
+// paths and services columns; paths and services hold several values separated by semicolons
+func parseIncidentCSV(data []byte) ([]Incident, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["id"]; !ok {
+		return nil, fmt.Errorf("missing id column")
+	}
+	if _, hasPaths := columns["paths"]; !hasPaths {
+		if _, hasServices := columns["services"]; !hasServices {
+			return nil, fmt.Errorf("missing paths or services column")
+		}
+	}
+	cell := func(record []string, column string) string {
+		if i, ok := columns[column]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	list := make([]Incident, 0, len(records)-1)
+	for _, record := range records[1:] {
+		list = append(list, Incident{
+			ID:       cell(record, "id"),
+			Title:    cell(record, "title"),
+			Date:     cell(record, "date"),
+			Paths:    splitList(cell(record, "paths")),
+			Services: splitList(cell(record, "services")),
+		})
+	}
+	return list, nil
+}
+
+// serviceDirectories finds the directories at HEAD named like the services, for services missing
+// from the service map
+func serviceDirectories(head *headTree, services map[string]bool) map[string][]string {
+	found := make(map[string][]string)
+	for dir := range head.dirs {
+		if name := strings.ToLower(path.Base(dir)); services[name] {
+			found[name] = append(found[name], dir)
+		}
+	}
+	for _, dirs := range found {
+		sort.Strings(dirs)
+	}
+	return found
+}
+
+// loadIncidents reads a JSON or CSV incident file and indexes the incidents by the nodes they
+// implicate: their paths, the directories of the services, and everything above them
+func loadIncidents(file string, head *headTree) (incidentIndex, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var export incidentExport
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		err = json.Unmarshal(trimmed, &export.Incidents)
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		err = json.Unmarshal(trimmed, &export)
+	default:
+		export.Incidents, err = parseIncidentCSV(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing incident file '%s': %w", file, err)
+	}
+
+	unmapped := make(map[string]bool)
+	services := make(map[string][]string)
+	for name, paths := range export.Services {
+		services[strings.ToLower(name)] = paths
+	}
+	for _, incident := range export.Incidents {
+		for _, service := range incident.Services {
+			if _, ok := services[strings.ToLower(service)]; !ok {
+				unmapped[strings.ToLower(service)] = true
+			}
+		}
+	}
+	for name, dirs := range serviceDirectories(head, unmapped) {
+		services[name] = dirs
+	}
+
+	index := make(incidentIndex)
+	for i, incident := range export.Incidents {
+		if incident.ID == "" {
+			incident.ID = "#" + strconv.Itoa(i+1)
+		}
+		nodes := map[string]bool{"": true}
+		paths := append([]string(nil), incident.Paths...)
+		for _, service := range incident.Services {
+			paths = append(paths, services[strings.ToLower(service)]...)
+		}
+		for _, path := range paths {
+			path = issuePath(path)
+			if path == "" {
+				continue
+			}
+			nodes[path] = true
+			for _, dir := range directoriesOf(path, math.MaxInt) {
+				nodes[dir] = true
+			}
+		}
+		for node := range nodes {
+			index[node] = append(index[node], incident.ID)
+		}
+	}
+	return index, nil
+}
+
+// risk returns the reliability risk of a node with the given churn value
+func (index incidentIndex) risk(path string, value, rootValue int) float64 {
+	total := len(index[""])
+	if rootValue <= 0 || total == 0 {
+		return 0
+	}
+	risk := 100 * math.Sqrt(float64(value)/float64(rootValue)*float64(len(index[path]))/float64(total))
+	return math.Round(risk*100) / 100
+}
+
+// incidentOverlay returns the tree overlay of the incidents
+func incidentOverlay(index incidentIndex) treeOverlay {
+	return treeOverlay{field: "incidents", value: func(path string, n Node, rootValue int) any {
+		if len(index[path]) == 0 {
+			return nil
+		}
+		return &IncidentOverlay{Incidents: len(index[path]), Risk: index.risk(path, n.Value(), rootValue)}
+	}}
+}
+
+// ImplicatedDirectory is a directory implicated in several incidents
+type ImplicatedDirectory struct {
+	Path      string   `json:"path"`
+	Incidents []string `json:"incidents"` // Incident IDs
+	Value     int      `json:"value"`     // Churn of the directory
+	Risk      float64  `json:"risk"`
+}
+
+// implicatedDirectories lists the directories up to depth levels implicated in at least
+// minIncidents incidents, the riskiest first
+func implicatedDirectories(a *Analysis, index incidentIndex, depth, minIncidents int) []ImplicatedDirectory {
+	list := []ImplicatedDirectory{}
+	for path, ids := range index {
+		if path == "" || len(ids) < minIncidents || strings.Count(path, "/") >= depth {
+			continue
+		}
+		value := 0
+		if node, _ := a.Root.find(path); node.exists() {
+			if node.IsFile() {
+				continue
+			}
+			value = node.Value()
+		}
+		list = append(list, ImplicatedDirectory{Path: path, Incidents: ids, Value: value, Risk: index.risk(path, value, a.Root.Value())})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Risk != list[j].Risk {
+			return list[i].Risk > list[j].Risk
+		}
+		return list[i].Path < list[j].Path
+	})
+	return list
+}
+
+// handleIncidents serves GET /incidents?min=2&depth=2, the directories implicated in at least min incidents
+func handleIncidents(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	if incidents == nil {
+		http.Error(w, "The incident overlay needs -incidents-file", http.StatusNotFound)
+		return
+	}
+	depth, ok := authorDepth(w, r)
+	if !ok {
+		return
+	}
+	minIncidents := defaultMinIncidents
+	if value := r.URL.Query().Get("min"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			http.Error(w, fmt.Sprintf("Invalid min '%s'", value), http.StatusBadRequest)
+			return
+		}
+		minIncidents = parsed
+	}
+	writeJSON(w, implicatedDirectories(analysis, incidents, depth, minIncidents))
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// jiraBatch is the number of ticket keys resolved per Jira search request
+const jiraBatch = 100
+
+// JiraOptions configures resolving the ticket keys referenced by commit messages
+type JiraOptions struct {
+	URL      string   // Base URL of the Jira instance, empty disables the overlay
+	User     string   // Jira Cloud account email for basic authentication; empty sends the token as bearer token (Server/Data Center)
+	Token    string   // API or personal access token
+	Projects []string // Project keys whose tickets are looked for, e.g. PAY
+	BugTypes []string // Issue types counting as defects
+}
+
+var jiraOptions JiraOptions
+
+// ticketPattern returns the regular expression matching ticket keys of the projects
+func ticketPattern(projects []string) *regexp.Regexp {
+	quoted := make([]string, len(projects))
+	for i, project := range projects {
+		quoted[i] = regexp.QuoteMeta(strings.ToUpper(strings.TrimSpace(project)))
+	}
+	return regexp.MustCompile(`\b(?:` + strings.Join(quoted, "|") + `)-[0-9]+\b`)
+}
+
+// jiraSearch returns the issue type of every found key of one batch
+func jiraSearch(ctx context.Context, opts JiraOptions, keys []string) (map[string]string, error) {
+	query := url.Values{
+		"jql":           {"key in (" + strings.Join(keys, ",") + ")"},
+		"fields":        {"issuetype"},
+		"maxResults":    {fmt.Sprint(len(keys))},
+		"validateQuery": {"warn"}, // Unknown keys (typos, deleted tickets) don't fail the batch
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(opts.URL, "/")+"/rest/api/2/search?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if opts.User != "" {
+		req.SetBasicAuth(opts.User, opts.Token)
+	} else if opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.Token)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("jira answered %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	var result struct {
+		Issues []struct {
+			Key    string `json:"key"`
+			Fields struct {
+				IssueType struct {
+					Name string `json:"name"`
+				} `json:"issuetype"`
+			} `json:"fields"`
+		} `json:"issues"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing the jira response: %w", err)
+	}
+	types := make(map[string]string, len(result.Issues))
+	for _, issue := range result.Issues {
+		types[issue.Key] = issue.Fields.IssueType.Name
+	}
+	return types, nil
+}
+
+// resolveIssueTypes looks up the issue types of the tickets referenced by the analyzed commits and
+// stores them in the analysis
+func resolveIssueTypes(ctx context.Context, a *Analysis, opts JiraOptions) error {
+	tickets := &ticketKeys{pattern: ticketPattern(opts.Projects), seen: make(map[string]bool)}
+	replay(a.Commits, tickets)
+	keys := tickets.keys
+	sort.Strings(keys)
+
+	a.IssueTypes = make(map[string]string, len(keys))
+	for start := 0; start < len(keys); start += jiraBatch {
+		types, err := jiraSearch(ctx, opts, keys[start:min(start+jiraBatch, len(keys))])
+		if err != nil {
+			return fmt.Errorf("error resolving jira tickets: %w", err)
+		}
+		for key, issueType := range types {
+			a.IssueTypes[key] = issueType
+		}
+	}
+	slog.Info("Resolved jira tickets", "referenced", len(keys), "found", len(a.IssueTypes))
+	return nil
+}
+
+// commitKind classifies a commit by the tickets it references: "bug" when one of them is a defect,
+// "feature" when it only references other resolved tickets, "" otherwise
+func commitKind(a *Analysis, pattern *regexp.Regexp, bugTypes []string, c *Commit) string {
+	kind := ""
+	for _, key := range pattern.FindAllString(c.Subject, -1) {
+		issueType, ok := a.IssueTypes[key]
+		if !ok {
+			continue
+		}
+		if slices.ContainsFunc(bugTypes, func(t string) bool { return strings.EqualFold(strings.TrimSpace(t), issueType) }) {
+			return "bug"
+		}
+		kind = "feature"
+	}
+	return kind
+}
+
+// DirectoryDefects compares the bug-driven and feature-driven churn of a directory
+type DirectoryDefects struct {
+	Path          string  `json:"path"`
+	BugChurn      int     `json:"bugChurn"`      // File changes of commits referencing a defect ticket
+	FeatureChurn  int     `json:"featureChurn"`  // File changes of commits referencing other tickets
+	DefectDensity float64 `json:"defectDensity"` // Share of the ticket-driven churn that fixes defects
+}
+
+// directoryDefects aggregates the ticket-driven churn per directory up to depth levels, the
+// directories with the most bug-driven churn first
+func directoryDefects(a *Analysis, depth int) []DirectoryDefects {
+	pattern := ticketPattern(jiraOptions.Projects)
+	dirs := make(map[string]*DirectoryDefects)
+	for _, commit := range a.Commits {
+		kind := commitKind(a, pattern, jiraOptions.BugTypes, commit)
+		if kind == "" {
+			continue
+		}
+		for _, file := range commit.Files {
+			for _, dir := range directoriesOf(file.Path, depth) {
+				entry := dirs[dir]
+				if entry == nil {
+					entry = &DirectoryDefects{Path: dir}
+					dirs[dir] = entry
+				}
+				if kind == "bug" {
+					entry.BugChurn++
+				} else {
+					entry.FeatureChurn++
+				}
+			}
+		}
+	}
+	list := make([]DirectoryDefects, 0, len(dirs))
+	for _, entry := range dirs {
+		entry.DefectDensity = float64(entry.BugChurn) / float64(entry.BugChurn+entry.FeatureChurn)
+		list = append(list, *entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].BugChurn != list[j].BugChurn {
+			return list[i].BugChurn > list[j].BugChurn
+		}
+		return list[i].Path < list[j].Path
+	})
+	return list
+}
+
+// handleDefects serves GET /defects?depth=2, the bug versus feature churn per directory
+func handleDefects(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	if analysis.IssueTypes == nil {
+		http.Error(w, "The defect overlay needs -jira-url and -jira-project", http.StatusNotFound)
+		return
+	}
+	depth, ok := authorDepth(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, directoryDefects(analysis, depth))
+}
+
+// handleOverlayData serves GET /data?overlay=bugs|features, the churn of the commits referencing
+// defect tickets or other tickets
+func handleOverlayData(w http.ResponseWriter, r *http.Request, a *Analysis) {
+	overlay := r.URL.Query().Get("overlay")
+	kind := map[string]string{"bugs": "bug", "features": "feature"}[overlay]
+	if kind == "" {
+		http.Error(w, fmt.Sprintf("Unknown overlay '%s', expected bugs or features", overlay), http.StatusBadRequest)
+		return
+	}
+	if a.IssueTypes == nil {
+		http.Error(w, "The defect overlay needs -jira-url and -jira-project", http.StatusNotFound)
+		return
+	}
+	pattern := ticketPattern(jiraOptions.Projects)
+	filtered := commitAnalysis(a, func(c *Commit) bool { return commitKind(a, pattern, jiraOptions.BugTypes, c) == kind })
+	writeCommitData(w, r, filtered, map[string]string{"overlay": overlay})
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxAnalyzeBody limits the size of a POST /analyze request body
+const maxAnalyzeBody = 16 * 1024
+
+// AnalyzeRequest is the body of POST /analyze: an ad-hoc analysis of a revision range
+type AnalyzeRequest struct {
+	Rev    string `json:"rev,omitempty"`    // Revision or range like v1.0..v2.0, -rev by default
+	Repo   string `json:"repo,omitempty"`   // Portfolio mode: the repository's name
+	Path   string `json:"path,omitempty"`   // Only changes below this directory or of this file
+	Author string `json:"author,omitempty"` // Only commits of this email or name
+	Since  string `json:"since,omitempty"`  // YYYY-MM-DD, inclusive
+	Until  string `json:"until,omitempty"`  // YYYY-MM-DD, inclusive
+	Weight string `json:"weight,omitempty"` // changes (default) or lines: added plus deleted lines
+}
+
+// Job statuses
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+// Options of the job queue
+var (
+	jobWorkers   = 2  // -job-workers: ad-hoc analyses running at once
+	jobQueueSize = 16 // -job-queue: ad-hoc analyses waiting for a worker, further ones are rejected
+	jobQueue     chan *Job
+)
+
+// Job is an ad-hoc analysis started with POST /analyze
+type Job struct {
+	ID          string         `json:"id"`
+	Status      string         `json:"status"`
+	Request     AnalyzeRequest `json:"request"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	StartedAt   *time.Time     `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time     `json:"finishedAt,omitempty"`
+	Position    int            `json:"position,omitempty"` // Queued jobs: 1 for the next one to run
+	Progress    string         `json:"progress,omitempty"` // Running jobs: the current step
+	Error       string         `json:"error,omitempty"`
+	CommitCount int            `json:"commitCount"`
+	DataURL     string         `json:"dataUrl,omitempty"` // Set once the job is done
+
+	analysis *Analysis
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// jobStore holds the jobs in memory, they don't survive a restart
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+var jobs = &jobStore{jobs: make(map[string]*Job)}
+
+// add stores a new queued job for the request
+func (s *jobStore) add(req AnalyzeRequest) (*Job, error) {
+	id, err := newViewID()
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{ID: id, Status: jobQueued, Request: req, CreatedAt: time.Now().UTC()}
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[id] = job
+	return job, nil
+}
+
+// remove forgets a job
+func (s *jobStore) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[id]; ok {
+		job.cancel()
+		delete(s.jobs, id)
+	}
+}
+
+// snapshotLocked returns a copy of the job with its queue position
+func (s *jobStore) snapshotLocked(job *Job) Job {
+	copied := *job
+	if job.Status == jobQueued {
+		for _, other := range s.jobs {
+			if other.Status == jobQueued && !other.CreatedAt.After(job.CreatedAt) {
+				copied.Position++
+			}
+		}
+	}
+	return copied
+}
+
+// get returns a copy of the job, safe to encode while the job runs
+func (s *jobStore) get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return s.snapshotLocked(job), true
+}
+
+// list returns copies of all jobs, the newest first
+func (s *jobStore) list() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		list = append(list, s.snapshotLocked(job))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list
+}
+
+// update changes the job under the store's lock
+func (s *jobStore) update(id string, change func(job *Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[id]; ok {
+		change(job)
+	}
+}
+
+// cancel stops a queued or running job, reporting false for jobs that already finished (and
+// unknown ones, returned empty)
+func (s *jobStore) cancel(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	if job.Status != jobQueued && job.Status != jobRunning {
+		return s.snapshotLocked(job), false
+	}
+	finished := time.Now().UTC()
+	job.Status, job.FinishedAt, job.Progress = jobCanceled, &finished, ""
+	job.cancel()
+	return s.snapshotLocked(job), true
+}
+
+// jobRepo returns the path of the repository the request analyzes
+func jobRepo(req AnalyzeRequest) (string, error) {
+	if req.Repo == "" {
+		if len(repoPaths) > 1 {
+			return "", errors.New("repo is required when serving several repositories")
+		}
+		return repoPath, nil
+	}
+	for _, path := range repoPaths {
+		if repoName(path) == req.Repo {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("unknown repo '%s'", req.Repo)
+}
+
+// validate checks the request before it is queued, filling in the defaults
+func (req *AnalyzeRequest) validate() error {
+	req.Rev = strings.TrimSpace(cmp.Or(req.Rev, analysisRev))
+	for _, rev := range strings.Split(req.Rev, "..") {
+		if rev = strings.TrimPrefix(rev, "."); strings.HasPrefix(rev, "-") {
+			return fmt.Errorf("invalid revision '%s'", req.Rev)
+		}
+	}
+	for _, date := range []string{req.Since, req.Until} {
+		if _, err := time.Parse(time.DateOnly, date); date != "" && err != nil {
+			return fmt.Errorf("invalid date '%s' (expected YYYY-MM-DD)", date)
+		}
+	}
+	var err error
+	if req.Path, err = cleanPath(req.Path); err != nil {
+		return err
+	}
+	req.Weight = cmp.Or(req.Weight, "changes")
+	if req.Weight != "changes" && req.Weight != "lines" {
+		return fmt.Errorf("unknown weight '%s' (expected changes or lines)", req.Weight)
+	}
+	_, err = jobRepo(*req)
+	return err
+}
+
+// resolveRange resolves both ends of a revision range like v1.0..v2.0 (or v1.0...v2.0) to
+// commit hashes, returning the git log argument and the hash of the newest end
+func resolveRange(ctx context.Context, repo, rev string) (logRange, head string, err error) {
+	base, tip, isRange := strings.Cut(rev, "..")
+	if !isRange {
+		head, err = resolveCommit(ctx, repo, rev)
+		return head, head, err
+	}
+	separator := ".."
+	if rest, ok := strings.CutPrefix(tip, "."); ok {
+		separator, tip = "...", rest
+	}
+	if base, err = resolveCommit(ctx, repo, cmp.Or(base, "HEAD")); err != nil {
+		return "", "", err
+	}
+	if head, err = resolveCommit(ctx, repo, cmp.Or(tip, "HEAD")); err != nil {
+		return "", "", err
+	}
+	return base + separator + head, head, nil
+}
+
+// runAnalysis performs the ad-hoc analysis of the request, reporting its steps to progress
+func runAnalysis(ctx context.Context, req AnalyzeRequest, progress func(step string)) (*Analysis, error) {
+	repo, err := jobRepo(req)
+	if err != nil {
+		return nil, err
+	}
+	progress("resolving revisions")
+	logRange, head, err := resolveRange(ctx, repo, req.Rev)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{logRange}
+	if req.Since != "" {
+		args = append(args, "--since="+req.Since+"T00:00:00Z")
+	}
+	if req.Until != "" {
+		args = append(args, "--until="+req.Until+"T23:59:59Z")
+	}
+	if req.Path != "" {
+		args = append(args, "--", req.Path)
+	}
+	progress("reading history")
+	_, commits, err := logHistory(ctx, repo, args...)
+	if err != nil {
+		return nil, err
+	}
+	progress("building tree")
+
+	values := newWeightedCounts()
+	var kept []*Commit
+	for _, commit := range commits {
+		if req.Author != "" && !commit.isAuthor(req.Author) {
+			continue
+		}
+		kept = append(kept, commit)
+		for _, file := range commit.Files {
+			if req.Weight == "lines" {
+				values.add(file.Path, file.Added+file.Deleted, commit.Weight)
+			} else {
+				values.add(file.Path, 1, commit.Weight)
+			}
+		}
+	}
+	root := populateTree(repo, values.result())
+	root.aggregateCounts()
+
+	meta := repoMetadata(ctx, repo, len(kept))
+	meta.RevisionRange, meta.Revision, meta.Branch = req.Rev, head, ""
+	for key, value := range map[string]string{"path": req.Path, "author": req.Author, "since": req.Since, "until": req.Until, "weight": req.Weight} {
+		if value != "" {
+			meta.Filters[key] = value
+		}
+	}
+	meta.OptionsHash = optionsHash(meta)
+	return &Analysis{Root: root, Meta: meta, Commits: kept, Snapshots: buildSnapshots(repo, kept)}, nil
+}
+
+// startJobWorkers starts the workers running the queued jobs
+func startJobWorkers() {
+	jobQueue = make(chan *Job, jobQueueSize)
+	for range jobWorkers {
+		go func() {
+			for job := range jobQueue {
+				runJob(job)
+			}
+		}()
+	}
+}
+
+// enqueue queues the job, reporting false when the queue is full
+func enqueue(job *Job) bool {
+	select {
+	case jobQueue <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// runJob runs a queued job unless it was canceled meanwhile
+func runJob(job *Job) {
+	defer job.cancel()
+	started := false
+	jobs.update(job.ID, func(job *Job) {
+		if job.Status == jobQueued {
+			now := time.Now().UTC()
+			job.Status, job.StartedAt, started = jobRunning, &now, true
+		}
+	})
+	if !started {
+		return
+	}
+	start := time.Now()
+	analysis, err := runAnalysis(job.ctx, job.Request, func(step string) {
+		jobs.update(job.ID, func(job *Job) {
+			if job.Status == jobRunning {
+				job.Progress = step
+			}
+		})
+	})
+	jobs.update(job.ID, func(job *Job) {
+		if job.Status != jobRunning {
+			return // Canceled
+		}
+		finished := time.Now().UTC()
+		job.FinishedAt, job.Progress = &finished, ""
+		if err != nil {
+			job.Status, job.Error = jobFailed, err.Error()
+			return
+		}
+		job.Status, job.analysis = jobDone, analysis
+		job.CommitCount = analysis.Meta.CommitCount
+		job.DataURL = basePath + "/jobs/" + job.ID + "/data"
+	})
+	jobs.prune(time.Now(), retention)
+	switch {
+	case job.ctx.Err() != nil:
+		slog.Info("Ad-hoc analysis canceled", "job", job.ID, "rev", job.Request.Rev)
+	case err != nil:
+		slog.Warn("Ad-hoc analysis failed", "job", job.ID, "rev", job.Request.Rev, "error", err)
+	default:
+		slog.Info("Ad-hoc analysis complete", "job", job.ID, "rev", job.Request.Rev, "commits", analysis.Meta.CommitCount, "time", time.Since(start).Round(time.Millisecond).String())
+	}
+}
+
+// handleAnalyze serves POST /analyze, queuing an ad-hoc analysis of the JSON body's revision range.
+// It answers 202 Accepted with the job, whose status and result are served under /jobs/{id}, or
+// 503 when -job-queue jobs are already waiting.
+func handleAnalyze(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req := AnalyzeRequest{}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAnalyzeBody))
+	if err == nil && len(data) > 0 {
+		err = json.Unmarshal(data, &req)
+	}
+	if err == nil {
+		err = req.validate()
+	}
+	if err != nil {
+		http.Error(w, "Invalid analysis request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	job, err := jobs.add(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !enqueue(job) {
+		jobs.remove(job.ID)
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, "Too many analyses queued, retry later.", http.StatusServiceUnavailable)
+		return
+	}
+	queued, _ := jobs.get(job.ID)
+	w.Header().Set("Location", basePath+"/jobs/"+job.ID)
+	writeJSONStatus(w, http.StatusAccepted, queued)
+}
+
+// handleJobs serves GET /jobs, all ad-hoc analyses, the newest first
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, jobs.list())
+}
+
+// handleJob serves GET /jobs/{id}, the status and progress of an ad-hoc analysis, and DELETE
+// /jobs/{id}, canceling it
+func handleJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	switch r.Method {
+	case http.MethodGet:
+		job, ok := jobs.get(id)
+		if !ok {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, job)
+	case http.MethodDelete:
+		job, ok := jobs.cancel(id)
+		if job.ID == "" {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		if !ok {
+			http.Error(w, "The job is already "+job.Status, http.StatusConflict)
+			return
+		}
+		writeJSON(w, job)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleJobData serves GET /jobs/{id}/data, the tree of a finished ad-hoc analysis. It takes the
+// /data query parameters.
+func handleJobData(w http.ResponseWriter, r *http.Request) {
+	job, ok := jobs.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	switch job.Status {
+	case jobFailed:
+		http.Error(w, "The analysis failed: "+job.Error, http.StatusUnprocessableEntity)
+		return
+	case jobCanceled:
+		http.Error(w, "The analysis was canceled", http.StatusGone)
+		return
+	case jobQueued, jobRunning:
+		w.Header().Set("Retry-After", "2")
+		http.Error(w, "The analysis is still "+job.Status+", retry shortly.", http.StatusAccepted)
+		return
+	}
+	writeCommitData(w, r, job.analysis, map[string]string{"job": job.ID})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os/exec"
+	"runtime"
+)
+
+// portAttempts is the number of ports after a busy one tried before the system picks a free one
+const portAttempts = 10
+
+var (
+	openBrowser bool // -open: open the served URL in the default browser
+	strictPort  bool // -strict-port: fail instead of falling back to another port when the port is busy
+)
+
+// listen opens the server's listener on port. When the port is busy it tries the following ones
+// and then any free port, unless strictPort is set.
+func listen(port int) (net.Listener, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err == nil || strictPort || !addrInUse(err) {
+		return listener, err
+	}
+	for next := port + 1; next <= port+portAttempts && next <= 65535; next++ {
+		if listener, err := net.Listen("tcp", fmt.Sprintf(":%d", next)); err == nil {
+			slog.Warn("Port is busy, listening on another one", "port", port, "using", next)
+			return listener, nil
+		}
+	}
+	listener, err = net.Listen("tcp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	slog.Warn("Port is busy, listening on a free one", "port", port, "using", listener.Addr().(*net.TCPAddr).Port)
+	return listener, nil
+}
+
+// openURL opens the URL in the default browser, without waiting for it
+func openURL(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	case "darwin":
+		cmd = exec.Command("open", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error opening the browser: %w", err)
+	}
+	go cmd.Wait() // Reap the process
+	return nil
+}
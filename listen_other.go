@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// addrInUse reports whether listening failed because the address is in use
+func addrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenBusyPort(t *testing.T) {
+	busy, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	port := busy.Addr().(*net.TCPAddr).Port
+
+	t.Run("fallback", func(t *testing.T) {
+		listener, err := listen(port)
+		if err != nil {
+			t.Fatalf("listen(%d) error: %v", port, err)
+		}
+		defer listener.Close()
+		if got := listener.Addr().(*net.TCPAddr).Port; got == port {
+			t.Errorf("listen(%d) listens on the busy port", port)
+		}
+	})
+	t.Run("strict", func(t *testing.T) {
+		defer func(saved bool) { strictPort = saved }(strictPort)
+		strictPort = true
+		listener, err := listen(port)
+		if err == nil {
+			listener.Close()
+			t.Fatalf("listen(%d) succeeded on the busy port", port)
+		}
+		if !addrInUse(err) {
+			t.Errorf("addrInUse(%v) = false, want true", err)
+		}
+	})
+}
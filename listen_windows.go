@@ -0,0 +1,14 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// wsaeaddrinuse is Winsock's WSAEADDRINUSE, which syscall.EADDRINUSE doesn't match on Windows
+const wsaeaddrinuse = syscall.Errno(10048)
+
+// addrInUse reports whether listening failed because the address is in use
+func addrInUse(err error) bool {
+	return errors.Is(err, wsaeaddrinuse) || errors.Is(err, syscall.EADDRINUSE)
+}
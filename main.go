@@ -2,367 +2,1802 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"iter"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
-// Node represents a directory or file in the repository structure (Internal)
+// version is set at build time via -ldflags "-X main.version=..."
+var version = "dev"
+
+// heatmapHTML is the dashboard served on /, built into the binary so it works from any directory
+//
+//go:embed heatmap.html
+var heatmapHTML []byte
+
+// startedAt is when the process started, the modification time of the built-in dashboard
+var startedAt = time.Now()
+
+// commitMarker prefixes the header line emitted for every commit in the git log output
+const commitMarker = "\x1e"
+
+// Node represents a directory or file in the repository structure (Internal): a handle to the
+// node's entry in the table of its tree. The zero Node is no node, see exists.
 type Node struct {
-	Name     string
-	Path     string // Relative path from repo root
-	Value    int    // Aggregated change count
-	IsFile   bool
-	Children map[string]*Node
+	tree  *tree
+	index int32
 }
 
-// JSONNode is the structure used for JSON output, compatible with D3.js
-type JSONNode struct {
-	Name     string      `json:"name"`
-	Value    int         `json:"value"`
-	Children []*JSONNode `json:"children,omitempty"` // Use slice for JSON
+// tree stores the nodes of a tree in a single table laid out breadth first, so the children of
+// every node are adjacent and sorted by name, and their names in a single string. Without a
+// pointer or an allocation per node, trees of millions of files stay small and cost the garbage
+// collector nothing to scan.
+type tree struct {
+	nodes []nodeData
+	names string
 }
 
-// NewNode creates a new internal Node
-func NewNode(name, path string, isFile bool) *Node {
-	return &Node{
-		Name:     name,
-		Path:     path,
-		Value:    0,
-		IsFile:   isFile,
-		Children: make(map[string]*Node),
-	}
+// nodeData is the table entry of a node
+type nodeData struct {
+	value      int    // Aggregated change count
+	name       uint32 // Offset of the name in the tree's names
+	nameLen    uint32
+	parent     int32 // -1 for the root
+	children   int32 // Index of the first child
+	childCount int32
+	flags      uint8
 }
 
-// ensurePath navigates or creates nodes for the given path parts
-// and returns the final node (which represents a file in this context).
-func (n *Node) ensurePath(pathParts []string) *Node {
-	current := n
-	currentPath := "/"
+// Flags of a node
+const (
+	nodeFile    uint8 = 1 << iota
+	nodeSymlink       // Tracked as a symbolic link at the analyzed revision
+)
 
-	for i, part := range pathParts {
-		if part == "" {
-			continue // Skip empty parts
-		}
+// Metadata describes how a result was generated, so consumers can tell what a given JSON blob represents
+type Metadata struct {
+	ToolVersion   string            `json:"toolVersion"`
+	RepoPath      string            `json:"repoPath"`
+	Branch        string            `json:"branch,omitempty"`
+	Revision      string            `json:"revision,omitempty"` // Commit hash of the analyzed HEAD (or -rev)
+	RevisionRange string            `json:"revisionRange"`
+	CommitCount   int               `json:"commitCount"`
+	Filters       map[string]string `json:"filters,omitempty"`
+	OptionsHash   string            `json:"optionsHash,omitempty"` // Of the revision range, filters and tool version, see -expect-hash
+	GeneratedAt   time.Time         `json:"generatedAt"`
+	Repos         []RepoInfo        `json:"repos,omitempty"`        // Portfolio mode: the combined repositories
+	PartialClone  string            `json:"partialClone,omitempty"` // Object filter of a partial clone, analyzed without line counts
+	Shallow       *ShallowInfo      `json:"shallow,omitempty"`      // Set when a shallow clone was analyzed
+}
+
+// FileChange is a single file's line changes within a commit
+type FileChange struct {
+	Path    string // Slash separated path relative to the repository root, as used in the tree
+	Added   int
+	Deleted int
+}
+
+// Commit is a parsed commit of the analyzed history
+type Commit struct {
+	Hash    string
+	Author  string
+	Email   string
+	Date    time.Time
+	Subject string
+	Files   []FileChange
+	Copies  []FileCopy // With -copies: files this commit created as copies of others
+
+	Trailers []Trailer // Trailers of the commit message, e.g. Reviewed-by
+	// Weight the commit gives itself by trailer (see sourceWeight): every view counts its changes
+	// this many times. Commits weighing 0 (Skip-Dirheat) are left out of the analysis.
+	Weight float64
+}
+
+// FileCopy is a file created as a copy of another one, as found by git's copy detection
+type FileCopy struct {
+	From string
+	To   string
+}
+
+// Analysis is the result of analyzing a repository
+type Analysis struct {
+	Root      Node
+	Meta      Metadata
+	Commits   []*Commit         // Newest first, as emitted by git log
+	Snapshots []*Snapshot       // Per calendar month, oldest first
+	Repos     map[string]string // Portfolio mode: repository path per first path segment
+
+	IssueTypes map[string]string // Jira overlay: issue type per ticket key referenced by the commits
+
+	metrics  map[string]map[string]float64 // Node metrics by tree path of derived trees, see nodeMetrics
+	profiles map[string]*Analysis          // Trees of the weight profiles by name, see -profile
+}
 
-		child, exists := current.Children[part]
-		currentPath = filepath.Join(currentPath, part)
-		isFile := (i == len(pathParts)-1) // It's a file if it's the last part
+// TreeOptions controls how the internal tree is converted to JSON
+type TreeOptions struct {
+	MinValue   int     // Siblings below this value are collapsed into an "other" node
+	MinPercent float64 // Siblings below this share of the root (in percent) are collapsed into an "other" node
+	MaxDepth   int     // Levels of descendants to include below the requested node, 0 means unlimited
+	Scale      string  // Transform of the values for skewed distributions: log, sqrt or percentile ("" keeps them raw)
+}
+
+// exists reports whether n is a node rather than the zero Node
+func (n Node) exists() bool {
+	return n.tree != nil
+}
+
+func (n Node) data() *nodeData {
+	return &n.tree.nodes[n.index]
+}
+
+// Name returns the name of the node, the repository's for a root
+func (n Node) Name() string {
+	d := n.data()
+	return n.tree.names[d.name : d.name+d.nameLen]
+}
 
-		if !exists {
-			child = NewNode(part, currentPath, isFile)
-			current.Children[part] = child
-			// Ensure parent nodes are marked as not files if they were initially created as files
-			current.IsFile = false
+// Value returns the node's aggregated change count
+func (n Node) Value() int {
+	return n.data().value
+}
+
+func (n Node) setValue(value int) {
+	n.data().value = value
+}
+
+// IsFile reports whether the node is a file
+func (n Node) IsFile() bool {
+	return n.data().flags&nodeFile != 0
+}
+
+// Symlink reports whether the node is tracked as a symbolic link at the analyzed revision
+func (n Node) Symlink() bool {
+	return n.data().flags&nodeSymlink != 0
+}
+
+func (n Node) markSymlink() {
+	n.data().flags |= nodeSymlink
+}
+
+// parent returns the directory of the node, the zero Node for a root
+func (n Node) parent() Node {
+	if parent := n.data().parent; parent >= 0 {
+		return Node{n.tree, parent}
+	}
+	return Node{}
+}
+
+// childCount returns the number of children of the node
+func (n Node) childCount() int {
+	return int(n.data().childCount)
+}
+
+// Children returns the children of the node in name order
+func (n Node) Children() iter.Seq[Node] {
+	return func(yield func(Node) bool) {
+		d := n.data()
+		for i := d.children; i < d.children+d.childCount; i++ {
+			if !yield(Node{n.tree, i}) {
+				return
+			}
 		}
-		current = child
 	}
-	return current
+}
+
+// child returns the child with the given name, or the zero Node
+func (n Node) child(name string) Node {
+	d := n.data()
+	i := sort.Search(int(d.childCount), func(i int) bool { return Node{n.tree, d.children + int32(i)}.Name() >= name })
+	if child := (Node{n.tree, d.children + int32(i)}); i < int(d.childCount) && child.Name() == name {
+		return child
+	}
+	return Node{}
 }
 
 // aggregateCounts recursively calculates the sum of changes for directories.
 // It assumes file node values are already set.
-func (n *Node) aggregateCounts() int {
-	if n.IsFile {
-		return n.Value // Base case: file's value is its own count
+func (n Node) aggregateCounts() int {
+	if n.IsFile() {
+		return n.Value() // Base case: file's value is its own count
 	}
 
 	sum := 0
-	for _, child := range n.Children {
+	for child := range n.Children() {
 		sum += child.aggregateCounts()
 	}
-	n.Value = sum // Set directory's value to the sum of its children
+	n.setValue(sum) // Set directory's value to the sum of its children
 	return sum
 }
 
-// ToJSONNode converts the internal Node structure to the JSONNode structure.
-func (n *Node) ToJSONNode() *JSONNode {
-	jNode := &JSONNode{
-		Name:  n.Name,
-		Value: n.Value,
+// relPath returns the node's slash separated path relative to the repository root ("" for the root)
+func (n Node) relPath() string {
+	var names []string
+	for node := n; node.parent().exists(); node = node.parent() {
+		names = append(names, node.Name())
 	}
+	slices.Reverse(names)
+	return strings.Join(names, "/")
+}
 
-	if len(n.Children) > 0 {
-		jNode.Children = make([]*JSONNode, 0, len(n.Children))
-		for _, child := range n.Children {
-			// Only include children with changes or that are non-empty directories
-			if child.Value > 0 {
-				jNode.Children = append(jNode.Children, child.ToJSONNode())
-			}
+// nodeID derives a short identifier from a node path that stays the same across analyses
+func nodeID(path string) string {
+	sum := sha1.Sum([]byte(path))
+	return hex.EncodeToString(sum[:6])
+}
+
+// find returns the node at the given slash separated path relative to n, along with its parent.
+// It returns zero Nodes if no such node exists.
+func (n Node) find(path string) (node, parent Node) {
+	node = n
+	for _, part := range strings.Split(path, "/") {
+		if part == "" {
+			continue
+		}
+		child := node.child(part)
+		if !child.exists() {
+			return Node{}, Node{}
+		}
+		node, parent = child, node
+	}
+	return node, parent
+}
+
+// fileCount returns the number of changed files in the subtree
+func (n Node) fileCount() int {
+	if n.IsFile() {
+		if n.Value() > 0 {
+			return 1
+		}
+		return 0
+	}
+	count := 0
+	for child := range n.Children() {
+		count += child.fileCount()
+	}
+	return count
+}
+
+// treeBuilder lays out a new tree breadth first: every node gets all of its children at once,
+// after the nodes before it got theirs, so that the children of each node are adjacent
+type treeBuilder struct {
+	nodes    []nodeData
+	names    []byte
+	interned map[string]uint32 // Offsets of the names added so far
+}
+
+// newTreeBuilder starts a tree with a root directory of the given name
+func newTreeBuilder(rootName string) *treeBuilder {
+	b := &treeBuilder{interned: make(map[string]uint32)}
+	b.add(-1, rootName, 0, 0)
+	return b
+}
+
+// add appends a node below parent (-1 for the root) and returns its index. The children of a
+// node must be added in name order, one after the other.
+func (b *treeBuilder) add(parent int32, name string, flags uint8, value int) int32 {
+	offset, ok := b.interned[name]
+	if !ok {
+		offset = uint32(len(b.names))
+		b.names = append(b.names, name...)
+		b.interned[name] = offset
+	}
+	index := int32(len(b.nodes))
+	b.nodes = append(b.nodes, nodeData{value: value, name: offset, nameLen: uint32(len(name)), parent: parent, flags: flags})
+	if parent >= 0 {
+		p := &b.nodes[parent]
+		if p.childCount == 0 {
+			p.children = index
+		}
+		p.childCount++
+		p.flags &^= nodeFile
+	}
+	return index
+}
+
+// root returns the root of the built tree, stored without the builder's spare capacity
+func (b *treeBuilder) root() Node {
+	t := &tree{nodes: slices.Clone(b.nodes), names: string(b.names)}
+	return Node{t, 0}
+}
+
+// minValue returns the effective collapse threshold for a tree with the given root value
+func (o TreeOptions) minValue(rootValue int) int {
+	threshold := o.MinValue
+	if o.MinPercent > 0 {
+		if byPercent := int(math.Ceil(o.MinPercent * float64(rootValue) / 100)); byPercent > threshold {
+			threshold = byPercent
 		}
+	}
+	return threshold
+}
 
-		// Sort children by value (descending) for consistent treemap layout
-		sort.Slice(jNode.Children, func(i, j int) bool {
-			return jNode.Children[i].Value > jNode.Children[j].Value
-		})
+// percentOf returns value as a percentage of total, rounded to two decimals
+func percentOf(value, total int) float64 {
+	if total == 0 {
+		return 0
 	}
+	return math.Round(float64(value)*10000/float64(total)) / 100
+}
 
-	return jNode
+// dataView returns the view of the tree a /data query asks for, "" for the churn tree (grouped by
+// -group-by). The views don't compose, a query asking for several of them is an error.
+func dataView(query url.Values) (string, error) {
+	requested := map[string]bool{
+		"mine":     query.Get("mine") == "true",
+		"overlay":  query.Has("overlay"),
+		"category": query.Has("category"),
+		"security": query.Get("security") == "true",
+		"trailer":  query.Has("trailer") || query.Has("trailerWeights"),
+		"metric":   query.Get("metric") != "",
+		"profile":  query.Has("profile"),
+		"remap":    query.Has("remap"),
+		"groupBy":  query.Get("groupBy") != "" && query.Get("groupBy") != "none",
+	}
+	var views []string
+	for view, ok := range requested {
+		if ok {
+			views = append(views, view)
+		}
+	}
+	if len(views) > 1 {
+		slices.Sort(views)
+		last := len(views) - 1
+		return "", fmt.Errorf("the %s and %s views of /data can't be combined, request one of them", strings.Join(views[:last], ", "), views[last])
+	}
+	if len(views) == 0 {
+		return "", nil
+	}
+	return views[0], nil
 }
 
+// treeOptionsFromQuery overrides the tree options with the depth, minValue, minPercent and scale query parameters
+func treeOptionsFromQuery(opts TreeOptions, query url.Values) (TreeOptions, error) {
+	if depth := query.Get("depth"); depth != "" {
+		maxDepth, err := strconv.Atoi(depth)
+		if err != nil || maxDepth < 0 {
+			return opts, fmt.Errorf("invalid depth '%s'", depth)
+		}
+		opts.MaxDepth = maxDepth
+	}
+	if value := query.Get("minValue"); value != "" {
+		minValue, err := strconv.Atoi(value)
+		if err != nil || minValue < 0 {
+			return opts, fmt.Errorf("invalid minValue '%s'", value)
+		}
+		opts.MinValue = minValue
+	}
+	if value := query.Get("minPercent"); value != "" {
+		minPercent, err := strconv.ParseFloat(value, 64)
+		if err != nil || minPercent < 0 {
+			return opts, fmt.Errorf("invalid minPercent '%s'", value)
+		}
+		opts.MinPercent = minPercent
+	}
+	if query.Has("scale") {
+		opts.Scale = query.Get("scale")
+	}
+	if _, ok := valueScales[opts.Scale]; !ok && opts.Scale != "" && opts.Scale != "linear" {
+		return opts, fmt.Errorf("invalid scale '%s' (expected linear, log, sqrt or percentile)", opts.Scale)
+	}
+	return opts, nil
+}
+
+// errPathNotFound is returned when a requested path does not exist in the analyzed tree
+var errPathNotFound = errors.New("path not found in the analyzed tree")
+
+// cachedResponse is an encoded /data response along with its entity tag
+type cachedResponse struct {
+	etag        string
+	body        []byte
+	optionsHash string // Of the response, see writeDataResponse
+}
+
+// maxCachedResponses bounds the response cache, arbitrary path/depth combinations could otherwise grow it forever
+const maxCachedResponses = 256
+
+// responseCache keeps encoded /data responses so polling clients don't force re-encoding large payloads
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedResponse
+}
+
+// get returns the encoded response for the analysis and options, building and caching it on first use.
+// The entity tag is derived from the analyzed revision plus the options, so it changes whenever either does.
+func (c *responseCache) get(a *Analysis, opts TreeOptions, path string) (*cachedResponse, error) {
+	annotationsVersion := 0
+	if annotations != nil {
+		annotationsVersion = annotations.currentVersion()
+	}
+	key := fmt.Sprintf("%s|%d|%d|%d|%g|%d|%s|%s", a.Meta.Revision, a.Meta.GeneratedAt.UnixNano(), annotationsVersion, opts.MinValue, opts.MinPercent, opts.MaxDepth, opts.Scale, path)
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok {
+		return entry, nil
+	}
+
+	var body bytes.Buffer
+	hash, err := writeDataResponse(&body, a, opts, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key))
+	entry = &cachedResponse{etag: `"` + hex.EncodeToString(sum[:8]) + `"`, body: body.Bytes(), optionsHash: hash}
+
+	c.mu.Lock()
+	if c.entries == nil || len(c.entries) >= maxCachedResponses {
+		c.entries = make(map[string]*cachedResponse)
+	}
+	c.entries[key] = entry
+	c.mu.Unlock()
+	return entry, nil
+}
+
+// shutdownTimeout bounds how long a shutdown waits for the requests in flight
+const shutdownTimeout = 30 * time.Second
+
 // --- Globals ---
 var (
-	repoData     *Node
 	dataOnce     sync.Once
 	repoPath     string
-	analyzeError error
+	repoPaths    []string
+	normalize    string
+	treeOptions  TreeOptions
+	dataCache    responseCache
+	heatMode     string
+	blameOptions BlameOptions
+	annotations  *annotationStore
+	views        *viewStore
+	gitTimeout   time.Duration // Bounds every single git invocation, 0 disables the limit
+	basePath     string        // URL path prefix the server is mounted at, "" or e.g. "/dirheat"
+	analysisRev  = "HEAD"      // Commit whose history is analyzed (-rev)
+	detectCopies bool          // Detect copied files (-copies)
 )
 
-// analyzeRepo performs the git log analysis using --numstat
-func analyzeRepo(path string) (*Node, error) {
-	gitDir := filepath.Join(path, ".git")
-	if _, err := os.Stat(gitDir); os.IsNotExist(err) {
-		return nil, fmt.Errorf("path '%s' does not appear to be a git repository (.git directory not found)", path)
+// gitRun runs a git command in the repository and returns its standard output. The command is
+// killed when ctx is done or it runs longer than gitTimeout.
+func gitRun(ctx context.Context, path string, args ...string) ([]byte, error) {
+	return gitRunEnv(ctx, path, nil, args...)
+}
+
+// gitRunEnv is gitRun with additional environment variables, e.g. configuration git must not store
+func gitRunEnv(ctx context.Context, path string, env []string, args ...string) ([]byte, error) {
+	defer startPhase(ctx, "git "+args[0])()
+	if gitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, gitTimeout)
+		defer cancel()
+	}
+	cmd := gitCommand(ctx, path, args...)
+	cmd.Env = append(cmd.Env, env...)
+	output, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, &GitNotFoundError{Err: err}
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil, fmt.Errorf("git %s aborted: %w", args[0], ctx.Err())
+	}
+	return output, err
+}
+
+// gitConfig overrides user settings that would change the output git-dirheat parses: quoted
+// non-ASCII paths, colors and signature verification output interleaved with the log. A
+// commit-graph is always read when the repository has one.
+var gitConfig = []string{"-c", "core.quotePath=false", "-c", "color.ui=false", "-c", "log.showSignature=false", "-c", "core.commitGraph=true"}
+
+// gitCommand returns the git command running args in the repository with a clean environment:
+// untranslated messages, no pager and no credential prompts
+func gitCommand(ctx context.Context, path string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, gitExecutable(), slices.Concat(gitConfig, []string{"-C", path}, args)...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C", "LANGUAGE=", "GIT_PAGER=cat", "PAGER=cat", "GIT_TERMINAL_PROMPT=0")
+	return cmd
+}
+
+// unquotePath decodes a path git quoted in C style because of special characters, e.g. tabs,
+// quotes or, with core.quotePath, non-ASCII bytes ("\303\244.txt")
+func unquotePath(path string) string {
+	if len(path) >= 2 && path[0] == '"' && path[len(path)-1] == '"' {
+		if unquoted, err := strconv.Unquote(path); err == nil {
+			return unquoted
+		}
+	}
+	return path
+}
+
+// gitExecutable locates git once: on the PATH, or on Windows also in the default Git for Windows
+// install locations, which aren't always on the PATH of non-Git-Bash shells
+var gitExecutable = sync.OnceValue(func() string {
+	if path, err := exec.LookPath("git"); err == nil {
+		return path
+	}
+	if runtime.GOOS == "windows" {
+		var candidates []string
+		for _, dir := range []string{os.Getenv("ProgramFiles"), os.Getenv("ProgramW6432")} {
+			if dir != "" {
+				candidates = append(candidates, filepath.Join(dir, "Git", "cmd", "git.exe"))
+			}
+		}
+		if dir := os.Getenv("LocalAppData"); dir != "" {
+			candidates = append(candidates, filepath.Join(dir, "Programs", "Git", "cmd", "git.exe"))
+		}
+		for _, candidate := range candidates {
+			if _, err := os.Stat(candidate); err == nil {
+				return candidate
+			}
+		}
+	}
+	return "git" // Running it reports the missing executable
+})
+
+// repoName returns the directory name of the repository at path, e.g. "repo" for C:\src\repo\
+func repoName(path string) string {
+	name := filepath.Base(filepath.Clean(path))
+	if name == "." || name == "/" || name == string(filepath.Separator) || strings.HasSuffix(name, ":") {
+		return "repository_root"
 	}
-	fmt.Printf("Analyzing Git repository (using numstat) at: %s", path)
+	return name
+}
 
-	// Use --numstat to get lines added/deleted per file per commit
-	cmd := exec.Command("git", "-C", path, "log", "--numstat", "--pretty=format:", "--no-merges")
-	output, err := cmd.CombinedOutput()
+// absRepoPath returns the absolute repository path, with an upper-case drive letter on Windows so
+// differently typed paths of the same repository compare equal
+func absRepoPath(path string) string {
+	if absPath, err := filepath.Abs(path); err == nil {
+		path = absPath
+	}
+	if volume := filepath.VolumeName(path); len(volume) == 2 && volume[1] == ':' {
+		path = strings.ToUpper(volume) + path[2:]
+	}
+	return path
+}
+
+// gitOutput runs a git command in the repository and returns its trimmed standard output
+func gitOutput(ctx context.Context, path string, args ...string) (string, error) {
+	output, err := gitRun(ctx, path, args...)
 	if err != nil {
-		// ... (Fetch/retry logic remains the same as before) ...
-		log.Printf("Initial 'git log --numstat' failed. Error: %v", err)
-		log.Printf("Git log output (if any):%s", string(output))
-		log.Printf("Attempting git fetch --unshallow...")
-		fetchCmd := exec.Command("git", "-C", path, "fetch", "--unshallow")
-		fetchOutput, fetchErr := fetchCmd.CombinedOutput()
-		if fetchErr != nil {
-			fmt.Printf("Git fetch --unshallow failed: %v Fetch Output: %s", fetchErr, string(fetchOutput))
-			fmt.Println("Attempting simple 'git fetch'...")
-			fetchCmdSimple := exec.Command("git", "-C", path, "fetch")
-			fetchOutputSimple, fetchErrSimple := fetchCmdSimple.CombinedOutput()
-			if fetchErrSimple != nil {
-				fmt.Printf("Simple 'git fetch' also failed: %v Fetch Output: %s", fetchErrSimple, string(fetchOutputSimple))
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// gitStderr returns the standard error git wrote before failing, if any
+func gitStderr(err error) string {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return strings.TrimSpace(string(exitErr.Stderr))
+	}
+	return ""
+}
+
+// commitFormat is the git log format of the per-commit header line, fields are separated by \x1f
+// and the unfolded trailers of the message by \x1e
+const commitFormat = "%H%x1f%an%x1f%ae%x1f%at%x1f%s%x1f%(trailers:only,unfold,separator=%x1e)"
+
+// parseCommitHeader parses a header line produced by commitFormat (without the commit marker)
+func parseCommitHeader(header string) *Commit {
+	fields := strings.Split(header, "\x1f")
+	for len(fields) < 6 {
+		fields = append(fields, "")
+	}
+	// The trailers come last, a subject containing the separator keeps its parts
+	commit := &Commit{Hash: fields[0], Author: fields[1], Email: fields[2], Subject: strings.Join(fields[4:len(fields)-1], "\x1f")}
+	commit.Trailers = parseTrailers(fields[len(fields)-1])
+	commit.Weight = sourceWeight(commit)
+	if seconds, err := strconv.ParseInt(fields[3], 10, 64); err == nil {
+		commit.Date = time.Unix(seconds, 0).UTC()
+	}
+	return commit
+}
+
+// renameDestination resolves git's rename notation ("old => new" or "dir/{old => new}/file")
+// to the destination path, returning other paths unchanged
+func renameDestination(path string) string {
+	if left := strings.Index(path, "{"); left >= 0 {
+		if right := strings.Index(path[left:], "}"); right >= 0 {
+			if _, to, ok := strings.Cut(path[left+1:left+right], " => "); ok {
+				// An empty side ("dir/{ => sub}/file") leaves a double slash behind
+				return strings.ReplaceAll(path[:left]+to+path[left+right+1:], "//", "/")
 			}
 		}
-		fmt.Println("Retrying git log --numstat...")
-		cmd = exec.Command("git", "-C", path, "log", "--numstat", "--pretty=format:", "--no-merges")
-		output, err = cmd.CombinedOutput()
-		if err != nil {
-			log.Printf("Retried 'git log --numstat' failed. Error: %v", err)
-			log.Printf("Git log output (after retry):%s", string(output))
-			return nil, fmt.Errorf("error running git log --numstat even after fetch attempts: %v", err)
+	}
+	if _, to, ok := strings.Cut(path, " => "); ok {
+		return to
+	}
+	return path
+}
+
+// treePath sanitizes each path segment by removing leading/trailing curly braces and whitespace,
+// yielding the path under which the file appears in the tree
+func treePath(path string) string {
+	pathParts := strings.Split(path, "/")
+	for i, part := range pathParts {
+		pathParts[i] = strings.Trim(part, " {}")
+	}
+	return strings.Join(pathParts, "/")
+}
+
+// buildTree builds the node tree for the repository at path from per-file values and aggregates
+// the values upwards into the directories
+func buildTree(ctx context.Context, path string, fileValues map[string]int) Node {
+	done := startPhase(ctx, "tree build")
+	rootDir := populateTree(path, fileValues)
+	done()
+
+	// --- Aggregate Counts Upwards ---
+	slog.Debug("Aggregating directory counts")
+	done = startPhase(ctx, "aggregation")
+	rootDir.aggregateCounts()
+	done()
+	slog.Debug("Aggregation complete", "root", rootDir.Name(), "value", rootDir.Value())
+	return rootDir
+}
+
+// populateTree creates the node structure for the per-file values, without aggregating directories.
+// The paths are sorted first, so the paths below every directory are adjacent and its children
+// come out in name order, level by level as the tree's table needs them.
+func populateTree(path string, fileValues map[string]int) Node {
+	type entry struct {
+		parts []string
+		value int
+	}
+	entries := make([]entry, 0, len(fileValues))
+	for filePath, count := range fileValues {
+		if count == 0 {
+			continue
+		} // Skip files with zero count if using line changes
+		parts := strings.FieldsFunc(treePath(filePath), func(r rune) bool { return r == '/' })
+		if len(parts) > 0 {
+			entries = append(entries, entry{parts, count})
 		}
-		log.Println("Git log --numstat succeeded after fetch attempt.")
 	}
+	sort.Slice(entries, func(i, j int) bool {
+		return slices.Compare(entries[i].parts, entries[j].parts) < 0
+	})
 
-	// --- Data Processing ---
-	fileChangeCounts := make(map[string]int)
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	// The entries below each node added so far, the paths of the root's children being at depth 0
+	type span struct{ start, end, depth int }
+	spans := []span{{0, len(entries), 0}}
+	b := newTreeBuilder(repoName(path)) // Root is a directory
+	for parent := int32(0); int(parent) < len(spans); parent++ {
+		below := spans[parent]
+		for i := below.start; i < below.end; {
+			// A child with the entries sharing its name: its own, then those of its descendants
+			name, value, flags := entries[i].parts[below.depth], 0, uint8(0)
+			start := i
+			for ; i < below.end && entries[i].parts[below.depth] == name; i++ {
+				if len(entries[i].parts) == below.depth+1 {
+					value, flags = entries[i].value, nodeFile // Set the file's final aggregated count
+					start = i + 1
+				}
+			}
+			b.add(parent, name, flags, value)
+			spans = append(spans, span{start, i, below.depth + 1})
+		}
+	}
+	return b.root()
+}
+
+// maxLineSize is the longest line of git output the parsers accept, see -max-line-size
+var maxLineSize = 16 * 1024 * 1024
+
+// newLineScanner scans the lines of git output, accepting lines up to maxLineSize bytes
+func newLineScanner(output []byte) *bufio.Scanner {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 0, min(64*1024, maxLineSize)), maxLineSize)
+	return scanner
+}
+
+// parseNumstatLog parses git log --numstat output with commitFormat headers into per-file change
+// counts and the commits, also returning the number of numstat lines processed. Commits marked
+// with a Skip-Dirheat trailer are left out and those with Dirheat-Weight have their changes
+// scaled. Parsing stops with the context's error once ctx is done.
+func parseNumstatLog(ctx context.Context, output []byte) (map[string]int, []*Commit, int, error) {
+	counts, list := newChangeCounts(), &commitList{}
+	processedLines, err := streamNumstatLog(ctx, output, counts, list)
+	if err != nil {
+		return nil, nil, processedLines, err
+	}
+	return counts.result(), list.commits, processedLines, nil
+}
+
+// streamNumstatLog parses git log --numstat output with commitFormat headers into a stream of
+// commits and file changes sent to the collectors, returning the number of numstat lines
+// processed. Commits marked with a Skip-Dirheat trailer are left out.
+func streamNumstatLog(ctx context.Context, output []byte, collectors ...collector) (int, error) {
+	paths := make(map[string]string) // Interned tree paths, files are changed by many commits
+	scanner := newLineScanner(output)
 	processedLines := 0
+	lineNumber := 0
+	var current *Commit
+	weight, skipped, weightedCommits := 1.0, 0, 0 // Weight of the current commit
 
 	for scanner.Scan() {
 		line := scanner.Text()
+		lineNumber++
 		if line == "" {
 			continue // Skip empty lines between commits
 		}
+		if strings.HasPrefix(line, commitMarker) {
+			current = parseCommitHeader(strings.TrimPrefix(line, commitMarker))
+			if weight = current.Weight; weight == 0 {
+				skipped++
+				continue
+			} else if weight != 1 {
+				weightedCommits++
+			}
+			for _, col := range collectors {
+				col.commit(current)
+			}
+			continue
+		}
 		processedLines++
+		if processedLines%10000 == 0 && ctx.Err() != nil {
+			return processedLines, ctx.Err()
+		}
+		if weight == 0 {
+			continue // Changes of a skipped commit
+		}
 
-		parts := strings.Fields(line)
-		var filePath string
-		var addedStr, deletedStr string
-		if len(parts) < 3 {
-			// Handle potential rename lines like: 1       0       src/{foo.go => bar.go} or {old/path/foo.go => new/path/bar.go}
-			if strings.Contains(line, "=>") {
-				// Extract the destination path robustly
-				leftCurly := strings.Index(line, "{")
-				rightCurly := strings.Index(line, "}")
-				arrow := strings.Index(line, "=>")
-				if leftCurly >= 0 && rightCurly > leftCurly && arrow > leftCurly && arrow < rightCurly {
-					// e.g. src/{foo.go => bar.go}
-					prefix := line[:leftCurly]
-					inside := line[leftCurly+1 : rightCurly]
-					insideParts := strings.Split(inside, "=>")
-					if len(insideParts) == 2 {
-						// Use the right side (destination)
-						filePath = strings.TrimSpace(prefix + insideParts[1])
-					}
-				} else if leftCurly == 0 && arrow > 0 {
-					// e.g. {old/path/foo.go => new/path/bar.go}
-					inside := line[1:]
-					arrow = strings.Index(inside, "=>")
-					if arrow > 0 {
-						right := inside[arrow+2:]
-						right = strings.TrimPrefix(right, " ")
-						right = strings.TrimSuffix(right, "}")
-						filePath = strings.TrimSpace(right)
-					}
-				}
-				// If still not found, skip
-				if filePath == "" {
-					log.Printf("WARN: Could not robustly parse rename line: %s", line)
-					continue
+		// With -copies, --raw lines ":<modes> <objects> C<score><TAB>from<TAB>to" report copies
+		if strings.HasPrefix(line, ":") {
+			fields := strings.Split(line, "\t")
+			if len(fields) == 3 && strings.Contains(fields[0], " C") && current != nil {
+				fileCopy := FileCopy{From: treePath(unquotePath(fields[1])), To: treePath(unquotePath(fields[2]))}
+				for _, col := range collectors {
+					col.copied(current, fileCopy)
 				}
-				if len(parts) >= 2 {
-					addedStr, deletedStr = parts[0], parts[1]
-				} else {
-					log.Printf("WARN: Could not parse numeric fields in rename line: %s", line)
-					continue
-				}
-			} else {
-				log.Printf("WARN: Skipping malformed numstat line (expected 3+ fields): %s", line)
-				continue
 			}
-		} else {
-			// Normal line
-			addedStr = parts[0]
-			deletedStr = parts[1]
-			filePath = parts[2]
+			continue
 		}
 
-		var changeAmount int
-		if addedStr == "-" || deletedStr == "-" {
-			changeAmount = 1
-		} else {
-			changeAmount = 1
+		// numstat lines are "added<TAB>deleted<TAB>path"; paths may contain spaces. Partial clones
+		// are logged with --name-only, listing just the path.
+		parts := strings.SplitN(line, "\t", 3)
+		if len(parts) == 1 {
+			parts = []string{"-", "-", line}
+		}
+		if len(parts) < 3 {
+			slog.Warn("Skipping malformed numstat line (expected 3 fields)", "line", line)
+			continue
 		}
+		addedStr, deletedStr := parts[0], parts[1]
+		// Renames look like: src/{foo.go => bar.go} or old/path/foo.go => new/path/bar.go
+		filePath := renameDestination(unquotePath(parts[2]))
 
 		normalizedPath := filepath.ToSlash(strings.TrimSpace(filePath))
 		normalizedPath = strings.TrimLeft(normalizedPath, "{ ")
 		if normalizedPath != "" {
-			fileChangeCounts[normalizedPath] += changeAmount
-			// log.Printf("DEBUG: File: %s, Change: %d, Total: %d", normalizedPath, changeAmount, fileChangeCounts[normalizedPath]) // Verbose
+			// Binary files report "-" for both counts, which parses as zero lines
+			added, _ := strconv.Atoi(addedStr)
+			deleted, _ := strconv.Atoi(deletedStr)
+			path, ok := paths[normalizedPath]
+			if !ok {
+				path = treePath(normalizedPath)
+				paths[strings.Clone(normalizedPath)] = path
+			}
+			for _, col := range collectors {
+				col.change(current, FileChange{Path: path, Added: added, Deleted: deleted})
+			}
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		log.Printf("WARN: Error reading git log output: %v", err)
-		// Continue processing with data gathered so far
+		// The scanner stopped at the line following the last one read
+		return processedLines, &ParseError{Source: "git log", Line: lineNumber + 1, Err: err}
+	}
+	if skipped > 0 || weightedCommits > 0 {
+		slog.Info("Applied commit weight trailers", "skipped", skipped, "weighted", weightedCommits)
 	}
-	fmt.Printf("Processed %d numstat lines, found %d unique files changed.", processedLines, len(fileChangeCounts))
+	return processedLines, nil
+}
 
-	// --- Build Tree Structure ---
-	rootDirName := filepath.Base(path)
-	if rootDirName == "." || rootDirName == "/" {
-		rootDirName = "repository_root"
+// hasCommits reports whether HEAD of the repository points to a commit, which it doesn't in a
+// freshly initialized repository
+func hasCommits(ctx context.Context, path string) (bool, error) {
+	_, err := gitRun(ctx, path, "rev-parse", "--verify", "--quiet", "HEAD")
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false, nil
 	}
-	rootDir := NewNode(rootDirName, "/", false) // Root is a directory
+	return err == nil, err
+}
 
-	for filePath, count := range fileChangeCounts {
-		if count == 0 {
-			continue
-		} // Skip files with zero count if using line changes
-		pathParts := strings.Split(filePath, "/")
-		// Sanitize each path segment to remove leading/trailing curly braces and whitespace
-		for i, part := range pathParts {
-			pathParts[i] = strings.Trim(part, " {}")
+// analyzeRepo performs the git log analysis using --numstat
+func analyzeRepo(ctx context.Context, path string) (*Analysis, error) {
+	gitDir := filepath.Join(path, ".git")
+	if _, err := os.Stat(gitDir); os.IsNotExist(err) {
+		return nil, &NotARepoError{Path: path}
+	}
+	slog.Info("Analyzing Git repository", "repo", path)
+	if ok, err := hasCommits(ctx, path); err != nil {
+		return nil, err
+	} else if !ok && analysisRev == "HEAD" {
+		return nil, &EmptyHistoryError{Repo: path}
+	}
+	start, err := resolveCommit(ctx, path, analysisRev)
+	if err != nil {
+		return nil, err
+	}
+
+	// Shallow clones are analyzed as far as they go, deepened only on request and disclosed in the metadata
+	shallow := isShallow(ctx, path)
+	deepened := 0
+	if shallow && deepenBy > 0 {
+		if err := deepen(ctx, path, deepenBy); err != nil {
+			slog.Warn("Could not deepen the shallow clone, analyzing the available history", "repo", path, "error", err)
+		} else {
+			deepened = deepenBy
 		}
-		fileNode := rootDir.ensurePath(pathParts) // Create structure down to the file
-		fileNode.Value = count                    // Set the file's final aggregated count
 	}
 
-	// --- Aggregate Counts Upwards ---
-	log.Println("Aggregating directory counts...")
-	rootDir.aggregateCounts()
-	log.Printf("Aggregation complete. Root node '%s' final value: %d", rootDir.Name, rootDir.Value)
+	prepareCommitGraph(ctx, path, shallow)
 
-	if rootDir.Value == 0 && len(fileChangeCounts) > 0 {
-		fmt.Println("Warning: Root directory value is 0 after aggregation, but files were processed. Check aggregation logic.")
-	} else if rootDir.Value == 0 {
-		fmt.Println("Warning: No file changes seem to have been recorded or aggregated.")
+	// Use --numstat to get lines added/deleted per file per commit, with a marker line per commit
+	filter := partialCloneFilter(ctx, path)
+	if filter != "" {
+		slog.Warn("Repository is a partial clone, analyzing changed file names without line counts or renames to avoid fetching file contents", "repo", path, "filter", filter)
 	}
+	logArgs := append(append([]string{"log"}, changeListArgs(filter)...), "--pretty=format:"+commitMarker+commitFormat, "--no-merges")
+	if shallow {
+		boundary, err := shallowBoundary(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		logArgs = append(append(logArgs, start, "--not"), boundary...)
+	} else {
+		logArgs = append(logArgs, start)
+	}
+	output, err := gitRun(ctx, path, logArgs...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		slog.Warn("Initial git log --numstat failed, attempting git fetch", "error", err, "stderr", gitStderr(err))
+		if _, fetchErr := gitRun(ctx, path, "fetch"); fetchErr != nil {
+			slog.Warn("Git fetch also failed", "error", fetchErr, "stderr", gitStderr(fetchErr))
+		}
+		slog.Info("Retrying git log --numstat")
+		output, err = gitRun(ctx, path, logArgs...)
+		if err != nil {
+			slog.Error("Retried git log --numstat failed", "error", err, "stderr", gitStderr(err))
+			return nil, fmt.Errorf("error running git log --numstat even after fetch attempts: %w", err)
+		}
+		slog.Info("Git log --numstat succeeded after fetch attempt")
+	}
+
+	// --- Data Processing ---
+	done := startPhase(ctx, "parse")
+	fileChangeCounts, commits, processedLines, err := parseNumstatLog(ctx, output)
+	done()
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Parsed git log", "repo", path, "numstatLines", processedLines, "files", len(fileChangeCounts), "commits", len(commits))
+
+	fileChangeCounts = checkCaseCollisions(path, fileChangeCounts, commits)
+	rootDir := buildTree(ctx, path, fileChangeCounts)
+	if err := markSymlinks(ctx, path, start, rootDir); err != nil {
+		return nil, err
+	}
+
+	if rootDir.Value() == 0 && len(fileChangeCounts) > 0 {
+		slog.Warn("Root directory value is 0 after aggregation, but files were processed")
+	} else if rootDir.Value() == 0 {
+		slog.Warn("No file changes seem to have been recorded or aggregated")
+	}
+
+	meta := repoMetadata(ctx, path, len(commits))
+	meta.PartialClone = filter
+	if caseFold {
+		meta.Filters["caseFold"] = "on"
+	}
+	if detectCopies && filter == "" {
+		meta.Filters["copies"] = "on"
+	}
+	if shallow {
+		meta.Shallow = shallowInfo(commits, deepened)
+		slog.Warn("Repository is a shallow clone, the analysis covers only the history it has; use -deepen N to fetch more",
+			"repo", path, "commits", meta.Shallow.Commits, "since", meta.Shallow.Since.Format(time.DateOnly))
+	}
+	done = startPhase(ctx, "snapshots")
+	snapshots := buildSnapshots(path, commits)
+	done()
+	return &Analysis{Root: rootDir, Meta: meta, Commits: commits, Snapshots: snapshots}, nil
+}
+
+// repoMetadata describes the analysis of the repository at path
+func repoMetadata(ctx context.Context, path string, commitCount int) Metadata {
+	meta := Metadata{
+		ToolVersion:   version,
+		RepoPath:      path,
+		RevisionRange: analysisRev,
+		CommitCount:   commitCount,
+		Filters:       map[string]string{"merges": "excluded"},
+		GeneratedAt:   time.Now().UTC(),
+	}
+	meta.RepoPath = absRepoPath(path)
+	// Detached HEADs (CI checkouts) and tags or commits given with -rev have no branch
+	if ref, err := gitOutput(ctx, path, "rev-parse", "--symbolic-full-name", analysisRev); err == nil {
+		meta.Branch, _ = strings.CutPrefix(ref, "refs/heads/")
+		if !strings.HasPrefix(ref, "refs/heads/") {
+			meta.Branch = ""
+		}
+	} else if branch, err := gitOutput(ctx, path, "symbolic-ref", "--short", "HEAD"); err == nil && analysisRev == "HEAD" {
+		meta.Branch = branch // Unborn branch of a repository without commits
+	}
+	if revision, err := gitOutput(ctx, path, "rev-parse", "--verify", "--quiet", analysisRev+"^{commit}"); err == nil {
+		meta.Revision = revision
+	}
+	return meta
+}
+
+// emptyAnalysis is the analysis of a repository without commits: an empty tree, so the server can
+// still explain the situation instead of failing
+func emptyAnalysis(ctx context.Context, path string) *Analysis {
+	return &Analysis{Root: buildTree(ctx, path, nil), Meta: repoMetadata(ctx, path, 0)}
+}
+
+// parseAge parses a duration like time.ParseDuration, additionally accepting days ("90d") and weeks ("12w")
+func parseAge(value string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if number, ok := strings.CutSuffix(value, suffix); ok {
+			n, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration '%s'", value)
+			}
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(value)
+}
+
+// analyze analyzes the repository in the configured heat mode. Several repositories are combined
+// into a portfolio with the repository names as first path segment.
+func analyze(ctx context.Context, paths []string) (*Analysis, error) {
+	analyses := make([]*Analysis, 0, len(paths))
+	for _, path := range paths {
+		analysis, err := analyzeRepo(ctx, path)
+		var emptyHistory *EmptyHistoryError
+		if errors.As(err, &emptyHistory) {
+			slog.Warn("Repository has no commits yet, serving an empty tree", "repo", path)
+			analysis, err = emptyAnalysis(ctx, path), nil
+		} else if err == nil && heatMode == "blame" {
+			err = applyBlameHeat(ctx, analysis, blameOptions)
+		} else if err == nil && prOptions.From > 0 {
+			err = restrictToPRs(ctx, analysis, prOptions)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if writeNotes {
+			if err := writeSummaryNote(ctx, analysis); err != nil {
+				slog.Warn("Could not store the analysis summary", "repo", path, "error", err)
+			}
+		}
+		analyses = append(analyses, analysis)
+	}
+	result := analyses[0]
+	if len(analyses) > 1 {
+		combined, err := combinePortfolio(analyses, normalize)
+		if err != nil {
+			return nil, err
+		}
+		result = combined
+	}
+	result.Meta.OptionsHash = optionsHash(result.Meta)
+	result.profiles = profileAnalyses(result, weightProfiles)
+	return result, checkOptionsHash(result)
+}
+
+// currentAnalysis returns the analysis to serve, or writes an error response and returns false
+// when the analysis failed or isn't available.
+func currentAnalysis(w http.ResponseWriter) (*Analysis, bool) {
+	state := loadState()
+	if state.pending() {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "The repository analysis is still running, retry shortly.", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	if state.err != nil {
+		http.Error(w, fmt.Sprintf("Error analyzing repository: %v", state.err), http.StatusInternalServerError)
+		return nil, false
+	}
+	w.Header().Set("X-Dirheat-Options-Hash", state.analysis.Meta.OptionsHash)
+	return state.analysis, true
+}
+
+// writeJSON encodes v as the JSON response body
+func writeJSON(w http.ResponseWriter, v any) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+// writeJSONStatus encodes v as the JSON response body with the given status code
+func writeJSONStatus(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error encoding JSON data", "error", err)
+	}
+}
 
-	return rootDir, nil
+// subcommands maps command names to their entry points, which return the process exit code
+var subcommands = map[string]func(args []string) int{
+	"compare-repos": runCompareRepos,
+	"completion":    runCompletion,
+	"digest":        runDigest,
+	"export":        runExport,
+	"hook":          runHook,
+	"push-metrics":  runPushMetrics,
+	"query":         runQuery,
+	"refactoring":   runRefactoring,
+	"testgen":       runTestgen,
+}
+
+// commandSummaries describe the subcommands in the help and the completion scripts
+var commandSummaries = map[string]string{
+	"compare-repos": "compare the churn of a fork with its upstream",
+	"completion":    "print the bash, zsh or fish completion script",
+	"digest":        "print or email a digest of the recent changes",
+	"export":        "write the parsed history as change events",
+	"hook":          "warn about hotspots a commit or push touches",
+	"push-metrics":  "push churn and hotspot metrics to a pushgateway",
+	"query":         "query the daemon started with -socket",
+	"refactoring":   "print the directories ranked for refactoring",
+	"testgen":       "generate a repository with a synthetic history",
 }
 
 // main function
 func main() {
-	if len(os.Args) < 2 {
+	if len(os.Args) > 1 {
+		if command, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s [options] <path_to_local_git_repo> [more_repos...]\n", os.Args[0])
+		fmt.Fprintf(out, "       %s <command> [options] [arguments]\n\n", os.Args[0])
+		fmt.Fprintln(out, "Serves a heat-map of the directories and files of git repositories that change the most.")
+		fmt.Fprintln(out, "\nCommands (see <command> -h):")
+		names := make([]string, 0, len(subcommands))
+		for name := range subcommands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(out, "  %-14s %s\n", name, commandSummaries[name])
+		}
+		fmt.Fprintln(out, "\nExamples:")
+		fmt.Fprintln(out, "  git-dirheat .                                     # serve the current repository on :8080")
+		fmt.Fprintln(out, "  git-dirheat -min-percent 0.5 -rev v2.0 ~/src/app  # declutter, analyze up to a tag")
+		fmt.Fprintln(out, "  git-dirheat -mode blame -blame-window 90d .       # surviving lines of the last 90 days")
+		fmt.Fprintln(out, "  git-dirheat -refresh 1h ~/src/service-a ~/src/service-b")
+		fmt.Fprintln(out, "  git-dirheat -clone https://github.com/org/repo.git -port 9000")
+		fmt.Fprintln(out, "  source <(git-dirheat completion bash)")
+		fmt.Fprintln(out, "\nOptions (also DIRHEAT_<OPTION> environment variables and .dirheat.yaml, see -print-config):")
+		flag.PrintDefaults()
+	}
+	flag.IntVar(&treeOptions.MinValue, "min-value", 0, "collapse siblings with fewer changes than this into an \"other\" node")
+	flag.StringVar(&treeOptions.Scale, "scale", "", "transform the values served by /data so one huge file doesn't flatten the treemap: log, sqrt or percentile (raw values move to rawValue)")
+	flag.Float64Var(&treeOptions.MinPercent, "min-percent", 0, "collapse siblings below this percentage of the total into an \"other\" node")
+	flag.IntVar(&treeOptions.MaxDepth, "max-depth", 0, "levels of the tree served by /data, deeper levels are fetched on demand (0 = unlimited)")
+	flag.StringVar(&expectedHash, "expect-hash", "", "fail (exit code 5) unless the analysis has this options hash (metadata optionsHash), so pipelines only compare like-for-like analyses")
+	flag.StringVar(&analysisRev, "rev", "HEAD", "analyze the history reachable from this commit, tag or branch instead of the checked-out HEAD")
+	flag.Func("test-patterns", "comma separated patterns of test files: /dir/ matches a directory, patterns with wildcards the file name, others the end of the path (default \""+defaultTestPatterns+"\")", func(value string) error {
+		fileCategories[0].patterns = strings.Split(value, ",")
+		return nil
+	})
+	flag.Func("prs", "only analyze the commits of this range of merged pull (merge) requests, e.g. 1200..1300, read from the GitHub or GitLab API of the origin remote", func(value string) error {
+		var err error
+		prOptions.From, prOptions.To, err = parsePRRange(value)
+		return err
+	})
+	flag.StringVar(&prOptions.API, "prs-api", "", "with -prs: API base URL, e.g. https://github.example.com/api/v3 (default: derived from the origin remote)")
+	flag.Func("security-paths", "comma separated security-critical path patterns for /security: dir/ matches a directory at any depth, patterns with wildcards the file name, others the end of the path (default \""+defaultSecurityPaths+"\")", func(value string) error {
+		securityPatterns = strings.Split(value, ",")
+		return nil
+	})
+	flag.BoolVar(&writeNotes, "notes", false, "store a summary of every analysis (totals, hottest directories and files) as git note in "+notesRef+", listed on /notes")
+	flag.StringVar(&groupBy, "group-by", "", "group the tree served by /data: category (test, ci, dependencies, infrastructure, docs, config and source files), module (see -module-map) or none")
+	moduleMapFile := flag.String("module-map", "", "YAML file mapping modules to the path prefixes they consist of, e.g. payments: [services/billing, libs/invoice], for /data?groupBy=module")
+	flag.BoolVar(&writeCommitGraph, "commit-graph", false, "write or update the repository's commit-graph (git commit-graph write) before every analysis, speeding up the history walk of large repositories")
+	flag.BoolVar(&detectCopies, "copies", false, "detect copied files (git log -C): copying a file counts as a change of the original, and /file lists the copies")
+	flag.BoolVar(&caseFold, "case-fold", false, "merge paths that differ only in case (like case-insensitive file systems see them) under their newest spelling")
+	flag.IntVar(&deepenBy, "deepen", 0, "fetch this many more commits into shallow clones before analyzing them (git fetch --deepen); shallow clones are otherwise analyzed as far as they go")
+	flag.StringVar(&heatMode, "mode", "churn", "heat mode: churn (historical changes) or blame (surviving lines at HEAD)")
+	flag.Func("blame-window", "blame mode: only count surviving lines written within this age, e.g. 90d (default: all lines)", func(value string) error {
+		window, err := parseAge(value)
+		blameOptions.Window = window
+		return err
+	})
+	flag.StringVar(&blameOptions.Author, "blame-author", "", "blame mode: only count lines by authors whose name or email contains this")
+	flag.IntVar(&blameOptions.Workers, "blame-workers", runtime.NumCPU(), "blame mode: number of concurrent git blame processes")
+	flag.StringVar(&blameOptions.CacheDir, "blame-cache-dir", filepath.Join(defaultCacheDir(), "blame"), "blame mode: directory caching blame results between runs (empty disables)")
+	flag.StringVar(&normalize, "normalize", "none", "with several repositories: none, share (each repository totals 10000) or commits (per 1000 commits)")
+	annotationsFile := flag.String("annotations-file", "", "JSON file storing path annotations (default: .git/dirheat-annotations.json in the repository)")
+	viewsFile := flag.String("views-file", "", "JSON file storing saved views (default: .git/dirheat-views.json in the repository)")
+	flag.StringVar(&basePath, "base-path", "", "serve everything below this URL path, e.g. /dirheat when mounted at a sub path by a reverse proxy")
+	profile := flag.Bool("profile", false, "log the time spent per analysis phase (git, parse, tree build, aggregation, encode)")
+	profileDir := flag.String("profile-dir", "", "with -profile: also write CPU and heap pprof profiles of the analysis to this directory")
+	analysisTimeout := flag.Duration("analysis-timeout", 0, "abort the initial analysis (and each refresh) after this long, e.g. 10m (0 = no limit)")
+	flag.DurationVar(&refreshInterval, "refresh", 0, "re-analyze the repositories this often, e.g. 1h, serving the previous analysis meanwhile (0 = analyze once)")
+	flag.IntVar(&maxLineSize, "max-line-size", maxLineSize, "longest line of git output (in bytes) the parsers accept, e.g. for huge generated paths")
+	logLevel := flag.String("log-level", "info", "minimum level of log messages: debug, info, warn or error")
+	quiet := flag.Bool("q", false, "quiet: only log errors (-log-level error)")
+	verbose := flag.Bool("v", false, "verbose: log debug messages too (-log-level debug)")
+	flag.StringVar(&progressFormat, "progress", "", "write machine-readable progress events of the analyses to standard error: json")
+	logFormat := flag.String("log-format", "text", "log output format: text or json")
+	flag.IntVar(&limiter.perMinute, "rate-limit", 0, "requests per minute and client (IP address or bearer token) to the endpoints running git, e.g. /compare (0 = unlimited)")
+	flag.IntVar(&maxConcurrent, "max-concurrent", 4, "endpoints running git serve at most this many requests at once, others get 503 (0 = unlimited)")
+	flag.IntVar(&jobWorkers, "job-workers", jobWorkers, "ad-hoc analyses (POST /analyze) running at once")
+	flag.IntVar(&jobQueueSize, "job-queue", jobQueueSize, "ad-hoc analyses waiting for a worker, further ones get 503")
+	flag.IntVar(&retention.MaxJobs, "retention-jobs", retention.MaxJobs, "finished ad-hoc analyses kept, the oldest are removed first (0 = unlimited)")
+	flag.Func("retention-ttl", "remove finished ad-hoc analyses and blame cache files unused for this long, e.g. 30d (0 = keep them; default 7d)", func(value string) error {
+		ttl, err := parseAge(value)
+		retention.TTL = ttl
+		return err
+	})
+	flag.Func("retention-disk", "bound the blame cache to this size, removing the least recently used files, e.g. 500MB (default: unlimited)", func(value string) error {
+		size, err := parseSize(value)
+		retention.MaxDisk = size
+		return err
+	})
+	accessLog := flag.String("access-log", "", "log every HTTP request to this file, - for standard output (default: no access log)")
+	accessLogFormat := flag.String("access-log-format", "combined", "access log format: common, combined or json")
+	var push PushOptions
+	flag.StringVar(&push.Gateway, "pushgateway", "", "push per-directory churn and hotspot metrics of each analysis to this Prometheus pushgateway URL")
+	flag.StringVar(&push.Job, "pushgateway-job", "git-dirheat", "job label of the pushed metrics")
+	flag.DurationVar(&push.Window, "pushgateway-window", 7*24*time.Hour, "window of the recent churn metrics")
+	flag.StringVar(&jiraOptions.URL, "jira-url", "", "Jira base URL resolving the ticket keys in commit messages for the defect overlay, e.g. https://acme.atlassian.net")
+	flag.StringVar(&jiraOptions.User, "jira-user", "", "Jira Cloud account email; the token is read from DIRHEAT_JIRA_TOKEN (without a user it is sent as bearer token)")
+	flag.Func("jira-project", "comma separated Jira project keys whose tickets are looked for in commit messages", func(value string) error {
+		jiraOptions.Projects = append(jiraOptions.Projects, strings.Split(value, ",")...)
+		return nil
+	})
+	jiraBugTypes := flag.String("jira-bug-types", "Bug", "comma separated Jira issue types counting as defects")
+	issuesFile := flag.String("issues-file", "", "SonarQube or Code Climate JSON export whose open issues per file are joined with the churn (quality overlay)")
+	alertRulesFile := flag.String("alert-rules", "", "JSON list of alert rules evaluated after each analysis, e.g. [{\"name\": \"billing spike\", \"path\": \"src/billing\", \"factor\": 2}], posted to -notify-webhook")
+	periodsFile := flag.String("periods-file", "", "JSON list of team calendar periods ({\"name\", \"start\", \"end\"} with inclusive YYYY-MM-DD dates) for /periods (default: calendar quarters)")
+	flag.BoolVar(&readOnly, "read-only", false, "reject requests changing annotations, views or ad-hoc analyses and never serve file contents, for instances exposed beyond localhost")
+	flag.BoolVar(&noFileContent, "no-file-content", false, "never serve or derive anything from file contents (e.g. -describe)")
+	flag.BoolVar(&filePreview, "file-preview", false, "serve file contents on /file/content, for previews of hot files in the UI")
+	describe := flag.Bool("describe", false, "attach the first heading of each directory's README and its CODEOWNERS owners to the tree, for the tooltips (description overlay)")
+	coverageFile := flag.String("coverage-file", "", "LCOV, Cobertura XML or Go coverprofile report whose coverage is attached to the tree nodes (coverage overlay)")
+	incidentsFile := flag.String("incidents-file", "", "JSON or CSV incidents (postmortems) mapped to paths or services, correlated with the churn (incident overlay, /incidents)")
+	var notify NotifyOptions
+	flag.StringVar(&notify.Webhook, "notify-webhook", "", "post a digest (top movers, new hotspots, bus-factor warnings) to this Slack or Teams incoming webhook after each analysis")
+	flag.StringVar(&notify.Format, "notify-format", "auto", "webhook message format: slack, teams or auto (by the webhook host)")
+	flag.Func("notify-window", "period the digest covers, e.g. 7d (default 7d)", func(value string) error {
+		window, err := parseAge(value)
+		notify.Window = window
+		return err
+	})
+	flag.StringVar(&meIdentity, "me", "", "your git email or name, for /data?mine=true without a login")
+	var oauth OAuthOptions
+	flag.StringVar(&oauth.Provider, "oauth-provider", "", "require a login with this OAuth provider: github or google (default: no authentication)")
+	flag.StringVar(&oauth.ClientID, "oauth-client-id", "", "OAuth client ID of the registered application")
+	flag.StringVar(&oauth.ClientSecret, "oauth-client-secret", "", "OAuth client secret (prefer the DIRHEAT_OAUTH_CLIENT_SECRET environment variable)")
+	flag.StringVar(&oauth.RedirectURL, "oauth-redirect-url", "", "external URL of /auth/callback registered with the provider, e.g. https://dirheat.example.com/auth/callback")
+	flag.Func("weight-profile", "named weight profile selectable with /data?profile=, computed along with every analysis, e.g. recent-lines:weight=lines,window=90d (settings: weight=changes|lines, window, message regexp, trailer filter, author; repeatable)", func(value string) error {
+		profile, err := parseProfile(value)
+		if err != nil {
+			return err
+		}
+		for _, other := range weightProfiles {
+			if other.name == profile.name {
+				return fmt.Errorf("duplicate profile '%s'", profile.name)
+			}
+		}
+		weightProfiles = append(weightProfiles, profile)
+		return nil
+	})
+	flag.Func("oauth-allow", "comma separated users allowed to sign in: emails, @domain or org:name (GitHub organization)", func(value string) error {
+		oauth.Allow = append(oauth.Allow, strings.Split(value, ",")...)
+		return nil
+	})
+	flag.DurationVar(&gitTimeout, "git-timeout", 0, "abort any single git command running longer than this, e.g. 2m (0 = no limit)")
+	port := flag.Int("port", 8080, "port the server listens on; when it is busy the next free one is taken")
+	flag.BoolVar(&strictPort, "strict-port", false, "fail when -port is busy instead of taking another port")
+	flag.StringVar(&socketPath, "socket", "", "serve on this Unix domain socket instead of the port, as a daemon holding the analysis for the query command")
+	flag.BoolVar(&openBrowser, "open", false, "open the heat-map in the default browser once the server listens")
+	flag.StringVar(&cloneURL, "clone", "", "analyze a clone of this repository URL instead of a local repository, fetched every -refresh (default 15m)")
+	flag.StringVar(&cacheDir, "cache-dir", defaultCacheDir(), "directory keeping clones of -clone and the blame cache, e.g. a container volume")
+	printConfigOnly := flag.Bool("print-config", false, "print the effective configuration with the source of each option, and exit")
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	parseArgs(flag.CommandLine, os.Args[1:])
+	args := flag.Args()
+	if repos := os.Getenv("DIRHEAT_REPO"); len(args) == 0 && repos != "" {
+		args = filepath.SplitList(repos)
+	}
+	var repo string
+	if len(args) > 0 {
+		repo = args[0]
+	}
+	configRead, err := applyConfig(flag.CommandLine, configFiles(repo))
+	if err != nil {
+		fmt.Printf("Error: %v.\n", err)
+		os.Exit(exitUsage)
+	}
+	if cloneURL != "" {
+		if len(args) > 0 {
+			fmt.Println("Error: -clone replaces the repository arguments.")
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		args = []string{cloneDir(cloneURL)}
+		if configSources["refresh"] == "" {
+			refreshInterval = defaultCloneRefresh
+		}
+	}
+	if configSources["cache-dir"] != "" && configSources["blame-cache-dir"] == "" {
+		blameOptions.CacheDir = filepath.Join(cacheDir, "blame")
+	}
+	if *printConfigOnly {
+		printConfig(os.Stdout, flag.CommandLine, args)
+		os.Exit(exitOK)
+	}
+
+	if maxLineSize < 1024 {
+		fmt.Println("Error: -max-line-size must be at least 1024 bytes.")
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if *quiet && *verbose {
+		fmt.Println("Error: -q and -v exclude each other.")
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if *quiet {
+		*logLevel = "error"
+	} else if *verbose {
+		*logLevel = "debug"
+	}
+	if progressFormat != "" && progressFormat != "json" {
+		fmt.Printf("Error: Unknown progress format '%s'.\n", progressFormat)
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	setupProgress(os.Stderr, progressFormat)
+	if err := setupLogging(os.Stderr, *logLevel, *logFormat); err != nil {
+		fmt.Printf("Error: %v.\n", err)
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if len(configRead) > 0 {
+		slog.Info("Loaded configuration", "files", strings.Join(configRead, ", "))
+	}
+
+	if limiter.perMinute < 0 || maxConcurrent < 0 {
+		fmt.Println("Error: -rate-limit and -max-concurrent must not be negative.")
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	noFileContent = noFileContent || readOnly
+	if *describe && noFileContent {
+		fmt.Println("Error: -describe reads READMEs and CODEOWNERS, which -no-file-content and -read-only forbid.")
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if heatMode == "blame" && noFileContent {
+		fmt.Printf("Error: -mode blame reads file contents: %v.\n", errNoFileContent)
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if filePreview && noFileContent {
+		fmt.Println("Error: -file-preview serves file contents, which -no-file-content and -read-only forbid.")
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if jobWorkers < 1 || jobQueueSize < 0 || retention.MaxJobs < 0 || retention.TTL < 0 {
+		fmt.Println("Error: -job-workers must be at least 1, -job-queue, -retention-jobs and -retention-ttl must not be negative.")
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if jiraOptions.URL != "" {
+		if len(jiraOptions.Projects) == 0 {
+			fmt.Println("Error: -jira-project is required with -jira-url.")
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		jiraOptions.Token = os.Getenv("DIRHEAT_JIRA_TOKEN")
+		jiraOptions.BugTypes = strings.Split(*jiraBugTypes, ",")
+	}
+	if notify.Window <= 0 {
+		notify.Window = defaultDigestWindow
+	}
+	if notify.Webhook != "" {
+		if _, err := webhookFormat(notify); err != nil {
+			fmt.Printf("Error: %v.\n", err)
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+	}
+	if maxConcurrent > 0 {
+		expensiveSlot = make(chan struct{}, maxConcurrent)
+	}
+	startJobWorkers()
+	startRetention()
+
+	var requestLog *accessLogger
+	if *accessLog != "" {
+		var err error
+		if requestLog, err = openAccessLog(*accessLog, *accessLogFormat); err != nil {
+			fatal("Error setting up the access log", "error", err)
+		}
+	}
+
+	var auth *authenticator
+	if oauth.Provider != "" {
+		oauth.ClientSecret = oauthClientSecret(oauth.ClientSecret)
+		var err error
+		if auth, err = newAuthenticator(oauth); err != nil {
+			fmt.Printf("Error: %v.\n", err)
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+	}
+
+	basePath = strings.TrimRight(basePath, "/")
+	if basePath != "" && !strings.HasPrefix(basePath, "/") {
+		basePath = "/" + basePath
+	}
+
+	if heatMode != "churn" && heatMode != "blame" {
+		fmt.Printf("Error: Unknown mode '%s'.\n", heatMode)
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if prOptions.From > 0 {
+		if heatMode == "blame" {
+			fmt.Println("Error: -prs is not available in blame mode.")
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		prOptions.Token = cmp.Or(os.Getenv("DIRHEAT_GITHUB_TOKEN"), os.Getenv("DIRHEAT_GITLAB_TOKEN"))
+	}
+	if _, ok := valueScales[treeOptions.Scale]; !ok && treeOptions.Scale != "" && treeOptions.Scale != "linear" {
+		fmt.Printf("Error: Unknown scale '%s'.\n", treeOptions.Scale)
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if groupBy != "" && groupBy != "none" && groupBy != "category" && groupBy != "module" {
+		fmt.Printf("Error: Unknown grouping '%s'.\n", groupBy)
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if groupBy == "module" && *moduleMapFile == "" {
+		fmt.Println("Error: -group-by module needs -module-map.")
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if analysisRev == "" || strings.HasPrefix(analysisRev, "-") {
+		fmt.Printf("Error: Invalid revision '%s'.\n", analysisRev)
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	if len(args) < 1 {
 		fmt.Println("Error: Missing required argument.")
-		log.Fatal("Usage: go run main.go <path_to_local_git_repo>")
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	repoPaths = args
+	repoPath = repoPaths[0]
+
+	for _, path := range repoPaths {
+		if cloneURL != "" {
+			break // Cloned before the analysis
+		}
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			slog.Error("Error accessing path", "path", path, "error", err)
+			os.Exit(exitNotARepo)
+		}
+		if !fileInfo.IsDir() {
+			slog.Error("Path is not a directory", "path", path)
+			os.Exit(exitNotARepo)
+		}
+	}
+	if _, err := normalizer(normalize, 1, 1); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flag.Usage()
+		os.Exit(exitUsage)
 	}
-	repoPath = os.Args[1]
 
-	fileInfo, err := os.Stat(repoPath)
+	if *annotationsFile == "" {
+		*annotationsFile = filepath.Join(repoPath, ".git", "dirheat-annotations.json")
+	}
+	annotations, err = loadAnnotations(*annotationsFile)
 	if err != nil {
-		log.Fatalf("Error accessing path '%s': %v", repoPath, err)
+		fatal("Error loading annotations", "error", err)
+	}
+	if *issuesFile != "" {
+		counts, err := loadQualityIssues(*issuesFile)
+		if err != nil {
+			fatal("Error loading issues", "error", err)
+		}
+		treeOverlays = append(treeOverlays, qualityOverlay(counts))
+		metricSources = append(metricSources, metricSource{name: "issues", value: func(path string) (float64, bool) {
+			return float64(counts[path]), counts[path] > 0
+		}})
+		slog.Info("Loaded code quality issues", "file", *issuesFile, "issues", counts[""])
+	}
+	if *periodsFile != "" {
+		if calendarPeriods, err = loadPeriods(*periodsFile); err != nil {
+			fatal("Error loading periods", "error", err)
+		}
 	}
-	if !fileInfo.IsDir() {
-		log.Fatalf("Path '%s' is not a directory", repoPath)
+	if *moduleMapFile != "" {
+		if moduleMap, err = loadModuleMap(*moduleMapFile); err != nil {
+			fatal("Error loading module map", "error", err)
+		}
+		slog.Info("Loaded module map", "file", *moduleMapFile, "prefixes", len(moduleMap))
+	}
+	if *alertRulesFile != "" {
+		if alertRules, err = loadAlertRules(*alertRulesFile); err != nil {
+			fatal("Error loading alert rules", "error", err)
+		}
+		slog.Info("Loaded alert rules", "file", *alertRulesFile, "rules", len(alertRules))
+	}
+	var head *headTree
+	if *incidentsFile != "" || *coverageFile != "" || *describe {
+		if head, err = loadHeadTree(context.Background(), repoPath); err != nil {
+			fatal("Error reading the repository", "error", err)
+		}
+	}
+	if *incidentsFile != "" {
+		index, err := loadIncidents(*incidentsFile, head)
+		if err != nil {
+			fatal("Error loading incidents", "error", err)
+		}
+		incidents = index
+		treeOverlays = append(treeOverlays, incidentOverlay(index))
+		metricSources = append(metricSources, metricSource{name: "incidents", value: func(path string) (float64, bool) {
+			return float64(len(index[path])), len(index[path]) > 0
+		}})
+		slog.Info("Loaded incidents", "file", *incidentsFile, "incidents", len(index[""]))
+	}
+	if *describe {
+		descriptions, err := loadDescriptions(context.Background(), repoPath, head)
+		if err != nil {
+			fatal("Error reading READMEs and CODEOWNERS", "error", err)
+		}
+		treeOverlays = append(treeOverlays, descriptionOverlay(descriptions))
+		slog.Info("Loaded directory descriptions", "directories", len(descriptions))
+	}
+	if *coverageFile != "" {
+		coverage, err := loadCoverage(*coverageFile, head)
+		if err != nil {
+			fatal("Error loading coverage", "error", err)
+		}
+		treeOverlays = append(treeOverlays, coverageOverlay(coverage))
+		metricSources = append(metricSources, metricSource{name: "coverage", value: func(path string) (float64, bool) {
+			if entry := coverage[path]; entry != nil {
+				return entry.Percent, true
+			}
+			return 0, false
+		}})
+		if total := coverage[""]; total != nil {
+			slog.Info("Loaded coverage", "file", *coverageFile, "percent", total.Percent)
+		}
+	}
+	if *viewsFile == "" {
+		*viewsFile = filepath.Join(repoPath, ".git", "dirheat-views.json")
+	}
+	views, err = loadViews(*viewsFile)
+	if err != nil {
+		fatal("Error loading views", "error", err)
 	}
 
-	// Run analysis once
-	dataOnce.Do(func() {
-		log.Println("Starting initial repository analysis (numstat approach)...")
-		repoData, analyzeError = analyzeRepo(repoPath)
+	// afterAnalysis feeds the integrations with every published analysis
+	afterAnalysis := func(ctx context.Context, repoData *Analysis) {
+		if push.Gateway != "" {
+			if err := pushMetrics(ctx, repoData, push); err != nil {
+				slog.Warn("Could not push metrics", "error", err)
+			}
+		}
+		if notify.Webhook != "" {
+			if err := notifyDigest(ctx, repoData, notify); err != nil {
+				slog.Warn("Could not post the digest", "error", err)
+			}
+		}
+		if len(alertRules) > 0 {
+			if err := dispatchAlerts(ctx, repoData, notify); err != nil {
+				slog.Warn("Could not post the alerts", "error", err)
+			}
+		}
+	}
+
+	// Run analysis once in the background, the server answers /healthz and /readyz meanwhile.
+	// Ctrl-C aborts it (and the still running git commands).
+	go dataOnce.Do(func() {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if *analysisTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *analysisTimeout)
+			defer cancel()
+		}
+		var phases *phaseProfile
+		if *profile {
+			phases = newPhaseProfile()
+			ctx = withProfile(ctx, phases)
+			if *profileDir != "" {
+				stopPprof, err := startPprof(*profileDir)
+				if err != nil {
+					fatal("Error starting pprof profiles", "error", err)
+				}
+				defer stopPprof()
+			}
+		}
+		if cloneURL != "" {
+			if err := syncClone(ctx, cloneURL, repoPath); err != nil {
+				fatal("Error cloning the repository", "error", err)
+			}
+		}
+		slog.Info("Starting initial repository analysis")
+		start := time.Now()
+		repoData, analyzeError := analyzeWithProgress(ctx, repoPaths)
+		if errors.Is(analyzeError, context.Canceled) {
+			fatal("Repository analysis interrupted", "error", analyzeError)
+		}
+		if errors.Is(analyzeError, context.DeadlineExceeded) {
+			analyzeError = fmt.Errorf("analysis exceeded the configured timeout: %w", analyzeError)
+		}
 		if analyzeError != nil {
-			log.Printf("!!! CRITICAL error during initial repository analysis: %v", analyzeError)
+			slog.Error("Initial repository analysis failed", "error", analyzeError, "kind", errorKind(analyzeError))
+			// Without a repository or git, or with other options than expected, there is nothing to
+			// serve until the setup is fixed
+			if kind := errorKind(analyzeError); kind == "notARepo" || kind == "gitNotFound" || kind == "optionsMismatch" {
+				os.Exit(exitCode(analyzeError))
+			}
 		} else if repoData != nil {
-			// Log the value calculated by aggregation now
-			log.Printf("Initial repository analysis complete. Root node ('%s') aggregated value: %d", repoData.Name, repoData.Value)
+			if jiraOptions.URL != "" {
+				if err := resolveIssueTypes(ctx, repoData, jiraOptions); err != nil {
+					slog.Warn("Defect overlay unavailable", "error", err)
+					repoData.IssueTypes = nil
+				}
+			}
+			slog.Info("Initial repository analysis complete", "root", repoData.Root.Name, "value", repoData.Root.Value, "commits", repoData.Meta.CommitCount, "optionsHash", repoData.Meta.OptionsHash)
 		} else {
-			log.Printf("Repository analysis finished, but repoData is nil (and no error reported).")
+			analyzeError = errors.New("repository analysis finished without data")
+			slog.Error("Initial repository analysis failed", "error", analyzeError)
+		}
+		if phases != nil && repoData != nil {
+			// Encoding the default /data response also warms the cache for the first request
+			done := startPhase(ctx, "encode")
+			if _, err := dataCache.get(repoData, treeOptions, ""); err != nil {
+				slog.Warn("Error encoding JSON data", "error", err)
+			}
+			done()
+			phases.report()
+			slog.Info("Profile", "phase", "total", "time", time.Since(start).Round(time.Microsecond).String())
+		}
+		publishAnalysis(repoData, analyzeError)
+		if repoData != nil {
+			afterAnalysis(ctx, repoData)
+		}
+		if refreshInterval > 0 {
+			refreshPeriodically(*analysisTimeout, afterAnalysis)
 		}
 	})
 
-	http.HandleFunc("/data", func(w http.ResponseWriter, r *http.Request) {
-		if analyzeError != nil {
-			log.Printf("ERROR /data: Analysis error encountered: %v", analyzeError)
-			http.Error(w, fmt.Sprintf("Error analyzing repository: %v", analyzeError), http.StatusInternalServerError)
+	http.HandleFunc("/data/events", handleDataEvents)
+	http.HandleFunc("/data", withCompression(func(w http.ResponseWriter, r *http.Request) {
+		repoData, ok := currentAnalysis(w)
+		if !ok {
 			return
 		}
-		if repoData == nil {
-			log.Println("ERROR /data: repoData is nil")
-			http.Error(w, "Repository data is not available or analysis failed.", http.StatusInternalServerError)
+		view, err := dataView(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch view {
+		case "mine":
+			handleMineData(w, r, repoData)
+			return
+		case "overlay":
+			handleOverlayData(w, r, repoData)
+			return
+		case "category":
+			handleCategoryFilterData(w, r, repoData)
+			return
+		case "security":
+			handleSecurityData(w, r, repoData)
+			return
+		case "trailer":
+			handleTrailerData(w, r, repoData)
+			return
+		case "metric":
+			handleMetricData(w, r, repoData, r.URL.Query().Get("metric"))
+			return
+		case "profile":
+			handleProfileData(w, r, repoData)
+			return
+		case "remap":
+			handleRemapData(w, r, repoData)
+			return
+		}
+		switch grouping := cmp.Or(r.URL.Query().Get("groupBy"), groupBy); grouping {
+		case "category":
+			handleCategoryData(w, r, repoData)
+			return
+		case "module":
+			handleModuleData(w, r, repoData)
+			return
+		case "", "none":
+		default:
+			http.Error(w, fmt.Sprintf("Unknown groupBy '%s' (expected category, module or none)", grouping), http.StatusBadRequest)
 			return
 		}
 
-		// Convert aggregated internal structure to JSON-friendly structure
-		jsonData := repoData.ToJSONNode()
+		opts, err := treeOptionsFromQuery(treeOptions, r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		// Optional logging for the data being sent
-		// log.Printf("Serving Data for Root: '%s' (Aggregated Value: %d)", jsonData.Name, jsonData.Value)
-		// if len(jsonData.Children) > 0 {
-		//     log.Printf("  First child: Name='%s', Value=%d", jsonData.Children[0].Name, jsonData.Children[0].Value)
-		// }
+		// Convert aggregated internal structure to JSON-friendly structure, reusing earlier encodings
+		entry, err := dataCache.get(repoData, opts, r.URL.Query().Get("path"))
+		if errors.Is(err, errPathNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			slog.Error("Error encoding JSON data", "error", err)
+			http.Error(w, "Error encoding JSON data", http.StatusInternalServerError)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		err := json.NewEncoder(w).Encode(jsonData) // Encode the JSON-friendly structure
-		if err != nil {
-			log.Printf("Error encoding JSON data: %v", err)
-			http.Error(w, "Error encoding JSON data", http.StatusInternalServerError)
-		}
-	})
+		w.Header().Set("Cache-Control", "no-cache") // Clients may cache but must revalidate
+		w.Header().Set("ETag", entry.etag)
+		w.Header().Set("X-Dirheat-Options-Hash", entry.optionsHash)
+		// ServeContent honors If-None-Match and If-Modified-Since
+		http.ServeContent(w, r, "", repoData.Meta.GeneratedAt, bytes.NewReader(entry.body))
+	}))
+
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
+	http.HandleFunc("/status", handleStatus)
+	http.HandleFunc("/commits", withCompression(handleCommits))
+	http.HandleFunc("/file", withLimits(withCompression(handleFile)))
+	http.HandleFunc("/file/content", withLimits(withCompression(handleFileContent)))
+	http.HandleFunc("/file/blame", withLimits(withCompression(handleFileBlame)))
+	http.HandleFunc("/authors", withCompression(handleAuthors))
+	http.HandleFunc("/authors/{name}", withCompression(handleAuthor))
+	http.HandleFunc("/reviewers", withLimits(handleReviewers))
+	http.HandleFunc("/commit-sizes", withCompression(handleCommitSizes))
+	http.HandleFunc("/test-ratio", withCompression(handleTestRatio))
+	http.HandleFunc("/periods", withCompression(handlePeriods))
+	http.HandleFunc("/dependencies", withCompression(handleDependencies))
+	http.HandleFunc("/notes", withLimits(withCompression(handleNotes)))
+	http.HandleFunc("/security", withCompression(handleSecurity))
+	http.HandleFunc("/search", withCompression(handleSearch))
+	http.HandleFunc("/filemap", withCompression(handleFileMap))
+	http.HandleFunc("/annotations", withWrites(handleAnnotations))
+	http.HandleFunc("/annotations/{path...}", withWrites(handleAnnotation))
+	http.HandleFunc("/snapshots", withCompression(handleSnapshots))
+	http.HandleFunc("/compare", withLimits(withCompression(handleCompare)))
+	http.HandleFunc("/compare-paths", withCompression(handleComparePaths))
+	http.HandleFunc("/analyze", withWrites(withLimits(handleAnalyze)))
+	http.HandleFunc("/jobs", handleJobs)
+	http.HandleFunc("/jobs/{id}", withWrites(handleJob))
+	http.HandleFunc("/jobs/{id}/data", withCompression(handleJobData))
+	http.HandleFunc("/views", withWrites(handleViews))
+	http.HandleFunc("/views/{id}", withWrites(handleView))
+	http.HandleFunc("/v/{id}", handleViewPermalink)
+	http.HandleFunc("/digest", handleDigest)
+	http.HandleFunc("/movers", withCompression(handleMovers))
+	http.HandleFunc("/anomalies", withCompression(handleAnomalies))
+	http.HandleFunc("/forecast", withCompression(handleForecast))
+	http.HandleFunc("/refactoring", withCompression(handleRefactoring))
+	http.HandleFunc("/clusters", withCompression(handleClusters))
+	http.HandleFunc("/ripple", withCompression(handleRipple))
+	http.HandleFunc("/files", withCompression(handleFiles))
+	http.HandleFunc("/profiles", withCompression(handleProfiles))
+	http.HandleFunc("/alerts", handleAlerts)
+	http.HandleFunc("/defects", handleDefects)
+	http.HandleFunc("/incidents", handleIncidents)
+	http.HandleFunc("/auth/me", handleMe)
+	http.HandleFunc("GET /grafana/{$}", handleGrafanaTest)
+	http.HandleFunc("/grafana/search", handleGrafanaSearch)
+	http.HandleFunc("/grafana/query", withCompression(handleGrafanaQuery))
+	http.HandleFunc("/grafana/annotations", handleGrafanaAnnotations)
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// ... (Root handler remains the same) ...
 		if r.URL.Path != "/" {
 			http.NotFound(w, r)
 			return
 		}
-		if _, err := os.Stat("heatmap.html"); err == nil {
-			http.ServeFile(w, r, "heatmap.html")
-		} else {
-			w.Header().Set("Content-Type", "text/html; charset=utf-8")
-			fmt.Fprintln(w, `<!DOCTYPE html>
-<html>
-<head><title>Git Heatmap</title></head>
-<body>
-    <h1>Git Repository Heatmap</h1>
-    <p><strong>Error:</strong> Could not find <code>heatmap.html</code>.</p>
-    <p>Data is served at <a href="/data">/data</a>.</p>
-</body>
-</html>`)
-		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		http.ServeContent(w, r, "heatmap.html", startedAt, bytes.NewReader(heatmapHTML))
 	})
 
-	port := "8080"
-	// ... (Server start logic remains the same) ...
-	fmt.Printf("Attempting to start server on http://localhost:%s", port)
-	fmt.Printf("Serving data for repository: %s", repoPath)
-	fmt.Printf("Access http://localhost:%s/ for visualization (requires heatmap.html)", port)
-	fmt.Printf("Access http://localhost:%s/data for raw JSON data", port)
+	var listener net.Listener
+	if socketPath != "" {
+		listener, err = listenSocket(socketPath)
+		if err != nil {
+			fatal("Failed to start the daemon", "error", err)
+		}
+		slog.Info("Starting daemon", "socket", socketPath, "repos", strings.Join(repoPaths, ", "))
+		emitProgress(ProgressEvent{Event: "listening", URL: "unix:" + socketPath})
+	} else {
+		listener, err = listen(*port)
+		if err != nil {
+			fatal("Failed to start server", "error", err)
+		}
+		address := fmt.Sprintf("localhost:%d", listener.Addr().(*net.TCPAddr).Port)
+		slog.Info("Starting server", "url", "http://"+address+basePath+"/", "data", "http://"+address+basePath+"/data", "repos", strings.Join(repoPaths, ", "))
+		emitProgress(ProgressEvent{Event: "listening", URL: "http://" + address + basePath + "/"})
+		if openBrowser {
+			if err := openURL("http://" + address + basePath + "/"); err != nil {
+				slog.Warn("Could not open the browser", "error", err)
+			}
+		}
+	}
 
-	err = http.ListenAndServe(":"+port, nil)
-	if err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	var handler http.Handler = withPathChecks(http.DefaultServeMux)
+	if auth != nil {
+		handler = auth.wrap(handler)
+		slog.Info("Authentication enabled", "provider", oauth.Provider, "allow", strings.Join(oauth.Allow, ","))
+	}
+	if basePath != "" {
+		// The subtree pattern also redirects the bare base path to base path + "/"
+		mounted := http.NewServeMux()
+		mounted.Handle(basePath+"/", http.StripPrefix(basePath, handler))
+		handler = mounted
+	}
+	if requestLog != nil {
+		handler = requestLog.wrap(handler)
+	}
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second, MaxHeaderBytes: maxHeaderBytes}
+	server.RegisterOnShutdown(closeStreams)
+
+	// SIGTERM (e.g. from a container runtime) and Ctrl-C let the requests in flight finish
+	shutdownCtx, stopShutdown := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopShutdown()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-shutdownCtx.Done()
+		slog.Info("Shutting down, finishing the requests in flight")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Warn("Requests didn't finish in time", "error", err)
+		}
+	}()
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		fatal("Failed to start server", "error", err)
 	}
+	<-stopped
 }
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// skipUnlessOn skips a test case meant for another platform: "windows", "unix" (any other) or "" (all)
+func skipUnlessOn(t *testing.T, goos string) {
+	t.Helper()
+	if goos == "unix" && runtime.GOOS != "windows" || goos == runtime.GOOS || goos == "" {
+		return
+	}
+	t.Skipf("only on %s", goos)
+}
+
+func TestRepoName(t *testing.T) {
+	tests := []struct {
+		goos string // See skipUnlessOn
+		path string
+		want string
+	}{
+		{"", "repo", "repo"},
+		{"", "work/repo/", "repo"},
+		{"", ".", "repository_root"},
+		{"", "work/..", "repository_root"},
+		{"", "naïve café", "naïve café"},
+		{"unix", "/", "repository_root"},
+		{"unix", "/work/repo", "repo"},
+		{"unix", `work\repo`, `work\repo`}, // A backslash is part of the name
+		{"windows", `C:\`, "repository_root"},
+		{"windows", `c:`, "repository_root"},
+		{"windows", `C:\work\repo`, "repo"},
+		{"windows", `C:\work\repo\`, "repo"},
+		{"windows", `C:/work/repo`, "repo"},
+		{"windows", `C:repo`, "repo"},
+		{"windows", `\\server\share`, "repository_root"},
+		{"windows", `\\server\share\repo`, "repo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			skipUnlessOn(t, tt.goos)
+			if got := repoName(tt.path); got != tt.want {
+				t.Errorf("repoName(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAbsRepoPath(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		goos string // See skipUnlessOn
+		path string
+		want string
+	}{
+		{"", "repo", filepath.Join(wd, "repo")},
+		{"", ".", wd},
+		{"unix", "/work/repo", "/work/repo"},
+		{"unix", "/work/../repo/", "/repo"},
+		{"unix", `/work/c:\repo`, `/work/c:\repo`},
+		{"windows", `c:\work\repo`, `C:\work\repo`},
+		{"windows", `C:\work\repo`, `C:\work\repo`},
+		{"windows", `c:/work/../repo`, `C:\repo`},
+		{"windows", `c:\`, `C:\`},
+		{"windows", `\\server\share\repo`, `\\server\share\repo`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			skipUnlessOn(t, tt.goos)
+			if got := absRepoPath(tt.path); got != tt.want {
+				t.Errorf("absRepoPath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTreePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"src/main.go", "src/main.go"},
+		{"", ""},
+		{"{src/main.go", "src/main.go"},
+		{"src/{old/main.go", "src/old/main.go"},
+		{" src /main.go}", "src/main.go"},
+		{`dir\file.txt`, `dir\file.txt`}, // git paths are slash separated, a backslash is part of the name
+		{`C:\dir/file.txt`, `C:\dir/file.txt`},
+		{"naïve café/日本語.txt", "naïve café/日本語.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := treePath(tt.path); got != tt.want {
+				t.Errorf("treePath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenameDestination(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"src/main.go", "src/main.go"},
+		{"old.go => new.go", "new.go"},
+		{"src/{old => new}/main.go", "src/new/main.go"},
+		{"src/{old.go => new.go}", "src/new.go"},
+		{"src/{ => sub}/main.go", "src/sub/main.go"},
+		{"src/{sub => }/main.go", "src/main.go"},
+		{"{old => new}/main.go", "new/main.go"},
+		{`dir\{a => b}\file.txt`, `dir\b\file.txt`},
+		{`C:\old.txt => C:\new.txt`, `C:\new.txt`},
+		{"{braces}/file.go", "{braces}/file.go"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := renameDestination(tt.path); got != tt.want {
+				t.Errorf("renameDestination(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+// BenchmarkPopulateTree measures building the tree of a large synthetic monorepo, and the live heap
+// the tree keeps per node
+func BenchmarkPopulateTree(b *testing.B) {
+	values := make(map[string]int)
+	for i := range 1_000_000 {
+		values[fmt.Sprintf("module%d/pkg%d/sub%d/file%d.go", i%50, i%1000, i%7000, i)] = i%9 + 1
+	}
+	var heap, nodes uint64
+	b.ResetTimer()
+	for range b.N {
+		runtime.GC()
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		root := populateTree("monorepo", values)
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		heap, nodes = after.HeapAlloc-before.HeapAlloc, uint64(countNodes(root))
+		runtime.KeepAlive(root)
+	}
+	b.ReportMetric(float64(heap)/1e6, "MB/tree")
+	b.ReportMetric(float64(heap)/float64(nodes), "B/node")
+}
+
+// countNodes returns the number of nodes of the tree
+func countNodes(n Node) int {
+	count := 1
+	for child := range n.Children() {
+		count += countNodes(child)
+	}
+	return count
+}
+
+func TestDataView(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"path=src&minValue=2", "", false},
+		{"mine=true", "mine", false},
+		{"mine=false&profile=all", "profile", false},
+		{"trailerWeights=Co-authored-by:2", "trailer", false},
+		{"groupBy=none&metric=lines", "metric", false},
+		{"groupBy=module", "groupBy", false},
+		{"profile=all&category=test", "", true},
+		{"mine=true&trailer=Reviewed-by", "", true},
+		{"metric=age&groupBy=category&remap=a=>b", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := dataView(query)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("dataView(%q) = %q, %v, want %q, error %v", tt.query, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
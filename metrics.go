@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// selectableMetrics are the metrics ?metric= can make the value of the tree nodes
+var selectableMetrics = []string{"commits", "lines", "authors", "age"}
+
+// metricSource adds a metric from data loaded at startup, e.g. the coverage report
+type metricSource struct {
+	name  string
+	value func(path string) (float64, bool)
+}
+
+// metricSources are the metric sources configured at startup
+var metricSources []metricSource
+
+// nodeMetricsIndex caches the metrics of the current analysis by tree path
+var nodeMetricsIndex struct {
+	mu       sync.Mutex
+	analysis *Analysis
+	metrics  map[string]map[string]float64
+}
+
+// nodeMetrics returns the metrics of every file and directory of the analysis by tree path:
+// commits, lines (changed), authors, age (days since the last change), hotspot (hotspot files
+// within) and those of the metric sources. Trees derived from an analysis bring the metrics along.
+func nodeMetrics(a *Analysis) map[string]map[string]float64 {
+	if a.metrics != nil {
+		return a.metrics
+	}
+	nodeMetricsIndex.mu.Lock()
+	defer nodeMetricsIndex.mu.Unlock()
+	if nodeMetricsIndex.analysis == a {
+		return nodeMetricsIndex.metrics
+	}
+
+	collector := newMetricCollector(a.Meta.GeneratedAt)
+	replay(a.Commits, collector)
+	metrics := collector.metrics
+	for _, hotspot := range hotspots(a) {
+		for _, path := range append(directoriesOf(hotspot.Path, math.MaxInt), hotspot.Path, "") {
+			if entry := metrics[path]; entry != nil {
+				entry["hotspot"]++
+			}
+		}
+	}
+	for path, entry := range metrics {
+		for _, source := range metricSources {
+			if value, ok := source.value(path); ok {
+				entry[source.name] = value
+			}
+		}
+	}
+
+	if len(a.Commits) > 0 { // Trees without commits (mine=true, snapshots) would only evict the analysis
+		nodeMetricsIndex.analysis, nodeMetricsIndex.metrics = a, metrics
+	}
+	return metrics
+}
+
+// metricAnalysis returns the tree of the analysis with the named metric as the value of the files.
+// Directories sum their files like in the churn tree, so the treemap areas add up; their own
+// figures are in their metrics.
+func metricAnalysis(a *Analysis, metric string) *Analysis {
+	metrics := nodeMetrics(a)
+	values := make(map[string]int)
+	var collect func(n Node)
+	collect = func(n Node) {
+		if n.IsFile() {
+			path := n.relPath()
+			value := int(metrics[path][metric])
+			if metric == "age" {
+				value = max(value, 1) // Files changed today would otherwise drop out of the tree
+			}
+			values[path] = value
+		}
+		for child := range n.Children() {
+			collect(child)
+		}
+	}
+	collect(a.Root)
+	root := populateTree(a.Root.Name(), values)
+	root.aggregateCounts()
+	return &Analysis{Root: root, Meta: a.Meta, Commits: a.Commits, metrics: metrics}
+}
+
+// handleMetricData serves GET /data?metric=lines, the tree with the metric as value. It takes the
+// /data query parameters.
+func handleMetricData(w http.ResponseWriter, r *http.Request, a *Analysis, metric string) {
+	known := false
+	for _, name := range selectableMetrics {
+		known = known || name == metric
+	}
+	if !known {
+		http.Error(w, fmt.Sprintf("Unknown metric '%s' (expected %s)", metric, strings.Join(selectableMetrics, ", ")), http.StatusBadRequest)
+		return
+	}
+	filters := map[string]string{"metric": metric}
+	if mode := a.Meta.Filters["mode"]; mode != "" {
+		filters["mode"] = mode
+	}
+	writeCommitData(w, r, metricAnalysis(a, metric), filters)
+}
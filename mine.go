@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+// meIdentity is the -me option: the email or name whose commits /data?mine=true shows
+var meIdentity string
+
+// isAuthor reports whether the commit was authored by identity, an email or a name (case-insensitive)
+func (c *Commit) isAuthor(identity string) bool {
+	return strings.EqualFold(c.Email, identity) || strings.EqualFold(c.Author, identity)
+}
+
+// commitAnalysis returns the churn tree of the commits kept by keep, in the shape of a churn
+// analysis so it can be served like /data
+func commitAnalysis(a *Analysis, keep func(c *Commit) bool) *Analysis {
+	return weightedCommitAnalysis(a, func(c *Commit) int {
+		if keep(c) {
+			return 1
+		}
+		return 0
+	})
+}
+
+// weightedCommitAnalysis returns the churn tree where every file change of a commit counts with
+// the commit's weight, times the weight the commit gives itself by trailer; commits weighing 0
+// are left out
+func weightedCommitAnalysis(a *Analysis, weight func(c *Commit) int) *Analysis {
+	values := newWeightedCounts()
+	commits := 0
+	for _, commit := range a.Commits {
+		w := weight(commit)
+		if w <= 0 {
+			continue
+		}
+		commits++
+		for _, file := range commit.Files {
+			values.add(file.Path, w, commit.Weight)
+		}
+	}
+	root := populateTree(a.Root.Name(), values.result())
+	root.aggregateCounts()
+	filtered := &Analysis{Root: root, Meta: a.Meta}
+	filtered.Meta.CommitCount = commits
+	return filtered
+}
+
+// writeCommitData writes the /data response of a commitAnalysis tree, honoring the /data query
+// parameters and recording filters (churn mode unless they name one) in the metadata
+func writeCommitData(w http.ResponseWriter, r *http.Request, a *Analysis, filters map[string]string) {
+	opts, err := treeOptionsFromQuery(treeOptions, r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var body bytes.Buffer
+	if filters["mode"] == "" {
+		filters["mode"] = "churn"
+	}
+	hash, err := writeDataResponse(&body, a, opts, r.URL.Query().Get("path"), filters)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("X-Dirheat-Options-Hash", hash)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	body.WriteByte('\n')
+	body.WriteTo(w)
+}
+
+// handleMineData serves GET /data?mine=true, the heat of the signed-in user's own commits (or the
+// -me identity's). It takes the /data query parameters.
+func handleMineData(w http.ResponseWriter, r *http.Request, a *Analysis) {
+	identity := meIdentity
+	if user := currentUser(r); user != nil && user.Email != "" {
+		identity = user.Email
+	}
+	if identity == "" {
+		http.Error(w, "mine=true needs a signed-in user or the -me option", http.StatusBadRequest)
+		return
+	}
+	mine := commitAnalysis(a, func(c *Commit) bool { return c.isAuthor(identity) })
+	writeCommitData(w, r, mine, map[string]string{"author": identity})
+}
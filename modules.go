@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// unmappedModule is the module of the files no prefix of the module map matches
+const unmappedModule = "unmapped"
+
+// modulePrefix maps the files below a path prefix to a module
+type modulePrefix struct {
+	prefix, module string
+}
+
+// moduleMap is loaded from -module-map, longest prefix first
+var moduleMap []modulePrefix
+
+// loadModuleMap reads a module map: YAML mapping every module to the path prefixes it consists of,
+// e.g. "payments: [services/billing, libs/invoice]". A module may span several directories, and a
+// more specific prefix can carve a directory out of the module of its parent.
+func loadModuleMap(file string) ([]modulePrefix, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	config, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing module map '%s': %w", file, err)
+	}
+	owners := make(map[string]string)
+	var prefixes []modulePrefix
+	for module, paths := range config {
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("error parsing module map '%s': module '%s' has no paths", file, module)
+		}
+		for _, path := range paths {
+			prefix := strings.Trim(strings.TrimPrefix(path, "./"), "/")
+			if prefix == "" || prefix == "." {
+				return nil, fmt.Errorf("error parsing module map '%s': invalid path '%s' of module '%s'", file, path, module)
+			}
+			if owner, ok := owners[prefix]; ok && owner != module {
+				return nil, fmt.Errorf("error parsing module map '%s': '%s' belongs to both '%s' and '%s'", file, prefix, owner, module)
+			}
+			owners[prefix] = module
+			prefixes = append(prefixes, modulePrefix{prefix: prefix, module: module})
+		}
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if len(prefixes[i].prefix) != len(prefixes[j].prefix) {
+			return len(prefixes[i].prefix) > len(prefixes[j].prefix)
+		}
+		return prefixes[i].prefix < prefixes[j].prefix
+	})
+	return prefixes, nil
+}
+
+// moduleOf returns the module of the file by the longest matching prefix, unmappedModule without one
+func moduleOf(file string) string {
+	for _, entry := range moduleMap {
+		if file == entry.prefix || strings.HasPrefix(file, entry.prefix+"/") {
+			return entry.module
+		}
+	}
+	return unmappedModule
+}
+
+// moduleAnalysis returns the tree of the analysis regrouped with the module as first path segment,
+// so the root's children show the change energy of the logical components
+func moduleAnalysis(a *Analysis) *Analysis {
+	return regroupAnalysis(a, func(file string) string { return moduleOf(file) + "/" + file })
+}
+
+// handleModuleData serves GET /data?groupBy=module (the default with -group-by module), the tree
+// grouped by the modules of -module-map. It takes the /data query parameters.
+func handleModuleData(w http.ResponseWriter, r *http.Request, a *Analysis) {
+	if len(moduleMap) == 0 {
+		http.Error(w, "Grouping by module needs a module map (-module-map)", http.StatusBadRequest)
+		return
+	}
+	filters := map[string]string{"groupBy": "module"}
+	if mode := a.Meta.Filters["mode"]; mode != "" {
+		filters["mode"] = mode
+	}
+	writeCommitData(w, r, moduleAnalysis(a), filters)
+}
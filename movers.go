@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// defaultMovers is the number of increases and decreases /movers lists by default
+const defaultMovers = 10
+
+// HeatMove is the change of a directory's heat between two points in time
+type HeatMove struct {
+	Path    string   `json:"path"`
+	Before  int      `json:"before"`
+	After   int      `json:"after"`
+	Delta   int      `json:"delta"`
+	Percent *float64 `json:"percent,omitempty"` // Delta relative to before, left out for new directories
+}
+
+// MoversReport lists the directories whose heat grew and shrank the most, as served on /movers
+type MoversReport struct {
+	// Basis is "refresh" for the change of the tree values since the previous analysis (-refresh),
+	// "window" for the churn within the window against the window before
+	Basis     string     `json:"basis"`
+	Window    string     `json:"window,omitempty"`
+	Since     time.Time  `json:"since"`
+	Increases []HeatMove `json:"increases"` // Biggest increase first
+	Decreases []HeatMove `json:"decreases"` // Biggest decrease first
+}
+
+// directoryValues returns the tree values of the directories up to depth levels
+func directoryValues(root Node, depth int) map[string]int {
+	values := make(map[string]int)
+	var collect func(n Node, level int)
+	collect = func(n Node, level int) {
+		for child := range n.Children() {
+			if child.IsFile() || level > depth {
+				continue
+			}
+			values[child.relPath()] = child.Value()
+			collect(child, level+1)
+		}
+	}
+	collect(root, 1)
+	return values
+}
+
+// windowValues returns the file changes per directory up to depth levels within the window
+// before now and within the window before that one
+func windowValues(a *Analysis, window time.Duration, depth int, now time.Time) (previous, recent map[string]int) {
+	before, within := newWeightedCounts(), newWeightedCounts()
+	for _, commit := range a.Commits {
+		age := now.Sub(commit.Date)
+		if age > 2*window {
+			break // Newest first
+		}
+		values := before
+		if age <= window {
+			values = within
+		}
+		for _, file := range commit.Files {
+			for _, dir := range directoriesOf(file.Path, depth) {
+				values.add(dir, 1, commit.Weight)
+			}
+		}
+	}
+	return before.result(), within.result()
+}
+
+// heatMoves compares the directory values before and after, returning the increases and the
+// decreases, at most limit each
+func heatMoves(before, after map[string]int, limit int) (increases, decreases []HeatMove) {
+	increases, decreases = []HeatMove{}, []HeatMove{}
+	paths := make(map[string]bool)
+	for path := range before {
+		paths[path] = true
+	}
+	for path := range after {
+		paths[path] = true
+	}
+	for path := range paths {
+		move := HeatMove{Path: path, Before: before[path], After: after[path], Delta: after[path] - before[path]}
+		if move.Before > 0 {
+			percent := percentOf(move.Delta, move.Before)
+			move.Percent = &percent
+		}
+		if move.Delta > 0 {
+			increases = append(increases, move)
+		} else if move.Delta < 0 {
+			decreases = append(decreases, move)
+		}
+	}
+	sort.Slice(increases, func(i, j int) bool {
+		if increases[i].Delta != increases[j].Delta {
+			return increases[i].Delta > increases[j].Delta
+		}
+		return increases[i].Path < increases[j].Path
+	})
+	sort.Slice(decreases, func(i, j int) bool {
+		if decreases[i].Delta != decreases[j].Delta {
+			return decreases[i].Delta < decreases[j].Delta
+		}
+		return decreases[i].Path < decreases[j].Path
+	})
+	return increases[:min(limit, len(increases))], decreases[:min(limit, len(decreases))]
+}
+
+// handleMovers serves GET /movers?window=7d&depth=2&limit=10, the directories whose heat grew and
+// shrank the most: since the previous refresh (-refresh) without a window, otherwise (and before
+// the first refresh) the churn within the window against the window before, 7 days by default
+func handleMovers(w http.ResponseWriter, r *http.Request) {
+	state := loadState()
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	depth := digestDirectoryDepth
+	if query.Has("depth") {
+		if depth, ok = authorDepth(w, r); !ok {
+			return
+		}
+	}
+	limit := defaultMovers
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit '"+value+"'", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	report := &MoversReport{}
+	if state.previous != nil && state.analysis == analysis && !query.Has("window") {
+		report.Basis, report.Since = "refresh", state.previousAt
+		report.Increases, report.Decreases = heatMoves(directoryValues(state.previous.Root, depth), directoryValues(analysis.Root, depth), limit)
+		writeJSON(w, report)
+		return
+	}
+	window := defaultDigestWindow
+	if value := query.Get("window"); value != "" {
+		var err error
+		if window, err = parseAge(value); err != nil || window <= 0 {
+			http.Error(w, "Invalid window '"+value+"' (expected e.g. 7d)", http.StatusBadRequest)
+			return
+		}
+	}
+	now := analysis.Meta.GeneratedAt
+	previous, recent := windowValues(analysis, window, depth, now)
+	report.Basis, report.Window, report.Since = "window", formatAge(window), now.Add(-window)
+	report.Increases, report.Decreases = heatMoves(previous, recent, limit)
+	writeJSON(w, report)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// notesRef holds the analysis summaries written with -notes
+const notesRef = "refs/notes/dirheat"
+
+// summaryTop is the number of directories and hotspots kept in a summary
+const summaryTop = 10
+
+// writeNotes is the -notes option: write a summary of every analysis as a git note
+var writeNotes bool
+
+// AnalysisSummary is the gist of an analysis, stored as git note on the analyzed commit
+type AnalysisSummary struct {
+	Repo        string            `json:"repo,omitempty"` // Portfolio mode: the repository's name, when listed
+	Revision    string            `json:"revision"`
+	ToolVersion string            `json:"toolVersion"`
+	GeneratedAt time.Time         `json:"generatedAt"`
+	Filters     map[string]string `json:"filters,omitempty"`
+	CommitCount int               `json:"commitCount"`
+	Value       int               `json:"value"`       // Total value of the tree
+	Directories []Hotspot         `json:"directories"` // Hottest top-level directories
+	Hotspots    []Hotspot         `json:"hotspots"`
+}
+
+// summarize returns the summary of the analysis
+func summarize(a *Analysis) *AnalysisSummary {
+	summary := &AnalysisSummary{
+		Revision:    a.Meta.Revision,
+		ToolVersion: a.Meta.ToolVersion,
+		GeneratedAt: a.Meta.GeneratedAt,
+		Filters:     a.Meta.Filters,
+		CommitCount: a.Meta.CommitCount,
+		Value:       a.Root.Value(),
+		Directories: []Hotspot{},
+	}
+	for child := range a.Root.Children() {
+		if !child.IsFile() && child.Value() > 0 {
+			summary.Directories = append(summary.Directories, Hotspot{Path: child.Name(), Value: child.Value()})
+		}
+	}
+	sort.Slice(summary.Directories, func(i, j int) bool {
+		if summary.Directories[i].Value != summary.Directories[j].Value {
+			return summary.Directories[i].Value > summary.Directories[j].Value
+		}
+		return summary.Directories[i].Path < summary.Directories[j].Path
+	})
+	summary.Directories = summary.Directories[:min(summaryTop, len(summary.Directories))]
+	hot := hotspots(a)
+	summary.Hotspots = hot[:min(summaryTop, len(hot))]
+	return summary
+}
+
+// writeSummaryNote stores the summary of the analysis as note on the analyzed commit, replacing an
+// earlier one. Without a configured git identity the note is committed as git-dirheat.
+func writeSummaryNote(ctx context.Context, a *Analysis) error {
+	if a.Meta.Revision == "" {
+		return nil // Nothing to attach the note to
+	}
+	data, err := json.MarshalIndent(summarize(a), "", "  ")
+	if err != nil {
+		return err
+	}
+	repo := a.Meta.RepoPath
+	cmd := gitCommand(ctx, repo, "notes", "--ref", notesRef, "add", "-f", "-F", "-", a.Meta.Revision)
+	if email, _ := gitOutput(ctx, repo, "config", "user.email"); email == "" {
+		cmd.Env = append(cmd.Env, "GIT_AUTHOR_NAME=git-dirheat", "GIT_AUTHOR_EMAIL=git-dirheat@localhost",
+			"GIT_COMMITTER_NAME=git-dirheat", "GIT_COMMITTER_EMAIL=git-dirheat@localhost")
+	}
+	cmd.Stdin = strings.NewReader(string(data) + "\n")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("error writing the summary note: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	slog.Info("Wrote the analysis summary as git note", "repo", repo, "ref", notesRef, "revision", a.Meta.Revision)
+	return nil
+}
+
+// readSummaryNotes returns the summaries stored in the repository, newest first
+func readSummaryNotes(ctx context.Context, repo string) ([]*AnalysisSummary, error) {
+	if _, err := gitOutput(ctx, repo, "rev-parse", "--verify", "--quiet", notesRef); err != nil {
+		return nil, nil // No notes yet
+	}
+	output, err := gitOutput(ctx, repo, "notes", "--ref", notesRef, "list")
+	if err != nil {
+		return nil, fmt.Errorf("error listing the summary notes: %w", err)
+	}
+	var notes []string
+	for _, line := range strings.Split(output, "\n") {
+		if note, _, ok := strings.Cut(line, " "); ok {
+			notes = append(notes, note)
+		}
+	}
+	contents, err := catFiles(ctx, repo, notes)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the summary notes: %w", err)
+	}
+	var summaries []*AnalysisSummary
+	for _, note := range notes {
+		summary := &AnalysisSummary{}
+		if err := json.Unmarshal(contents[note], summary); err != nil {
+			slog.Warn("Skipping a note that is no analysis summary", "repo", repo, "note", note)
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].GeneratedAt.After(summaries[j].GeneratedAt) })
+	return summaries, nil
+}
+
+// handleNotes serves GET /notes, the analysis summaries stored as git notes with -notes, newest first
+func handleNotes(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	repos := map[string]string{"": analysis.Meta.RepoPath}
+	if analysis.Repos != nil {
+		repos = analysis.Repos
+	}
+	summaries := []*AnalysisSummary{}
+	for name, repo := range repos {
+		found, err := readSummaryNotes(r.Context(), repo)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, summary := range found {
+			summary.Repo = name
+		}
+		summaries = append(summaries, found...)
+	}
+	sort.SliceStable(summaries, func(i, j int) bool { return summaries[i].GeneratedAt.After(summaries[j].GeneratedAt) })
+	writeJSON(w, summaries)
+}
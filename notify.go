@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// NotifyOptions configures the digest posted to a chat webhook after an analysis
+type NotifyOptions struct {
+	Webhook string        // Incoming webhook URL, empty disables notifications
+	Format  string        // slack, teams or auto (by the webhook host)
+	Window  time.Duration // Period the digest covers
+}
+
+// webhookFormat resolves the auto format from the webhook host
+func webhookFormat(opts NotifyOptions) (string, error) {
+	switch opts.Format {
+	case "slack", "teams":
+		return opts.Format, nil
+	case "auto", "":
+		u, err := url.Parse(opts.Webhook)
+		if err != nil || u.Host == "" {
+			return "", fmt.Errorf("invalid webhook URL '%s'", opts.Webhook)
+		}
+		if strings.HasSuffix(u.Host, ".office.com") || strings.Contains(u.Host, "logic.azure.com") {
+			return "teams", nil
+		}
+		return "slack", nil
+	}
+	return "", fmt.Errorf("unknown webhook format '%s', expected slack, teams or auto", opts.Format)
+}
+
+// webhookPayload returns the message body posting a Markdown-like text in the webhook's format;
+// markdown renders the text with the format's bold markup
+func webhookPayload(summary string, markdown func(bold func(string) string) string, format string) ([]byte, error) {
+	if format == "teams" {
+		return json.Marshal(map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  summary,
+			"text":     strings.ReplaceAll(markdown(func(s string) string { return "**" + s + "**" }), "\n", "\n\n"),
+		})
+	}
+	return json.Marshal(map[string]string{"text": markdown(func(s string) string { return "*" + s + "*" })})
+}
+
+// postWebhook posts a message to the configured webhook, returning its resolved format
+func postWebhook(ctx context.Context, opts NotifyOptions, summary string, markdown func(bold func(string) string) string) (string, error) {
+	format, err := webhookFormat(opts)
+	if err != nil {
+		return "", err
+	}
+	payload, err := webhookPayload(summary, markdown, format)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, opts.Webhook, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return format, nil
+}
+
+// notifyDigest posts the digest of the analysis to the configured webhook
+func notifyDigest(ctx context.Context, a *Analysis, opts NotifyOptions) error {
+	digest := buildDigest(a, opts.Window, time.Now())
+	format, err := postWebhook(ctx, opts, "git-dirheat digest for "+digest.Repo, digest.markdown)
+	if err != nil {
+		return fmt.Errorf("error posting the digest: %w", err)
+	}
+	slog.Info("Posted digest", "format", format, "repo", a.Root.Name)
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// expectedHash is the -expect-hash option: fail unless the analysis has this options hash
+var expectedHash string
+
+// OptionsMismatchError reports an analysis whose options hash isn't the expected one
+type OptionsMismatchError struct {
+	Expected, Actual string
+}
+
+func (e *OptionsMismatchError) Error() string {
+	return fmt.Sprintf("the analysis has options hash %s, expected %s", e.Actual, e.Expected)
+}
+
+// hashedSettings are the options shaping the views of an analysis without being filters of it:
+// the test and security patterns, the module map and the weight profiles
+func hashedSettings() []string {
+	lines := []string{
+		"testPatterns=" + strings.Join(fileCategories[0].patterns, ","),
+		"securityPaths=" + strings.Join(securityPatterns, ","),
+	}
+	for _, entry := range moduleMap {
+		lines = append(lines, "moduleMap="+entry.prefix+"=>"+entry.module)
+	}
+	for _, profile := range weightProfiles {
+		lines = append(lines, "weightProfile="+profile.name+":"+profile.spec)
+	}
+	return lines
+}
+
+// optionsHash returns the canonical hash of what an analysis or a response depends on besides the
+// history: the tool version, the revision range, the filters (mode, copy detection, case folding,
+// pull requests, normalization, the per-request options of a response, ...), the combined
+// repositories and the hashedSettings. Analyses with the same hash are comparable across runs,
+// whatever revision they analyzed and wherever the clone is.
+func optionsHash(meta Metadata) string {
+	lines := []string{"toolVersion=" + meta.ToolVersion, "revisionRange=" + meta.RevisionRange}
+	if meta.PartialClone != "" {
+		lines = append(lines, "partialClone="+meta.PartialClone)
+	}
+	filters := make([]string, 0, len(meta.Filters))
+	for key, value := range meta.Filters {
+		filters = append(filters, "filter."+key+"="+value)
+	}
+	sort.Strings(filters)
+	lines = append(lines, filters...)
+	for _, repo := range meta.Repos {
+		lines = append(lines, "repo="+repo.Name)
+	}
+	lines = append(lines, hashedSettings()...)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// checkOptionsHash returns an OptionsMismatchError when -expect-hash is set and the analysis has
+// another options hash
+func checkOptionsHash(a *Analysis) error {
+	if expectedHash != "" && !strings.EqualFold(a.Meta.OptionsHash, expectedHash) {
+		return &OptionsMismatchError{Expected: expectedHash, Actual: a.Meta.OptionsHash}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+// partialCloneFilter returns the object filter of a partial clone, e.g. "blob:none", or "" for a
+// complete clone. Objects left out by the filter are fetched from the promisor remote on demand.
+func partialCloneFilter(ctx context.Context, repo string) string {
+	// Older clones name the promisor remote in extensions.partialClone, newer ones mark it with
+	// remote.<name>.promisor
+	remote, _ := gitOutput(ctx, repo, "config", "--get", "extensions.partialClone")
+	if remote == "" {
+		promisors, _ := gitOutput(ctx, repo, "config", "--bool", "--get-regexp", `^remote\..*\.promisor$`)
+		for _, line := range strings.Split(promisors, "\n") {
+			if key, value, ok := strings.Cut(line, " "); ok && value == "true" {
+				remote = strings.TrimSuffix(strings.TrimPrefix(key, "remote."), ".promisor")
+				break
+			}
+		}
+	}
+	if remote == "" {
+		return ""
+	}
+	filter, _ := gitOutput(ctx, repo, "config", "--get", "remote."+remote+".partialCloneFilter")
+	if filter == "" {
+		return "unknown" // Promisor remote without a recorded filter, e.g. set up by hand
+	}
+	return filter
+}
+
+// changeListArgs returns the git log options listing the files changed by every commit. Line
+// counts, rename and copy detection need the file contents, which a partial clone fetches commit
+// by commit, so partial clones only list the file names.
+func changeListArgs(filter string) []string {
+	if filter != "" {
+		return []string{"--name-only", "--no-renames"}
+	}
+	if detectCopies {
+		return []string{"--numstat", "--raw", "-C"}
+	}
+	return []string{"--numstat"}
+}
+
+// prefetchBlobs downloads the blobs of a partial clone in batches before blame, which otherwise
+// fetches every version of every file one by one. git backfill exists since git 2.49.
+func prefetchBlobs(ctx context.Context, repo, filter string) {
+	slog.Warn("Blame mode on a partial clone needs the file contents, prefetching them", "repo", repo, "filter", filter)
+	if _, err := gitRun(ctx, repo, "backfill"); err != nil {
+		slog.Warn("Could not prefetch the blobs, blame fetches them on demand and may be slow; consider a full clone",
+			"error", err, "stderr", gitStderr(err))
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// Period is a named span of the team calendar, e.g. a quarter or a sprint
+type Period struct {
+	Name  string `json:"name"`
+	Start string `json:"start"` // YYYY-MM-DD, inclusive
+	End   string `json:"end"`   // YYYY-MM-DD, inclusive
+
+	from, until time.Time // until is the midnight after End
+}
+
+// calendarPeriods are the periods of -periods-file, calendar quarters without it
+var calendarPeriods []Period
+
+// contains reports whether the period contains the time
+func (p Period) contains(t time.Time) bool {
+	return !t.Before(p.from) && t.Before(p.until)
+}
+
+// loadPeriods reads a JSON list of periods. Periods may overlap, e.g. sprints within quarters.
+func loadPeriods(file string) ([]Period, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var periods []Period
+	if err := json.Unmarshal(data, &periods); err != nil {
+		return nil, fmt.Errorf("error parsing periods file '%s': %w", file, err)
+	}
+	for i := range periods {
+		period := &periods[i]
+		if period.Name == "" {
+			return nil, fmt.Errorf("error parsing periods file '%s': period %d has no name", file, i+1)
+		}
+		if period.from, err = time.Parse(time.DateOnly, period.Start); err != nil {
+			return nil, fmt.Errorf("error parsing periods file '%s': invalid start of '%s': %w", file, period.Name, err)
+		}
+		end, err := time.Parse(time.DateOnly, period.End)
+		if err != nil || end.Before(period.from) {
+			return nil, fmt.Errorf("error parsing periods file '%s': invalid end '%s' of '%s'", file, period.End, period.Name)
+		}
+		period.until = end.AddDate(0, 0, 1)
+	}
+	sort.SliceStable(periods, func(i, j int) bool { return periods[i].from.Before(periods[j].from) })
+	return periods, nil
+}
+
+// quarterPeriods returns the calendar quarters from the oldest to the newest commit, e.g. "2024-Q3"
+func quarterPeriods(commits []*Commit) []Period {
+	if len(commits) == 0 {
+		return []Period{}
+	}
+	oldest, newest := commits[len(commits)-1].Date, commits[0].Date
+	var periods []Period
+	for from := time.Date(oldest.Year(), (oldest.Month()-1)/3*3+1, 1, 0, 0, 0, 0, time.UTC); !from.After(newest); from = from.AddDate(0, 3, 0) {
+		until := from.AddDate(0, 3, 0)
+		periods = append(periods, Period{
+			Name:  fmt.Sprintf("%d-Q%d", from.Year(), (from.Month()-1)/3+1),
+			Start: from.Format(time.DateOnly),
+			End:   until.AddDate(0, 0, -1).Format(time.DateOnly),
+			from:  from,
+			until: until,
+		})
+	}
+	return periods
+}
+
+// PeriodRow is the churn of a directory per period
+type PeriodRow struct {
+	Path   string `json:"path"`
+	Values []int  `json:"values"` // File changes per period, in the order of the periods
+	Total  int    `json:"total"`  // File changes within any of the periods
+}
+
+// PeriodPivot is the churn per period and directory, as served on /periods
+type PeriodPivot struct {
+	Periods []Period    `json:"periods"`
+	Totals  []int       `json:"totals"` // File changes of the whole repository per period
+	Rows    []PeriodRow `json:"rows"`
+}
+
+// periodPivot aggregates the file changes per period of every directory up to depth levels. The
+// rows are ordered by the changes within the period at sortBy, or by their total for -1.
+func periodPivot(a *Analysis, periods []Period, depth, sortBy int) *PeriodPivot {
+	pivot := &PeriodPivot{Periods: periods, Totals: make([]int, len(periods)), Rows: []PeriodRow{}}
+	values := make([]*weightedCounts, len(periods)) // Per period, by directory ("" for the total)
+	for i := range values {
+		values[i] = newWeightedCounts()
+	}
+	for _, commit := range a.Commits {
+		for i, period := range periods {
+			if !period.contains(commit.Date) {
+				continue
+			}
+			values[i].add("", len(commit.Files), commit.Weight)
+			for _, file := range commit.Files {
+				for _, dir := range directoriesOf(file.Path, depth) {
+					values[i].add(dir, 1, commit.Weight)
+				}
+			}
+		}
+	}
+	rows := make(map[string]*PeriodRow)
+	for i, counts := range values {
+		for dir, value := range counts.result() {
+			if dir == "" {
+				pivot.Totals[i] = value
+				continue
+			}
+			row := rows[dir]
+			if row == nil {
+				row = &PeriodRow{Path: dir, Values: make([]int, len(periods))}
+				rows[dir] = row
+			}
+			row.Values[i] = value
+		}
+	}
+
+	for _, row := range rows {
+		for _, value := range row.Values {
+			row.Total += value
+		}
+		pivot.Rows = append(pivot.Rows, *row)
+	}
+	key := func(row PeriodRow) int {
+		if sortBy < 0 {
+			return row.Total
+		}
+		return row.Values[sortBy]
+	}
+	sort.Slice(pivot.Rows, func(i, j int) bool {
+		if key(pivot.Rows[i]) != key(pivot.Rows[j]) {
+			return key(pivot.Rows[i]) > key(pivot.Rows[j])
+		}
+		return pivot.Rows[i].Path < pivot.Rows[j].Path
+	})
+	return pivot
+}
+
+// handlePeriods serves GET /periods?depth=2&sort=2024-Q3, the churn per calendar period and
+// directory, ordered by the changes within the sort period (default: the total)
+func handlePeriods(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	depth, ok := authorDepth(w, r)
+	if !ok {
+		return
+	}
+	periods := calendarPeriods
+	if periods == nil {
+		periods = quarterPeriods(analysis.Commits)
+	}
+	sortBy := -1
+	if name := r.URL.Query().Get("sort"); name != "" {
+		for i, period := range periods {
+			if period.Name == name {
+				sortBy = i
+			}
+		}
+		if sortBy < 0 {
+			http.Error(w, fmt.Sprintf("Unknown period '%s'", name), http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, periodPivot(analysis, periods, depth, sortBy))
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// portfolioRootName names the synthetic root combining several repositories
+const portfolioRootName = "portfolio"
+
+// RepoInfo describes one repository of a portfolio analysis
+type RepoInfo struct {
+	Name        string       `json:"name"` // First path segment of the repository's nodes
+	RepoPath    string       `json:"repoPath"`
+	Branch      string       `json:"branch,omitempty"`
+	Revision    string       `json:"revision,omitempty"`
+	CommitCount int          `json:"commitCount"`
+	Value       int          `json:"value"` // Value before normalization
+	Shallow     *ShallowInfo `json:"shallow,omitempty"`
+}
+
+// repoFor returns the repository holding the slash separated tree path and the path within it
+func (a *Analysis) repoFor(path string) (repo, relPath string) {
+	if a.Repos == nil {
+		return a.Meta.RepoPath, path
+	}
+	name, rest, _ := strings.Cut(path, "/")
+	return a.Repos[name], rest
+}
+
+// portfolioNames derives unique first path segments from the repository directory names
+func portfolioNames(analyses []*Analysis) []string {
+	names := make([]string, len(analyses))
+	used := make(map[string]int)
+	for i, analysis := range analyses {
+		name := repoName(analysis.Meta.RepoPath)
+		used[name]++
+		if used[name] > 1 {
+			name = fmt.Sprintf("%s-%d", name, used[name])
+		}
+		names[i] = name
+	}
+	return names
+}
+
+// normalizer returns the function scaling a repository's file values for the normalization mode:
+// "none" keeps raw values, "share" scales every repository to a total of 10000 and "commits"
+// expresses values per 1000 commits of the repository
+func normalizer(mode string, total, commits int) (func(int) int, error) {
+	scaled := func(factor float64) func(int) int {
+		return func(value int) int {
+			if value <= 0 {
+				return 0
+			}
+			return max(1, int(math.Round(float64(value)*factor)))
+		}
+	}
+	switch mode {
+	case "", "none":
+		return func(value int) int { return value }, nil
+	case "share":
+		return scaled(10000 / float64(max(total, 1))), nil
+	case "commits":
+		return scaled(1000 / float64(max(commits, 1))), nil
+	}
+	return nil, fmt.Errorf("unknown normalization '%s' (expected none, share or commits)", mode)
+}
+
+// graft builds a tree with the given trees below its root, each named after its repository and
+// with its file values scaled
+func graft(rootName string, repos []string, roots []Node, scales []func(int) int) Node {
+	type source struct {
+		node  Node
+		scale func(int) int
+	}
+	order := make([]int, len(repos))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return repos[order[i]] < repos[order[j]] })
+
+	b := newTreeBuilder(rootName)
+	sources := []source{{}} // Of the nodes added so far, copied breadth first
+	for _, i := range order {
+		b.add(0, repos[i], 0, 0)
+		sources = append(sources, source{roots[i], scales[i]})
+	}
+	for parent := int32(1); int(parent) < len(sources); parent++ {
+		src := sources[parent]
+		for child := range src.node.Children() { // Already in name order
+			flags, value := uint8(0), 0
+			if child.IsFile() {
+				flags, value = nodeFile, src.scale(child.Value())
+			}
+			if child.Symlink() {
+				flags |= nodeSymlink
+			}
+			b.add(parent, child.Name(), flags, value)
+			sources = append(sources, source{child, src.scale})
+		}
+	}
+	return b.root()
+}
+
+// combinePortfolio merges per-repository analyses under a synthetic root, with each repository's
+// name as the first path segment
+func combinePortfolio(analyses []*Analysis, normalize string) (*Analysis, error) {
+	combined := &Analysis{Repos: make(map[string]string)}
+	combined.Meta = Metadata{
+		ToolVersion:   version,
+		RevisionRange: analysisRev,
+		Filters:       map[string]string{"merges": "excluded"},
+		GeneratedAt:   time.Now().UTC(),
+	}
+	if normalize != "" && normalize != "none" {
+		combined.Meta.Filters["normalize"] = normalize
+	}
+
+	revisions := make([]string, 0, len(analyses))
+	names := portfolioNames(analyses)
+	roots, scales := make([]Node, len(analyses)), make([]func(int) int, len(analyses))
+	for i, name := range names {
+		analysis := analyses[i]
+		scale, err := normalizer(normalize, analysis.Root.Value(), analysis.Meta.CommitCount)
+		if err != nil {
+			return nil, err
+		}
+		roots[i], scales[i] = analysis.Root, scale
+
+		combined.Repos[name] = analysis.Meta.RepoPath
+		combined.Meta.Repos = append(combined.Meta.Repos, RepoInfo{
+			Name:        name,
+			RepoPath:    analysis.Meta.RepoPath,
+			Branch:      analysis.Meta.Branch,
+			Revision:    analysis.Meta.Revision,
+			CommitCount: analysis.Meta.CommitCount,
+			Shallow:     analysis.Meta.Shallow,
+			Value:       analysis.Root.Value(),
+		})
+		combined.Meta.CommitCount += analysis.Meta.CommitCount
+		for key, value := range analysis.Meta.Filters {
+			combined.Meta.Filters[key] = value
+		}
+		revisions = append(revisions, analysis.Meta.Revision)
+
+		for _, commit := range analysis.Commits {
+			prefixed := *commit
+			prefixed.Files = make([]FileChange, len(commit.Files))
+			for j, file := range commit.Files {
+				file.Path = name + "/" + file.Path
+				prefixed.Files[j] = file
+			}
+			prefixed.Copies = make([]FileCopy, len(commit.Copies))
+			for j, copied := range commit.Copies {
+				prefixed.Copies[j] = FileCopy{From: name + "/" + copied.From, To: name + "/" + copied.To}
+			}
+			combined.Commits = append(combined.Commits, &prefixed)
+		}
+	}
+	combined.Root = graft(portfolioRootName, names, roots, scales)
+	combined.Root.aggregateCounts()
+
+	// A single revision identifies the combined state, e.g. for entity tags
+	sum := sha1.Sum([]byte(strings.Join(revisions, ",")))
+	combined.Meta.Revision = hex.EncodeToString(sum[:])
+	sort.SliceStable(combined.Commits, func(i, j int) bool {
+		return combined.Commits[i].Date.After(combined.Commits[j].Date)
+	})
+	combined.Snapshots = buildSnapshots(portfolioRootName, combined.Commits)
+	return combined, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+// phaseProfile accumulates the wall time spent per analysis phase
+type phaseProfile struct {
+	mu     sync.Mutex
+	order  []string
+	totals map[string]time.Duration
+	counts map[string]int
+}
+
+type profileKey struct{}
+
+// withProfile returns a context whose analysis phases are timed into p
+func withProfile(ctx context.Context, p *phaseProfile) context.Context {
+	return context.WithValue(ctx, profileKey{}, p)
+}
+
+// startPhase starts timing a phase when ctx carries a profile (and reports it when ctx carries
+// progress); the returned func ends it.
+// Phases running concurrently (e.g. git blame workers) add up their individual times.
+func startPhase(ctx context.Context, phase string) func() {
+	reportPhase(ctx, phase)
+	p, ok := ctx.Value(profileKey{}).(*phaseProfile)
+	if !ok {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		elapsed := time.Since(start)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if _, seen := p.totals[phase]; !seen {
+			p.order = append(p.order, phase)
+		}
+		p.totals[phase] += elapsed
+		p.counts[phase]++
+	}
+}
+
+// newPhaseProfile creates an empty profile
+func newPhaseProfile() *phaseProfile {
+	return &phaseProfile{totals: make(map[string]time.Duration), counts: make(map[string]int)}
+}
+
+// report logs the time of every phase in the order they first ran
+func (p *phaseProfile) report() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, phase := range p.order {
+		slog.Info("Profile", "phase", phase, "time", p.totals[phase].Round(time.Microsecond).String(), "runs", p.counts[phase])
+	}
+}
+
+// startPprof starts a CPU profile written to dir, the returned func stops it and writes a heap
+// profile next to it
+func startPprof(dir string) (func(), error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	cpuFile, err := os.Create(filepath.Join(dir, "cpu.pprof"))
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(cpuFile); err != nil {
+		cpuFile.Close()
+		return nil, fmt.Errorf("error starting CPU profile: %w", err)
+	}
+	return func() {
+		pprof.StopCPUProfile()
+		cpuFile.Close()
+		heapFile, err := os.Create(filepath.Join(dir, "heap.pprof"))
+		if err != nil {
+			slog.Warn("Could not write heap profile", "error", err)
+			return
+		}
+		defer heapFile.Close()
+		runtime.GC() // Up-to-date statistics of the live heap
+		if err := pprof.WriteHeapProfile(heapFile); err != nil {
+			slog.Warn("Could not write heap profile", "error", err)
+		}
+		slog.Info("Wrote pprof profiles", "dir", dir)
+	}, nil
+}
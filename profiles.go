@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// profileName matches the name of a weight profile, as passed to /data?profile=
+var profileName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// weightProfile is a named view of the history, from -weight-profile: which commits count and how much
+type weightProfile struct {
+	name, spec string
+	lines      bool           // weight=lines: count the lines changed instead of the file changes
+	window     time.Duration  // Only commits within this age, 0 for all
+	message    *regexp.Regexp // Only commits whose subject matches
+	trailer    *trailerFilter // Only commits passing the trailer filter
+	author     string         // Only commits whose author name or email contains this, lowercase
+}
+
+// weightProfiles are the profiles of -weight-profile, computed along with every analysis
+var weightProfiles []weightProfile
+
+// parseProfile parses a profile like "recent-lines:weight=lines,window=90d" or
+// "bugfix-only:message=(?i)^fix"
+func parseProfile(value string) (weightProfile, error) {
+	name, spec, ok := strings.Cut(value, ":")
+	profile := weightProfile{name: strings.TrimSpace(name), spec: strings.TrimSpace(spec)}
+	if !ok || !profileName.MatchString(profile.name) {
+		return profile, fmt.Errorf("invalid profile '%s' (expected e.g. recent-lines:weight=lines,window=90d)", value)
+	}
+	for _, setting := range splitParam(spec) {
+		key, setting, _ := strings.Cut(setting, "=")
+		var err error
+		switch key {
+		case "weight":
+			if setting != "changes" && setting != "lines" {
+				err = fmt.Errorf("unknown weight '%s' (expected changes or lines)", setting)
+			}
+			profile.lines = setting == "lines"
+		case "window":
+			profile.window, err = parseAge(setting)
+			if err == nil && profile.window <= 0 {
+				err = fmt.Errorf("invalid window '%s'", setting)
+			}
+		case "message":
+			profile.message, err = regexp.Compile(setting)
+		case "trailer":
+			var filter trailerFilter
+			filter, err = parseTrailerFilter(setting)
+			profile.trailer = &filter
+		case "author":
+			profile.author = strings.ToLower(setting)
+		default:
+			err = fmt.Errorf("unknown setting '%s' (expected weight, window, message, trailer or author)", key)
+		}
+		if err != nil {
+			return profile, fmt.Errorf("invalid profile '%s': %w", profile.name, err)
+		}
+	}
+	return profile, nil
+}
+
+// matches reports whether the commit counts for the profile, given the time of the analysis
+func (p weightProfile) matches(c *Commit, now time.Time) bool {
+	switch {
+	case p.window > 0 && now.Sub(c.Date) > p.window:
+		return false
+	case p.message != nil && !p.message.MatchString(c.Subject):
+		return false
+	case p.trailer != nil && !p.trailer.matches(c):
+		return false
+	case p.author != "" && !strings.Contains(strings.ToLower(c.Author), p.author) && !strings.Contains(strings.ToLower(c.Email), p.author):
+		return false
+	}
+	return true
+}
+
+// profileAnalyses computes the trees of all profiles in one pass over the commits of the analysis
+func profileAnalyses(a *Analysis, profiles []weightProfile) map[string]*Analysis {
+	if len(profiles) == 0 {
+		return nil
+	}
+	views := make([]*profileValues, len(profiles))
+	collectors := make([]collector, len(profiles))
+	for i, profile := range profiles {
+		views[i] = &profileValues{profile: profile, now: a.Meta.GeneratedAt, values: newWeightedCounts()}
+		collectors[i] = views[i]
+	}
+	replay(a.Commits, collectors...)
+
+	analyses := make(map[string]*Analysis, len(profiles))
+	for _, view := range views {
+		root := populateTree(a.Root.Name(), view.values.result())
+		root.aggregateCounts()
+		meta := a.Meta
+		meta.CommitCount = len(view.commits)
+		meta.Filters = make(map[string]string)
+		for key, value := range a.Meta.Filters {
+			if !strings.HasPrefix(key, "blame") {
+				meta.Filters[key] = value
+			}
+		}
+		// Profiles weigh the commits whatever the mode of the analysis
+		meta.Filters["mode"], meta.Filters["profile"], meta.Filters["profileSpec"] = "churn", view.profile.name, view.profile.spec
+		meta.OptionsHash = optionsHash(meta)
+		analyses[view.profile.name] = &Analysis{Root: root, Meta: meta, Commits: view.commits, Repos: a.Repos}
+	}
+	return analyses
+}
+
+// ProfileInfo describes a weight profile, as listed on /profiles
+type ProfileInfo struct {
+	Name        string `json:"name"`
+	Spec        string `json:"spec"`
+	CommitCount int    `json:"commitCount"` // Commits counting for the profile
+	Value       int    `json:"value"`       // Value of the root
+}
+
+// handleProfiles serves GET /profiles, the weight profiles selectable with /data?profile=
+func handleProfiles(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	list := []ProfileInfo{}
+	for _, profile := range weightProfiles {
+		if tree := analysis.profiles[profile.name]; tree != nil {
+			list = append(list, ProfileInfo{Name: profile.name, Spec: profile.spec, CommitCount: tree.Meta.CommitCount, Value: tree.Root.Value()})
+		}
+	}
+	writeJSON(w, list)
+}
+
+// handleProfileData serves GET /data?profile=recent-lines, the tree of a weight profile computed
+// along with the analysis. It takes the /data query parameters.
+func handleProfileData(w http.ResponseWriter, r *http.Request, a *Analysis) {
+	name := r.URL.Query().Get("profile")
+	tree := a.profiles[name]
+	if tree == nil {
+		names := make([]string, 0, len(a.profiles))
+		for profile := range a.profiles {
+			names = append(names, profile)
+		}
+		sort.Strings(names)
+		http.Error(w, fmt.Sprintf("Unknown profile '%s' (profiles: %s)", name, strings.Join(names, ", ")), http.StatusNotFound)
+		return
+	}
+	writeCommitData(w, r, tree, map[string]string{"profile": name})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// progressFormat is the -progress option: "json" writes progress events to standard error
+var progressFormat string
+
+// ProgressEvent is a line written by -progress json
+type ProgressEvent struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`             // analysisStarted, phase, analysisFinished, analysisFailed or listening
+	Phase   string    `json:"phase,omitempty"`   // phase: e.g. git log, parse, tree build
+	Commits int       `json:"commits,omitempty"` // analysisFinished
+	Value   int       `json:"value,omitempty"`   // analysisFinished: total churn
+	Elapsed int64     `json:"elapsedMs,omitempty"`
+	Error   string    `json:"error,omitempty"` // analysisFailed
+	URL     string    `json:"url,omitempty"`   // listening
+}
+
+// progressOutput serializes the progress events, nil without -progress
+var progressOutput struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// setupProgress directs the progress events to w in the format (json, or "" for none)
+func setupProgress(w io.Writer, format string) {
+	if format == "json" {
+		progressOutput.encoder = json.NewEncoder(w)
+	}
+}
+
+// emitProgress writes a progress event when -progress is on
+func emitProgress(event ProgressEvent) {
+	progressOutput.mu.Lock()
+	defer progressOutput.mu.Unlock()
+	if progressOutput.encoder == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+	progressOutput.encoder.Encode(event)
+}
+
+type progressKey struct{}
+
+// progressPhases remembers the phases of an analysis already reported
+type progressPhases struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+// withProgress returns a context whose analysis phases are reported as progress events, each
+// the first time it starts. Ad-hoc analyses don't carry it, so they don't mix into the events.
+func withProgress(ctx context.Context) context.Context {
+	if progressOutput.encoder == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, progressKey{}, &progressPhases{seen: make(map[string]bool)})
+}
+
+// reportPhase emits a phase event when ctx carries progress and the phase is new to it
+func reportPhase(ctx context.Context, phase string) {
+	phases, ok := ctx.Value(progressKey{}).(*progressPhases)
+	if !ok {
+		return
+	}
+	phases.mu.Lock()
+	seen := phases.seen[phase]
+	phases.seen[phase] = true
+	phases.mu.Unlock()
+	if !seen {
+		emitProgress(ProgressEvent{Event: "phase", Phase: phase})
+	}
+}
+
+// analyzeWithProgress runs analyze, framed by analysisStarted and analysisFinished or
+// analysisFailed events
+func analyzeWithProgress(ctx context.Context, paths []string) (*Analysis, error) {
+	start := time.Now()
+	emitProgress(ProgressEvent{Event: "analysisStarted"})
+	analysis, err := analyze(withProgress(ctx), paths)
+	elapsed := time.Since(start).Milliseconds()
+	if err != nil {
+		emitProgress(ProgressEvent{Event: "analysisFailed", Error: err.Error(), Elapsed: elapsed})
+	} else if analysis != nil {
+		emitProgress(ProgressEvent{Event: "analysisFinished", Commits: analysis.Meta.CommitCount, Value: analysis.Root.Value(), Elapsed: elapsed})
+	}
+	return analysis, err
+}
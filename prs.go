@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPRRange bounds the pull requests of -prs, each costs at least two API requests
+const maxPRRange = 1000
+
+// PROptions configures restricting the analysis to the commits of a range of merged pull requests
+type PROptions struct {
+	From, To int    // Inclusive pull (merge) request numbers, 0 disables the restriction
+	API      string // API base URL, derived from the origin remote by default
+	Token    string // GitHub or GitLab token, from DIRHEAT_GITHUB_TOKEN or DIRHEAT_GITLAB_TOKEN
+}
+
+var prOptions PROptions
+
+// parsePRRange parses a pull request range like 1200..1300 (or a single number)
+func parsePRRange(value string) (from, to int, err error) {
+	first, last, isRange := strings.Cut(value, "..")
+	if !isRange {
+		last = first
+	}
+	from, err = strconv.Atoi(first)
+	if err == nil {
+		to, err = strconv.Atoi(last)
+	}
+	if err != nil || from <= 0 || to < from {
+		return 0, 0, fmt.Errorf("invalid pull request range '%s' (expected e.g. 1200..1300)", value)
+	}
+	if to-from+1 > maxPRRange {
+		return 0, 0, fmt.Errorf("pull request range '%s' is too large (at most %d pull requests)", value, maxPRRange)
+	}
+	return from, to, nil
+}
+
+// prHost is the hosting service of a repository and the API paths of its pull requests
+type prHost struct {
+	gitlab  bool
+	api     string // API base URL
+	project string // owner/repo on GitHub, the URL-escaped project path on GitLab
+}
+
+// remoteHost derives the hosting service from the origin remote, e.g. git@github.com:acme/shop.git
+// or https://gitlab.example.com/group/shop.git. Hosts with "gitlab" in their name are GitLab
+// instances, all others GitHub; apiURL overrides the API base URL, e.g. for GitHub Enterprise.
+func remoteHost(ctx context.Context, repo, apiURL string) (*prHost, error) {
+	remote, err := gitOutput(ctx, repo, "remote", "get-url", "origin")
+	if err != nil {
+		return nil, fmt.Errorf("error reading the origin remote: %w", err)
+	}
+	host, project := "", ""
+	if parsed, err := url.Parse(remote); err == nil && parsed.Host != "" {
+		host, project = parsed.Hostname(), parsed.Path
+	} else if user, rest, ok := strings.Cut(remote, "@"); ok && !strings.Contains(user, "/") {
+		host, project, _ = strings.Cut(rest, ":") // scp-like SSH syntax
+	}
+	project = strings.TrimSuffix(strings.Trim(project, "/"), ".git")
+	if host == "" || !strings.Contains(project, "/") {
+		return nil, fmt.Errorf("origin remote '%s' is not a GitHub or GitLab repository", remote)
+	}
+
+	if strings.Contains(host, "gitlab") {
+		if apiURL == "" {
+			apiURL = "https://" + host + "/api/v4"
+		}
+		return &prHost{gitlab: true, api: strings.TrimRight(apiURL, "/"), project: url.PathEscape(project)}, nil
+	}
+	if apiURL == "" {
+		apiURL = "https://api.github.com"
+		if host != "github.com" {
+			apiURL = "https://" + host + "/api/v3" // GitHub Enterprise Server
+		}
+	}
+	return &prHost{api: strings.TrimRight(apiURL, "/"), project: project}, nil
+}
+
+// prGet fetches a JSON API resource, reporting false for resources that don't exist
+func prGet(ctx context.Context, apiURL, token string, v any) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return false, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return false, fmt.Errorf("%s answered %s: %s", req.URL.Host, resp.Status, strings.TrimSpace(string(detail)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return false, fmt.Errorf("error parsing the %s response: %w", req.URL.Host, err)
+	}
+	return true, nil
+}
+
+// mergedCommits returns the commits of a merged pull request: those of its branch plus the commit
+// that landed it, which is the only one on the target branch for squash merges. Numbers of open or
+// closed pull requests, or of issues, give none.
+func (h *prHost) mergedCommits(ctx context.Context, number int, token string) ([]string, error) {
+	var pull struct {
+		MergedAt       *time.Time `json:"merged_at"`        // GitHub
+		State          string     `json:"state"`            // GitLab
+		MergeCommitSHA string     `json:"merge_commit_sha"` // Both
+		SquashSHA      string     `json:"squash_commit_sha"`
+	}
+	path := fmt.Sprintf("%s/repos/%s/pulls/%d", h.api, h.project, number)
+	if h.gitlab {
+		path = fmt.Sprintf("%s/projects/%s/merge_requests/%d", h.api, h.project, number)
+	}
+	if found, err := prGet(ctx, path, token, &pull); err != nil || !found {
+		return nil, err
+	}
+	if (h.gitlab && pull.State != "merged") || (!h.gitlab && pull.MergedAt == nil) {
+		return nil, nil
+	}
+
+	hashes := []string{}
+	for _, hash := range []string{pull.MergeCommitSHA, pull.SquashSHA} {
+		if hash != "" {
+			hashes = append(hashes, hash)
+		}
+	}
+	for page := 1; ; page++ {
+		var commits []struct {
+			SHA string `json:"sha"` // GitHub
+			ID  string `json:"id"`  // GitLab
+		}
+		if _, err := prGet(ctx, fmt.Sprintf("%s/commits?per_page=100&page=%d", path, page), token, &commits); err != nil {
+			return nil, err
+		}
+		for _, commit := range commits {
+			hashes = append(hashes, commit.SHA+commit.ID)
+		}
+		if len(commits) < 100 {
+			return hashes, nil
+		}
+	}
+}
+
+// restrictToPRs reduces the analysis to the commits of the merged pull requests of the range,
+// rebuilding the tree and snapshots from them. Rebase merges land rewritten commits, of which only
+// the last one is known to the API.
+func restrictToPRs(ctx context.Context, a *Analysis, opts PROptions) error {
+	repo := a.Meta.RepoPath
+	host, err := remoteHost(ctx, repo, opts.API)
+	if err != nil {
+		return err
+	}
+	included := make(map[string]bool)
+	merged := 0
+	for number := opts.From; number <= opts.To; number++ {
+		hashes, err := host.mergedCommits(ctx, number, opts.Token)
+		if err != nil {
+			return fmt.Errorf("error reading pull request %d: %w", number, err)
+		}
+		if len(hashes) > 0 {
+			merged++
+		}
+		for _, hash := range hashes {
+			included[hash] = true
+		}
+	}
+
+	var commits []*Commit
+	values := newWeightedCounts()
+	for _, commit := range a.Commits {
+		if !included[commit.Hash] {
+			continue
+		}
+		commits = append(commits, commit)
+		for _, file := range commit.Files {
+			values.add(file.Path, 1, commit.Weight)
+		}
+	}
+	slog.Info("Restricted the analysis to merged pull requests", "repo", repo, "range", fmt.Sprintf("%d..%d", opts.From, opts.To), "merged", merged, "commits", len(commits))
+
+	a.Root = buildTree(ctx, repo, values.result())
+	if err := markSymlinks(ctx, repo, analysisRev, a.Root); err != nil {
+		return err
+	}
+	a.Commits = commits
+	a.Snapshots = buildSnapshots(repo, commits)
+	a.Meta.CommitCount = len(commits)
+	a.Meta.Filters["prs"] = fmt.Sprintf("%d..%d", opts.From, opts.To)
+	return nil
+}
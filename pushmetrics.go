@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// PushOptions configures a push of the hotspot metrics to a Prometheus pushgateway
+type PushOptions struct {
+	Gateway string        // Base URL of the pushgateway, e.g. http://pushgateway:9091
+	Job     string        // Job label of the pushed group
+	Window  time.Duration // Window of the recent churn metric
+}
+
+// directoryMetrics are the metrics of one top-level directory
+type directoryMetrics struct {
+	churn        int
+	recentChurn  int
+	hotspotFiles int
+	hotspotValue int
+}
+
+// topLevel returns the first path segment, "." for files in the repository root
+func topLevel(path string) string {
+	if dir, _, ok := strings.Cut(path, "/"); ok {
+		return dir
+	}
+	return "."
+}
+
+// prometheusLabel escapes a label value for the Prometheus text format
+func prometheusLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatMetrics renders the per-top-level-directory metrics of the analysis in the Prometheus text
+// exposition format
+func formatMetrics(a *Analysis, window time.Duration, now time.Time) []byte {
+	dirs := make(map[string]*directoryMetrics)
+	metrics := func(path string) *directoryMetrics {
+		dir := topLevel(path)
+		if dirs[dir] == nil {
+			dirs[dir] = &directoryMetrics{}
+		}
+		return dirs[dir]
+	}
+	for child := range a.Root.Children() {
+		if child.Value() > 0 {
+			metrics(child.Name()).churn = child.Value()
+		}
+	}
+	totalHotspotValue := 0
+	for _, spot := range hotspots(a) {
+		m := metrics(spot.Path)
+		m.hotspotFiles++
+		m.hotspotValue += spot.Value
+		totalHotspotValue += spot.Value
+	}
+	recentCommits := 0
+	recentChurn := newWeightedCounts()
+	for _, commit := range a.Commits {
+		if now.Sub(commit.Date) > window {
+			break // Newest first
+		}
+		recentCommits++
+		for _, file := range commit.Files {
+			recentChurn.add(topLevel(file.Path), 1, commit.Weight)
+		}
+	}
+	for dir, churn := range recentChurn.result() {
+		metrics(dir).recentChurn = churn
+	}
+
+	names := make([]string, 0, len(dirs))
+	for name := range dirs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# HELP dirheat_commits Commits of the analyzed history.\n# TYPE dirheat_commits gauge\ndirheat_commits %d\n", a.Meta.CommitCount)
+	fmt.Fprintf(&b, "# HELP dirheat_recent_commits Commits within the recent window.\n# TYPE dirheat_recent_commits gauge\ndirheat_recent_commits{window=%q} %d\n", window.String(), recentCommits)
+	gauge := func(name, help string, value func(m *directoryMetrics) string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, dir := range names {
+			fmt.Fprintf(&b, "%s{directory=\"%s\"} %s\n", name, prometheusLabel(dir), value(dirs[dir]))
+		}
+	}
+	gauge("dirheat_churn", "Heat of the top-level directory (changes, or surviving lines in blame mode).",
+		func(m *directoryMetrics) string { return fmt.Sprint(m.churn) })
+	gauge("dirheat_recent_churn", "File changes within the recent window.",
+		func(m *directoryMetrics) string { return fmt.Sprint(m.recentChurn) })
+	gauge("dirheat_hotspot_files", "Hotspot files (the hottest 5% of the changed files) in the directory.",
+		func(m *directoryMetrics) string { return fmt.Sprint(m.hotspotFiles) })
+	gauge("dirheat_hotspot_score", "Share (0-1) of the hotspot heat located in the directory.",
+		func(m *directoryMetrics) string {
+			if totalHotspotValue == 0 {
+				return "0"
+			}
+			return fmt.Sprint(float64(m.hotspotValue) / float64(totalHotspotValue))
+		})
+	return b.Bytes()
+}
+
+// pushMetrics replaces the metrics group of the repository on the pushgateway
+func pushMetrics(ctx context.Context, a *Analysis, opts PushOptions) error {
+	target := strings.TrimRight(opts.Gateway, "/") + "/metrics/job/" + url.PathEscape(opts.Job) +
+		"/repo/" + url.PathEscape(a.Root.Name())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(formatMetrics(a, opts.Window, time.Now())))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return fmt.Errorf("error pushing metrics: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("error pushing metrics: pushgateway answered %s", resp.Status)
+	}
+	slog.Info("Pushed metrics", "gateway", opts.Gateway, "job", opts.Job, "repo", a.Root.Name)
+	return nil
+}
+
+// runPushMetrics implements the push-metrics command
+func runPushMetrics(args []string) int {
+	flags := flag.NewFlagSet("push-metrics", flag.ContinueOnError)
+	var opts PushOptions
+	flags.StringVar(&opts.Gateway, "gateway", "", "base URL of the Prometheus pushgateway, e.g. http://pushgateway:9091 (required)")
+	flags.StringVar(&opts.Job, "job", "git-dirheat", "job label of the pushed metrics")
+	window := flags.String("window", "7d", "window of the recent churn metrics, e.g. 7d or 24h")
+	flags.StringVar(&expectedHash, "expect-hash", "", "fail (exit code 5) unless the analysis has this options hash")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s push-metrics [options] <repo> [more_repos...]\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Analyzes the repositories and pushes per-top-level-directory churn and hotspot metrics to a pushgateway.")
+		flags.PrintDefaults()
+	}
+	parseArgs(flags, args)
+	var err error
+	if opts.Window, err = parseAge(*window); err != nil || opts.Window <= 0 || opts.Gateway == "" || flags.NArg() < 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	code := 0
+	for _, path := range flags.Args() {
+		analysis, err := analyze(ctx, []string{path})
+		if err == nil {
+			err = pushMetrics(ctx, analysis, opts)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error pushing metrics of '%s': %v\n", path, err)
+			code = exitCode(err)
+		}
+	}
+	return code
+}
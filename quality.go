@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"strings"
+)
+
+// QualityOverlay is the code quality of a node: the imported open issues below it and how hot and
+// dirty it is
+type QualityOverlay struct {
+	Issues int `json:"issues"`
+	// Risk is the geometric mean of the node's share of the churn and its share of the issues, in
+	// percent: 100 for the root, high for nodes that are both hot and dirty
+	Risk float64 `json:"risk"`
+}
+
+// sonarExport is the issue list of SonarQube's api/issues/search and its exports
+type sonarExport struct {
+	Issues []struct {
+		Component string `json:"component"` // projectKey:path/to/file
+		Status    string `json:"status"`
+	} `json:"issues"`
+}
+
+// codeClimateIssue is an issue of the Code Climate engine spec, as written by codeclimate analyze -f json
+type codeClimateIssue struct {
+	Type     string `json:"type"`
+	Location struct {
+		Path string `json:"path"`
+	} `json:"location"`
+}
+
+// issuePath normalizes a file path of a report to the slash separated tree path
+func issuePath(file string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(file, `\`, "/")), "/")
+}
+
+// loadQualityIssues reads a SonarQube or Code Climate JSON export and returns the number of open
+// issues per file and per directory, the total under ""
+func loadQualityIssues(file string) (map[string]int, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(data, []byte("{")) && bytes.Contains(data, []byte(`"issues"`)):
+		var export sonarExport
+		if err := json.Unmarshal(data, &export); err != nil {
+			return nil, fmt.Errorf("error parsing SonarQube export '%s': %w", file, err)
+		}
+		for _, issue := range export.Issues {
+			if issue.Status == "CLOSED" || issue.Status == "RESOLVED" {
+				continue
+			}
+			if _, filePath, ok := strings.Cut(issue.Component, ":"); ok {
+				files = append(files, filePath)
+			}
+		}
+	default:
+		// Code Climate: a JSON array, or the engine output of objects separated by NUL or newlines
+		var issues []codeClimateIssue
+		if bytes.HasPrefix(data, []byte("[")) {
+			if err := json.Unmarshal(data, &issues); err != nil {
+				return nil, fmt.Errorf("error parsing Code Climate report '%s': %w", file, err)
+			}
+		} else {
+			decoder := json.NewDecoder(bytes.NewReader(bytes.ReplaceAll(data, []byte{0}, []byte("\n"))))
+			for decoder.More() {
+				var issue codeClimateIssue
+				if err := decoder.Decode(&issue); err != nil {
+					return nil, fmt.Errorf("error parsing Code Climate report '%s': %w", file, err)
+				}
+				issues = append(issues, issue)
+			}
+		}
+		for _, issue := range issues {
+			if issue.Type == "issue" || issue.Type == "" {
+				files = append(files, issue.Location.Path)
+			}
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, file := range files {
+		filePath := issuePath(file)
+		counts[filePath]++
+		for _, dir := range directoriesOf(filePath, math.MaxInt) {
+			counts[dir]++
+		}
+		counts[""]++
+	}
+	return counts, nil
+}
+
+// qualityOverlay returns the tree overlay of the issue counts
+func qualityOverlay(counts map[string]int) treeOverlay {
+	return treeOverlay{field: "quality", value: func(path string, n Node, rootValue int) any {
+		issues := counts[path]
+		if issues == 0 {
+			return nil
+		}
+		risk := 0.0
+		if rootValue > 0 && counts[""] > 0 {
+			risk = 100 * math.Sqrt(float64(n.Value())/float64(rootValue)*float64(issues)/float64(counts[""]))
+		}
+		return &QualityOverlay{Issues: issues, Risk: math.Round(risk*100) / 100}
+	}}
+}
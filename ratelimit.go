@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxHeaderBytes limits the size of request headers, the request line included
+const maxHeaderBytes = 64 * 1024
+
+// maxClientBuckets bounds the per-client state kept by the rate limiter
+const maxClientBuckets = 10000
+
+// rateLimiter is a token bucket per client: every client may burst up to perMinute requests and
+// regains perMinute tokens per minute
+type rateLimiter struct {
+	perMinute int // 0 disables the limit
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from the bucket of client, it returns how long to wait when none is left
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	if l.perMinute <= 0 {
+		return true, 0
+	}
+	rate := float64(l.perMinute) / float64(time.Minute)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	bucket := l.buckets[client]
+	if bucket == nil {
+		if len(l.buckets) >= maxClientBuckets {
+			l.prune(now)
+		}
+		bucket = &tokenBucket{tokens: float64(l.perMinute), last: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = min(float64(l.perMinute), bucket.tokens+float64(now.Sub(bucket.last))*rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / rate)
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// prune drops the buckets that refilled completely, their clients start over with a full bucket anyway
+func (l *rateLimiter) prune(now time.Time) {
+	for client, bucket := range l.buckets {
+		if now.Sub(bucket.last) >= time.Minute {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+var (
+	limiter       rateLimiter
+	maxConcurrent int           // Expensive requests served at once, 0 = unlimited
+	expensiveSlot chan struct{} // Semaphore sized maxConcurrent
+)
+
+// clientKey identifies the client of r for rate limiting: the signed-in user when authentication
+// is enabled, otherwise its IP address. Unverified credentials like any bearer token would let
+// a client pick a fresh bucket for every request.
+func clientKey(r *http.Request) string {
+	if user := currentUser(r); user != nil {
+		return "user:" + strings.ToLower(user.Email)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// withLimits guards an expensive endpoint (one running git per request) with the per-client rate
+// limit and the cap on concurrently served expensive requests
+func withLimits(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := limiter.allow(clientKey(r), time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			http.Error(w, "Rate limit exceeded, retry later.", http.StatusTooManyRequests)
+			return
+		}
+		if expensiveSlot != nil {
+			select {
+			case expensiveSlot <- struct{}{}:
+				defer func() { <-expensiveSlot }()
+			default:
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "Too many expensive requests in progress, retry shortly.", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
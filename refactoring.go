@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// defaultRefactoringEntries is the number of candidates listed by default
+const defaultRefactoringEntries = 10
+
+// refactoringSignals are the signals a refactoring candidate is scored on, with their default weights
+var refactoringSignals = []struct {
+	name   string
+	weight float64
+}{
+	{"churn", 3},     // File changes
+	{"coupling", 2},  // Share of the commits also changing other directories
+	{"busFactor", 2}, // Share of the commits by the top author
+	{"size", 1},      // Lines, estimated from the lines added and deleted over the history
+	{"tests", 2},     // Lack of test changes against source changes
+}
+
+// RefactoringCandidate is a directory ranked for refactoring, with the signals behind its score
+type RefactoringCandidate struct {
+	Path    string             `json:"path"`
+	Score   float64            `json:"score"`   // 0-100, the weighted mean of the signals
+	Signals map[string]float64 `json:"signals"` // 0-1 each, relative to the other directories
+	Reasons []string           `json:"reasons"` // Explanations of the strong signals
+}
+
+// RefactoringReport is the ranked list of refactoring candidates, as served on /refactoring
+type RefactoringReport struct {
+	Repo       string                 `json:"repo"`
+	Weights    map[string]float64     `json:"weights"`
+	Candidates []RefactoringCandidate `json:"candidates"` // Highest score first
+}
+
+// refactoringFacts are the raw figures of a directory the signals derive from
+type refactoringFacts struct {
+	churn, commits, coupled, lines int
+	authors                        map[string]int
+	testChurn, sourceChurn         int
+}
+
+// parseRefactoringWeights parses weights like "churn:3,tests:0" over the defaults
+func parseRefactoringWeights(value string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, signal := range refactoringSignals {
+		weights[signal.name] = signal.weight
+	}
+	if value == "" {
+		return weights, nil
+	}
+	for _, entry := range strings.Split(value, ",") {
+		name, number, _ := strings.Cut(entry, ":")
+		weight, err := strconv.ParseFloat(number, 64)
+		if _, known := weights[name]; !known || err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight '%s' (expected e.g. churn:3, signals: churn, coupling, busFactor, size, tests)", entry)
+		}
+		weights[name] = weight
+	}
+	return weights, nil
+}
+
+// refactoringCandidates scores the directories up to depth levels on the weighted signals and
+// returns the highest limit
+func refactoringCandidates(a *Analysis, depth int, weights map[string]float64, limit int) *RefactoringReport {
+	facts := make(map[string]*refactoringFacts)
+	lines := make(map[string]int)
+	categories := make(map[string]string)
+	for _, commit := range a.Commits {
+		author := strings.ToLower(commit.Email)
+		touched := make(map[string]bool)
+		for _, file := range commit.Files {
+			lines[file.Path] += file.Added - file.Deleted
+			category, ok := categories[file.Path]
+			if !ok {
+				category = categoryOf(file.Path)
+				categories[file.Path] = category
+			}
+			for _, dir := range directoriesOf(file.Path, depth) {
+				entry := facts[dir]
+				if entry == nil {
+					entry = &refactoringFacts{authors: make(map[string]int)}
+					facts[dir] = entry
+				}
+				entry.churn++
+				if category == "test" {
+					entry.testChurn++
+				} else if category == "source" {
+					entry.sourceChurn++
+				}
+				touched[dir] = true
+			}
+		}
+		// Directories at the same level the commit changed, to judge coupling between siblings
+		levels := make(map[int]int)
+		for dir := range touched {
+			levels[strings.Count(dir, "/")]++
+		}
+		for dir := range touched {
+			entry := facts[dir]
+			entry.commits++
+			entry.authors[author]++
+			if levels[strings.Count(dir, "/")] > 1 {
+				entry.coupled++
+			}
+		}
+	}
+	for file, count := range lines {
+		for _, dir := range directoriesOf(file, depth) {
+			if entry := facts[dir]; entry != nil && count > 0 {
+				entry.lines += count
+			}
+		}
+	}
+
+	// Churn and size are relative to the largest directory, the others are shares already
+	maxChurn, maxLines := 1, 1
+	for _, entry := range facts {
+		maxChurn, maxLines = max(maxChurn, entry.churn), max(maxLines, entry.lines)
+	}
+	totalWeight := 0.0
+	for _, weight := range weights {
+		totalWeight += weight
+	}
+	report := &RefactoringReport{Repo: a.Root.Name(), Weights: weights, Candidates: []RefactoringCandidate{}}
+	for dir, entry := range facts {
+		topAuthor, topCommits := "", 0
+		for author, commits := range entry.authors {
+			if commits > topCommits || (commits == topCommits && author < topAuthor) {
+				topAuthor, topCommits = author, commits
+			}
+		}
+		signals := map[string]float64{
+			"churn":     float64(entry.churn) / float64(maxChurn),
+			"coupling":  float64(entry.coupled) / float64(entry.commits),
+			"busFactor": float64(topCommits) / float64(entry.commits),
+			"size":      float64(entry.lines) / float64(maxLines),
+		}
+		if entry.sourceChurn > 0 {
+			signals["tests"] = 1 - min(1, float64(entry.testChurn)/float64(entry.sourceChurn)/undertestedRatio)
+		}
+		if len(entry.authors) == 1 && entry.commits < busFactorMinCommits {
+			signals["busFactor"] = 0 // Too few commits to tell
+		}
+
+		candidate := RefactoringCandidate{Path: dir, Signals: make(map[string]float64)}
+		score := 0.0
+		for name, value := range signals {
+			candidate.Signals[name] = math.Round(value*100) / 100
+			score += weights[name] * value
+		}
+		if totalWeight > 0 {
+			candidate.Score = math.Round(score/totalWeight*1000) / 10
+		}
+		if signals["churn"] >= 0.5 {
+			candidate.Reasons = append(candidate.Reasons, fmt.Sprintf("High churn: %d file changes in %d commits", entry.churn, entry.commits))
+		}
+		if signals["coupling"] >= 0.5 {
+			candidate.Reasons = append(candidate.Reasons, fmt.Sprintf("High coupling: %.0f%% of its commits also change other directories", signals["coupling"]*100))
+		}
+		if signals["busFactor"] >= busFactorShare {
+			candidate.Reasons = append(candidate.Reasons, fmt.Sprintf("Low bus factor: %.0f%% of its commits by %s", signals["busFactor"]*100, topAuthor))
+		}
+		if signals["size"] >= 0.5 {
+			candidate.Reasons = append(candidate.Reasons, fmt.Sprintf("Large: about %d lines", entry.lines))
+		}
+		if entry.sourceChurn >= undertestedMin && signals["tests"] >= 0.5 {
+			candidate.Reasons = append(candidate.Reasons, fmt.Sprintf("Low test churn: %d test changes for %d source changes", entry.testChurn, entry.sourceChurn))
+		}
+		if candidate.Reasons == nil {
+			candidate.Reasons = []string{}
+		}
+		report.Candidates = append(report.Candidates, candidate)
+	}
+	sort.Slice(report.Candidates, func(i, j int) bool {
+		if report.Candidates[i].Score != report.Candidates[j].Score {
+			return report.Candidates[i].Score > report.Candidates[j].Score
+		}
+		return report.Candidates[i].Path < report.Candidates[j].Path
+	})
+	report.Candidates = report.Candidates[:min(len(report.Candidates), limit)]
+	return report
+}
+
+// markdown renders the report as a Markdown document, e.g. for a planning page
+func (r *RefactoringReport) markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Refactoring candidates for %s\n\n", r.Repo)
+	if len(r.Candidates) == 0 {
+		b.WriteString("No candidates.\n")
+	}
+	for i, candidate := range r.Candidates {
+		fmt.Fprintf(&b, "%d. **`%s`** (score %.1f)\n", i+1, candidate.Path, candidate.Score)
+		for _, reason := range candidate.Reasons {
+			fmt.Fprintf(&b, "   - %s\n", reason)
+		}
+	}
+	names := make([]string, 0, len(r.Weights))
+	for _, signal := range refactoringSignals {
+		names = append(names, fmt.Sprintf("%s %g", signal.name, r.Weights[signal.name]))
+	}
+	fmt.Fprintf(&b, "\nWeights: %s.\n", strings.Join(names, ", "))
+	return b.String()
+}
+
+// handleRefactoring serves GET /refactoring?depth=2&limit=10&weights=churn:3&format=json, the
+// directories ranked for refactoring by churn, coupling, bus factor, size and lack of test
+// changes, with the reasons; format=markdown renders them as Markdown
+func handleRefactoring(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	depth := digestDirectoryDepth
+	if query.Has("depth") {
+		if depth, ok = authorDepth(w, r); !ok {
+			return
+		}
+	}
+	limit := defaultRefactoringEntries
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, fmt.Sprintf("Invalid limit '%s'", value), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	weights, err := parseRefactoringWeights(query.Get("weights"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	report := refactoringCandidates(analysis, depth, weights, limit)
+	switch format := query.Get("format"); format {
+	case "", "json":
+		writeJSON(w, report)
+	case "markdown":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		fmt.Fprint(w, report.markdown())
+	default:
+		http.Error(w, fmt.Sprintf("Unknown format '%s' (expected json or markdown)", format), http.StatusBadRequest)
+	}
+}
+
+// runRefactoring implements the refactoring command, printing the report of /refactoring
+func runRefactoring(args []string) int {
+	flags := flag.NewFlagSet("refactoring", flag.ContinueOnError)
+	depth := flags.Int("depth", digestDirectoryDepth, "directory levels ranked")
+	limit := flags.Int("limit", defaultRefactoringEntries, "number of candidates")
+	weights := flags.String("weights", "", "signal weights over the defaults, e.g. churn:3,tests:0")
+	format := flags.String("format", "markdown", "output: markdown or json")
+	flags.StringVar(&expectedHash, "expect-hash", "", "fail (exit code 5) unless the analysis has this options hash")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s refactoring [options] <repo>\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Prints the directories ranked for refactoring by churn, coupling, bus factor, size and lack of test changes.")
+		flags.PrintDefaults()
+	}
+	parseArgs(flags, args)
+	parsed, err := parseRefactoringWeights(*weights)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	if *depth < 1 || *limit < 1 || flags.NArg() != 1 || (*format != "markdown" && *format != "json") {
+		flags.Usage()
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	analysis, err := analyze(ctx, flags.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error analyzing repository: %v\n", err)
+		return exitCode(err)
+	}
+	report := refactoringCandidates(analysis, *depth, parsed, *limit)
+	if *format == "markdown" {
+		fmt.Print(report.markdown())
+		return exitOK
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing the report: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// refreshInterval is the -refresh option: re-analyze the repositories this often, 0 analyzes once
+var refreshInterval time.Duration
+
+// refreshPeriodically re-analyzes the repositories (fetching a -clone first) every refreshInterval
+// until the process is interrupted, publishing each new analysis and handing it to afterAnalysis. A failed refresh
+// keeps the previous analysis served.
+func refreshPeriodically(timeout time.Duration, afterAnalysis func(ctx context.Context, a *Analysis)) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		runCtx, cancel := ctx, context.CancelFunc(func() {})
+		if timeout > 0 {
+			runCtx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		start := time.Now()
+		if cloneURL != "" {
+			if err := syncClone(runCtx, cloneURL, repoPaths[0]); err != nil {
+				cancel()
+				if ctx.Err() != nil {
+					return
+				}
+				slog.Error("Fetching the repository failed, serving the previous analysis", "error", err)
+				continue
+			}
+		}
+		analysis, err := analyzeWithProgress(runCtx, repoPaths)
+		if err == nil && jiraOptions.URL != "" {
+			if err := resolveIssueTypes(runCtx, analysis, jiraOptions); err != nil {
+				slog.Warn("Defect overlay unavailable", "error", err)
+				analysis.IssueTypes = nil
+			}
+		}
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Error("Refreshing the analysis failed, serving the previous one", "error", err, "kind", errorKind(err))
+			continue
+		}
+		publishAnalysis(analysis, nil)
+		slog.Info("Refreshed the analysis", "commits", analysis.Meta.CommitCount, "value", analysis.Root.Value, "time", time.Since(start).Round(time.Millisecond).String())
+		afterAnalysis(ctx, analysis)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// remapRule moves the files below a path prefix to another one. A "*" segment of the prefix
+// matches any directory, and the "*" segments of the target take the matched names in turn.
+type remapRule struct {
+	from []string
+	to   string
+}
+
+// parseRemap parses comma separated rules like "pkg/a=>core,pkg/b=>core" (merge two packages) or
+// "internal/*=>internal-*" (split internal/ by its second level)
+func parseRemap(value string) ([]remapRule, error) {
+	var rules []remapRule
+	for _, entry := range splitParam(value) {
+		from, to, ok := strings.Cut(entry, "=>")
+		from, to = strings.Trim(strings.TrimSpace(from), "/"), strings.Trim(strings.TrimSpace(to), "/")
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid remapping '%s' (expected e.g. pkg/a=>core or internal/*=>*)", entry)
+		}
+		rule := remapRule{from: strings.Split(from, "/"), to: to}
+		wildcards := 0
+		for _, segment := range rule.from {
+			if segment == "*" {
+				wildcards++
+			} else if segment == "" || strings.Contains(segment, "*") {
+				return nil, fmt.Errorf("invalid remapping '%s' (* stands for a whole directory name)", entry)
+			}
+		}
+		if strings.Count(to, "*") > wildcards {
+			return nil, fmt.Errorf("invalid remapping '%s' (more * in the target than in the prefix)", entry)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// apply returns where the rule moves the file, and false when the file isn't below its prefix
+func (r remapRule) apply(file string) (string, bool) {
+	segments := strings.Split(file, "/")
+	if len(segments) < len(r.from) {
+		return "", false
+	}
+	var matched []string
+	for i, segment := range r.from {
+		if segment == "*" {
+			matched = append(matched, segments[i])
+		} else if segment != segments[i] {
+			return "", false
+		}
+	}
+	target := r.to
+	for _, name := range matched {
+		target = strings.Replace(target, "*", name, 1)
+	}
+	if rest := segments[len(r.from):]; len(rest) > 0 {
+		target += "/" + strings.Join(rest, "/")
+	}
+	return target, true
+}
+
+// remapPath returns the path of the file after the first matching rule, the file without a match
+func remapPath(file string, rules []remapRule) string {
+	for _, rule := range rules {
+		if target, ok := rule.apply(file); ok {
+			return target
+		}
+	}
+	return file
+}
+
+// handleRemapData serves GET /data?remap=pkg/a=>core,pkg/b=>core, the tree recomputed as if the
+// files were moved by the rules, to weigh a reorganization before making it. The first matching
+// rule moves a file, files meeting at the same path add up. It takes the /data query parameters.
+func handleRemapData(w http.ResponseWriter, r *http.Request, a *Analysis) {
+	rules, err := parseRemap(r.URL.Query().Get("remap"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filters := map[string]string{"remap": r.URL.Query().Get("remap")}
+	if mode := a.Meta.Filters["mode"]; mode != "" {
+		filters["mode"] = mode
+	}
+	writeCommitData(w, r, regroupAnalysis(a, func(file string) string { return remapPath(file, rules) }), filters)
+}
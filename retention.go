@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retentionInterval is how often the garbage collector runs
+const retentionInterval = 10 * time.Minute
+
+// RetentionOptions bounds what the server keeps of finished ad-hoc analyses and its on-disk caches
+type RetentionOptions struct {
+	MaxJobs int           // Finished jobs kept, the oldest are removed first (0 = unlimited)
+	TTL     time.Duration // Finished jobs and cache files unused for this long are removed (0 = forever)
+	MaxDisk int64         // Bytes of the blame cache, the least recently used files are removed first (0 = unlimited)
+}
+
+var retention = RetentionOptions{MaxJobs: 100, TTL: 7 * 24 * time.Hour}
+
+// parseSize parses a size like 500MB or 2GB (powers of 1024), plain numbers are bytes
+func parseSize(value string) (int64, error) {
+	number, unit := strings.ToUpper(strings.TrimSpace(value)), int64(1)
+	for _, suffix := range []struct {
+		name string
+		unit int64
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"TB", 1 << 40}, {"B", 1}} {
+		if n, ok := strings.CutSuffix(number, suffix.name); ok {
+			number, unit = strings.TrimSpace(n), suffix.unit
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s' (expected e.g. 500MB)", value)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// prune removes the finished jobs past the TTL and, beyond MaxJobs finished ones, the oldest
+func (s *jobStore) prune(now time.Time, opts RetentionOptions) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var finished []*Job
+	removed := 0
+	for id, job := range s.jobs {
+		if job.FinishedAt == nil {
+			continue
+		}
+		if opts.TTL > 0 && now.Sub(*job.FinishedAt) > opts.TTL {
+			delete(s.jobs, id)
+			removed++
+			continue
+		}
+		finished = append(finished, job)
+	}
+	if opts.MaxJobs > 0 && len(finished) > opts.MaxJobs {
+		sort.Slice(finished, func(i, j int) bool { return finished[i].FinishedAt.Before(*finished[j].FinishedAt) })
+		for _, job := range finished[:len(finished)-opts.MaxJobs] {
+			delete(s.jobs, job.ID)
+			removed++
+		}
+	}
+	return removed
+}
+
+// pruneCacheDir removes the cache files of dir unused for longer than the TTL and then, beyond
+// MaxDisk bytes, the least recently used ones. Cache hits refresh a file's modification time.
+func pruneCacheDir(dir string, now time.Time, opts RetentionOptions) (removed int, freed int64, err error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	type cacheFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cacheFile
+	var total int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		files = append(files, cacheFile{path: filepath.Join(dir, entry.Name()), size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, file := range files {
+		expired := opts.TTL > 0 && now.Sub(file.modTime) > opts.TTL
+		if !expired && (opts.MaxDisk <= 0 || total <= opts.MaxDisk) {
+			continue
+		}
+		if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+			return removed, freed, err
+		}
+		removed++
+		freed += file.size
+		total -= file.size
+	}
+	return removed, freed, nil
+}
+
+// pruneClones removes the clones below dir (cloneDir's repos directory) not fetched for longer
+// than the TTL, e.g. those of an earlier -clone URL, except the clone in use. Fetches mark a clone
+// as used through its modification time.
+func pruneClones(dir string, now time.Time, opts RetentionOptions, inUse string) (removed int, err error) {
+	if opts.TTL <= 0 {
+		return 0, nil
+	}
+	clones, err := filepath.Glob(filepath.Join(dir, "*", "*"))
+	if err != nil {
+		return 0, err
+	}
+	for _, clone := range clones {
+		info, err := os.Stat(clone)
+		if err != nil || !info.IsDir() || clone == inUse || now.Sub(info.ModTime()) <= opts.TTL {
+			continue
+		}
+		if err := os.RemoveAll(clone); err != nil {
+			return removed, err
+		}
+		removed++
+		os.Remove(filepath.Dir(clone)) // Only succeeds once the host directory is empty
+	}
+	return removed, nil
+}
+
+// collectGarbage applies the retention policy to the finished jobs, the clones and the blame cache
+func collectGarbage(now time.Time) {
+	if removed := jobs.prune(now, retention); removed > 0 {
+		slog.Info("Removed expired ad-hoc analyses", "jobs", removed)
+	}
+	if cacheDir != "" {
+		inUse := ""
+		if cloneURL != "" {
+			inUse = cloneDir(cloneURL)
+		}
+		clonesDir := filepath.Join(cacheDir, "repos")
+		removed, err := pruneClones(clonesDir, now, retention, inUse)
+		if err != nil {
+			slog.Warn("Could not clean up the clones", "dir", clonesDir, "error", err)
+		}
+		if removed > 0 {
+			slog.Info("Removed clones unused for longer than the retention", "dir", clonesDir, "clones", removed)
+		}
+	}
+	if blameOptions.CacheDir == "" {
+		return
+	}
+	removed, freed, err := pruneCacheDir(blameOptions.CacheDir, now, retention)
+	if err != nil {
+		slog.Warn("Could not clean up the blame cache", "dir", blameOptions.CacheDir, "error", err)
+	}
+	if removed > 0 {
+		slog.Info("Cleaned up the blame cache", "dir", blameOptions.CacheDir, "files", removed, "freedBytes", freed)
+	}
+}
+
+// startRetention collects garbage now and every retentionInterval
+func startRetention() {
+	go func() {
+		collectGarbage(time.Now())
+		for now := range time.Tick(retentionInterval) {
+			collectGarbage(now)
+		}
+	}()
+}
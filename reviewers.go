@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reviewer suggestion tuning
+const (
+	defaultReviewerLimit  = 3
+	defaultReviewerActive = 90 * 24 * time.Hour // Authors without commits in this period are not suggested
+	expertiseHalfLife     = 180 * 24 * time.Hour
+	siblingExpertise      = 0.25 // Weight of commits to the directory around a path, relative to the path itself
+)
+
+// Reviewer is a suggested reviewer for a set of changed paths
+type Reviewer struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	// Score sums the reviewer's share of the recency weighted expertise of every path, so it is
+	// at most the number of paths
+	Score      float64   `json:"score"`
+	Paths      int       `json:"paths"`   // Requested paths the reviewer changed or worked next to
+	Commits    int       `json:"commits"` // Commits to the requested paths
+	LastCommit time.Time `json:"lastCommit"`
+}
+
+// suggestReviewers ranks the authors active since activeSince by their expertise on the paths:
+// their commits to a path and, less, to the directory around it, halving in weight every
+// expertiseHalfLife. Authors whose email or name is in exclude (e.g. the change's author) are skipped.
+func suggestReviewers(a *Analysis, paths []string, exclude map[string]bool, activeSince, now time.Time) []Reviewer {
+	type expertise struct {
+		Reviewer
+		scores []float64 // Per path
+	}
+	authors := make(map[string]*expertise) // By email
+	totals := make([]float64, len(paths))
+	for _, commit := range a.Commits {
+		key := strings.ToLower(commit.Email)
+		entry := authors[key]
+		if entry == nil {
+			entry = &expertise{Reviewer: Reviewer{Name: commit.Author, Email: commit.Email, LastCommit: commit.Date}, scores: make([]float64, len(paths))}
+			authors[key] = entry
+		}
+		weight := math.Pow(0.5, float64(now.Sub(commit.Date))/float64(expertiseHalfLife))
+		counted := false
+		for i, p := range paths {
+			best := 0.0
+			for _, file := range commit.Files {
+				if pathWithin(file.Path, p) {
+					best = 1
+					break
+				}
+				if dir := path.Dir(p); dir != "." && pathWithin(file.Path, dir) {
+					best = siblingExpertise
+				}
+			}
+			if best == 1 && !counted {
+				entry.Commits++
+				counted = true
+			}
+			entry.scores[i] += best * weight
+			totals[i] += best * weight
+		}
+	}
+
+	reviewers := []Reviewer{}
+	for _, entry := range authors {
+		if entry.LastCommit.Before(activeSince) || exclude[strings.ToLower(entry.Email)] || exclude[strings.ToLower(entry.Name)] {
+			continue
+		}
+		for i, score := range entry.scores {
+			if score > 0 {
+				entry.Score += score / totals[i]
+				entry.Paths++
+			}
+		}
+		if entry.Score > 0 {
+			entry.Score = math.Round(entry.Score*1000) / 1000
+			reviewers = append(reviewers, entry.Reviewer)
+		}
+	}
+	sort.Slice(reviewers, func(i, j int) bool {
+		if reviewers[i].Score != reviewers[j].Score {
+			return reviewers[i].Score > reviewers[j].Score
+		}
+		return reviewers[i].Email < reviewers[j].Email
+	})
+	return reviewers
+}
+
+// splitParam splits a comma separated query parameter, dropping empty values
+func splitParam(value string) []string {
+	var values []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
+
+// handleReviewers serves GET /reviewers?paths=a,b,c&exclude=alice@example.com&limit=3, the most
+// knowledgeable active reviewers of a change
+func handleReviewers(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	var paths []string
+	for _, p := range splitParam(query.Get("paths")) {
+		paths = append(paths, strings.Trim(p, "/"))
+	}
+	if len(paths) == 0 {
+		http.Error(w, "Missing paths parameter", http.StatusBadRequest)
+		return
+	}
+	limit := defaultReviewerLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			http.Error(w, fmt.Sprintf("Invalid limit '%s'", value), http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+	active := defaultReviewerActive
+	if value := query.Get("active"); value != "" {
+		parsed, err := parseAge(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid active period '%s'", value), http.StatusBadRequest)
+			return
+		}
+		active = parsed
+	}
+	exclude := make(map[string]bool)
+	for _, author := range splitParam(query.Get("exclude")) {
+		exclude[strings.ToLower(author)] = true
+	}
+
+	now := time.Now()
+	reviewers := suggestReviewers(analysis, paths, exclude, now.Add(-active), now)
+	writeJSON(w, reviewers[:min(limit, len(reviewers))])
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultRippleEntries is the number of directories listed by default
+	defaultRippleEntries = 20
+	// rippleMinCommits is the number of commits a directory needs to be ranked, fewer are anecdotes
+	rippleMinCommits = 3
+	// rippleTargets is the number of other top-level directories listed per directory
+	rippleTargets = 3
+)
+
+// Ripple tells how far the changes of a directory fan out across the system
+type Ripple struct {
+	Path    string   `json:"path"`
+	Commits int      `json:"commits"`
+	Ripple  float64  `json:"ripple"`  // Other top-level directories touched per commit, on average
+	FanOut  float64  `json:"fanOut"`  // Share of the commits touching another top-level directory, 0-1
+	Targets []string `json:"targets"` // The other top-level directories touched most often along with it
+}
+
+// topLevels returns the top-level directories (first path segments) the commit touches
+func topLevels(commit *Commit) map[string]bool {
+	tops := make(map[string]bool)
+	for _, file := range commit.Files {
+		top, _, _ := strings.Cut(file.Path, "/")
+		tops[top] = true
+	}
+	return tops
+}
+
+// ripples computes the ripple factor of every directory up to depth levels touched by at least
+// rippleMinCommits commits, the highest first
+func ripples(a *Analysis, depth int) []Ripple {
+	type counts struct {
+		commits, others, fanned int
+		targets                 map[string]int
+	}
+	dirs := make(map[string]*counts)
+	for _, commit := range a.Commits {
+		tops := topLevels(commit)
+		touched := make(map[string]bool)
+		for _, file := range commit.Files {
+			for _, dir := range directoriesOf(file.Path, depth) {
+				touched[dir] = true
+			}
+		}
+		for dir := range touched {
+			entry := dirs[dir]
+			if entry == nil {
+				entry = &counts{targets: make(map[string]int)}
+				dirs[dir] = entry
+			}
+			own, _, _ := strings.Cut(dir, "/")
+			entry.commits++
+			if others := len(tops) - 1; others > 0 {
+				entry.others += others
+				entry.fanned++
+				for top := range tops {
+					if top != own {
+						entry.targets[top]++
+					}
+				}
+			}
+		}
+	}
+
+	list := []Ripple{}
+	for dir, entry := range dirs {
+		if entry.commits < rippleMinCommits {
+			continue
+		}
+		targets := make([]string, 0, len(entry.targets))
+		for top := range entry.targets {
+			targets = append(targets, top)
+		}
+		sort.Slice(targets, func(i, j int) bool {
+			if entry.targets[targets[i]] != entry.targets[targets[j]] {
+				return entry.targets[targets[i]] > entry.targets[targets[j]]
+			}
+			return targets[i] < targets[j]
+		})
+		list = append(list, Ripple{
+			Path:    dir,
+			Commits: entry.commits,
+			Ripple:  math.Round(float64(entry.others)/float64(entry.commits)*100) / 100,
+			FanOut:  math.Round(float64(entry.fanned)/float64(entry.commits)*1000) / 1000,
+			Targets: targets[:min(len(targets), rippleTargets)],
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Ripple != list[j].Ripple {
+			return list[i].Ripple > list[j].Ripple
+		}
+		if list[i].Commits != list[j].Commits {
+			return list[i].Commits > list[j].Commits
+		}
+		return list[i].Path < list[j].Path
+	})
+	return list
+}
+
+// handleRipple serves GET /ripple?depth=2&limit=20, the directories whose changes habitually fan
+// out across the top-level directories, by their ripple factor
+func handleRipple(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	depth := digestDirectoryDepth
+	if query.Has("depth") {
+		if depth, ok = authorDepth(w, r); !ok {
+			return
+		}
+	}
+	limit := defaultRippleEntries
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, fmt.Sprintf("Invalid limit '%s'", value), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	list := ripples(analysis, depth)
+	writeJSON(w, list[:min(len(list), limit)])
+}
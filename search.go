@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultSearchLimit bounds the number of matches returned by /search unless a limit is given
+const defaultSearchLimit = 50
+
+// SearchMatch is a node matching a search query, with its metrics and position in the tree
+type SearchMatch struct {
+	ID            string   `json:"id"`
+	Path          string   `json:"path"`
+	Name          string   `json:"name"`
+	IsFile        bool     `json:"isFile"`
+	Value         int      `json:"value"`
+	PercentOfRoot float64  `json:"percentOfRoot"`
+	Depth         int      `json:"depth"`
+	Ancestors     []string `json:"ancestors"` // Names from the root's first level down to the parent
+	Rank          int      `json:"rank"`      // 1-based position among siblings, by value
+}
+
+// SearchResponse is the response of /search
+type SearchResponse struct {
+	Query   string        `json:"query"`
+	Total   int           `json:"total"`
+	Matches []SearchMatch `json:"matches"` // Hottest first
+}
+
+// siblingRank returns the 1-based rank of child among the children of parent, ordered like the /data tree
+func siblingRank(parent, child Node) int {
+	rank := 1
+	for sibling := range parent.Children() {
+		if sibling.Value() > child.Value() || (sibling.Value() == child.Value() && sibling.Name() < child.Name()) {
+			rank++
+		}
+	}
+	return rank
+}
+
+// searchTree returns the nodes whose name contains the query (case-insensitive), hottest first
+func searchTree(root Node, query string) []SearchMatch {
+	query = strings.ToLower(query)
+	matches := []SearchMatch{}
+	var walk func(node Node, ancestors []string)
+	walk = func(node Node, ancestors []string) {
+		for child := range node.Children() {
+			if child.Value() <= 0 {
+				continue
+			}
+			if strings.Contains(strings.ToLower(child.Name()), query) {
+				matches = append(matches, SearchMatch{
+					ID:            nodeID(child.relPath()),
+					Path:          child.relPath(),
+					Name:          child.Name(),
+					IsFile:        child.IsFile(),
+					Value:         child.Value(),
+					PercentOfRoot: percentOf(child.Value(), root.Value()),
+					Depth:         len(ancestors) + 1,
+					Ancestors:     append([]string{}, ancestors...),
+					Rank:          siblingRank(node, child),
+				})
+			}
+			walk(child, append(ancestors, child.Name()))
+		}
+	}
+	walk(root, []string{})
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Value != matches[j].Value {
+			return matches[i].Value > matches[j].Value
+		}
+		return matches[i].Path < matches[j].Path
+	})
+	return matches
+}
+
+// handleSearch serves GET /search?q=handler&limit=50, find-as-you-type lookup of tree nodes
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "Missing query parameter 'q'", http.StatusBadRequest)
+		return
+	}
+	limit := defaultSearchLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid limit '%s'", value), http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	matches := searchTree(analysis.Root, query)
+	writeJSON(w, &SearchResponse{Query: query, Total: len(matches), Matches: matches[:min(len(matches), limit)]})
+}
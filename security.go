@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultSecurityPaths are the security-critical path patterns used without -security-paths
+const defaultSecurityPaths = "auth/,authn/,authz/,oauth/,crypto/,security/,secrets/,session/,payment/,payments/,billing/,*.pem,*.key"
+
+// securityPatterns are the patterns of -security-paths: "dir/" matches a directory at any depth,
+// a pattern with wildcards the file name, anything else the end of the path
+var securityPatterns = strings.Split(defaultSecurityPaths, ",")
+
+// securityArea returns the security-critical area of the file: the directory matched by a
+// pattern (e.g. "src/auth" for "auth/"), or the file's directory ("" for the root) for file patterns
+func securityArea(file string) (string, bool) {
+	for _, pattern := range securityPatterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if dir, ok := strings.CutSuffix(pattern, "/"); ok {
+			dir = strings.Trim(dir, "/")
+			if i := strings.Index("/"+file, "/"+dir+"/"); i >= 0 {
+				return file[:i+len(dir)], true
+			}
+			continue
+		}
+		if matchesPattern(file, pattern) {
+			return strings.TrimPrefix(path.Dir(file), "."), true
+		}
+	}
+	return "", false
+}
+
+// SecurityArea is the churn and ownership of a security-critical area
+type SecurityArea struct {
+	Path       string    `json:"path"`
+	Churn      int       `json:"churn"` // File changes
+	Commits    int       `json:"commits"`
+	Authors    int       `json:"authors"`
+	LastChange time.Time `json:"lastChange"`
+	// BusFactor is the number of authors who made half of the area's commits of the last year,
+	// 0 without commits in the last year
+	BusFactor int     `json:"busFactor"`
+	TopAuthor string  `json:"topAuthor,omitempty"`
+	TopShare  float64 `json:"topShare"` // The top author's share of the commits of the last year
+}
+
+// securityAreas returns the security-critical areas of the analysis, the most churned first
+func securityAreas(a *Analysis) []SecurityArea {
+	areas := make(map[string]*SecurityArea)
+	authors := make(map[string]map[string]bool)
+	recent := make(map[string]map[string]int) // Area, author: commits within busFactorPeriod
+	for _, commit := range a.Commits {
+		touched := make(map[string]bool)
+		for _, file := range commit.Files {
+			dir, ok := securityArea(file.Path)
+			if !ok {
+				continue
+			}
+			area := areas[dir]
+			if area == nil {
+				area = &SecurityArea{Path: dir, LastChange: commit.Date} // Commits are newest first
+				areas[dir] = area
+				authors[dir] = make(map[string]bool)
+				recent[dir] = make(map[string]int)
+			}
+			area.Churn++
+			touched[dir] = true
+		}
+		for dir := range touched {
+			areas[dir].Commits++
+			authors[dir][strings.ToLower(commit.Email)] = true
+			if a.Meta.GeneratedAt.Sub(commit.Date) <= busFactorPeriod {
+				recent[dir][commit.Author]++
+			}
+		}
+	}
+
+	list := make([]SecurityArea, 0, len(areas))
+	for dir, area := range areas {
+		area.Authors = len(authors[dir])
+		counts := make([]int, 0, len(recent[dir]))
+		total := 0
+		for author, commits := range recent[dir] {
+			counts = append(counts, commits)
+			total += commits
+			if top := recent[dir][area.TopAuthor]; commits > top || (commits == top && author < area.TopAuthor) || area.TopAuthor == "" {
+				area.TopAuthor = author
+			}
+		}
+		if total > 0 {
+			sort.Sort(sort.Reverse(sort.IntSlice(counts)))
+			covered := 0
+			for _, commits := range counts {
+				covered += commits
+				area.BusFactor++
+				if 2*covered >= total {
+					break
+				}
+			}
+			area.TopShare = float64(recent[dir][area.TopAuthor]) / float64(total)
+		}
+		list = append(list, *area)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Churn != list[j].Churn {
+			return list[i].Churn > list[j].Churn
+		}
+		return list[i].Path < list[j].Path
+	})
+	return list
+}
+
+// securityAnalysis returns the tree of the security-critical files only
+func securityAnalysis(a *Analysis) *Analysis {
+	return fileAnalysis(a, func(path string) bool {
+		_, ok := securityArea(path)
+		return ok
+	})
+}
+
+// handleSecurity serves GET /security, the churn and bus factor of the security-critical areas
+// (-security-paths) for AppSec review prioritization
+func handleSecurity(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+	writeJSON(w, securityAreas(analysis))
+}
+
+// handleSecurityData serves GET /data?security=true, the tree of the security-critical files. It
+// takes the /data query parameters.
+func handleSecurityData(w http.ResponseWriter, r *http.Request, a *Analysis) {
+	filters := map[string]string{"security": "true"}
+	if mode := a.Meta.Filters["mode"]; mode != "" {
+		filters["mode"] = mode
+	}
+	writeCommitData(w, r, securityAnalysis(a), filters)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// deepenBy is the number of commits fetched into shallow clones before the analysis (-deepen)
+var deepenBy int
+
+// ShallowInfo discloses that a shallow clone was analyzed, covering only part of the history
+type ShallowInfo struct {
+	Commits  int       `json:"commits"`            // Analyzed commits: all the clone has but its boundary
+	Since    time.Time `json:"since"`              // Date of the oldest analyzed commit
+	Deepened int       `json:"deepened,omitempty"` // Commits fetched by -deepen before the analysis
+}
+
+// isShallow reports whether the repository is a shallow clone
+func isShallow(ctx context.Context, repo string) bool {
+	shallow, err := gitOutput(ctx, repo, "rev-parse", "--is-shallow-repository")
+	return err == nil && shallow == "true"
+}
+
+// deepen fetches n more commits of history into a shallow clone
+func deepen(ctx context.Context, repo string, n int) error {
+	slog.Info("Deepening shallow clone", "repo", repo, "commits", n)
+	if _, err := gitRun(ctx, repo, "fetch", fmt.Sprintf("--deepen=%d", n)); err != nil {
+		return fmt.Errorf("error deepening shallow clone: %w (%s)", err, gitStderr(err))
+	}
+	return nil
+}
+
+// shallowBoundary returns the commits at the edge of a shallow clone. Their parents are missing,
+// so they look like they added every file and are left out of the analysis.
+func shallowBoundary(ctx context.Context, repo string) ([]string, error) {
+	file, err := gitOutput(ctx, repo, "rev-parse", "--git-path", "shallow")
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(repo, file)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the shallow boundary: %w", err)
+	}
+	return strings.Fields(string(data)), nil
+}
+
+// shallowInfo describes the history a shallow clone covers
+func shallowInfo(commits []*Commit, deepened int) *ShallowInfo {
+	info := &ShallowInfo{Commits: len(commits), Deepened: deepened}
+	for _, commit := range commits {
+		if info.Since.IsZero() || commit.Date.Before(info.Since) {
+			info.Since = commit.Date
+		}
+	}
+	return info
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// Snapshot is the heat tree of the changes made within one calendar month
+type Snapshot struct {
+	Month   string // YYYY-MM
+	Commits int
+	Root    Node
+}
+
+// SnapshotSummary describes an available snapshot, as listed by /snapshots
+type SnapshotSummary struct {
+	Month   string `json:"month"`
+	Commits int    `json:"commits"`
+	Value   int    `json:"value"`
+}
+
+// buildSnapshots precomputes a tree per calendar month (UTC) of the analyzed history, with the
+// changes of every commit counting its weight. Months without commits are left out.
+func buildSnapshots(path string, commits []*Commit) []*Snapshot {
+	months := make(map[string]*weightedCounts)
+	commitCounts := make(map[string]int)
+	for _, commit := range commits {
+		month := commit.Date.Format("2006-01")
+		values, ok := months[month]
+		if !ok {
+			values = newWeightedCounts()
+			months[month] = values
+		}
+		commitCounts[month]++
+		for _, file := range commit.Files {
+			values.add(file.Path, 1, commit.Weight)
+		}
+	}
+
+	snapshots := make([]*Snapshot, 0, len(months))
+	for month, values := range months {
+		root := populateTree(path, values.result())
+		root.aggregateCounts()
+		snapshots = append(snapshots, &Snapshot{Month: month, Commits: commitCounts[month], Root: root})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Month < snapshots[j].Month })
+	return snapshots
+}
+
+// handleSnapshots serves GET /snapshots, the list of available months, and
+// GET /snapshots?month=2023-06, the tree of that month in the /data format
+func handleSnapshots(w http.ResponseWriter, r *http.Request) {
+	analysis, ok := currentAnalysis(w)
+	if !ok {
+		return
+	}
+
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		summaries := make([]SnapshotSummary, 0, len(analysis.Snapshots))
+		for _, snapshot := range analysis.Snapshots {
+			summaries = append(summaries, SnapshotSummary{Month: snapshot.Month, Commits: snapshot.Commits, Value: snapshot.Root.Value()})
+		}
+		writeJSON(w, summaries)
+		return
+	}
+
+	var snapshot *Snapshot
+	for _, candidate := range analysis.Snapshots {
+		if candidate.Month == month {
+			snapshot = candidate
+			break
+		}
+	}
+	if snapshot == nil {
+		http.Error(w, fmt.Sprintf("No snapshot for month '%s'", month), http.StatusNotFound)
+		return
+	}
+
+	// Snapshots are small, so the whole tree is served unless a depth is requested explicitly
+	base := treeOptions
+	base.MaxDepth = 0
+	opts, err := treeOptionsFromQuery(base, r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	monthAnalysis := &Analysis{Root: snapshot.Root, Meta: analysis.Meta}
+	monthAnalysis.Meta.CommitCount = snapshot.Commits
+	var body bytes.Buffer
+	filters := map[string]string{"mode": "churn", "month": month}
+	hash, err := writeDataResponse(&body, monthAnalysis, opts, r.URL.Query().Get("path"), filters)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("X-Dirheat-Options-Hash", hash)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	body.WriteByte('\n')
+	body.WriteTo(w)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// analysisState is the immutable outcome of one analysis run. Handlers load the current state once
+// per request, so a refresh publishing a new state never changes the data under a running request.
+type analysisState struct {
+	analysis    *Analysis // nil when the analysis failed or hasn't finished
+	err         error
+	publishedAt time.Time // Zero while the first analysis is still running
+
+	previous   *Analysis // With -refresh: the analysis published before, nil before the first refresh
+	previousAt time.Time
+
+	replaced chan struct{} // Closed once the next state is published
+}
+
+// currentState holds the published analysisState, swapped atomically
+var currentState atomic.Pointer[analysisState]
+
+// pendingState is the state before the first analysis finished
+var pendingState = &analysisState{replaced: make(chan struct{})}
+
+// publishAnalysis makes the outcome of an analysis run visible to the handlers. The analysis must
+// not be modified afterwards.
+func publishAnalysis(analysis *Analysis, err error) {
+	state := &analysisState{analysis: analysis, err: err, publishedAt: time.Now().UTC(), replaced: make(chan struct{})}
+	old := loadState()
+	if old.analysis != nil {
+		state.previous, state.previousAt = old.analysis, old.publishedAt
+	}
+	currentState.Store(state)
+	close(old.replaced)
+}
+
+// loadState returns the published analysis state, a pending one before the first analysis finished
+func loadState() *analysisState {
+	if state := currentState.Load(); state != nil {
+		return state
+	}
+	return pendingState
+}
+
+// pending reports whether the first analysis is still running
+func (s *analysisState) pending() bool {
+	return s.publishedAt.IsZero()
+}
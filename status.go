@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// StatusError describes why the analysis failed
+type StatusError struct {
+	Kind     string `json:"kind"` // notARepo, gitNotFound, emptyHistory, parseError, timeout, canceled or error
+	Message  string `json:"message"`
+	ExitCode int    `json:"exitCode"`
+	Line     int    `json:"line,omitempty"` // Parse errors: the line of the git output parsing stopped at
+}
+
+// StatusOptions are the options the instance analyzes and serves with
+type StatusOptions struct {
+	Mode       string  `json:"mode"`
+	Normalize  string  `json:"normalize"`
+	MinValue   int     `json:"minValue,omitempty"`
+	MinPercent float64 `json:"minPercent,omitempty"`
+	MaxDepth   int     `json:"maxDepth,omitempty"`
+	BasePath   string  `json:"basePath,omitempty"`
+	GitTimeout string  `json:"gitTimeout,omitempty"`
+	Rev        string  `json:"rev,omitempty"` // Analyzed revision when not HEAD
+}
+
+// StatusResponse is the state of the served analysis
+type StatusResponse struct {
+	Status      string        `json:"status"` // "starting" (first analysis running), "ok", "empty" (no commits to analyze yet) or "failed"
+	Message     string        `json:"message,omitempty"`
+	Error       *StatusError  `json:"error,omitempty"`
+	Repos       []string      `json:"repos"`
+	LastRefresh *time.Time    `json:"lastRefresh,omitempty"` // When the served analysis (or error) was published
+	Options     StatusOptions `json:"options"`
+	Metadata    *Metadata     `json:"metadata,omitempty"`
+}
+
+// statusOptions returns the options of this instance
+func statusOptions() StatusOptions {
+	opts := StatusOptions{
+		Mode:       heatMode,
+		Normalize:  normalize,
+		MinValue:   treeOptions.MinValue,
+		MinPercent: treeOptions.MinPercent,
+		MaxDepth:   treeOptions.MaxDepth,
+		BasePath:   basePath,
+	}
+	if analysisRev != "HEAD" {
+		opts.Rev = analysisRev
+	}
+	if gitTimeout > 0 {
+		opts.GitTimeout = gitTimeout.String()
+	}
+	return opts
+}
+
+// handleHealthz serves GET /healthz, answering as long as the process serves requests
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz serves GET /readyz: 200 once an analysis is published and can be served, 503 while
+// the first analysis is running or when it failed
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	state := loadState()
+	switch {
+	case state.pending():
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("analysis in progress\n"))
+	case state.analysis == nil:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("analysis failed\n"))
+	default:
+		w.Write([]byte("ok\n"))
+	}
+}
+
+// handleStatus serves GET /status, telling automation whether the analysis succeeded and why not
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	state := loadState()
+	response := StatusResponse{Repos: repoPaths, Options: statusOptions()}
+	if state.pending() {
+		response.Status = "starting"
+		response.Message = "The repository analysis is still running."
+		writeJSON(w, response)
+		return
+	}
+	response.LastRefresh = &state.publishedAt
+	if analyzeError := state.err; analyzeError != nil {
+		statusErr := &StatusError{Kind: errorKind(analyzeError), Message: analyzeError.Error(), ExitCode: exitCode(analyzeError)}
+		var parseErr *ParseError
+		if errors.As(analyzeError, &parseErr) {
+			statusErr.Line = parseErr.Line
+		}
+		response.Status, response.Error = "failed", statusErr
+		writeJSON(w, response)
+		return
+	}
+	response.Status, response.Metadata = "ok", &state.analysis.Meta
+	if state.analysis.Meta.CommitCount == 0 {
+		response.Status = "empty"
+		response.Message = "The repository has no commits yet, the tree fills up once changes are committed."
+		if state.analysis.Meta.Shallow != nil {
+			response.Message = "The shallow clone has no history beyond its boundary commits, deepen it with -deepen N."
+		}
+	}
+	writeJSON(w, response)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// streamKeepAlive is how often an idle event stream gets a comment, so proxies keep it open
+const streamKeepAlive = 30 * time.Second
+
+// streamsClosed is closed when the server shuts down, ending the event streams that would
+// otherwise keep the shutdown waiting
+var (
+	streamsClosed    = make(chan struct{})
+	closeStreamsOnce sync.Once
+)
+
+// closeStreams ends the event streams, see streamsClosed
+func closeStreams() {
+	closeStreamsOnce.Do(func() { close(streamsClosed) })
+}
+
+// TreeDelta is the change of the tree from one analysis to the next, as sent by /data/events:
+// the nodes whose value changed, the new nodes and the removed ones (only the topmost of a
+// removed subtree). Nodes are identified by their path, "" being the root.
+type TreeDelta struct {
+	From        string         `json:"from"` // Event ID of the analysis the delta applies to
+	Revision    string         `json:"revision"`
+	GeneratedAt time.Time      `json:"generatedAt"`
+	CommitCount int            `json:"commitCount"`
+	Changed     map[string]int `json:"changed"` // New values by path
+	Added       []DeltaNode    `json:"added"`   // Parents before their children
+	Removed     []string       `json:"removed"`
+}
+
+// DeltaNode is a node new to the tree
+type DeltaNode struct {
+	Path  string `json:"path"`
+	Value int    `json:"value"`
+	File  bool   `json:"file,omitempty"`
+}
+
+// eventID identifies an analysis in the event stream, for Last-Event-ID on reconnection
+func eventID(a *Analysis) string {
+	return strconv.FormatInt(a.Meta.GeneratedAt.UnixNano(), 10)
+}
+
+// treeValues returns the nodes of the tree with changes by path
+func treeValues(root Node) map[string]Node {
+	nodes := make(map[string]Node)
+	var walk func(n Node, path string)
+	walk = func(n Node, path string) {
+		if n.Value() <= 0 {
+			return // Left out of the JSON tree too
+		}
+		nodes[path] = n
+		for child := range n.Children() {
+			childPath := child.Name()
+			if path != "" {
+				childPath = path + "/" + child.Name()
+			}
+			walk(child, childPath)
+		}
+	}
+	walk(root, "")
+	return nodes
+}
+
+// treeDelta returns the change of the tree from one analysis to the other
+func treeDelta(from, to *Analysis) *TreeDelta {
+	delta := &TreeDelta{
+		From:        eventID(from),
+		Revision:    to.Meta.Revision,
+		GeneratedAt: to.Meta.GeneratedAt,
+		CommitCount: to.Meta.CommitCount,
+		Changed:     make(map[string]int),
+		Added:       []DeltaNode{},
+		Removed:     []string{},
+	}
+	before, after := treeValues(from.Root), treeValues(to.Root)
+	for path, node := range after {
+		if old, ok := before[path]; !ok {
+			delta.Added = append(delta.Added, DeltaNode{Path: path, Value: node.Value(), File: node.IsFile()})
+		} else if old.Value() != node.Value() {
+			delta.Changed[path] = node.Value()
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			parent := ""
+			if i := strings.LastIndex(path, "/"); i >= 0 {
+				parent = path[:i]
+			}
+			if _, kept := after[parent]; kept {
+				delta.Removed = append(delta.Removed, path)
+			}
+		}
+	}
+	// Sorted paths list parents before their children
+	sort.Slice(delta.Added, func(i, j int) bool { return delta.Added[i].Path < delta.Added[j].Path })
+	sort.Strings(delta.Removed)
+	return delta
+}
+
+// deltaCache keeps the last delta encoded, every stream sends the same one after a refresh
+var deltaCache struct {
+	mu       sync.Mutex
+	from, to *Analysis
+	data     []byte
+}
+
+// encodedDelta returns the encoded delta from one analysis to the other
+func encodedDelta(from, to *Analysis) ([]byte, error) {
+	deltaCache.mu.Lock()
+	defer deltaCache.mu.Unlock()
+	if deltaCache.from == from && deltaCache.to == to {
+		return deltaCache.data, nil
+	}
+	data, err := json.Marshal(treeDelta(from, to))
+	if err != nil {
+		return nil, err
+	}
+	deltaCache.from, deltaCache.to, deltaCache.data = from, to, data
+	return data, nil
+}
+
+// writeEvent writes a server-sent event
+func writeEvent(w io.Writer, id, event string, data []byte) {
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\n", event)
+	for _, line := range bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n")) {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+// handleDataEvents serves GET /data/events, a server-sent event stream keeping live dashboards up
+// to date: a "tree" event with the whole tree (the /data format without collapsing or scaling),
+// then a "delta" event with the change after every refresh. Reconnecting clients sending the
+// Last-Event-ID of the current or the previous analysis get nothing or just the delta.
+func handleDataEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Keep nginx from buffering the events
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	lastID := r.Header.Get("Last-Event-ID")
+	var sent *Analysis
+	send := func(state *analysisState) {
+		switch {
+		case state.pending():
+		case state.analysis == nil:
+			data, _ := json.Marshal(map[string]string{"error": state.err.Error()})
+			writeEvent(w, "", "error", data)
+		case sent != nil:
+			if data, err := encodedDelta(sent, state.analysis); err == nil {
+				writeEvent(w, eventID(state.analysis), "delta", data)
+				sent = state.analysis
+			}
+		case lastID == eventID(state.analysis):
+			sent = state.analysis // The client is up to date
+		case state.previous != nil && lastID == eventID(state.previous):
+			if data, err := encodedDelta(state.previous, state.analysis); err == nil {
+				writeEvent(w, eventID(state.analysis), "delta", data)
+				sent = state.analysis
+			}
+		default:
+			if entry, err := dataCache.get(state.analysis, TreeOptions{}, ""); err == nil {
+				writeEvent(w, eventID(state.analysis), "tree", entry.body)
+				sent = state.analysis
+			}
+		}
+		flusher.Flush()
+	}
+
+	state := loadState()
+	send(state)
+	for {
+		select {
+		case <-state.replaced:
+			state = loadState()
+			send(state)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-streamsClosed:
+			return
+		}
+	}
+}
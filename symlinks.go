@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+// symlinkMode is the git file mode of symbolic links
+const symlinkMode = "120000"
+
+// markSymlinks flags the nodes tracked as symbolic links at the analyzed revision. git records a
+// link as a file holding its target, so a link to a directory is a file node and the files behind
+// it are only counted where they live, on every platform.
+func markSymlinks(ctx context.Context, repo, revision string, root Node) error {
+	output, err := gitRun(ctx, repo, "ls-tree", "-r", "-z", revision)
+	if err != nil {
+		return fmt.Errorf("error listing symbolic links: %w", err)
+	}
+	for _, entry := range bytes.Split(output, []byte{0}) {
+		// <mode> SP <type> SP <object> TAB <path>
+		if !bytes.HasPrefix(entry, []byte(symlinkMode+" ")) {
+			continue
+		}
+		if _, path, ok := bytes.Cut(entry, []byte{'\t'}); ok {
+			if node, _ := root.find(string(path)); node.exists() {
+				node.markSymlink()
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMarkSymlinks(t *testing.T) {
+	repo := testRepo(t)
+	_, counts := testHistory(t, repo)
+	var file, dir string // A generated file and its directory, as link targets
+	for path := range counts {
+		if d, _, ok := strings.Cut(path, "/"); ok && (file == "" || path < file) {
+			file, dir = path, d
+		}
+	}
+	if file == "" {
+		t.Fatal("the test repository has no file in a directory")
+	}
+	if err := os.Symlink(dir, filepath.Join(repo, "dir-link")); err != nil {
+		t.Skipf("symbolic links are not supported: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(repo, "links"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("..", filepath.FromSlash(file)), filepath.Join(repo, "links", "file-link")); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, repo, map[string]string{"links/plain.txt": "not a link\n"})
+	_, counts = testHistory(t, repo)
+
+	tests := []struct {
+		revision string
+		path     string
+		want     bool
+	}{
+		{"HEAD", "dir-link", true},
+		{"HEAD", "links/file-link", true},
+		{"HEAD", "links/plain.txt", false},
+		{"HEAD", "links", false},
+		{"HEAD", file, false},
+		{"HEAD", dir, false},
+		{"HEAD~1", "dir-link", false}, // Not tracked yet at the revision
+	}
+	for _, tt := range tests {
+		t.Run(tt.revision+" "+tt.path, func(t *testing.T) {
+			root := populateTree(repo, counts)
+			if err := markSymlinks(context.Background(), repo, tt.revision, root); err != nil {
+				t.Fatalf("markSymlinks() error: %v", err)
+			}
+			node, _ := root.find(tt.path)
+			if !node.exists() {
+				t.Fatalf("no node at %q", tt.path)
+			}
+			if node.Symlink() != tt.want {
+				t.Errorf("%q Symlink = %v, want %v", tt.path, node.Symlink(), tt.want)
+			}
+		})
+	}
+}
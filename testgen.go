@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// TestgenOptions configures the shape of a generated repository
+type TestgenOptions struct {
+	Files       int     // Files alive at any time (deleted files are replaced by new ones)
+	Depth       int     // Maximum directory depth
+	Commits     int     // Number of commits, the first one adds all files
+	RenameRate  float64 // Probability that a commit renames a file
+	DeleteRate  float64 // Probability that a commit deletes a file
+	UnicodeRate float64 // Share of directory and file names with non-ASCII characters
+	Seed        int64   // Same seed and options yield the same history, commit hashes included
+}
+
+// unicodeNames are the non-ASCII name stems of generated paths
+var unicodeNames = []string{"données", "日本語", "файлы", "ünïcödé", "naïve café", "αρχεία", "中文"}
+
+// repoGenerator produces the fast-import stream of a synthetic history
+type repoGenerator struct {
+	opts  TestgenOptions
+	rng   *rand.Rand
+	dirs  []string
+	files []string // Alive files, hot files first
+	next  int      // Counter making generated names unique
+}
+
+// name returns a fresh directory or file name stem
+func (g *repoGenerator) name(prefix string) string {
+	g.next++
+	if g.rng.Float64() < g.opts.UnicodeRate {
+		return fmt.Sprintf("%s %d", unicodeNames[g.rng.Intn(len(unicodeNames))], g.next)
+	}
+	return fmt.Sprintf("%s%d", prefix, g.next)
+}
+
+// newFile returns the path of a new file in a random (possibly new) directory
+func (g *repoGenerator) newFile() string {
+	dir := ""
+	if len(g.dirs) > 0 && g.rng.Float64() < 0.8 {
+		dir = g.dirs[g.rng.Intn(len(g.dirs))]
+	} else if g.opts.Depth > 0 {
+		// Grow a new directory below an existing one, within the depth limit
+		parent := ""
+		if len(g.dirs) > 0 {
+			parent = g.dirs[g.rng.Intn(len(g.dirs))]
+		}
+		if strings.Count(parent, "/")+2 > g.opts.Depth {
+			parent = ""
+		}
+		dir = path.Join(parent, g.name("dir"))
+		g.dirs = append(g.dirs, dir)
+	}
+	return path.Join(dir, g.name("file")+".txt")
+}
+
+// pick returns the index of an alive file, skewed towards the first (hot) files like real histories
+func (g *repoGenerator) pick() int {
+	r := g.rng.Float64()
+	return int(r * r * r * float64(len(g.files)))
+}
+
+// content returns a file body of a few generated lines
+func (g *repoGenerator) content(file string, commit int) string {
+	var b strings.Builder
+	for i, lines := 0, 3+g.rng.Intn(40); i < lines; i++ {
+		fmt.Fprintf(&b, "%s line %d rev %d %d\n", file, i, commit, g.rng.Intn(1000))
+	}
+	return b.String()
+}
+
+// write emits the whole history as a git fast-import stream
+func (g *repoGenerator) write(w *bufio.Writer) {
+	date := time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC)
+	for commit := 1; commit <= g.opts.Commits; commit++ {
+		author := g.rng.Intn(8)
+		author = author * author / 8 // A few authors do most of the work
+		date = date.Add(time.Duration(1+g.rng.Intn(48)) * time.Hour)
+		message := fmt.Sprintf("Synthetic change %d", commit)
+		if commit == 1 {
+			message = "Initial import"
+		}
+
+		fmt.Fprintf(w, "commit refs/heads/main\nmark :%d\n", commit)
+		fmt.Fprintf(w, "author Author %d <author%d@example.com> %d +0000\n", author, author, date.Unix())
+		fmt.Fprintf(w, "committer Author %d <author%d@example.com> %d +0000\n", author, author, date.Unix())
+		fmt.Fprintf(w, "data %d\n%s\n", len(message), message)
+		if commit > 1 {
+			fmt.Fprintf(w, "from :%d\n", commit-1)
+		}
+
+		if commit == 1 {
+			for len(g.files) < g.opts.Files {
+				file := g.newFile()
+				g.files = append(g.files, file)
+				g.modify(w, file, commit)
+			}
+			continue
+		}
+		if len(g.files) > 1 && g.rng.Float64() < g.opts.DeleteRate {
+			i := g.pick()
+			fmt.Fprintf(w, "D %s\n", strconv.Quote(g.files[i]))
+			g.files = append(g.files[:i], g.files[i+1:]...)
+			file := g.newFile()
+			g.files = append(g.files, file)
+			g.modify(w, file, commit)
+		}
+		if len(g.files) > 0 && g.rng.Float64() < g.opts.RenameRate {
+			i := g.pick()
+			renamed := g.newFile()
+			fmt.Fprintf(w, "R %s %s\n", strconv.Quote(g.files[i]), strconv.Quote(renamed))
+			g.files[i] = renamed
+		}
+		touched := make(map[int]bool)
+		for n := 1 + g.rng.Intn(5); n > 0 && len(g.files) > 0; n-- {
+			touched[g.pick()] = true
+		}
+		indexes := make([]int, 0, len(touched))
+		for i := range touched {
+			indexes = append(indexes, i)
+		}
+		sort.Ints(indexes) // Map order would make the stream non-reproducible
+		for _, i := range indexes {
+			g.modify(w, g.files[i], commit)
+		}
+	}
+}
+
+// modify emits an inline file change
+func (g *repoGenerator) modify(w *bufio.Writer, file string, commit int) {
+	content := g.content(file, commit)
+	fmt.Fprintf(w, "M 100644 inline %s\ndata %d\n%s\n", strconv.Quote(file), len(content), content)
+}
+
+// generateRepo creates a new repository in dir with a synthetic history shaped by opts
+func generateRepo(ctx context.Context, dir string, opts TestgenOptions) error {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("directory '%s' is not empty", dir)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if _, err := gitRun(ctx, dir, "init", "--quiet"); err != nil {
+		return fmt.Errorf("error initializing repository: %w", err)
+	}
+	if _, err := gitRun(ctx, dir, "symbolic-ref", "HEAD", "refs/heads/main"); err != nil {
+		return fmt.Errorf("error setting up the main branch: %w", err)
+	}
+
+	cmd := gitCommand(ctx, dir, "fast-import", "--quiet")
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting git fast-import: %w", err)
+	}
+	w := bufio.NewWriter(stdin)
+	generator := &repoGenerator{opts: opts, rng: rand.New(rand.NewSource(opts.Seed))}
+	generator.write(w)
+	writeErr := w.Flush()
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("error running git fast-import: %w", err)
+	}
+	if writeErr != nil {
+		return fmt.Errorf("error writing the history: %w", writeErr)
+	}
+
+	if _, err := gitRun(ctx, dir, "reset", "--quiet", "--hard"); err != nil {
+		return fmt.Errorf("error checking out the generated history: %w", err)
+	}
+	return nil
+}
+
+// runTestgen implements the testgen command
+func runTestgen(args []string) int {
+	flags := flag.NewFlagSet("testgen", flag.ContinueOnError)
+	var opts TestgenOptions
+	flags.IntVar(&opts.Files, "files", 1000, "number of files alive at any time")
+	flags.IntVar(&opts.Depth, "depth", 4, "maximum directory depth")
+	flags.IntVar(&opts.Commits, "commits", 5000, "number of commits")
+	flags.Float64Var(&opts.RenameRate, "rename-rate", 0.05, "probability that a commit renames a file")
+	flags.Float64Var(&opts.DeleteRate, "delete-rate", 0.02, "probability that a commit deletes a file")
+	flags.Float64Var(&opts.UnicodeRate, "unicode-rate", 0.1, "share of directory and file names with non-ASCII characters")
+	flags.Int64Var(&opts.Seed, "seed", 1, "random seed, the same seed and options reproduce the same repository")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s testgen [options] <new_repo_dir>\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Generates a repository with a synthetic history for testing and benchmarks.")
+		flags.PrintDefaults()
+	}
+	parseArgs(flags, args)
+	if flags.NArg() != 1 || opts.Files < 1 || opts.Commits < 1 || opts.Depth < 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	start := time.Now()
+	if err := generateRepo(ctx, flags.Arg(0), opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating repository: %v\n", err)
+		return exitCode(err)
+	}
+	fmt.Fprintf(os.Stderr, "Generated %d commits over %d files in %s (%s)\n", opts.Commits, opts.Files, flags.Arg(0), time.Since(start).Round(time.Millisecond))
+	return exitOK
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testRepoOptions shape the repositories of testRepo: small, with nested directories and deletions
+var testRepoOptions = TestgenOptions{Files: 30, Depth: 3, Commits: 40, DeleteRate: 0.05, Seed: 1}
+
+// testRepo generates a small synthetic repository for a test, the same for every run
+func testRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	if err := generateRepo(context.Background(), dir, testRepoOptions); err != nil {
+		t.Fatalf("generating the test repository: %v", err)
+	}
+	return dir
+}
+
+// initRepo creates an empty repository for a test
+func initRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	if _, err := gitRun(context.Background(), dir, "init", "--quiet"); err != nil {
+		t.Fatalf("git init: %v (%s)", err, gitStderr(err))
+	}
+	return dir
+}
+
+// commitFiles writes the files (path to content) into the repository and commits them, with the
+// paragraphs of the message if given
+func commitFiles(t *testing.T, repo string, files map[string]string, message ...string) {
+	t.Helper()
+	ctx := context.Background()
+	for path, content := range files {
+		file := filepath.Join(repo, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := gitRun(ctx, repo, "add", "--all"); err != nil {
+		t.Fatalf("git add: %v", err)
+	}
+	if len(message) == 0 {
+		message = []string{"Test change"}
+	}
+	args := []string{"-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "--quiet"}
+	for _, paragraph := range message {
+		args = append(args, "--message", paragraph)
+	}
+	if _, err := gitRun(ctx, repo, args...); err != nil {
+		t.Fatalf("git commit: %v (%s)", err, gitStderr(err))
+	}
+}
+
+func TestGenerateRepo(t *testing.T) {
+	ctx := context.Background()
+	first, second := testRepo(t), testRepo(t)
+	git := func(repo string, args ...string) string {
+		t.Helper()
+		output, err := gitOutput(ctx, repo, args...)
+		if err != nil {
+			t.Fatalf("git %s: %v (%s)", strings.Join(args, " "), err, gitStderr(err))
+		}
+		return output
+	}
+
+	if a, b := git(first, "rev-parse", "HEAD"), git(second, "rev-parse", "HEAD"); a != b {
+		t.Errorf("the same seed generated different histories: %s and %s", a, b)
+	}
+	if got, want := git(first, "rev-list", "--count", "HEAD"), strconv.Itoa(testRepoOptions.Commits); got != want {
+		t.Errorf("commits = %s, want %s", got, want)
+	}
+	files := strings.Split(git(first, "ls-files"), "\n")
+	if len(files) != testRepoOptions.Files {
+		t.Errorf("files = %d, want %d", len(files), testRepoOptions.Files)
+	}
+	for _, file := range files {
+		if dirs := strings.Count(file, "/"); dirs > testRepoOptions.Depth {
+			t.Errorf("%s is %d directories deep, want at most %d", file, dirs, testRepoOptions.Depth)
+		}
+	}
+	if err := generateRepo(ctx, first, testRepoOptions); err == nil {
+		t.Error("generating into a non-empty directory succeeded")
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Trailer is a "Key: value" line of the trailer block of a commit message, e.g. Reviewed-by
+type Trailer struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// parseTrailers parses the trailers of a commit header, separated by \x1e
+func parseTrailers(field string) []Trailer {
+	var trailers []Trailer
+	for _, line := range strings.Split(field, "\x1e") {
+		if key, value, ok := strings.Cut(line, ":"); ok && strings.TrimSpace(key) != "" {
+			trailers = append(trailers, Trailer{Key: strings.TrimSpace(key), Value: strings.TrimSpace(value)})
+		}
+	}
+	return trailers
+}
+
+// Trailers a commit marks itself with to change its heat at the source, e.g. mass renames or
+// generated code: "Skip-Dirheat: codegen" leaves it out of the analysis and "Dirheat-Weight: 0.1"
+// scales its file changes
+const (
+	skipTrailer   = "Skip-Dirheat"
+	weightTrailer = "Dirheat-Weight"
+)
+
+// sourceWeight returns the weight the commit gives itself by trailer: 0 to skip it, 1 without one
+func sourceWeight(c *Commit) float64 {
+	weight := 1.0
+	for _, trailer := range c.Trailers {
+		switch {
+		case strings.EqualFold(trailer.Key, skipTrailer):
+			// The value is a reason, or a boolean turning it off
+			if skip, err := strconv.ParseBool(trailer.Value); err != nil || skip {
+				return 0
+			}
+		case strings.EqualFold(trailer.Key, weightTrailer):
+			value, err := strconv.ParseFloat(trailer.Value, 64)
+			if err != nil || value < 0 || math.IsInf(value, 0) {
+				slog.Warn("Ignoring invalid commit weight trailer", "commit", c.Hash, "trailer", weightTrailer, "value", trailer.Value)
+				continue
+			}
+			weight = value
+		}
+	}
+	return weight
+}
+
+// trailerFilter selects commits by a trailer: "Reviewed-by" those having it, "Severity:critical"
+// those having it with this value and "!Reviewed-by" those without it. Keys and values are
+// compared case-insensitively.
+type trailerFilter struct {
+	key, value string
+	negate     bool
+}
+
+// parseTrailerFilter parses a trailer filter like "!Reviewed-by" or "Severity:critical"
+func parseTrailerFilter(value string) (trailerFilter, error) {
+	filter := trailerFilter{}
+	value, filter.negate = strings.CutPrefix(strings.TrimSpace(value), "!")
+	key, want, _ := strings.Cut(value, ":")
+	filter.key, filter.value = strings.TrimSpace(key), strings.TrimSpace(want)
+	if filter.key == "" {
+		return filter, fmt.Errorf("invalid trailer filter '%s' (expected e.g. Reviewed-by, !Reviewed-by or Severity:critical)", value)
+	}
+	return filter, nil
+}
+
+// matches reports whether the commit passes the filter
+func (f trailerFilter) matches(c *Commit) bool {
+	found := false
+	for _, trailer := range c.Trailers {
+		if strings.EqualFold(trailer.Key, f.key) && (f.value == "" || strings.EqualFold(trailer.Value, f.value)) {
+			found = true
+			break
+		}
+	}
+	return found != f.negate
+}
+
+// trailerWeight weighs the changes of commits matching a trailer filter
+type trailerWeight struct {
+	filter trailerFilter
+	weight int
+}
+
+// parseTrailerWeights parses comma separated weights like "Severity:critical=5,Severity:high=3"
+func parseTrailerWeights(value string) ([]trailerWeight, error) {
+	var weights []trailerWeight
+	for _, entry := range splitParam(value) {
+		spec, number, ok := cutLast(entry, "=")
+		weight, err := strconv.Atoi(number)
+		if !ok || err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid trailer weight '%s' (expected e.g. Severity:critical=5)", entry)
+		}
+		filter, err := parseTrailerFilter(spec)
+		if err != nil {
+			return nil, err
+		}
+		weights = append(weights, trailerWeight{filter: filter, weight: weight})
+	}
+	return weights, nil
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// commitWeight returns the highest weight of the matching trailer weights, 1 without a match
+func commitWeight(c *Commit, weights []trailerWeight) int {
+	weight, matched := 0, false
+	for _, w := range weights {
+		if w.filter.matches(c) {
+			weight, matched = max(weight, w.weight), true
+		}
+	}
+	if !matched {
+		return 1
+	}
+	return weight
+}
+
+// handleTrailerData serves GET /data?trailer=!Reviewed-by&trailerWeights=Severity:critical=5, the
+// churn of the commits passing every trailer filter, weighted by their trailers. It takes the /data
+// query parameters.
+func handleTrailerData(w http.ResponseWriter, r *http.Request, a *Analysis) {
+	query := r.URL.Query()
+	var filters []trailerFilter
+	for _, value := range query["trailer"] {
+		filter, err := parseTrailerFilter(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filters = append(filters, filter)
+	}
+	weights, err := parseTrailerWeights(query.Get("trailerWeights"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	weighted := weightedCommitAnalysis(a, func(c *Commit) int {
+		for _, filter := range filters {
+			if !filter.matches(c) {
+				return 0
+			}
+		}
+		return commitWeight(c, weights)
+	})
+	recorded := map[string]string{}
+	if len(filters) > 0 {
+		recorded["trailer"] = strings.Join(query["trailer"], ",")
+	}
+	if len(weights) > 0 {
+		recorded["trailerWeights"] = query.Get("trailerWeights")
+	}
+	writeCommitData(w, r, weighted, recorded)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"testing"
+)
+
+// TestCommitWeightViews checks that the views counting file changes agree on the commits' weight
+// trailers
+func TestCommitWeightViews(t *testing.T) {
+	repo := initRepo(t)
+	var all []string
+	initial, reformatted := make(map[string]string), make(map[string]string)
+	for i := range 10 {
+		path := fmt.Sprintf("src/f%d.txt", i)
+		all = append(all, path)
+		initial[path], reformatted[path] = fmt.Sprintf("file %d\n", i), fmt.Sprintf("file  %d\n", i)
+	}
+	commitFiles(t, repo, initial)
+	commitFiles(t, repo, reformatted, "Reformat", "Dirheat-Weight: 0.1")
+	commitFiles(t, repo, map[string]string{"src/f0.txt": "rewritten\n"}, "Rewrite", "Dirheat-Weight: 2")
+	commitFiles(t, repo, map[string]string{"src/f1.txt": "generated\n"}, "Regenerate", "Skip-Dirheat: codegen")
+
+	a, err := analyzeRepo(context.Background(), repo)
+	if err != nil {
+		t.Fatalf("analyzeRepo() error: %v", err)
+	}
+	want := map[string]int{"src/f0.txt": 3} // 1 + 0.1 + 2, rounded
+	for _, path := range all[1:] {
+		want[path] = 1 // 1 + 0.1, rounded; the skipped commit does not count
+	}
+
+	profile, err := parseProfile("all:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshots := make(map[string]int)
+	for _, snapshot := range a.Snapshots {
+		for path, value := range fileValues(snapshot.Root) {
+			snapshots[path] += value
+		}
+	}
+	views := map[string]map[string]int{
+		"data":      fileValues(a.Root),
+		"mine":      fileValues(commitAnalysis(a, func(c *Commit) bool { return c.Email == "test@example.com" }).Root),
+		"profile":   fileValues(profileAnalyses(a, []weightProfile{profile})["all"].Root),
+		"snapshots": snapshots,
+	}
+	for name, got := range views {
+		if !maps.Equal(got, want) {
+			t.Errorf("%s values = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// fileValues returns the values of the tree's files by path
+func fileValues(root Node) map[string]int {
+	values := make(map[string]int)
+	var collect func(n Node)
+	collect = func(n Node) {
+		for child := range n.Children() {
+			if child.IsFile() {
+				values[child.relPath()] = child.Value()
+			}
+			collect(child)
+		}
+	}
+	collect(root)
+	return values
+}
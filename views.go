@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxViewBody limits the size of a view request body
+const maxViewBody = 64 * 1024
+
+// View is a named, shareable heat-map configuration
+type View struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Path      string            `json:"path"`             // Zoomed-in node, relative to the repository root
+	Params    map[string]string `json:"params,omitempty"` // /data query parameters, e.g. minValue or depth
+	Owner     string            `json:"owner,omitempty"`  // Email of the signed-in user who created the view
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// permalink returns the URL reopening the view in the UI
+func (v *View) permalink() string {
+	query := url.Values{}
+	for key, value := range v.Params {
+		query.Set(key, value)
+	}
+	link := basePath + "/"
+	if len(query) > 0 {
+		link += "?" + query.Encode()
+	}
+	if v.Path != "" {
+		link += "#/" + v.Path
+	}
+	return link
+}
+
+// viewStore keeps views in memory and persists them to a JSON file on every change
+type viewStore struct {
+	mu    sync.RWMutex
+	file  string
+	views map[string]*View
+}
+
+// writeJSONFile writes v as indented JSON to file, replacing it atomically
+func writeJSONFile(file string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// loadViews opens the view store backed by file, which may not exist yet
+func loadViews(file string) (*viewStore, error) {
+	store := &viewStore{file: file, views: make(map[string]*View)}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var list []*View
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("error parsing views file '%s': %w", file, err)
+	}
+	for _, view := range list {
+		store.views[view.ID] = view
+	}
+	return store, nil
+}
+
+// listLocked returns the views ordered by name. The caller holds the lock.
+func (s *viewStore) listLocked() []*View {
+	list := make([]*View, 0, len(s.views))
+	for _, view := range s.views {
+		list = append(list, view)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+func (s *viewStore) list() []*View {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.listLocked()
+}
+
+func (s *viewStore) get(id string) *View {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.views[id]
+}
+
+// put creates or replaces the view and persists the store
+func (s *viewStore) put(view *View) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.views[view.ID] = view
+	return writeJSONFile(s.file, s.listLocked())
+}
+
+// remove deletes the view, reporting whether it existed
+func (s *viewStore) remove(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.views[id]; !ok {
+		return false, nil
+	}
+	delete(s.views, id)
+	return true, writeJSONFile(s.file, s.listLocked())
+}
+
+// newViewID returns a short random identifier suitable for permalinks
+func newViewID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// viewResponse is a view as served by the API, including its permalink
+type viewResponse struct {
+	*View
+	Permalink string `json:"permalink"`
+}
+
+// readViewBody decodes the name, path and params of a view from the request body
+func readViewBody(w http.ResponseWriter, r *http.Request) (*View, error) {
+	view := &View{}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxViewBody))
+	if err == nil {
+		err = json.Unmarshal(data, view)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(view.Name) == "" {
+		return nil, errors.New("name is required")
+	}
+	if view.Path, err = cleanPath(view.Path); err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+// handleViews serves GET /views (list, ?mine=true for the signed-in user's views) and POST /views (create)
+func handleViews(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list := views.list()
+		owner := ""
+		if user := currentUser(r); user != nil && r.URL.Query().Get("mine") == "true" {
+			owner = user.Email
+		}
+		response := make([]viewResponse, 0, len(list))
+		for _, view := range list {
+			if owner != "" && view.Owner != owner {
+				continue
+			}
+			response = append(response, viewResponse{View: view, Permalink: view.permalink()})
+		}
+		writeJSON(w, response)
+
+	case http.MethodPost:
+		view, err := readViewBody(w, r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid view: %v", err), http.StatusBadRequest)
+			return
+		}
+		if view.ID, err = newViewID(); err != nil {
+			http.Error(w, fmt.Sprintf("Error creating view: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if user := currentUser(r); user != nil {
+			view.Owner = user.Email
+		}
+		view.CreatedAt = time.Now().UTC()
+		view.UpdatedAt = view.CreatedAt
+		if err := views.put(view); err != nil {
+			http.Error(w, fmt.Sprintf("Error saving view: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Location", basePath+"/views/"+view.ID)
+		writeJSONStatus(w, http.StatusCreated, viewResponse{View: view, Permalink: view.permalink()})
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleView serves GET, PUT and DELETE /views/{id}
+func handleView(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	existing := views.get(id)
+	if existing == nil {
+		http.Error(w, fmt.Sprintf("View '%s' not found", id), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, viewResponse{View: existing, Permalink: existing.permalink()})
+
+	case http.MethodPut:
+		view, err := readViewBody(w, r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid view: %v", err), http.StatusBadRequest)
+			return
+		}
+		view.ID = id
+		view.Owner = existing.Owner
+		view.CreatedAt = existing.CreatedAt
+		view.UpdatedAt = time.Now().UTC()
+		if err := views.put(view); err != nil {
+			http.Error(w, fmt.Sprintf("Error saving view: %v", err), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, viewResponse{View: view, Permalink: view.permalink()})
+
+	case http.MethodDelete:
+		if _, err := views.remove(id); err != nil {
+			http.Error(w, fmt.Sprintf("Error saving views: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleViewPermalink serves GET /v/{id}, redirecting to the UI configured like the view
+func handleViewPermalink(w http.ResponseWriter, r *http.Request) {
+	view := views.get(r.PathValue("id"))
+	if view == nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.Redirect(w, r, view.permalink(), http.StatusFound)
+}